@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/sqlguard"
+)
+
+// accessPolicy limits the tables and operations each connection may use;
+// nil allows everything
+var accessPolicy *acl.Policy
+
+// toolOperations lists the operations each data tool needs. A tool is offered
+// while the policy allows at least one of them somewhere; tools not listed
+// here are always offered.
+var toolOperations = map[string][]acl.Operation{
+	"query":              {acl.Read},
+	"fetch_more":         {acl.Read},
+	"close_cursor":       {acl.Read},
+	"select":             {acl.Read},
+	"explain":            {acl.Read},
+	"federated_query":    {acl.Read},
+	"insert":             {acl.Insert},
+	"bulk_insert":        {acl.Insert},
+	"upsert":             {acl.Insert},
+	"update":             {acl.Update},
+	"delete":             {acl.Delete},
+	"undo":               {acl.Insert, acl.Update, acl.Delete},
+	"migrate_up":         {acl.DDL},
+	"migrate_down":       {acl.DDL},
+	"snapshot_tables":    {acl.Read},
+	"restore_snapshot":   {acl.Insert, acl.Update, acl.Delete},
+	"generate_seed_data": {acl.Read, acl.Insert},
+}
+
+// toolVisible reports whether tools/list should advertise the tool name
+func toolVisible(name string) bool {
+	ops, ok := toolOperations[name]
+	if accessPolicy == nil || !ok {
+		return true
+	}
+	for _, op := range ops {
+		if accessPolicy.Permits(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccess checks ops on table, a name as given by the client, for database
+func checkAccess(database, table string, ops ...acl.Operation) error {
+	if accessPolicy == nil {
+		return nil
+	}
+	schema, name := "public", strings.ReplaceAll(table, `"`, "")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		schema, name = name[:i], name[i+1:]
+	}
+	for _, op := range ops {
+		if err := accessPolicy.Check(database, schema, name, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkConnectionAccess checks op on every table of database
+func checkConnectionAccess(database string, op acl.Operation) error {
+	if accessPolicy == nil {
+		return nil
+	}
+	return accessPolicy.CheckConnection(database, op)
+}
+
+// checkStatementAccess checks every relation the statements of text
+// reference for how they use it. The target of MERGE needs insert, update and
+// delete.
+func checkStatementAccess(database, text string) error {
+	if accessPolicy == nil {
+		return nil
+	}
+	tokens, err := sqlguard.Tokenize(text)
+	if err != nil {
+		return err
+	}
+	var relations []sqlguard.Relation
+	for _, stmt := range sqlguard.SplitStatements(tokens) {
+		relations = append(relations, sqlguard.Relations(stmt)...)
+	}
+	for _, rel := range relations {
+		schema := rel.Schema
+		if schema == "" {
+			schema = "public"
+		}
+		var ops []acl.Operation
+		switch rel.Access {
+		case sqlguard.AccessInsert:
+			ops = []acl.Operation{acl.Insert}
+		case sqlguard.AccessUpdate:
+			ops = []acl.Operation{acl.Update}
+		case sqlguard.AccessDelete:
+			ops = []acl.Operation{acl.Delete}
+		case sqlguard.AccessMerge:
+			ops = []acl.Operation{acl.Insert, acl.Update, acl.Delete}
+		default:
+			ops = []acl.Operation{acl.Read}
+		}
+		for _, op := range ops {
+			if err := accessPolicy.Check(database, schema, rel.Name, op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// accessDeniedResponse reports a refusal of the access policy
+func accessDeniedResponse(err error) map[string]interface{} {
+	var aerr *acl.Error
+	if errors.As(err, &aerr) {
+		return errResponseWithDetail(fmt.Sprintf("Access denied: %s", aerr), aerr)
+	}
+	return errResponse(fmt.Sprintf("Access denied: %s", err))
+}
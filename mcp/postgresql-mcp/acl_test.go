@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+)
+
+// withAccessPolicy lets primary_db read and update everything but secrets
+func withAccessPolicy(t *testing.T) {
+	t.Helper()
+	prev := accessPolicy
+	accessPolicy = &acl.Policy{
+		Allow: []acl.Rule{{Connection: "primary_db", Operations: []acl.Operation{acl.Read, acl.Update}}},
+		Deny:  []acl.Rule{{Table: "secrets"}},
+	}
+	t.Cleanup(func() { accessPolicy = prev })
+}
+
+func TestQueryChecksReferencedRelations(t *testing.T) {
+	withAccessPolicy(t)
+	d := withFakeDB(t, "primary_db", nil)
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT u.id FROM users u JOIN public.secrets s ON s.user_id = u.id"}))
+	if r.OK || !strings.Contains(r.Error, "Access denied: read on public.secrets") {
+		t.Fatalf("expected the join on secrets to be denied: %+v", r)
+	}
+	if len(d.statements()) != 0 {
+		t.Errorf("nothing should run when access is denied: %v", d.statements())
+	}
+	detail := r.Detail.(map[string]interface{})
+	if detail["rule"] != "deny[0]" || detail["table"] != "secrets" {
+		t.Errorf("expected the deny rule in the detail: %+v", detail)
+	}
+
+	if r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT id FROM users"})); !r.OK {
+		t.Errorf("reading users should be allowed: %+v", r)
+	}
+}
+
+func TestQueryChecksEveryScope(t *testing.T) {
+	withAccessPolicy(t)
+	withFakeDB(t, "primary_db", nil)
+	for _, sql := range []string{
+		"WITH s AS (TABLE secrets) SELECT * FROM s",
+		"(TABLE secrets)",
+		"SELECT 1 UNION ALL TABLE secrets",
+		"SELECT * FROM secrets, (WITH secrets AS (SELECT 1) SELECT 1) q",
+		"WITH secrets AS (SELECT * FROM secrets) SELECT * FROM secrets",
+	} {
+		r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": sql}))
+		if r.OK || !strings.Contains(r.Error, "Access denied: read on public.secrets") {
+			t.Errorf("expected %q to be denied: %+v", sql, r)
+		}
+	}
+}
+
+func TestMutationsCheckOperations(t *testing.T) {
+	withAccessPolicy(t)
+	withFakeDB(t, "primary_db", nil)
+
+	r := decodeResponse(t, deleteHandler(map[string]interface{}{"table": "users", "where": map[string]interface{}{"id": float64(1)}}))
+	if r.OK || !strings.Contains(r.Error, "delete on public.users is not allowed") {
+		t.Errorf("expected delete to be denied: %+v", r)
+	}
+	r = decodeResponse(t, updateHandler(map[string]interface{}{
+		"table": "users",
+		"data":  map[string]interface{}{"name": "Jane"},
+		"where": map[string]interface{}{"id": float64(1)},
+	}))
+	if !r.OK {
+		t.Errorf("expected update to be allowed: %+v", r)
+	}
+	r = decodeResponse(t, upsertHandler(map[string]interface{}{
+		"table":            "users",
+		"data":             map[string]interface{}{"id": float64(1), "name": "Jane"},
+		"conflict_columns": []interface{}{"id"},
+	}))
+	if r.OK || !strings.Contains(r.Error, "insert on public.users") {
+		t.Errorf("expected upsert to need insert: %+v", r)
+	}
+}
+
+func TestToolVisibility(t *testing.T) {
+	withAccessPolicy(t)
+	for tool, want := range map[string]bool{"query": true, "update": true, "undo": true, "delete": false, "insert": false, "bulk_insert": false, "list_tables": true} {
+		if got := toolVisible(tool); got != want {
+			t.Errorf("toolVisible(%s) = %v, want %v", tool, got, want)
+		}
+	}
+}
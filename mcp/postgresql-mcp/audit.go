@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/audit"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/dbguard"
+)
+
+const defaultAuditLimit = 100
+
+// auditLog receives an entry for every data change; nil disables auditing
+var auditLog *audit.Log
+
+// defaultAuditPath is the audit log used when none is configured
+func defaultAuditPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".postgresql-mcp", "audit.jsonl")
+}
+
+// openAuditLog opens the audit log at path, creating its directory
+func openAuditLog(path string) (*audit.Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	return audit.Open(path)
+}
+
+// lockRows reads and locks the rows a statement is about to change, for the
+// before image of its audit entry
+func lockRows(ctx context.Context, q queryer, table, where string, values []interface{}) (*rowSet, error) {
+	return queryRowSet(ctx, q, fmt.Sprintf("SELECT * FROM %s WHERE %s FOR UPDATE", table, where), values...)
+}
+
+// recordChange appends the audit entry of a statement that ran on t. It is
+// called before the change commits, so a change that cannot be recorded is
+// rolled back.
+func recordChange(t *target, mut *mutation, query string, affected int, before, after *rowSet) error {
+	e := &audit.Entry{
+		Tool:       mut.tool,
+		Operation:  mut.operation(),
+		Connection: t.database,
+		DSN:        dbguard.RedactDSN(dbManager.DSN(t.database)),
+		Table:      mut.name,
+		SQL:        query,
+		Params:     mut.values,
+		Affected:   affected,
+	}
+	if t.session != nil {
+		e.Transaction = t.session.id
+	}
+	if before != nil {
+		e.Before = before.maps()
+		e.Columns = before.describe()
+	}
+	if after != nil {
+		e.After = after.maps()
+		if len(after.columns) > 0 {
+			e.Columns = after.describe()
+		}
+	}
+	if err := auditLog.Append(e); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+func getAuditLogHandler(args map[string]interface{}) map[string]interface{} {
+	if auditLog == nil {
+		return errResponse("Audit logging is disabled; start the server with -audit-log")
+	}
+
+	var q audit.Query
+	for key, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v, exists := args[key]; exists {
+			vStr, ok := v.(string)
+			if !ok {
+				return errResponse(fmt.Sprintf("%s must be an RFC 3339 timestamp", key))
+			}
+			ts, err := time.Parse(time.RFC3339, vStr)
+			if err != nil {
+				return errResponse(fmt.Sprintf("%s must be an RFC 3339 timestamp: %s", key, err))
+			}
+			*dst = ts
+		}
+	}
+
+	if v, exists := args["id"]; exists {
+		if vStr, ok := v.(string); ok {
+			q.ID = vStr
+		}
+	}
+	if v, exists := args["table"]; exists {
+		if vStr, ok := v.(string); ok {
+			q.Table = vStr
+		}
+	}
+	if v, exists := args["operation"]; exists {
+		if vStr, ok := v.(string); ok {
+			q.Operation = vStr
+		}
+	}
+	if v, exists := args["database"]; exists {
+		if vStr, ok := v.(string); ok {
+			q.Connection = vStr
+		}
+	}
+
+	q.Limit = defaultAuditLimit
+	if l, exists := args["limit"]; exists {
+		if limitFloat, ok := l.(float64); ok && limitFloat > 0 {
+			q.Limit = int(limitFloat)
+		}
+	}
+
+	entries, err := auditLog.Search(q)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	// Row images of tables the access policy does not let the caller read are left out
+	visible := []*audit.Entry{}
+	for _, e := range entries {
+		if checkAccess(e.Connection, e.Table, acl.Read) == nil {
+			visible = append(visible, e)
+		}
+	}
+	entries = visible
+	for _, e := range entries {
+		masked := maskTableMaps(e.Connection, e.Table, e.Before)
+		masked = maskTableMaps(e.Connection, e.Table, e.After) || masked
+		if masked || tableMasked(e.Connection, e.Table, columnNames(e.Columns)) {
+			e.Params = maskParams(e.Params)
+		}
+	}
+	count := len(entries)
+	return okResponse(entries, &count)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultBulkBatchSize = 500
+	// maxBindParams is the most parameters one PostgreSQL statement accepts
+	maxBindParams = 65535
+)
+
+// Bulk load methods
+const (
+	bulkAuto   = "auto"
+	bulkCopy   = "copy"
+	bulkInsert = "insert"
+)
+
+// copyLineRe finds the input line in the context of a COPY error,
+// e.g. `COPY products, line 42, column price: "abc"`
+var copyLineRe = regexp.MustCompile(`COPY [^,]+, line (\d+)`)
+
+// bulkLoad is a validated bulk_insert request
+type bulkLoad struct {
+	table   string
+	schema  string
+	name    string
+	columns []string
+	rows    [][]interface{}
+}
+
+// bulkError reports the row that stopped a bulk load
+type bulkError struct {
+	row int
+	err error
+}
+
+func (e *bulkError) Error() string {
+	if e.row < 0 {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("rows[%d]: %s", e.row, e.err)
+}
+
+func (e *bulkError) Unwrap() error { return e.err }
+
+func bulkInsertHandler(args map[string]interface{}) map[string]interface{} {
+	table, ok := args["table"].(string)
+	if !ok {
+		return errResponse("table is required")
+	}
+
+	rows, ok := args["rows"].([]interface{})
+	if !ok || len(rows) == 0 {
+		return errResponse("rows must be a non-empty array")
+	}
+
+	columns, err := stringList(args, "columns")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+
+	method := bulkAuto
+	if m, exists := args["method"]; exists {
+		if mStr, ok := m.(string); ok && mStr != "" {
+			method = mStr
+		}
+	}
+	if method != bulkAuto && method != bulkCopy && method != bulkInsert {
+		return errResponse(fmt.Sprintf("unsupported method %q (use auto, copy or insert)", method))
+	}
+
+	batchSize := defaultBulkBatchSize
+	if b, exists := args["batch_size"]; exists {
+		if bFloat, ok := b.(float64); ok && bFloat > 0 {
+			batchSize = int(bFloat)
+		}
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	load, err := newBulkLoad(table, columns, rows)
+	if err != nil {
+		var errs pgparam.Errors
+		if errors.As(err, &errs) {
+			return invalidParamsResponse(err)
+		}
+		return errResponse(err.Error())
+	}
+
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkWritable(t.database); err != nil {
+		return errResponse(err.Error())
+	}
+	if err := checkAccess(t.database, table, acl.Insert); err != nil {
+		return accessDeniedResponse(err)
+	}
+
+	used := method
+	err = t.run(timeoutMs, modeAtomic, func(ctx context.Context, q queryer) error {
+		var err error
+		if used, err = load.run(ctx, q, method, batchSize); err != nil || auditLog == nil {
+			return err
+		}
+		// Bulk loads are recorded without parameters or row images
+		return recordChange(t, &mutation{tool: "bulk_insert", table: load.table, name: table}, load.statement(used), len(load.rows), nil, nil)
+	})
+	if err != nil {
+		detail := map[string]interface{}{"method": used, "rows_loaded": 0}
+		var be *bulkError
+		if errors.As(err, &be) && be.row >= 0 {
+			detail["failed_row"] = be.row
+			detail["row"] = load.rowMap(be.row)
+		}
+		return errResponseWithDetail(fmt.Sprintf("Bulk insert failed: %s", err), detail)
+	}
+
+	count := len(load.rows)
+	return okResponse(map[string]interface{}{
+		"table":       load.table,
+		"method":      used,
+		"columns":     load.columns,
+		"rows_loaded": count,
+	}, &count)
+}
+
+// newBulkLoad validates identifiers and converts every row. Rows are either
+// arrays matching columns, or objects whose keys name columns; without
+// columns, the first object's keys are used. Missing keys load NULL.
+func newBulkLoad(table string, columns []string, rows []interface{}) (*bulkLoad, error) {
+	quotedTable, err := qIdent(table)
+	if err != nil {
+		return nil, err
+	}
+	load := &bulkLoad{table: quotedTable, name: table}
+	switch parts := strings.Split(table, "."); len(parts) {
+	case 1:
+	case 2:
+		load.schema, load.name = parts[0], parts[1]
+	default:
+		return nil, fmt.Errorf("invalid table name: %q", table)
+	}
+
+	if first, ok := rows[0].(map[string]interface{}); ok && len(columns) == 0 {
+		for col := range first {
+			columns = append(columns, col)
+		}
+		sort.Strings(columns)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("columns is required when rows are arrays")
+	}
+	index := make(map[string]int, len(columns))
+	for i, col := range columns {
+		if _, err := validateIdentifier(col); err != nil {
+			return nil, err
+		}
+		if _, dup := index[col]; dup {
+			return nil, fmt.Errorf("duplicate column %q", col)
+		}
+		index[col] = i
+	}
+	load.columns = columns
+
+	var conv pgparam.Converter
+	for r, row := range rows {
+		values := make([]interface{}, len(columns))
+		switch x := row.(type) {
+		case []interface{}:
+			if len(x) != len(columns) {
+				return nil, fmt.Errorf("rows[%d]: has %d values for %d columns", r, len(x), len(columns))
+			}
+			for i, v := range x {
+				values[i] = conv.Convert(fmt.Sprintf("rows[%d].%s", r, columns[i]), v)
+			}
+		case map[string]interface{}:
+			for col, v := range x {
+				i, ok := index[col]
+				if !ok {
+					return nil, fmt.Errorf("rows[%d]: unknown column %q", r, col)
+				}
+				values[i] = conv.Convert(fmt.Sprintf("rows[%d].%s", r, col), v)
+			}
+		default:
+			return nil, fmt.Errorf("rows[%d]: must be an object or an array", r)
+		}
+		load.rows = append(load.rows, values)
+	}
+	if err := conv.Err(); err != nil {
+		return nil, err
+	}
+	return load, nil
+}
+
+// run loads every row and returns the method used. With auto, COPY is tried
+// first and batched INSERTs are used when the server refuses the COPY itself.
+func (l *bulkLoad) run(ctx context.Context, q queryer, method string, batchSize int) (string, error) {
+	if method == bulkInsert {
+		return bulkInsert, l.insert(ctx, q, batchSize)
+	}
+	if method == bulkCopy {
+		return bulkCopy, l.copy(ctx, q)
+	}
+
+	if _, err := q.ExecContext(ctx, "SAVEPOINT mcp_bulk"); err != nil {
+		return bulkAuto, err
+	}
+	err := l.copy(ctx, q)
+	var be *bulkError
+	if err != nil && errors.As(err, &be) && be.row < 0 {
+		logger.Printf("COPY into %s unavailable, falling back to INSERT: %s", l.table, err)
+		if _, rerr := q.ExecContext(ctx, "ROLLBACK TO SAVEPOINT mcp_bulk"); rerr != nil {
+			return bulkCopy, rerr
+		}
+		return bulkInsert, l.insert(ctx, q, batchSize)
+	}
+	if err != nil {
+		return bulkCopy, err
+	}
+	_, err = q.ExecContext(ctx, "RELEASE SAVEPOINT mcp_bulk")
+	return bulkCopy, err
+}
+
+// copy streams the rows through COPY FROM STDIN. Errors are bulkErrors whose
+// row is -1 when the COPY itself failed before any row was sent.
+func (l *bulkLoad) copy(ctx context.Context, q queryer) error {
+	var stmt *sql.Stmt
+	var err error
+	if l.schema != "" {
+		stmt, err = q.PrepareContext(ctx, pq.CopyInSchema(l.schema, l.name, l.columns...))
+	} else {
+		stmt, err = q.PrepareContext(ctx, pq.CopyIn(l.name, l.columns...))
+	}
+	if err != nil {
+		return &bulkError{row: -1, err: err}
+	}
+	defer stmt.Close()
+
+	for _, values := range l.rows {
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return copyError(err)
+		}
+	}
+	// The server reports bad rows when the buffered data is flushed
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return copyError(err)
+	}
+	return nil
+}
+
+// copyError attributes a COPY failure to the input line the server names.
+// Errors arrive asynchronously, so the row being sent says nothing.
+func copyError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if m := copyLineRe.FindStringSubmatch(pqErr.Where); m != nil {
+			if line, convErr := strconv.Atoi(m[1]); convErr == nil {
+				return &bulkError{row: line - 1, err: err}
+			}
+		}
+	}
+	// Keep the failure distinct from a COPY that never started
+	return fmt.Errorf("COPY failed: %w", err)
+}
+
+// insert loads the rows with multi-row INSERTs of up to batchSize rows. When a
+// batch fails, its rows are retried one at a time to find the failing row.
+func (l *bulkLoad) insert(ctx context.Context, q queryer, batchSize int) error {
+	if limit := maxBindParams / len(l.columns); batchSize > limit {
+		batchSize = limit
+	}
+	for start := 0; start < len(l.rows); start += batchSize {
+		end := start + batchSize
+		if end > len(l.rows) {
+			end = len(l.rows)
+		}
+		if _, err := q.ExecContext(ctx, "SAVEPOINT mcp_bulk_batch"); err != nil {
+			return err
+		}
+		query, values := l.insertStatement(start, end)
+		if _, err := q.ExecContext(ctx, query, values...); err != nil {
+			if _, rerr := q.ExecContext(ctx, "ROLLBACK TO SAVEPOINT mcp_bulk_batch"); rerr != nil {
+				return rerr
+			}
+			return l.findFailingRow(ctx, q, start, end, err)
+		}
+		if _, err := q.ExecContext(ctx, "RELEASE SAVEPOINT mcp_bulk_batch"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findFailingRow inserts rows start..end one by one until one fails. The
+// batch's error is returned when every row succeeds on its own.
+func (l *bulkLoad) findFailingRow(ctx context.Context, q queryer, start, end int, batchErr error) error {
+	for r := start; r < end; r++ {
+		query, values := l.insertStatement(r, r+1)
+		if _, err := q.ExecContext(ctx, query, values...); err != nil {
+			return &bulkError{row: r, err: err}
+		}
+	}
+	return &bulkError{row: -1, err: batchErr}
+}
+
+// insertStatement builds one INSERT for rows start..end
+func (l *bulkLoad) insertStatement(start, end int) (string, []interface{}) {
+	quoted := make([]string, len(l.columns))
+	for i, col := range l.columns {
+		quoted[i] = `"` + col + `"`
+	}
+	var tuples []string
+	var values []interface{}
+	for _, row := range l.rows[start:end] {
+		placeholders := make([]string, len(row))
+		for i, v := range row {
+			values = append(values, v)
+			placeholders[i] = fmt.Sprintf("$%d", len(values))
+		}
+		tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", l.table, strings.Join(quoted, ", "), strings.Join(tuples, ", ")), values
+}
+
+// statement describes how the rows were loaded for the audit log
+func (l *bulkLoad) statement(method string) string {
+	quoted := make([]string, len(l.columns))
+	for i, col := range l.columns {
+		quoted[i] = `"` + col + `"`
+	}
+	if method == bulkInsert {
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES ...", l.table, strings.Join(quoted, ", "))
+	}
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN", l.table, strings.Join(quoted, ", "))
+}
+
+// rowMap returns row r keyed by column for error reports
+func (l *bulkLoad) rowMap(r int) map[string]interface{} {
+	row := make(map[string]interface{}, len(l.columns))
+	for i, col := range l.columns {
+		row[col] = l.rows[r][i]
+	}
+	return row
+}
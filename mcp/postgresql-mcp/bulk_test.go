@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestBulkInsertCopiesInOneTransaction(t *testing.T) {
+	var copied [][]driver.Value
+	d := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if strings.HasPrefix(query, "COPY ") && len(args) > 0 {
+			row := make([]driver.Value, len(args))
+			for i, a := range args {
+				row[i] = a.Value
+			}
+			copied = append(copied, row)
+		}
+		return nil, nil
+	})
+
+	r := decodeResponse(t, bulkInsertHandler(map[string]interface{}{
+		"table": "public.products",
+		"rows": []interface{}{
+			map[string]interface{}{"name": "Pen", "price": "1.50"},
+			map[string]interface{}{"name": "Ink", "price": map[string]interface{}{"value": "12.00", "type": "numeric"}},
+			map[string]interface{}{"name": "Pad"},
+		},
+	}))
+	if !r.OK || *r.RowCount != 3 {
+		t.Fatalf("bulk insert failed: %+v", r)
+	}
+	if data := r.Data.(map[string]interface{}); data["method"] != "copy" || data["rows_loaded"].(float64) != 3 {
+		t.Errorf("unexpected result: %+v", data)
+	}
+	stmts := d.statements()
+	if !containsStatement(stmts, `COPY "public"."products" ("name", "price") FROM STDIN`) {
+		t.Errorf("expected a COPY with sorted columns: %v", stmts)
+	}
+	if stmts[0] != "BEGIN" || stmts[len(stmts)-1] != "COMMIT" {
+		t.Errorf("expected one committed transaction: %v", stmts)
+	}
+	want := fmt.Sprint([][]driver.Value{{"Pen", "1.50"}, {"Ink", "12.00"}, {"Pad", nil}})
+	if got := fmt.Sprint(copied); got != want {
+		t.Errorf("copied %s, want %s", got, want)
+	}
+}
+
+func TestBulkInsertReportsCopyLine(t *testing.T) {
+	d := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if strings.HasPrefix(query, "COPY ") && args != nil && len(args) == 0 {
+			return nil, &pq.Error{Message: `invalid input syntax for type numeric: "abc"`, Where: `COPY products, line 2, column price: "abc"`}
+		}
+		return nil, nil
+	})
+
+	r := decodeResponse(t, bulkInsertHandler(map[string]interface{}{
+		"table":   "products",
+		"columns": []interface{}{"name", "price"},
+		"rows":    []interface{}{[]interface{}{"Pen", "1.50"}, []interface{}{"Ink", "abc"}},
+	}))
+	if r.OK {
+		t.Fatalf("expected the load to fail")
+	}
+	detail := r.Detail.(map[string]interface{})
+	if detail["failed_row"].(float64) != 1 || detail["row"].(map[string]interface{})["price"] != "abc" {
+		t.Errorf("expected rows[1] to be reported: %+v", detail)
+	}
+	if !strings.Contains(r.Error, "rows[1]") || containsStatement(d.statements(), "COMMIT") {
+		t.Errorf("expected nothing to be committed: %s %v", r.Error, d.statements())
+	}
+}
+
+func TestBulkInsertFallsBackToBatchedInserts(t *testing.T) {
+	d := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if strings.HasPrefix(query, "COPY ") {
+			return nil, fmt.Errorf("COPY is not supported by this proxy")
+		}
+		if strings.HasPrefix(query, "INSERT ") {
+			for _, a := range args {
+				if a.Value == "dup" {
+					return nil, fmt.Errorf("duplicate key value violates unique constraint")
+				}
+			}
+		}
+		return nil, nil
+	})
+
+	rows := []interface{}{
+		map[string]interface{}{"sku": "a"},
+		map[string]interface{}{"sku": "b"},
+		map[string]interface{}{"sku": "c"},
+	}
+	r := decodeResponse(t, bulkInsertHandler(map[string]interface{}{"table": "products", "rows": rows, "batch_size": float64(2)}))
+	if !r.OK || r.Data.(map[string]interface{})["method"] != "insert" {
+		t.Fatalf("expected the INSERT fallback: %+v", r)
+	}
+	if !containsStatement(d.statements(), `INSERT INTO "products" ("sku") VALUES ($1), ($2)`) || !containsStatement(d.statements(), "ROLLBACK TO SAVEPOINT mcp_bulk") {
+		t.Errorf("expected batches after rolling back the COPY: %v", d.statements())
+	}
+
+	rows[2] = map[string]interface{}{"sku": "dup"}
+	r = decodeResponse(t, bulkInsertHandler(map[string]interface{}{"table": "products", "rows": rows, "method": "insert", "batch_size": float64(2)}))
+	if r.OK || r.Detail.(map[string]interface{})["failed_row"].(float64) != 2 {
+		t.Errorf("expected rows[2] to be found by retrying its batch row by row: %+v", r)
+	}
+}
+
+func TestBulkInsertValidatesBeforeRunning(t *testing.T) {
+	d := withFakeDB(t, "primary_db", nil)
+
+	for _, args := range []map[string]interface{}{
+		{"table": "products; DROP TABLE users", "rows": []interface{}{map[string]interface{}{"a": 1.0}}},
+		{"table": "products", "rows": []interface{}{map[string]interface{}{"bad name": 1.0}}},
+		{"table": "products", "rows": []interface{}{[]interface{}{1.0}}},
+		{"table": "products", "rows": []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"b": 2.0}}},
+		{"table": "products", "rows": []interface{}{map[string]interface{}{"a": map[string]interface{}{"value": "x", "type": "int"}}}},
+	} {
+		if r := decodeResponse(t, bulkInsertHandler(args)); r.OK {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+	if len(d.statements()) != 0 {
+		t.Errorf("expected nothing to run, got %v", d.statements())
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ColumnInfo describes one table column as recorded in pg_attribute
+type ColumnInfo struct {
+	Name       string   `json:"name"`
+	Position   int      `json:"position"`
+	DataType   string   `json:"data_type"`
+	TypeName   string   `json:"type_name"`
+	Nullable   bool     `json:"nullable"`
+	Default    *string  `json:"default"`
+	Comment    *string  `json:"comment,omitempty"`
+	Identity   string   `json:"identity,omitempty"`
+	Generated  string   `json:"generated,omitempty"`
+	EnumValues []string `json:"enum_values,omitempty"`
+}
+
+// ConstraintInfo describes a primary key, unique, check or exclusion constraint
+type ConstraintInfo struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Columns    []string `json:"columns,omitempty"`
+	Definition string   `json:"definition"`
+}
+
+// ForeignKeyInfo describes a foreign key from Schema.Table(Columns) to RefSchema.RefTable(RefColumns)
+type ForeignKeyInfo struct {
+	Name       string   `json:"name"`
+	Schema     string   `json:"schema"`
+	Table      string   `json:"table"`
+	Columns    []string `json:"columns"`
+	RefSchema  string   `json:"ref_schema"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+	OnUpdate   string   `json:"on_update"`
+	OnDelete   string   `json:"on_delete"`
+	Definition string   `json:"definition"`
+}
+
+// IndexInfo describes an index on the table
+type IndexInfo struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	Unique     bool     `json:"unique"`
+	Primary    bool     `json:"primary"`
+	Method     string   `json:"method"`
+	Predicate  *string  `json:"predicate,omitempty"`
+	Definition string   `json:"definition"`
+}
+
+// TriggerInfo describes a user trigger
+type TriggerInfo struct {
+	Name       string   `json:"name"`
+	Timing     string   `json:"timing"`
+	Events     []string `json:"events"`
+	Level      string   `json:"level"`
+	Function   string   `json:"function"`
+	Enabled    bool     `json:"enabled"`
+	Definition string   `json:"definition"`
+}
+
+// PartitionInfo describes declarative partitioning of a parent or a partition
+type PartitionInfo struct {
+	Strategy   string           `json:"strategy,omitempty"`
+	Key        string           `json:"key,omitempty"`
+	Partitions []PartitionBound `json:"partitions,omitempty"`
+	Parent     *PartitionBound  `json:"parent,omitempty"`
+}
+
+// PartitionBound names a partition (or parent) and its bound expression
+type PartitionBound struct {
+	Table string `json:"table"`
+	Bound string `json:"bound,omitempty"`
+}
+
+// TableInfo is everything describe_table reports about a relation
+type TableInfo struct {
+	Schema            string           `json:"schema"`
+	Name              string           `json:"name"`
+	Kind              string           `json:"kind"`
+	Comment           *string          `json:"comment"`
+	Columns           []ColumnInfo     `json:"columns"`
+	PrimaryKey        *ConstraintInfo  `json:"primary_key"`
+	UniqueConstraints []ConstraintInfo `json:"unique_constraints"`
+	CheckConstraints  []ConstraintInfo `json:"check_constraints"`
+	Exclusions        []ConstraintInfo `json:"exclusion_constraints,omitempty"`
+	ForeignKeys       []ForeignKeyInfo `json:"foreign_keys"`
+	ReferencedBy      []ForeignKeyInfo `json:"referenced_by"`
+	Indexes           []IndexInfo      `json:"indexes"`
+	Triggers          []TriggerInfo    `json:"triggers"`
+	Partitioning      *PartitionInfo   `json:"partitioning,omitempty"`
+
+	oid uint32
+}
+
+var relkindNames = map[string]string{
+	"r": "table",
+	"p": "partitioned_table",
+	"v": "view",
+	"m": "materialized_view",
+	"f": "foreign_table",
+}
+
+var fkActions = map[string]string{
+	"a": "NO ACTION",
+	"r": "RESTRICT",
+	"c": "CASCADE",
+	"n": "SET NULL",
+	"d": "SET DEFAULT",
+}
+
+// splitTableName splits "schema.table" and defaults the schema to public
+func splitTableName(table string) (string, string) {
+	parts := strings.SplitN(table, ".", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "public", parts[0]
+}
+
+// primaryKeyColumns returns the primary key columns of table, a quoted name
+// resolved through the search path, or nil when it has none
+func primaryKeyColumns(ctx context.Context, q queryer, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+            SELECT a.attname::text
+            FROM pg_index i
+            JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+            WHERE i.indrelid = $1::regclass AND i.indisprimary
+            ORDER BY array_position(i.indkey::int2[], a.attnum)
+    `, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// describeTable reads the full definition of schema.table from pg_catalog
+func describeTable(ctx context.Context, q queryer, schema, table string) (*TableInfo, error) {
+	info := &TableInfo{
+		Schema:            schema,
+		Name:              table,
+		Columns:           []ColumnInfo{},
+		UniqueConstraints: []ConstraintInfo{},
+		CheckConstraints:  []ConstraintInfo{},
+		ForeignKeys:       []ForeignKeyInfo{},
+		ReferencedBy:      []ForeignKeyInfo{},
+		Indexes:           []IndexInfo{},
+		Triggers:          []TriggerInfo{},
+	}
+
+	var relkind string
+	var isPartition bool
+	var comment sql.NullString
+	err := q.QueryRowContext(ctx, `
+            SELECT c.oid, c.relkind::text, c.relispartition, obj_description(c.oid, 'pg_class')
+            FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE n.nspname = $1 AND c.relname = $2
+    `, schema, table).Scan(&info.oid, &relkind, &isPartition, &comment)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("table not found: %s.%s", schema, table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Kind = relkindNames[relkind]
+	if info.Kind == "" {
+		info.Kind = relkind
+	}
+	if comment.Valid {
+		info.Comment = &comment.String
+	}
+
+	if info.Columns, err = loadColumns(ctx, q, info.oid); err != nil {
+		return nil, fmt.Errorf("columns: %w", err)
+	}
+	if err := loadConstraints(ctx, q, info); err != nil {
+		return nil, fmt.Errorf("constraints: %w", err)
+	}
+	if info.Indexes, err = loadIndexes(ctx, q, info.oid); err != nil {
+		return nil, fmt.Errorf("indexes: %w", err)
+	}
+	if info.Triggers, err = loadTriggers(ctx, q, info.oid); err != nil {
+		return nil, fmt.Errorf("triggers: %w", err)
+	}
+	if relkind == "p" || isPartition {
+		if info.Partitioning, err = loadPartitioning(ctx, q, info.oid, relkind == "p", isPartition); err != nil {
+			return nil, fmt.Errorf("partitioning: %w", err)
+		}
+	}
+	return info, nil
+}
+
+func loadColumns(ctx context.Context, q queryer, oid uint32) ([]ColumnInfo, error) {
+	rows, err := q.QueryContext(ctx, `
+            SELECT a.attname::text, a.attnum, format_type(a.atttypid, a.atttypmod), t.typname::text,
+                   NOT a.attnotnull, pg_get_expr(d.adbin, d.adrelid), col_description(a.attrelid, a.attnum),
+                   a.attidentity::text, a.attgenerated::text,
+                   ARRAY(SELECT e.enumlabel::text FROM pg_enum e WHERE e.enumtypid = t.oid ORDER BY e.enumsortorder)
+            FROM pg_attribute a
+            JOIN pg_type t ON t.oid = a.atttypid
+            LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+            WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+            ORDER BY a.attnum
+    `, oid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []ColumnInfo{}
+	for rows.Next() {
+		var c ColumnInfo
+		var def, comment sql.NullString
+		var enums pq.StringArray
+		if err := rows.Scan(&c.Name, &c.Position, &c.DataType, &c.TypeName, &c.Nullable, &def, &comment, &c.Identity, &c.Generated, &enums); err != nil {
+			return nil, err
+		}
+		if def.Valid {
+			c.Default = &def.String
+		}
+		if comment.Valid {
+			c.Comment = &comment.String
+		}
+		switch c.Identity {
+		case "a":
+			c.Identity = "ALWAYS"
+		case "d":
+			c.Identity = "BY DEFAULT"
+		}
+		if c.Generated == "s" {
+			c.Generated = "STORED"
+		}
+		if len(enums) > 0 {
+			c.EnumValues = enums
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func loadConstraints(ctx context.Context, q queryer, info *TableInfo) error {
+	rows, err := q.QueryContext(ctx, `
+            SELECT con.conname::text, con.contype::text, pg_get_constraintdef(con.oid),
+                   cn.nspname::text, cc.relname::text,
+                   ARRAY(SELECT a.attname::text FROM unnest(con.conkey) WITH ORDINALITY k(attnum, ord)
+                         JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum ORDER BY k.ord),
+                   COALESCE(fn.nspname::text, ''), COALESCE(fc.relname::text, ''),
+                   ARRAY(SELECT a.attname::text FROM unnest(con.confkey) WITH ORDINALITY k(attnum, ord)
+                         JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum ORDER BY k.ord),
+                   con.confupdtype::text, con.confdeltype::text,
+                   con.conrelid = $1
+            FROM pg_constraint con
+            JOIN pg_class cc ON cc.oid = con.conrelid
+            JOIN pg_namespace cn ON cn.oid = cc.relnamespace
+            LEFT JOIN pg_class fc ON fc.oid = con.confrelid
+            LEFT JOIN pg_namespace fn ON fn.oid = fc.relnamespace
+            WHERE con.conrelid = $1 OR (con.confrelid = $1 AND con.contype = 'f')
+            ORDER BY con.conname
+    `, info.oid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, contype, def, schema, table, refSchema, refTable, onUpdate, onDelete string
+		var cols, refCols pq.StringArray
+		var own bool
+		if err := rows.Scan(&name, &contype, &def, &schema, &table, &cols, &refSchema, &refTable, &refCols, &onUpdate, &onDelete, &own); err != nil {
+			return err
+		}
+		if contype == "f" {
+			fk := ForeignKeyInfo{
+				Name:       name,
+				Schema:     schema,
+				Table:      table,
+				Columns:    cols,
+				RefSchema:  refSchema,
+				RefTable:   refTable,
+				RefColumns: refCols,
+				OnUpdate:   fkActions[onUpdate],
+				OnDelete:   fkActions[onDelete],
+				Definition: def,
+			}
+			if own {
+				info.ForeignKeys = append(info.ForeignKeys, fk)
+			}
+			// A self-referencing key is both outgoing and incoming
+			if refSchema == info.Schema && refTable == info.Name {
+				info.ReferencedBy = append(info.ReferencedBy, fk)
+			}
+			continue
+		}
+		c := ConstraintInfo{Name: name, Columns: cols, Definition: def}
+		switch contype {
+		case "p":
+			c.Type = "primary_key"
+			info.PrimaryKey = &c
+		case "u":
+			c.Type = "unique"
+			info.UniqueConstraints = append(info.UniqueConstraints, c)
+		case "c":
+			c.Type = "check"
+			info.CheckConstraints = append(info.CheckConstraints, c)
+		case "x":
+			c.Type = "exclusion"
+			info.Exclusions = append(info.Exclusions, c)
+		}
+	}
+	return rows.Err()
+}
+
+func loadIndexes(ctx context.Context, q queryer, oid uint32) ([]IndexInfo, error) {
+	rows, err := q.QueryContext(ctx, `
+            SELECT ic.relname::text, i.indisunique, i.indisprimary, am.amname::text,
+                   pg_get_indexdef(i.indexrelid), pg_get_expr(i.indpred, i.indrelid),
+                   ARRAY(SELECT COALESCE(a.attname::text, pg_get_indexdef(i.indexrelid, k.ord::int, true))
+                         FROM unnest(i.indkey::int2[]) WITH ORDINALITY k(attnum, ord)
+                         LEFT JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+                         WHERE k.ord <= i.indnkeyatts
+                         ORDER BY k.ord)
+            FROM pg_index i
+            JOIN pg_class ic ON ic.oid = i.indexrelid
+            JOIN pg_am am ON am.oid = ic.relam
+            WHERE i.indrelid = $1
+            ORDER BY ic.relname
+    `, oid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := []IndexInfo{}
+	for rows.Next() {
+		var idx IndexInfo
+		var pred sql.NullString
+		var cols pq.StringArray
+		if err := rows.Scan(&idx.Name, &idx.Unique, &idx.Primary, &idx.Method, &idx.Definition, &pred, &cols); err != nil {
+			return nil, err
+		}
+		if pred.Valid {
+			idx.Predicate = &pred.String
+		}
+		idx.Columns = cols
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
+
+func loadTriggers(ctx context.Context, q queryer, oid uint32) ([]TriggerInfo, error) {
+	rows, err := q.QueryContext(ctx, `
+            SELECT t.tgname::text, t.tgtype::int, p.proname::text, t.tgenabled::text, pg_get_triggerdef(t.oid)
+            FROM pg_trigger t
+            JOIN pg_proc p ON p.oid = t.tgfoid
+            WHERE t.tgrelid = $1 AND NOT t.tgisinternal
+            ORDER BY t.tgname
+    `, oid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	triggers := []TriggerInfo{}
+	for rows.Next() {
+		var tr TriggerInfo
+		var tgtype int
+		var enabled string
+		if err := rows.Scan(&tr.Name, &tgtype, &tr.Function, &enabled, &tr.Definition); err != nil {
+			return nil, err
+		}
+		tr.Timing, tr.Events, tr.Level = decodeTriggerType(tgtype)
+		tr.Enabled = enabled != "D"
+		triggers = append(triggers, tr)
+	}
+	return triggers, rows.Err()
+}
+
+// decodeTriggerType unpacks pg_trigger.tgtype into timing, events and level
+func decodeTriggerType(tgtype int) (string, []string, string) {
+	level := "STATEMENT"
+	if tgtype&1 != 0 {
+		level = "ROW"
+	}
+	timing := "AFTER"
+	switch {
+	case tgtype&2 != 0:
+		timing = "BEFORE"
+	case tgtype&64 != 0:
+		timing = "INSTEAD OF"
+	}
+	var events []string
+	for _, ev := range []struct {
+		bit  int
+		name string
+	}{{4, "INSERT"}, {8, "DELETE"}, {16, "UPDATE"}, {32, "TRUNCATE"}} {
+		if tgtype&ev.bit != 0 {
+			events = append(events, ev.name)
+		}
+	}
+	return timing, events, level
+}
+
+func loadPartitioning(ctx context.Context, q queryer, oid uint32, isParent, isPartition bool) (*PartitionInfo, error) {
+	info := &PartitionInfo{}
+	if isParent {
+		var strategy string
+		err := q.QueryRowContext(ctx, `
+                SELECT pt.partstrat::text, pg_get_partkeydef(pt.partrelid)
+                FROM pg_partitioned_table pt
+                WHERE pt.partrelid = $1
+        `, oid).Scan(&strategy, &info.Key)
+		if err != nil {
+			return nil, err
+		}
+		info.Strategy = map[string]string{"r": "range", "l": "list", "h": "hash"}[strategy]
+
+		rows, err := q.QueryContext(ctx, `
+                SELECT n.nspname || '.' || c.relname, COALESCE(pg_get_expr(c.relpartbound, c.oid), '')
+                FROM pg_inherits i
+                JOIN pg_class c ON c.oid = i.inhrelid
+                JOIN pg_namespace n ON n.oid = c.relnamespace
+                WHERE i.inhparent = $1
+                ORDER BY 1
+        `, oid)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var b PartitionBound
+			if err := rows.Scan(&b.Table, &b.Bound); err != nil {
+				return nil, err
+			}
+			info.Partitions = append(info.Partitions, b)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if isPartition {
+		var b PartitionBound
+		err := q.QueryRowContext(ctx, `
+                SELECT n.nspname || '.' || p.relname, COALESCE(pg_get_expr(c.relpartbound, c.oid), '')
+                FROM pg_inherits i
+                JOIN pg_class p ON p.oid = i.inhparent
+                JOIN pg_namespace n ON n.oid = p.relnamespace
+                JOIN pg_class c ON c.oid = i.inhrelid
+                WHERE i.inhrelid = $1
+        `, oid).Scan(&b.Table, &b.Bound)
+		if err != nil {
+			return nil, err
+		}
+		info.Parent = &b
+	}
+	return info, nil
+}
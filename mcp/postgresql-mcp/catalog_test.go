@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// catalogAnswer serves a users table with a self-referencing key and an
+// incoming key from orders
+func catalogAnswer(query string, args []driver.NamedValue) (*fakeResult, error) {
+	switch {
+	case strings.Contains(query, "FROM pg_class c\n") && strings.Contains(query, "relispartition"):
+		if args[1].Value != "users" {
+			return &fakeResult{columns: []string{"oid", "relkind", "relispartition", "comment"}}, nil
+		}
+		return &fakeResult{
+			columns: []string{"oid", "relkind", "relispartition", "comment"},
+			rows:    [][]driver.Value{{int64(42), "r", false, "application users"}},
+		}, nil
+	case strings.Contains(query, "FROM pg_attribute a"):
+		return &fakeResult{
+			columns: []string{"attname", "attnum", "type", "typname", "nullable", "default", "comment", "identity", "generated", "enums"},
+			rows: [][]driver.Value{
+				{"id", int64(1), "bigint", "int8", false, nil, nil, "a", "", []byte("{}")},
+				{"email", int64(2), "character varying(255)", "varchar", false, nil, "login address", "", "", []byte("{}")},
+				{"role", int64(3), "user_role", "user_role", true, "'member'::user_role", nil, "", "", []byte("{admin,member}")},
+				{"manager_id", int64(4), "bigint", "int8", true, nil, nil, "", "", []byte("{}")},
+			},
+		}, nil
+	case strings.Contains(query, "FROM pg_constraint con"):
+		cols := []string{"conname", "contype", "def", "nspname", "relname", "conkey", "fnsp", "frel", "confkey", "upd", "del", "own"}
+		return &fakeResult{columns: cols, rows: [][]driver.Value{
+			{"orders_user_id_fkey", "f", "FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE", "public", "orders", []byte("{user_id}"), "public", "users", []byte("{id}"), "a", "c", false},
+			{"users_email_key", "u", "UNIQUE (email)", "public", "users", []byte("{email}"), "", "", []byte("{}"), " ", " ", true},
+			{"users_manager_id_fkey", "f", "FOREIGN KEY (manager_id) REFERENCES users(id)", "public", "users", []byte("{manager_id}"), "public", "users", []byte("{id}"), "a", "n", true},
+			{"users_pkey", "p", "PRIMARY KEY (id)", "public", "users", []byte("{id}"), "", "", []byte("{}"), " ", " ", true},
+			{"users_role_check", "c", "CHECK (role <> 'admin'::user_role OR manager_id IS NULL)", "public", "users", []byte("{role,manager_id}"), "", "", []byte("{}"), " ", " ", true},
+		}}, nil
+	case strings.Contains(query, "FROM pg_index i"):
+		return &fakeResult{
+			columns: []string{"relname", "unique", "primary", "amname", "def", "pred", "cols"},
+			rows: [][]driver.Value{
+				{"users_email_key", true, false, "btree", "CREATE UNIQUE INDEX users_email_key ON public.users USING btree (email)", nil, []byte("{email}")},
+				{"users_lower_email_idx", false, false, "btree", "CREATE INDEX users_lower_email_idx ON public.users USING btree (lower((email)::text)) WHERE (manager_id IS NOT NULL)", "(manager_id IS NOT NULL)", []byte("{lower((email)::text)}")},
+				{"users_pkey", true, true, "btree", "CREATE UNIQUE INDEX users_pkey ON public.users USING btree (id)", nil, []byte("{id}")},
+			},
+		}, nil
+	case strings.Contains(query, "FROM pg_trigger t"):
+		return &fakeResult{
+			columns: []string{"tgname", "tgtype", "proname", "tgenabled", "def"},
+			rows: [][]driver.Value{
+				// ROW | BEFORE | UPDATE
+				{"users_touch", int64(1 | 2 | 16), "touch_updated_at", "O", "CREATE TRIGGER users_touch BEFORE UPDATE ON public.users FOR EACH ROW EXECUTE FUNCTION touch_updated_at()"},
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+func TestDescribeTableHandler(t *testing.T) {
+	d := withFakeDB(t, "app", catalogAnswer)
+
+	resp := describeTableHandler(map[string]interface{}{"table": "users"})
+	r := decodeResponse(t, resp)
+	if !r.OK {
+		t.Fatalf("describe failed: %s", responseText(t, resp))
+	}
+
+	raw, _ := json.Marshal(r.Data)
+	var info TableInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if info.Schema != "public" || info.Kind != "table" || info.Comment == nil || *info.Comment != "application users" {
+		t.Errorf("unexpected table header: %+v", info)
+	}
+	if len(info.Columns) != 4 || info.Columns[0].Identity != "ALWAYS" || info.Columns[1].Comment == nil {
+		t.Errorf("unexpected columns: %+v", info.Columns)
+	}
+	if got := info.Columns[2].EnumValues; len(got) != 2 || got[0] != "admin" {
+		t.Errorf("unexpected enum values: %v", got)
+	}
+	if info.PrimaryKey == nil || info.PrimaryKey.Columns[0] != "id" {
+		t.Errorf("unexpected primary key: %+v", info.PrimaryKey)
+	}
+	if len(info.UniqueConstraints) != 1 || len(info.CheckConstraints) != 1 || len(info.CheckConstraints[0].Columns) != 2 {
+		t.Errorf("unexpected constraints: %+v %+v", info.UniqueConstraints, info.CheckConstraints)
+	}
+
+	if len(info.ForeignKeys) != 1 || info.ForeignKeys[0].Name != "users_manager_id_fkey" || info.ForeignKeys[0].OnDelete != "SET NULL" {
+		t.Errorf("unexpected outgoing keys: %+v", info.ForeignKeys)
+	}
+	if len(info.ReferencedBy) != 2 {
+		t.Fatalf("expected incoming keys from orders and the self reference, got %+v", info.ReferencedBy)
+	}
+	if fk := info.ReferencedBy[0]; fk.Table != "orders" || fk.Columns[0] != "user_id" || fk.OnDelete != "CASCADE" {
+		t.Errorf("unexpected incoming key: %+v", fk)
+	}
+
+	if len(info.Indexes) != 3 || info.Indexes[1].Predicate == nil || info.Indexes[1].Columns[0] != "lower((email)::text)" {
+		t.Errorf("unexpected indexes: %+v", info.Indexes)
+	}
+	if len(info.Triggers) != 1 {
+		t.Fatalf("unexpected triggers: %+v", info.Triggers)
+	}
+	if tr := info.Triggers[0]; tr.Timing != "BEFORE" || tr.Level != "ROW" || len(tr.Events) != 1 || tr.Events[0] != "UPDATE" || !tr.Enabled {
+		t.Errorf("unexpected trigger: %+v", tr)
+	}
+	if info.Partitioning != nil {
+		t.Errorf("plain table should not report partitioning: %+v", info.Partitioning)
+	}
+
+	if !containsStatement(d.statements(), "BEGIN READ ONLY") {
+		t.Errorf("expected catalog reads in a read-only transaction: %v", d.statements())
+	}
+}
+
+func TestDescribeTableNotFound(t *testing.T) {
+	withFakeDB(t, "app", catalogAnswer)
+
+	resp := describeTableHandler(map[string]interface{}{"table": "sales.missing"})
+	if r := decodeResponse(t, resp); r.OK || !strings.Contains(r.Error, "sales.missing") {
+		t.Errorf("expected not found error, got %s", responseText(t, resp))
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConfirmThreshold = 100
+	confirmTokenTTL         = 5 * time.Minute
+	defaultDryRunSample     = 5
+)
+
+// confirmThreshold is the most rows an update or delete may affect without a
+// confirmation token from a matching dry run
+var confirmThreshold = defaultConfirmThreshold
+
+// fingerprint identifies the statement a confirmation token was issued for
+func (m *mutation) fingerprint(database string) string {
+	b, _ := json.Marshal([]interface{}{m.tool, database, m.query, m.values})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// preview runs the statement and returns how many rows it affected with up to
+// sample rows before and after the change. The caller rolls it back. Both
+// samples are taken in primary key order so that they hold the same rows,
+// unless the update changes the key or the table has none.
+func (m *mutation) preview(ctx context.Context, q queryer, sample int) (before, after *rowSet, affected int, err error) {
+	keys, err := primaryKeyColumns(ctx, q, m.table)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	order := ""
+	if len(keys) > 0 {
+		quoted := make([]string, len(keys))
+		for i, k := range keys {
+			if quoted[i], err = validateIdentifier(k); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		order = " ORDER BY " + strings.Join(quoted, ", ")
+	}
+
+	// A data-modifying CTE runs to completion, so the window count covers
+	// every affected row while only the sample is read back
+	changed := fmt.Sprintf("WITH mcp_changed AS (%s RETURNING *) SELECT count(*) OVER () AS mcp_affected, * FROM mcp_changed%s LIMIT %d", m.query, order, sample)
+
+	if m.tool == "delete" {
+		rs, err := queryRowSet(ctx, q, changed, m.values...)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		affected, before = splitAffected(rs)
+		return before, nil, affected, nil
+	}
+
+	before, err = queryRowSet(ctx, q, fmt.Sprintf("SELECT * FROM %s WHERE %s%s LIMIT %d", m.table, m.where, order, sample), m.whereValues...)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	rs, err := queryRowSet(ctx, q, changed, m.values...)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	affected, after = splitAffected(rs)
+	return before, after, affected, nil
+}
+
+// splitAffected removes the leading window count from a preview result
+func splitAffected(rs *rowSet) (int, *rowSet) {
+	rest := &rowSet{}
+	if len(rs.columns) > 0 {
+		rest.columns = rs.columns[1:]
+	}
+	if len(rs.types) > 0 {
+		rest.types = rs.types[1:]
+	}
+	affected := 0
+	for i, row := range rs.rows {
+		if i == 0 {
+			if n, ok := row[0].(int64); ok {
+				affected = int(n)
+			}
+		}
+		rest.rows = append(rest.rows, row[1:])
+	}
+	return affected, rest
+}
+
+// confirmationError rejects a call that affected more rows than it may
+type confirmationError struct {
+	Affected  int `json:"affected"`
+	Threshold int `json:"threshold"`
+	// Confirmed is the count of the dry run whose token was presented
+	Confirmed *int `json:"confirmed,omitempty"`
+}
+
+func (e *confirmationError) Error() string {
+	if e.Confirmed != nil {
+		return fmt.Sprintf("statement affects %d rows, more than the %d confirmed by the dry run; run dry_run again", e.Affected, *e.Confirmed)
+	}
+	return fmt.Sprintf("statement affects %d rows, more than the confirmation threshold of %d; run it with dry_run and pass the returned confirmation_token", e.Affected, e.Threshold)
+}
+
+// confirmation is an issued token for one previewed statement
+type confirmation struct {
+	fingerprint string
+	affected    int
+	expires     time.Time
+}
+
+// ConfirmManager tracks confirmation tokens issued by dry runs
+type ConfirmManager struct {
+	mu     sync.Mutex
+	tokens map[string]*confirmation
+}
+
+func NewConfirmManager() *ConfirmManager {
+	return &ConfirmManager{tokens: make(map[string]*confirmation)}
+}
+
+var confirmManager = NewConfirmManager()
+
+// Issue returns a token confirming fingerprint for up to affected rows
+func (m *ConfirmManager) Issue(fingerprint string, affected int) (string, time.Time, error) {
+	token, err := newHandle("cfm_")
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate confirmation token: %w", err)
+	}
+	now := time.Now()
+	expires := now.Add(confirmTokenTTL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for t, c := range m.tokens {
+		if now.After(c.expires) {
+			delete(m.tokens, t)
+		}
+	}
+	m.tokens[token] = &confirmation{fingerprint: fingerprint, affected: affected, expires: expires}
+	return token, expires, nil
+}
+
+// Check returns the confirmation behind token when it was issued for
+// fingerprint and has not expired
+func (m *ConfirmManager) Check(token, fingerprint string) (*confirmation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.tokens[token]
+	if !ok {
+		return nil, fmt.Errorf("unknown or already used confirmation token: %s", token)
+	}
+	if time.Now().After(c.expires) {
+		delete(m.tokens, token)
+		return nil, fmt.Errorf("confirmation token %s expired; run dry_run again", token)
+	}
+	if c.fingerprint != fingerprint {
+		return nil, fmt.Errorf("confirmation token %s was issued for a different statement", token)
+	}
+	return c, nil
+}
+
+// Consume invalidates token once the confirmed statement has run
+func (m *ConfirmManager) Consume(token string) {
+	m.mu.Lock()
+	delete(m.tokens, token)
+	m.mu.Unlock()
+}
+
+// dryRun previews mut in a call that is always rolled back and issues a
+// confirmation token for it
+func dryRun(t *target, timeoutMs *int, mut *mutation, sample int) map[string]interface{} {
+	var before, after *rowSet
+	var affected int
+	err := t.run(timeoutMs, modeRollback, func(ctx context.Context, q queryer) error {
+		var err error
+		before, after, affected, err = mut.preview(ctx, q, sample)
+		return err
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Dry run failed: %s", err))
+	}
+	maskTableRows(t.database, mut.name, before.columns, before.rows)
+	if after != nil {
+		maskTableRows(t.database, mut.name, after.columns, after.rows)
+	}
+
+	token, expires, err := confirmManager.Issue(mut.fingerprint(t.database), affected)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	data := map[string]interface{}{
+		"dry_run":               true,
+		"affected":              affected,
+		"threshold":             confirmThreshold,
+		"confirmation_required": affected > confirmThreshold,
+		"confirmation_token":    token,
+		"expires_at":            expires.UTC().Format(time.RFC3339),
+		"before":                rowMaps(before),
+	}
+	if after != nil {
+		data["after"] = rowMaps(after)
+	}
+	return okResponse(data, &affected)
+}
+
+// rowMaps is rs.maps() with an empty list instead of nil
+func rowMaps(rs *rowSet) []map[string]interface{} {
+	if maps := rs.maps(); maps != nil {
+		return maps
+	}
+	return []map[string]interface{}{}
+}
+
+// sampleSizeArg reads sample_size, defaulting to defaultDryRunSample and
+// capped at maxPageSize
+func sampleSizeArg(args map[string]interface{}) int {
+	sample := defaultDryRunSample
+	if s, exists := args["sample_size"]; exists {
+		if sFloat, ok := s.(float64); ok && sFloat > 0 {
+			sample = int(sFloat)
+		}
+	}
+	if sample > maxPageSize {
+		sample = maxPageSize
+	}
+	return sample
+}
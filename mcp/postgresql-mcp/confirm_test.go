@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// withConfirmThreshold sets confirmThreshold for one test
+func withConfirmThreshold(t *testing.T, n int) {
+	t.Helper()
+	prev := confirmThreshold
+	confirmThreshold = n
+	t.Cleanup(func() { confirmThreshold = prev })
+}
+
+// previewAnswer answers dry-run previews and reports affected rows for writes
+func previewAnswer(affected int64) func(query string, args []driver.NamedValue) (*fakeResult, error) {
+	return func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		switch {
+		case strings.Contains(query, "indisprimary"):
+			return &fakeResult{columns: []string{"attname"}, rows: [][]driver.Value{{"id"}}}, nil
+		case strings.HasPrefix(query, "WITH mcp_changed AS"):
+			return &fakeResult{
+				columns: []string{"mcp_affected", "id", "status"},
+				rows:    [][]driver.Value{{affected, int64(1), "archived"}, {affected, int64(2), "archived"}},
+			}, nil
+		case strings.HasPrefix(query, "SELECT * FROM"):
+			return &fakeResult{
+				columns: []string{"id", "status"},
+				rows:    [][]driver.Value{{int64(1), "open"}, {int64(2), "open"}},
+			}, nil
+		}
+		return &fakeResult{affected: affected}, nil
+	}
+}
+
+func TestUpdateDryRunPreviewsAndRollsBack(t *testing.T) {
+	withConfirmThreshold(t, 10)
+	d := withFakeDB(t, "primary_db", previewAnswer(250))
+
+	r := decodeResponse(t, updateHandler(map[string]interface{}{
+		"table":       "orders",
+		"data":        map[string]interface{}{"status": "archived", "note": "bulk"},
+		"where":       map[string]interface{}{"column": "status", "op": "=", "value": "open"},
+		"dry_run":     true,
+		"sample_size": float64(2),
+	}))
+	if !r.OK || *r.RowCount != 250 {
+		t.Fatalf("dry run failed: %+v", r)
+	}
+	data := r.Data.(map[string]interface{})
+	if data["confirmation_required"] != true || !strings.HasPrefix(data["confirmation_token"].(string), "cfm_") {
+		t.Errorf("expected a required confirmation token: %+v", data)
+	}
+	before := data["before"].([]interface{})
+	after := data["after"].([]interface{})
+	if len(before) != 2 || before[0].(map[string]interface{})["status"] != "open" {
+		t.Errorf("unexpected before sample: %+v", before)
+	}
+	if len(after) != 2 || after[0].(map[string]interface{})["status"] != "archived" || after[0].(map[string]interface{})["mcp_affected"] != nil {
+		t.Errorf("unexpected after sample: %+v", after)
+	}
+
+	stmts := d.statements()
+	for _, want := range []string{
+		`SELECT * FROM "orders" WHERE "status" = $1 ORDER BY "id" LIMIT 2`,
+		`WITH mcp_changed AS (UPDATE "orders" SET "note" = $1, "status" = $2 WHERE "status" = $3 RETURNING *) SELECT count(*) OVER () AS mcp_affected, * FROM mcp_changed ORDER BY "id" LIMIT 2`,
+		"ROLLBACK",
+	} {
+		if !containsStatement(stmts, want) {
+			t.Errorf("expected statement %q in %v", want, stmts)
+		}
+	}
+	if containsStatement(stmts, "COMMIT") {
+		t.Errorf("a dry run must not commit: %v", stmts)
+	}
+}
+
+func TestDeleteNeedsConfirmationAboveThreshold(t *testing.T) {
+	withConfirmThreshold(t, 10)
+	d := withFakeDB(t, "primary_db", previewAnswer(250))
+	args := map[string]interface{}{
+		"table": "orders",
+		"where": map[string]interface{}{"status": "archived"},
+	}
+
+	r := decodeResponse(t, deleteHandler(args))
+	if r.OK || !strings.Contains(r.Error, "more than the confirmation threshold of 10") {
+		t.Fatalf("expected the delete to be rolled back: %+v", r)
+	}
+	if detail := r.Detail.(map[string]interface{}); detail["affected"].(float64) != 250 {
+		t.Errorf("unexpected detail: %+v", detail)
+	}
+	if containsStatement(d.statements(), "COMMIT") {
+		t.Fatalf("expected no commit: %v", d.statements())
+	}
+
+	preview := decodeResponse(t, deleteHandler(map[string]interface{}{
+		"table":   "orders",
+		"where":   map[string]interface{}{"status": "archived"},
+		"dry_run": true,
+	}))
+	data := preview.Data.(map[string]interface{})
+	if _, ok := data["after"]; ok || len(data["before"].([]interface{})) != 2 {
+		t.Errorf("a delete preview has only the deleted rows: %+v", data)
+	}
+	token := data["confirmation_token"].(string)
+
+	other := decodeResponse(t, deleteHandler(map[string]interface{}{
+		"table":              "orders",
+		"where":              map[string]interface{}{"status": "open"},
+		"confirmation_token": token,
+	}))
+	if other.OK || !strings.Contains(other.Error, "different statement") {
+		t.Errorf("expected the token to be bound to its statement: %+v", other)
+	}
+
+	args["confirmation_token"] = token
+	if r := decodeResponse(t, deleteHandler(args)); !r.OK || *r.RowCount != 250 {
+		t.Fatalf("confirmed delete failed: %+v", r)
+	}
+	if !containsStatement(d.statements(), "COMMIT") {
+		t.Errorf("expected the confirmed delete to commit: %v", d.statements())
+	}
+	if r := decodeResponse(t, deleteHandler(args)); r.OK || !strings.Contains(r.Error, "already used") {
+		t.Errorf("expected the token to be single-use: %+v", r)
+	}
+}
+
+func TestConfirmedCountCapsExecution(t *testing.T) {
+	withConfirmThreshold(t, 10)
+	var affected int64 = 50
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		return previewAnswer(affected)(query, args)
+	})
+	args := map[string]interface{}{
+		"table": "orders",
+		"data":  map[string]interface{}{"status": "archived"},
+		"where": map[string]interface{}{"id": []interface{}{float64(1), float64(2)}},
+	}
+
+	args["dry_run"] = true
+	token := decodeResponse(t, updateHandler(args)).Data.(map[string]interface{})["confirmation_token"].(string)
+	delete(args, "dry_run")
+
+	// More rows matched since the preview
+	affected = 80
+	args["confirmation_token"] = token
+	r := decodeResponse(t, updateHandler(args))
+	if r.OK || !strings.Contains(r.Error, "more than the 50 confirmed by the dry run") {
+		t.Errorf("expected the confirmed count to cap the update: %+v", r)
+	}
+}
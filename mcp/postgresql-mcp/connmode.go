@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ConnectionMode says whether a connection may change data
+type ConnectionMode string
+
+const (
+	connReadWrite ConnectionMode = "read-write"
+	connReadOnly  ConnectionMode = "read-only"
+)
+
+// parseConnectionMode accepts read-only or read-write; empty means read-write
+func parseConnectionMode(s string) (ConnectionMode, error) {
+	switch ConnectionMode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", connReadWrite:
+		return connReadWrite, nil
+	case connReadOnly:
+		return connReadOnly, nil
+	}
+	return "", fmt.Errorf("invalid connection mode %q (want read-only or read-write)", s)
+}
+
+// modeArg reads the optional mode argument of a tool call
+func modeArg(args map[string]interface{}) (ConnectionMode, error) {
+	m, _ := args["mode"].(string)
+	return parseConnectionMode(m)
+}
+
+// splitDSNMode removes the mode query parameter from a URL DSN of the CLI
+// list, e.g. postgresql://host/analytics_db?mode=read-only
+func splitDSNMode(dsn string) (string, ConnectionMode, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.RawQuery == "" {
+		return dsn, connReadWrite, nil
+	}
+	q := u.Query()
+	if !q.Has("mode") {
+		return dsn, connReadWrite, nil
+	}
+	mode, err := parseConnectionMode(q.Get("mode"))
+	if err != nil {
+		return "", "", err
+	}
+	q.Del("mode")
+	u.RawQuery = q.Encode()
+	return u.String(), mode, nil
+}
+
+// readOnlyDSN makes every physical connection opened from dsn start with
+// default_transaction_read_only = on. lib/pq sends parameters it does not
+// know as run-time settings in the startup message. The guard only lets URL
+// DSNs through.
+func readOnlyDSN(dsn string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "", fmt.Errorf("read-only connections need a postgres:// or postgresql:// URL")
+	}
+	q := u.Query()
+	q.Set("default_transaction_read_only", "on")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// connectionConfig is one entry of the connections config file
+type connectionConfig struct {
+	Name string `json:"name"`
+	DSN  string `json:"dsn"`
+	// Mode is read-only or read-write; empty means read-write
+	Mode string `json:"mode,omitempty"`
+}
+
+// loadConnectionsConfig reads a JSON file of named connections:
+// {"connections": [{"name": "...", "dsn": "...", "mode": "read-only"}]}
+func loadConnectionsConfig(file string) ([]connectionConfig, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read connections config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var cfg struct {
+		Connections []connectionConfig `json:"connections"`
+	}
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse connections config %s: %w", file, err)
+	}
+	seen := map[string]bool{}
+	for i, c := range cfg.Connections {
+		if c.Name == "" || c.DSN == "" {
+			return nil, fmt.Errorf("parse connections config %s: connections[%d]: name and dsn are required", file, i)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("parse connections config %s: duplicate connection %q", file, c.Name)
+		}
+		seen[c.Name] = true
+		if _, err := parseConnectionMode(c.Mode); err != nil {
+			return nil, fmt.Errorf("parse connections config %s: connections[%d]: %w", file, i, err)
+		}
+	}
+	return cfg.Connections, nil
+}
+
+// checkWritable refuses mutating tools on read-only connections before
+// anything is sent to the server
+func checkWritable(database string) error {
+	if dbManager.Mode(database) == connReadOnly {
+		return fmt.Errorf("connection %s is read-only", database)
+	}
+	return nil
+}
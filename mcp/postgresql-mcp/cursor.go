@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
+)
+
+const (
+	defaultMaxPageSize       = 1000
+	defaultCursorIdleTimeout = 2 * time.Minute
+)
+
+// maxPageSize is the default and largest page returned by query and fetch_more
+var maxPageSize = defaultMaxPageSize
+
+// cursor is a server-side cursor declared in a private read-only transaction
+// that ends with the cursor
+type cursor struct {
+	token    string
+	name     string
+	session  string
+	database string
+	// text is the query, which masking traces result columns through
+	text string
+	// remaining is how many rows the caller's limit still allows, or -1
+	remaining int
+	// columns and types describe the result, known after the first fetch
+	columns []string
+	types   []pgvalue.Column
+	// pending holds the row read ahead to learn whether another page exists
+	pending [][]interface{}
+}
+
+// CursorManager tracks open cursors by continuation token
+type CursorManager struct {
+	mu      sync.Mutex
+	cursors map[string]*cursor
+}
+
+func NewCursorManager() *CursorManager {
+	return &CursorManager{cursors: make(map[string]*cursor)}
+}
+
+var cursorManager = NewCursorManager()
+
+// Open runs text as a cursor in a new read-only transaction on db and reads
+// the first page. The token is empty when no rows are left, in which case the
+// transaction has already ended.
+func (m *CursorManager) Open(database string, db *sql.DB, text string, params []interface{}, limit, pageSize int, idleTimeout time.Duration, timeoutMs *int) (*rowSet, string, error) {
+	token, err := newHandle("cur_")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate cursor token: %w", err)
+	}
+	s, err := txManager.begin(database, db, &sql.TxOptions{ReadOnly: true}, idleTimeout, true)
+	if err != nil {
+		return nil, "", err
+	}
+	if s, err = txManager.Acquire(s.id); err != nil {
+		return nil, "", err
+	}
+
+	c := &cursor{
+		token:     token,
+		name:      "mcp_" + token,
+		session:   s.id,
+		database:  database,
+		text:      text,
+		remaining: -1,
+	}
+	if limit > 0 {
+		c.remaining = limit
+	}
+
+	ctx, cancel := timeoutContext(timeoutMs)
+	defer cancel()
+
+	var page *rowSet
+	var more bool
+	if err = declareCursor(ctx, s.tx, c.name, text, params); err == nil {
+		page, more, err = c.fetch(ctx, s.tx, pageSize)
+	}
+	s.Release()
+	if err != nil || !more {
+		txManager.Rollback(s.id)
+		return page, "", err
+	}
+
+	m.mu.Lock()
+	m.cursors[token] = c
+	m.mu.Unlock()
+	return page, token, nil
+}
+
+// Fetch reads the next page of the cursor behind token
+func (m *CursorManager) Fetch(token string, pageSize int, timeoutMs *int) (*rowSet, string, error) {
+	c, err := m.lookup(token)
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := txManager.Acquire(c.session)
+	if err != nil {
+		m.forget(token)
+		return nil, "", fmt.Errorf("cursor %s expired: %w", token, err)
+	}
+
+	ctx, cancel := timeoutContext(timeoutMs)
+	defer cancel()
+
+	page, more, err := c.fetch(ctx, s.tx, pageSize)
+	s.Release()
+	if err != nil || !more {
+		m.forget(token)
+		txManager.Rollback(c.session)
+		return page, "", err
+	}
+	return page, token, nil
+}
+
+// Close discards the cursor behind token
+func (m *CursorManager) Close(token string) error {
+	c, err := m.lookup(token)
+	if err != nil {
+		return err
+	}
+	m.forget(token)
+	if err := txManager.Rollback(c.session); err != nil {
+		// The idle timer may have ended the transaction already
+		logger.Printf("Closing cursor %s: %s", token, err)
+	}
+	return nil
+}
+
+func (m *CursorManager) lookup(token string) (*cursor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.cursors[token]
+	if !ok {
+		return nil, fmt.Errorf("no such cursor: %s (it may have been exhausted, closed or expired)", token)
+	}
+	return c, nil
+}
+
+func (m *CursorManager) forget(token string) {
+	m.mu.Lock()
+	delete(m.cursors, token)
+	m.mu.Unlock()
+}
+
+// forgetSession drops every cursor of a transaction that is ending
+func (m *CursorManager) forgetSession(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, c := range m.cursors {
+		if c.session == id {
+			delete(m.cursors, token)
+		}
+	}
+}
+
+// declareCursor declares name for text. DECLARE only accepts SELECT, VALUES
+// and TABLE without data-modifying CTEs.
+func declareCursor(ctx context.Context, q queryer, name, text string, params []interface{}) error {
+	_, err := q.ExecContext(ctx, "DECLARE "+name+" NO SCROLL CURSOR FOR "+text, params...)
+	return err
+}
+
+// fetch returns up to pageSize rows and whether more rows remain
+func (c *cursor) fetch(ctx context.Context, q queryer, pageSize int) (*rowSet, bool, error) {
+	want := pageSize
+	if c.remaining >= 0 && c.remaining < want {
+		want = c.remaining
+	}
+	rows := c.pending
+	c.pending = nil
+	if n := want + 1 - len(rows); n > 0 {
+		fetched, err := queryRowSet(ctx, q, fmt.Sprintf("FETCH FORWARD %d FROM %s", n, c.name))
+		if err != nil {
+			return nil, false, err
+		}
+		c.columns, c.types = fetched.columns, fetched.types
+		rows = append(rows, fetched.rows...)
+	}
+
+	more := len(rows) > want
+	if more {
+		c.pending = rows[want : want+1]
+		rows = rows[:want]
+	}
+	if c.remaining >= 0 {
+		c.remaining -= len(rows)
+		if c.remaining == 0 {
+			more = false
+		}
+	}
+	maskQueryRows(c.database, c.text, c.columns, rows)
+	return &rowSet{columns: c.columns, types: c.types, rows: rows}, more, nil
+}
+
+// readPage reads a single page through a cursor that does not outlive q.
+// Inside a caller's transaction the read-only savepoint is rolled back after
+// the call, so no continuation is possible; truncated reports dropped rows.
+func readPage(ctx context.Context, q queryer, database, text string, params []interface{}, limit, pageSize int) (*rowSet, bool, error) {
+	c := &cursor{name: "mcp_page", database: database, text: text, remaining: -1}
+	if limit > 0 {
+		c.remaining = limit
+	}
+	if err := declareCursor(ctx, q, c.name, text, params); err != nil {
+		return nil, false, err
+	}
+	return c.fetch(ctx, q, pageSize)
+}
+
+// pageSizeArg reads page_size, defaulting to and capped at maxPageSize
+func pageSizeArg(args map[string]interface{}) int {
+	pageSize := maxPageSize
+	if p, exists := args["page_size"]; exists {
+		if pFloat, ok := p.(float64); ok && pFloat > 0 && int(pFloat) < maxPageSize {
+			pageSize = int(pFloat)
+		}
+	}
+	return pageSize
+}
+
+func fetchMoreHandler(args map[string]interface{}) map[string]interface{} {
+	token, ok := args["cursor"].(string)
+	if !ok || token == "" {
+		return errResponse("cursor is required")
+	}
+
+	outputFormat, err := formatArg(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	page, next, err := cursorManager.Fetch(token, pageSizeArg(args), timeoutMs)
+	if err != nil {
+		return errResponse(fmt.Sprintf("Fetch failed: %s", err))
+	}
+	return okRowsResponse(outputFormat, page, next, false)
+}
+
+func closeCursorHandler(args map[string]interface{}) map[string]interface{} {
+	token, ok := args["cursor"].(string)
+	if !ok || token == "" {
+		return errResponse("cursor is required")
+	}
+	if err := cursorManager.Close(token); err != nil {
+		return errResponse(err.Error())
+	}
+	return okResponse(map[string]string{"cursor": token, "status": "closed"}, nil)
+}
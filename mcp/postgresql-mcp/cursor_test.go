@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cursorAnswer serves total rows through DECLARE/FETCH, tracking the position
+func cursorAnswer(total int) func(query string, args []driver.NamedValue) (*fakeResult, error) {
+	var mu sync.Mutex
+	pos := 0
+	return func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(query, "DECLARE ") {
+			pos = 0
+			return nil, nil
+		}
+		var n int
+		var name string
+		if _, err := fmt.Sscanf(query, "FETCH FORWARD %d FROM %s", &n, &name); err != nil {
+			return nil, nil
+		}
+		res := &fakeResult{columns: []string{"id"}}
+		for ; n > 0 && pos < total; n-- {
+			pos++
+			res.rows = append(res.rows, []driver.Value{int64(pos)})
+		}
+		return res, nil
+	}
+}
+
+func TestQueryPagesThroughCursor(t *testing.T) {
+	d := withFakeDB(t, "primary_db", cursorAnswer(5))
+
+	first := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT id FROM users ORDER BY id;", "page_size": float64(2)}))
+	if !first.OK || *first.RowCount != 2 || first.Cursor == "" {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	if !containsStatement(d.statements(), "NO SCROLL CURSOR FOR SELECT id FROM users ORDER BY id") || containsStatement(d.statements(), "ORDER BY id;") {
+		t.Errorf("expected the statement without its trailing semicolon to be declared: %v", d.statements())
+	}
+	if !containsStatement(d.statements(), "BEGIN READ ONLY") {
+		t.Errorf("expected a read-only transaction: %v", d.statements())
+	}
+	if len(txManager.List()) != 0 {
+		t.Errorf("cursor transactions should not be listed: %v", txManager.List())
+	}
+
+	second := decodeResponse(t, fetchMoreHandler(map[string]interface{}{"cursor": first.Cursor, "page_size": float64(2)}))
+	if !second.OK || *second.RowCount != 2 || second.Cursor != first.Cursor {
+		t.Fatalf("unexpected second page: %+v", second)
+	}
+	last := decodeResponse(t, fetchMoreHandler(map[string]interface{}{"cursor": first.Cursor, "page_size": float64(2)}))
+	if !last.OK || *last.RowCount != 1 || last.Cursor != "" {
+		t.Fatalf("unexpected last page: %+v", last)
+	}
+	if id := last.Data.([]interface{})[0].(map[string]interface{})["id"].(float64); id != 5 {
+		t.Errorf("expected the read-ahead row to be returned last, got %v", id)
+	}
+	if stmts := d.statements(); stmts[len(stmts)-1] != "ROLLBACK" {
+		t.Errorf("expected the cursor transaction to end, got %v", stmts)
+	}
+
+	if r := decodeResponse(t, fetchMoreHandler(map[string]interface{}{"cursor": first.Cursor})); r.OK {
+		t.Errorf("expected exhausted cursor to be gone")
+	}
+}
+
+func TestQueryLimitAndMaxPageSize(t *testing.T) {
+	withFakeDB(t, "primary_db", cursorAnswer(10))
+	prev := maxPageSize
+	maxPageSize = 3
+	t.Cleanup(func() { maxPageSize = prev })
+
+	first := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT * FROM user_events", "page_size": float64(50), "limit": float64(4)}))
+	if !first.OK || *first.RowCount != 3 || first.Cursor == "" {
+		t.Fatalf("expected page size to be capped at 3: %+v", first)
+	}
+	rest := decodeResponse(t, fetchMoreHandler(map[string]interface{}{"cursor": first.Cursor}))
+	if !rest.OK || *rest.RowCount != 1 || rest.Cursor != "" {
+		t.Fatalf("expected the limit to end the cursor after 4 rows: %+v", rest)
+	}
+}
+
+func TestCursorExpiresAndCloses(t *testing.T) {
+	withFakeDB(t, "primary_db", cursorAnswer(10))
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT 1", "page_size": float64(1), "cursor_idle_timeout_ms": float64(1)}))
+	if r.Cursor == "" {
+		t.Fatalf("expected a cursor: %+v", r)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := cursorManager.lookup(r.Cursor); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle cursor was not expired")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if f := decodeResponse(t, fetchMoreHandler(map[string]interface{}{"cursor": r.Cursor})); f.OK {
+		t.Errorf("expected expired cursor to fail")
+	}
+
+	r = decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT 1", "page_size": float64(1)}))
+	if c := decodeResponse(t, closeCursorHandler(map[string]interface{}{"cursor": r.Cursor})); !c.OK {
+		t.Fatalf("close failed: %s", c.Error)
+	}
+	if f := decodeResponse(t, fetchMoreHandler(map[string]interface{}{"cursor": r.Cursor})); f.OK {
+		t.Errorf("expected closed cursor to fail")
+	}
+}
+
+func TestQueryInTransactionReturnsOnePage(t *testing.T) {
+	d := withFakeDB(t, "primary_db", cursorAnswer(5))
+	begin := decodeResponse(t, beginTransactionHandler(map[string]interface{}{"database": "primary_db"}))
+	id := begin.Data.(map[string]interface{})["transaction"].(string)
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT id FROM users", "page_size": float64(2), "transaction": id}))
+	if !r.OK || *r.RowCount != 2 || r.Cursor != "" || !r.Truncated {
+		t.Fatalf("expected one truncated page without a cursor: %+v", r)
+	}
+	stmts := d.statements()
+	for _, want := range []string{"SET LOCAL transaction_read_only = on", "ROLLBACK TO SAVEPOINT mcp_stmt"} {
+		if !containsStatement(stmts, want) {
+			t.Errorf("expected %q in %v", want, stmts)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
+)
+
+const (
+	defaultDiffChunkSize = 1000
+	defaultDiffMaxRows   = 100
+)
+
+// diffTable is one side of data_diff
+type diffTable struct {
+	Connection string `json:"connection"`
+	Table      string `json:"table"`
+
+	quoted string
+	types  map[string]string
+	ctx    context.Context
+	q      queryer
+}
+
+func dataDiffHandler(args map[string]interface{}) map[string]interface{} {
+	var left, right diffTable
+	if s, ok := args["left_database"].(string); ok {
+		left.Connection = s
+	}
+	left.Table, _ = args["left_table"].(string)
+	if left.Table == "" {
+		return errResponse("left_table is required")
+	}
+	right.Connection, right.Table = left.Connection, left.Table
+	if s, ok := args["right_database"].(string); ok && s != "" {
+		right.Connection = s
+	}
+	if s, ok := args["right_table"].(string); ok && s != "" {
+		right.Table = s
+	}
+
+	keys, err := stringList(args, "key_columns")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	columns, err := stringList(args, "columns")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	chunkSize := defaultDiffChunkSize
+	if c, exists := args["chunk_size"]; exists {
+		if cFloat, ok := c.(float64); ok {
+			chunkSize = int(cFloat)
+		}
+	}
+	if chunkSize < 1 {
+		return errResponse("chunk_size must be at least 1")
+	}
+	maxRows := defaultDiffMaxRows
+	if m, exists := args["max_rows"]; exists {
+		if mFloat, ok := m.(float64); ok && mFloat >= 0 {
+			maxRows = int(mFloat)
+		}
+	}
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	var targets [2]*target
+	for i, side := range []*diffTable{&left, &right} {
+		if side.quoted, err = qIdent(side.Table); err != nil {
+			return errResponse(err.Error())
+		}
+		t, err := resolveTarget(map[string]interface{}{}, side.Connection)
+		if err != nil {
+			return errResponse(err.Error())
+		}
+		defer t.release()
+		side.Connection = t.database
+		targets[i] = t
+		if err := checkAccess(t.database, side.Table, acl.Read); err != nil {
+			return accessDeniedResponse(err)
+		}
+	}
+	if left.Connection == right.Connection && left.Table == right.Table {
+		return errResponse("left and right are the same table; pass right_database or right_table")
+	}
+
+	d := &dataDiff{
+		left: &left, right: &right, keys: keys, columns: columns, chunkSize: chunkSize, maxRows: maxRows,
+		leftOnly: []string{}, rightOnly: []string{},
+		insertedRows: []map[string]interface{}{}, deletedRows: []map[string]interface{}{}, changedRows: []map[string]interface{}{},
+	}
+	// Each side reads one snapshot for the whole comparison
+	err = targets[0].run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+		left.ctx, left.q = ctx, q
+		return targets[1].run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+			right.ctx, right.q = ctx, q
+			// Timestamps are hashed as text, so both sides print them in UTC
+			for _, side := range []*diffTable{&left, &right} {
+				for _, stmt := range []string{"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ", "SET LOCAL TimeZone = 'UTC'"} {
+					if _, err := side.q.ExecContext(side.ctx, stmt); err != nil {
+						return err
+					}
+				}
+			}
+			return d.run()
+		})
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Data diff failed: %s", err))
+	}
+
+	count := d.inserted + d.deleted + d.changed
+	return okResponse(map[string]interface{}{
+		"left":               left,
+		"right":              right,
+		"key_columns":        d.keys,
+		"columns":            d.columns,
+		"left_only_columns":  d.leftOnly,
+		"right_only_columns": d.rightOnly,
+		"identical":          count == 0,
+		"summary": map[string]int{
+			"inserted":  d.inserted,
+			"deleted":   d.deleted,
+			"changed":   d.changed,
+			"unchanged": d.unchanged,
+		},
+		"chunks":           d.chunks,
+		"chunks_differing": d.chunksDiffering,
+		"inserted_rows":    d.insertedRows,
+		"deleted_rows":     d.deletedRows,
+		"changed_rows":     d.changedRows,
+		"truncated":        len(d.insertedRows) < d.inserted || len(d.deletedRows) < d.deleted || len(d.changedRows) < d.changed,
+	}, &count)
+}
+
+// dataDiff compares two tables by key. Key ranges holding up to chunkSize
+// rows on each side are compared by row count and a hash over the rows in
+// key order; only ranges that differ are compared row by row, by the hash of
+// each row, and only rows that differ are read in full.
+type dataDiff struct {
+	left, right *diffTable
+	keys        []string
+	columns     []string
+	chunkSize   int
+	maxRows     int
+
+	leftOnly, rightOnly        []string
+	chunks, chunksDiffering    int
+	inserted, deleted, changed int
+	unchanged                  int
+	insertedRows, deletedRows  []map[string]interface{}
+	changedRows                []map[string]interface{}
+
+	// SQL pieces: the quoted key columns, the key columns as text, the
+	// hash of a row and the quoted compared columns
+	keyList, keyTexts, rowHash, columnList string
+}
+
+func (d *dataDiff) run() error {
+	if err := d.prepare(); err != nil {
+		return err
+	}
+	var lo []string
+	for {
+		// The range ends at the chunkSize-th key of the left side after lo,
+		// or earlier when the right side holds more rows up to there
+		hi, err := d.boundary(d.left, lo, nil)
+		if err != nil {
+			return err
+		}
+		right, err := d.boundary(d.right, lo, hi)
+		if err != nil {
+			return err
+		}
+		if right != nil {
+			hi = right
+		}
+		if err := d.compareRange(lo, hi); err != nil {
+			return err
+		}
+		if hi == nil {
+			return nil
+		}
+		lo = hi
+	}
+}
+
+// prepare resolves the key and compared columns and builds the SQL pieces
+func (d *dataDiff) prepare() error {
+	var leftOrder []string
+	for _, side := range []*diffTable{d.left, d.right} {
+		order, types, err := tableColumnTypes(side.ctx, side.q, side.quoted)
+		if err != nil {
+			return fmt.Errorf("%s on %s: %w", side.Table, side.Connection, err)
+		}
+		if side == d.left {
+			leftOrder = order
+		}
+		side.types = types
+	}
+	if len(d.keys) == 0 {
+		keys, err := primaryKeyColumns(d.left.ctx, d.left.q, d.left.quoted)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("%s has no primary key; pass key_columns", d.left.Table)
+		}
+		d.keys = keys
+	}
+
+	for _, col := range leftOrder {
+		if _, ok := d.right.types[col]; !ok {
+			d.leftOnly = append(d.leftOnly, col)
+		}
+	}
+	for col := range d.right.types {
+		if _, ok := d.left.types[col]; !ok {
+			d.rightOnly = append(d.rightOnly, col)
+		}
+	}
+	sort.Strings(d.rightOnly)
+	if len(d.columns) == 0 {
+		for _, col := range leftOrder {
+			if _, ok := d.right.types[col]; ok {
+				d.columns = append(d.columns, col)
+			}
+		}
+	} else {
+		// Changed rows report their key from the compared columns
+		var missing []string
+		for _, k := range d.keys {
+			if !containsString(d.columns, k) {
+				missing = append(missing, k)
+			}
+		}
+		d.columns = append(missing, d.columns...)
+	}
+
+	var keyCols, keyTexts, cols []string
+	for i, col := range append(append([]string{}, d.keys...), d.columns...) {
+		quoted, err := validateIdentifier(col)
+		if err != nil {
+			return err
+		}
+		if _, ok := d.left.types[col]; !ok {
+			return fmt.Errorf("column %s is not in %s", col, d.left.Table)
+		}
+		if _, ok := d.right.types[col]; !ok {
+			return fmt.Errorf("column %s is not in %s", col, d.right.Table)
+		}
+		if i < len(d.keys) {
+			keyCols = append(keyCols, quoted)
+			keyTexts = append(keyTexts, quoted+"::text")
+		} else {
+			cols = append(cols, quoted)
+		}
+	}
+	d.keyList = strings.Join(keyCols, ", ")
+	d.keyTexts = strings.Join(keyTexts, ", ")
+	d.rowHash = "md5(ROW(" + strings.Join(cols, ", ") + ")::text)"
+	d.columnList = strings.Join(cols, ", ")
+	return nil
+}
+
+// keyParams appends key, key values as text, to args and returns the
+// placeholders casting them to the key types of side
+func (d *dataDiff) keyParams(side *diffTable, key []string, args []interface{}) (string, []interface{}) {
+	params := make([]string, len(key))
+	for i, v := range key {
+		args = append(args, v)
+		params[i] = fmt.Sprintf("$%d::%s", len(args), side.types[d.keys[i]])
+	}
+	return "(" + strings.Join(params, ", ") + ")", args
+}
+
+// rangeCondition returns the WHERE clause selecting keys in (lo, hi] of side
+// with its arguments; nil bounds are open. Rows with NULL keys are skipped.
+func (d *dataDiff) rangeCondition(side *diffTable, lo, hi []string) (string, []interface{}) {
+	conds := []string{"(" + d.keyList + ") IS NOT NULL"}
+	var args []interface{}
+	var params string
+	if lo != nil {
+		params, args = d.keyParams(side, lo, args)
+		conds = append(conds, "("+d.keyList+") > "+params)
+	}
+	if hi != nil {
+		params, args = d.keyParams(side, hi, args)
+		conds = append(conds, "("+d.keyList+") <= "+params)
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// boundary returns the key of the chunkSize-th row of side in (lo, hi], or
+// nil when there are fewer
+func (d *dataDiff) boundary(side *diffTable, lo, hi []string) ([]string, error) {
+	where, args := d.rangeCondition(side, lo, hi)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s OFFSET %d LIMIT 1",
+		d.keyTexts, side.quoted, where, d.keyList, d.chunkSize-1)
+	rows, err := side.q.QueryContext(side.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	key := make([]string, len(d.keys))
+	ptrs := make([]interface{}, len(key))
+	for i := range key {
+		ptrs[i] = &key[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	return key, rows.Err()
+}
+
+// compareRange compares the rows with keys in (lo, hi]
+func (d *dataDiff) compareRange(lo, hi []string) error {
+	d.chunks++
+	var counts [2]int
+	var hashes [2]string
+	for i, side := range []*diffTable{d.left, d.right} {
+		where, args := d.rangeCondition(side, lo, hi)
+		query := fmt.Sprintf("SELECT count(*), COALESCE(md5(string_agg(%s, '' ORDER BY %s)), '') FROM %s%s",
+			d.rowHash, d.keyList, side.quoted, where)
+		if err := side.q.QueryRowContext(side.ctx, query, args...).Scan(&counts[i], &hashes[i]); err != nil {
+			return err
+		}
+	}
+	if counts[0] == counts[1] && hashes[0] == hashes[1] {
+		d.unchanged += counts[0]
+		return nil
+	}
+	d.chunksDiffering++
+
+	leftHashes, leftKeys, err := d.rowHashes(d.left, lo, hi)
+	if err != nil {
+		return err
+	}
+	rightHashes, rightKeys, err := d.rowHashes(d.right, lo, hi)
+	if err != nil {
+		return err
+	}
+	var deleted, inserted, changed [][]string
+	for _, key := range leftKeys {
+		id := keyID(key)
+		rh, ok := rightHashes[id]
+		switch {
+		case !ok:
+			d.deleted++
+			if len(d.deletedRows)+len(deleted) < d.maxRows {
+				deleted = append(deleted, key)
+			}
+		case rh != leftHashes[id]:
+			d.changed++
+			if len(d.changedRows)+len(changed) < d.maxRows {
+				changed = append(changed, key)
+			}
+		default:
+			d.unchanged++
+		}
+	}
+	for _, key := range rightKeys {
+		if _, ok := leftHashes[keyID(key)]; !ok {
+			d.inserted++
+			if len(d.insertedRows)+len(inserted) < d.maxRows {
+				inserted = append(inserted, key)
+			}
+		}
+	}
+
+	leftRows, types, err := d.fetch(d.left, append(deleted, changed...))
+	if err != nil {
+		return err
+	}
+	rightRows, _, err := d.fetch(d.right, append(inserted, changed...))
+	if err != nil {
+		return err
+	}
+	for _, key := range deleted {
+		row := leftRows[keyID(key)]
+		maskTableMaps(d.left.Connection, d.left.Table, []map[string]interface{}{row})
+		d.deletedRows = append(d.deletedRows, row)
+	}
+	for _, key := range inserted {
+		row := rightRows[keyID(key)]
+		maskTableMaps(d.right.Connection, d.right.Table, []map[string]interface{}{row})
+		d.insertedRows = append(d.insertedRows, row)
+	}
+	for _, key := range changed {
+		l, r := leftRows[keyID(key)], rightRows[keyID(key)]
+		keyValues := map[string]interface{}{}
+		for _, k := range d.keys {
+			keyValues[k] = l[k]
+		}
+		before, after := map[string]interface{}{}, map[string]interface{}{}
+		for _, col := range d.columns {
+			if !sameValue(types[col], l[col], r[col]) {
+				before[col], after[col] = l[col], r[col]
+			}
+		}
+		maskTableMaps(d.left.Connection, d.left.Table, []map[string]interface{}{keyValues, before})
+		maskTableMaps(d.right.Connection, d.right.Table, []map[string]interface{}{after})
+		diffs := map[string]interface{}{}
+		for col := range before {
+			diffs[col] = map[string]interface{}{"left": before[col], "right": after[col]}
+		}
+		d.changedRows = append(d.changedRows, map[string]interface{}{"key": keyValues, "columns": diffs})
+	}
+	return nil
+}
+
+// keyID identifies a row by its key values as text
+func keyID(key []string) string {
+	return strings.Join(key, "\x00")
+}
+
+// rowHashes reads the key and row hash of every row of side in (lo, hi],
+// keyed by keyID, and the keys in order
+func (d *dataDiff) rowHashes(side *diffTable, lo, hi []string) (map[string]string, [][]string, error) {
+	where, args := d.rangeCondition(side, lo, hi)
+	query := fmt.Sprintf("SELECT %s, %s FROM %s%s ORDER BY %s",
+		d.rowHash, d.keyTexts, side.quoted, where, d.keyList)
+	rows, err := side.q.QueryContext(side.ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	hashes := map[string]string{}
+	var keys [][]string
+	for rows.Next() {
+		var hash string
+		key := make([]string, len(d.keys))
+		ptrs := []interface{}{&hash}
+		for i := range key {
+			ptrs = append(ptrs, &key[i])
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		hashes[keyID(key)] = hash
+		keys = append(keys, key)
+	}
+	return hashes, keys, rows.Err()
+}
+
+// fetch reads the compared columns of the rows of side with the given keys,
+// keyed by keyID, and describes the columns
+func (d *dataDiff) fetch(side *diffTable, keys [][]string) (map[string]map[string]interface{}, map[string]pgvalue.Column, error) {
+	result := map[string]map[string]interface{}{}
+	types := map[string]pgvalue.Column{}
+	if len(keys) == 0 {
+		return result, types, nil
+	}
+	var tuples []string
+	var args []interface{}
+	for _, key := range keys {
+		var tuple string
+		tuple, args = d.keyParams(side, key, args)
+		tuples = append(tuples, tuple)
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE (%s) IN (%s)",
+		d.keyTexts, d.columnList, side.quoted, d.keyList, strings.Join(tuples, ", "))
+	rs, err := queryRowSet(side.ctx, side.q, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := len(d.keys)
+	for i, col := range d.columns {
+		types[col] = rs.types[n+i]
+	}
+	for _, values := range rs.rows {
+		key := make([]string, n)
+		for i := range key {
+			key[i], _ = values[i].(string)
+		}
+		row := map[string]interface{}{}
+		for i, col := range d.columns {
+			row[col] = values[n+i]
+		}
+		result[keyID(key)] = row
+	}
+	return result, types, nil
+}
+
+// tableColumnTypes returns the columns of table, a quoted name, in order and
+// the type of each
+func tableColumnTypes(ctx context.Context, q queryer, table string) ([]string, map[string]string, error) {
+	rows, err := q.QueryContext(ctx, `
+            SELECT a.attname::text, format_type(a.atttypid, a.atttypmod)
+            FROM pg_attribute a
+            WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+            ORDER BY a.attnum
+    `, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var order []string
+	types := map[string]string{}
+	for rows.Next() {
+		var col, typ string
+		if err := rows.Scan(&col, &typ); err != nil {
+			return nil, nil, err
+		}
+		order = append(order, col)
+		types[col] = typ
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf("no columns")
+	}
+	return order, types, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql/driver"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tableAnswer answers the data_diff queries from a users table with the given
+// email by id, using the email as the row hash
+func tableAnswer(rows map[int]string) func(string, []driver.NamedValue) (*fakeResult, error) {
+	var ids []int
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	arg := func(args []driver.NamedValue, i int) int {
+		n, _ := strconv.Atoi(args[i].Value.(string))
+		return n
+	}
+	// inRange returns the ids within the bounds of a range condition
+	inRange := func(query string, args []driver.NamedValue) []int {
+		lo, hi := -1, 1<<30
+		if strings.Contains(query, ") > ($1") {
+			lo = arg(args, 0)
+		}
+		if strings.Contains(query, ") <= ($") {
+			hi = arg(args, len(args)-1)
+		}
+		var in []int
+		for _, id := range ids {
+			if id > lo && id <= hi {
+				in = append(in, id)
+			}
+		}
+		return in
+	}
+	return func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		switch {
+		case strings.Contains(query, "FROM pg_attribute"):
+			return &fakeResult{columns: []string{"name", "type"}, rows: [][]driver.Value{{"id", "integer"}, {"email", "text"}}}, nil
+		case strings.Contains(query, "indisprimary"):
+			return &fakeResult{columns: []string{"attname"}, rows: [][]driver.Value{{"id"}}}, nil
+		case strings.Contains(query, " OFFSET "):
+			in := inRange(query, args)
+			offset, _ := strconv.Atoi(strings.Fields(query[strings.Index(query, " OFFSET ")+8:])[0])
+			if offset >= len(in) {
+				return &fakeResult{columns: []string{"id"}}, nil
+			}
+			return &fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{strconv.Itoa(in[offset])}}}, nil
+		case strings.HasPrefix(query, "SELECT count(*)"):
+			var hash string
+			in := inRange(query, args)
+			for _, id := range in {
+				hash += rows[id]
+			}
+			return &fakeResult{columns: []string{"count", "hash"}, rows: [][]driver.Value{{int64(len(in)), hash}}}, nil
+		case strings.HasPrefix(query, "SELECT md5(ROW"):
+			res := &fakeResult{columns: []string{"hash", "id"}}
+			for _, id := range inRange(query, args) {
+				res.rows = append(res.rows, []driver.Value{rows[id], strconv.Itoa(id)})
+			}
+			return res, nil
+		case strings.Contains(query, ") IN ("):
+			res := &fakeResult{columns: []string{"id", "id", "email"}}
+			for i := range args {
+				id := arg(args, i)
+				res.rows = append(res.rows, []driver.Value{strconv.Itoa(id), int64(id), rows[id]})
+			}
+			return res, nil
+		}
+		return nil, nil
+	}
+}
+
+func TestDataDiff(t *testing.T) {
+	withFakeDB(t, "local_db", tableAnswer(map[int]string{1: "a", 2: "b", 3: "c", 4: "d", 5: "e"}))
+	d := addFakeDB(t, "primary_db", tableAnswer(map[int]string{1: "a", 2: "b", 3: "C", 5: "e", 6: "f"}))
+
+	r := decodeResponse(t, dataDiffHandler(map[string]interface{}{
+		"left_database": "local_db", "left_table": "users", "right_database": "primary_db", "chunk_size": float64(2),
+	}))
+	if !r.OK || *r.RowCount != 3 {
+		t.Fatalf("data_diff failed: %+v", r)
+	}
+	data := r.Data.(map[string]interface{})
+	summary := data["summary"].(map[string]interface{})
+	if summary["inserted"] != 1.0 || summary["deleted"] != 1.0 || summary["changed"] != 1.0 || summary["unchanged"] != 3.0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if data["chunks"] != 4.0 || data["chunks_differing"] != 2.0 {
+		t.Errorf("expected only the ranges holding 3, 4 and 6 to be compared row by row: %+v", data)
+	}
+	if row := data["inserted_rows"].([]interface{})[0].(map[string]interface{}); row["id"] != 6.0 || row["email"] != "f" {
+		t.Errorf("unexpected inserted row: %+v", row)
+	}
+	if row := data["deleted_rows"].([]interface{})[0].(map[string]interface{}); row["id"] != 4.0 {
+		t.Errorf("unexpected deleted row: %+v", row)
+	}
+	changed := data["changed_rows"].([]interface{})[0].(map[string]interface{})
+	cols := changed["columns"].(map[string]interface{})
+	if changed["key"].(map[string]interface{})["id"] != 3.0 || len(cols) != 1 {
+		t.Errorf("expected only the email of row 3 to differ: %+v", changed)
+	}
+	if email := cols["email"].(map[string]interface{}); email["left"] != "c" || email["right"] != "C" {
+		t.Errorf("unexpected column diff: %+v", email)
+	}
+	if !containsStatement(d.statements(), "BEGIN READ ONLY") || !containsStatement(d.statements(), "REPEATABLE READ") {
+		t.Errorf("each side should be read from one read-only snapshot: %v", d.statements())
+	}
+}
+
+func TestDataDiffIdentical(t *testing.T) {
+	rows := map[int]string{1: "a", 2: "b"}
+	withFakeDB(t, "local_db", tableAnswer(rows))
+	d := addFakeDB(t, "primary_db", tableAnswer(rows))
+
+	r := decodeResponse(t, dataDiffHandler(map[string]interface{}{"left_database": "local_db", "left_table": "users", "right_database": "primary_db"}))
+	if !r.OK || *r.RowCount != 0 || r.Data.(map[string]interface{})["identical"] != true {
+		t.Fatalf("expected identical tables: %+v", r)
+	}
+	for _, s := range d.statements() {
+		if strings.HasPrefix(s, "SELECT md5(ROW") || strings.Contains(s, ") IN (") {
+			t.Errorf("matching ranges should not be read row by row: %s", s)
+		}
+	}
+
+	r = decodeResponse(t, dataDiffHandler(map[string]interface{}{"left_database": "local_db", "left_table": "users"}))
+	if r.OK || !strings.Contains(r.Error, "same table") {
+		t.Errorf("expected comparing a table with itself to be refused: %+v", r)
+	}
+}
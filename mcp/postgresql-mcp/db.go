@@ -8,27 +8,38 @@ import (
 	"strings"
 	"time"
 
-	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/cloudguard"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/dbguard"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
 )
 
 // Connection manager
 type PostgreSQLManager struct {
 	connections map[string]*sql.DB
 	configs     map[string]string
+	modes       map[string]ConnectionMode
+	guard       *dbguard.Guard
 }
 
 func NewPostgreSQLManager() *PostgreSQLManager {
 	return &PostgreSQLManager{
 		connections: make(map[string]*sql.DB),
 		configs:     make(map[string]string),
+		modes:       make(map[string]ConnectionMode),
+		guard:       dbguard.NewGuard(nil, logger.Printf),
 	}
 }
 
-func (m *PostgreSQLManager) AddConnection(name, dsn string) error {
-	// Validate connection against Cloud SQL Proxy
-	if err := cloudguard.ValidateConnection(dsn); err != nil {
-		logger.Printf("Connection rejected for %s: %s", name, err)
-		return fmt.Errorf("connection rejected: %w", err)
+// SetGuard replaces the guard every new connection is checked against
+func (m *PostgreSQLManager) SetGuard(g *dbguard.Guard) {
+	m.guard = g
+}
+
+// AddConnection is the only way connections are opened, so every source of DSNs
+// goes through the same guard pipeline. Read-only connections are opened so
+// that every session defaults to read-only transactions.
+func (m *PostgreSQLManager) AddConnection(name, dsn string, mode ConnectionMode) error {
+	if err := m.guard.Check(dsn); err != nil {
+		return err
 	}
 
 	// Close existing connection if re-adding
@@ -36,7 +47,14 @@ func (m *PostgreSQLManager) AddConnection(name, dsn string) error {
 		db.Close()
 	}
 
-	db, err := sql.Open("postgres", dsn)
+	open := dsn
+	if mode == connReadOnly {
+		var err error
+		if open, err = readOnlyDSN(dsn); err != nil {
+			return err
+		}
+	}
+	db, err := sql.Open("postgres", open)
 	if err != nil {
 		return fmt.Errorf("failed to open connection: %w", err)
 	}
@@ -48,7 +66,8 @@ func (m *PostgreSQLManager) AddConnection(name, dsn string) error {
 
 	m.connections[name] = db
 	m.configs[name] = dsn
-	logger.Printf("Connected to database: %s", name)
+	m.modes[name] = mode
+	logger.Printf("Connected to database: %s (%s)", name, mode)
 	return nil
 }
 
@@ -79,11 +98,36 @@ func (m *PostgreSQLManager) GetConnection(name string) *sql.DB {
 	return nil
 }
 
+// DSN returns the connection string name was opened with
+func (m *PostgreSQLManager) DSN(name string) string {
+	return m.configs[name]
+}
+
+// Mode returns the mode of connection name; unknown names are read-write
+func (m *PostgreSQLManager) Mode(name string) ConnectionMode {
+	if mode, ok := m.modes[name]; ok {
+		return mode
+	}
+	return connReadWrite
+}
+
+// NameOf returns the connection name of db, or "" if it is not managed
+func (m *PostgreSQLManager) NameOf(db *sql.DB) string {
+	for name, d := range m.connections {
+		if d == db {
+			return name
+		}
+	}
+	return ""
+}
+
 func (m *PostgreSQLManager) Disconnect(name string) bool {
 	if db, exists := m.connections[name]; exists {
+		txManager.RollbackDatabase(name)
 		db.Close()
 		delete(m.connections, name)
 		delete(m.configs, name)
+		delete(m.modes, name)
 		logger.Printf("Closed connection: %s", name)
 		return true
 	}
@@ -91,12 +135,14 @@ func (m *PostgreSQLManager) Disconnect(name string) bool {
 }
 
 func (m *PostgreSQLManager) CloseAll() {
+	txManager.RollbackDatabase("")
 	for name, db := range m.connections {
 		db.Close()
 		logger.Printf("Closed connection: %s", name)
 	}
 	m.connections = make(map[string]*sql.DB)
 	m.configs = make(map[string]string)
+	m.modes = make(map[string]ConnectionMode)
 }
 
 var dbManager = NewPostgreSQLManager()
@@ -105,7 +151,7 @@ var dbManager = NewPostgreSQLManager()
 func extractDatabaseName(dsn string) string {
 	u, err := url.Parse(dsn)
 	if err != nil {
-		logger.Printf("Failed to parse URL %s: %s", dsn, err)
+		logger.Printf("Failed to parse URL %s: %s", dbguard.RedactDSN(dsn), err)
 		return ""
 	}
 
@@ -117,24 +163,213 @@ func extractDatabaseName(dsn string) string {
 	return dbName
 }
 
-// Helper function to execute with timeout
-func execWithTimeout(db *sql.DB, timeoutMs *int, query string, args ...interface{}) (*sql.Rows, error) {
-	ctx := context.Background()
+// timeoutContext returns a context bounded by timeoutMs when it is positive
+func timeoutContext(timeoutMs *int) (context.Context, context.CancelFunc) {
 	if timeoutMs != nil && *timeoutMs > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(*timeoutMs)*time.Millisecond)
-		defer cancel()
+		return context.WithTimeout(context.Background(), time.Duration(*timeoutMs)*time.Millisecond)
 	}
-	return db.QueryContext(ctx, query, args...)
+	return context.WithCancel(context.Background())
 }
 
-func execWithTimeoutSingle(db *sql.DB, timeoutMs *int, query string, args ...interface{}) error {
-	ctx := context.Background()
-	if timeoutMs != nil && *timeoutMs > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(*timeoutMs)*time.Millisecond)
-		defer cancel()
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// target is where a tool call runs its statements: the connection pool of a named
+// database, or an open transaction when the call passes a transaction handle
+type target struct {
+	database string
+	db       *sql.DB
+	session  *txSession
+}
+
+// resolveTarget picks the transaction named by args["transaction"] if present,
+// otherwise the named database connection. Callers must call release.
+func resolveTarget(args map[string]interface{}, database string) (*target, error) {
+	if id, ok := args["transaction"].(string); ok && id != "" {
+		s, err := txManager.Acquire(id)
+		if err != nil {
+			return nil, err
+		}
+		if database != "" && database != s.database {
+			s.Release()
+			return nil, fmt.Errorf("transaction %s belongs to %s, not %s", id, s.database, database)
+		}
+		return &target{database: s.database, session: s}, nil
+	}
+
+	if !dbManager.HasConnection(database) {
+		return nil, fmt.Errorf("No database connection available")
+	}
+	db := dbManager.GetConnection(database)
+	if db == nil {
+		return nil, fmt.Errorf("No database connection available")
+	}
+	if database == "" {
+		database = dbManager.NameOf(db)
+	}
+	return &target{database: database, db: db}, nil
+}
+
+func (t *target) release() {
+	if t.session != nil {
+		t.session.Release()
+	}
+}
+
+// runMode selects how target.run treats the statements of a call
+type runMode int
+
+const (
+	// modeWrite auto-commits on a plain connection and keeps the changes in a transaction
+	modeWrite runMode = iota
+	// modeReadOnly runs read-only and is always rolled back
+	modeReadOnly
+	// modeRollback may write but is always rolled back, e.g. EXPLAIN ANALYZE of DML
+	modeRollback
+	// modeAtomic writes in a transaction of its own on a plain connection that
+	// commits only when every statement of the call succeeds
+	modeAtomic
+)
+
+// run executes fn with a statement runner bounded by timeoutMs.
+//
+// On a plain connection writes auto-commit as before, atomic writes commit their
+// own transaction, and the other modes run inside their own transaction that is
+// always rolled back. Inside a transaction
+// every call is wrapped in a savepoint so a failing statement does not abort the
+// whole transaction; read-only and rollback calls are rolled back to it.
+func (t *target) run(timeoutMs *int, mode runMode, fn func(ctx context.Context, q queryer) error) error {
+	ctx, cancel := timeoutContext(timeoutMs)
+	defer cancel()
+
+	if t.session == nil {
+		if mode == modeWrite {
+			return fn(ctx, t.db)
+		}
+		tx, err := t.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: mode == modeReadOnly})
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		if err := fn(ctx, tx); err != nil || mode != modeAtomic {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit: %w", err)
+		}
+		return nil
+	}
+
+	tx := t.session.tx
+	bg := context.Background()
+	if _, err := tx.ExecContext(bg, "SAVEPOINT mcp_stmt"); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if mode == modeReadOnly {
+		if _, err := tx.ExecContext(bg, "SET LOCAL transaction_read_only = on"); err != nil {
+			tx.ExecContext(bg, "ROLLBACK TO SAVEPOINT mcp_stmt")
+			return fmt.Errorf("failed to switch to read-only: %w", err)
+		}
+	}
+	err := fn(ctx, tx)
+	if err != nil || (mode != modeWrite && mode != modeAtomic) {
+		if _, rerr := tx.ExecContext(bg, "ROLLBACK TO SAVEPOINT mcp_stmt"); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	if _, rerr := tx.ExecContext(bg, "RELEASE SAVEPOINT mcp_stmt"); rerr != nil && err == nil {
+		err = rerr
 	}
-	_, err := db.ExecContext(ctx, query, args...)
 	return err
 }
+
+// rowSet is a result with its column order preserved
+type rowSet struct {
+	columns []string
+	// types describes each column when the rows came from the database
+	types []pgvalue.Column
+	rows  [][]interface{}
+}
+
+// describe returns the column metadata, or bare names for computed results
+func (rs *rowSet) describe() []pgvalue.Column {
+	if rs.types != nil {
+		return rs.types
+	}
+	cols := make([]pgvalue.Column, len(rs.columns))
+	for i, name := range rs.columns {
+		cols[i] = pgvalue.Column{Name: name}
+	}
+	return cols
+}
+
+// maps returns the rows as column-name keyed maps, or nil when there are none
+func (rs *rowSet) maps() []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, values := range rs.rows {
+		row := make(map[string]interface{}, len(rs.columns))
+		for i, col := range rs.columns {
+			row[col] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+// queryRowSet runs a row-returning statement and scans every row in column order
+func queryRowSet(ctx context.Context, q queryer, query string, args ...interface{}) (*rowSet, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRowSet(rows)
+}
+
+// scanRowSet reads all remaining rows positionally, decoding each value by
+// its column type
+func scanRowSet(rows *sql.Rows) (*rowSet, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+
+	rs := &rowSet{columns: columns, types: make([]pgvalue.Column, len(columnTypes))}
+	for i, ct := range columnTypes {
+		rs.types[i] = pgvalue.Describe(ct)
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		decodeRow(columnTypes, values)
+		rs.rows = append(rs.rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// decodeRow replaces scanned values with their JSON-faithful form
+func decodeRow(columnTypes []*sql.ColumnType, values []interface{}) {
+	for i, ct := range columnTypes {
+		values[i] = pgvalue.Decode(ct.DatabaseTypeName(), values[i])
+	}
+}
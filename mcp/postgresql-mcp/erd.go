@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/erd"
+)
+
+func erDiagramHandler(args map[string]interface{}) map[string]interface{} {
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+
+	format := "mermaid"
+	if f, exists := args["format"]; exists {
+		if formatStr, ok := f.(string); ok && formatStr != "" {
+			format = formatStr
+		}
+	}
+	if format != "mermaid" && format != "dot" {
+		return errResponse(fmt.Sprintf("unsupported format: %s (use mermaid or dot)", format))
+	}
+
+	var filter erd.Filter
+	if s, ok := args["schema_pattern"].(string); ok {
+		filter.SchemaPattern = s
+	}
+	if s, ok := args["table_pattern"].(string); ok {
+		filter.TablePattern = s
+	}
+	if err := filter.Validate(); err != nil {
+		return errResponse(err.Error())
+	}
+
+	var opts erd.Options
+	if a, exists := args["all_columns"]; exists {
+		if aBool, ok := a.(bool); ok {
+			opts.AllColumns = aBool
+		}
+	}
+
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+
+	var diagram *erd.Diagram
+	err = t.run(nil, modeReadOnly, func(ctx context.Context, q queryer) error {
+		var err error
+		diagram, err = loadDiagram(ctx, q, filter)
+		return err
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Failed to read schema: %s", err))
+	}
+
+	text := diagram.Mermaid(opts)
+	if format == "dot" {
+		text = diagram.DOT(opts)
+	}
+	return okResponse(map[string]interface{}{
+		"format":    format,
+		"diagram":   text,
+		"tables":    len(diagram.Tables),
+		"relations": len(diagram.Relations),
+	}, nil)
+}
+
+// loadDiagram reads tables, columns and key constraints of every user schema
+// in two catalog queries. Partitions are folded into their parent.
+func loadDiagram(ctx context.Context, q queryer, filter erd.Filter) (*erd.Diagram, error) {
+	rows, err := q.QueryContext(ctx, `
+            SELECT n.nspname::text, c.relname::text, a.attname::text,
+                   format_type(a.atttypid, a.atttypmod), NOT a.attnotnull
+            FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+            WHERE c.relkind IN ('r', 'p', 'f') AND NOT c.relispartition
+              AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+            ORDER BY n.nspname, c.relname, a.attnum
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diagram := &erd.Diagram{Tables: []erd.Table{}, Relations: []erd.Relation{}}
+	index := map[string]int{}
+	for rows.Next() {
+		var schema, table string
+		var col erd.Column
+		if err := rows.Scan(&schema, &table, &col.Name, &col.Type, &col.Nullable); err != nil {
+			return nil, err
+		}
+		if !filter.Match(schema, table) {
+			continue
+		}
+		key := schema + "." + table
+		i, ok := index[key]
+		if !ok {
+			i = len(diagram.Tables)
+			index[key] = i
+			diagram.Tables = append(diagram.Tables, erd.Table{Schema: schema, Name: table})
+		}
+		diagram.Tables[i].Columns = append(diagram.Tables[i].Columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	crows, err := q.QueryContext(ctx, `
+            SELECT con.conname::text, con.contype::text, n.nspname::text, c.relname::text,
+                   ARRAY(SELECT a.attname::text FROM unnest(con.conkey) WITH ORDINALITY k(attnum, ord)
+                         JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum ORDER BY k.ord),
+                   COALESCE(fn.nspname::text, ''), COALESCE(fc.relname::text, ''),
+                   ARRAY(SELECT a.attname::text FROM unnest(con.confkey) WITH ORDINALITY k(attnum, ord)
+                         JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum ORDER BY k.ord)
+            FROM pg_constraint con
+            JOIN pg_class c ON c.oid = con.conrelid
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            LEFT JOIN pg_class fc ON fc.oid = con.confrelid
+            LEFT JOIN pg_namespace fn ON fn.oid = fc.relnamespace
+            WHERE con.contype IN ('p', 'u', 'f') AND NOT c.relispartition
+              AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+            ORDER BY con.conname
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer crows.Close()
+
+	// unique column sets per table, used to spot one-to-one relations
+	uniqueSets := map[string][][]string{}
+	primary := map[string]map[string]bool{}
+	var foreign []erd.Relation
+	for crows.Next() {
+		var name, contype, schema, table, refSchema, refTable string
+		var cols, refCols pq.StringArray
+		if err := crows.Scan(&name, &contype, &schema, &table, &cols, &refSchema, &refTable, &refCols); err != nil {
+			return nil, err
+		}
+		key := schema + "." + table
+		i, ok := index[key]
+		if !ok {
+			continue
+		}
+		t := &diagram.Tables[i]
+		switch contype {
+		case "p":
+			primary[key] = map[string]bool{}
+			for _, c := range cols {
+				primary[key][c] = true
+			}
+			markColumns(t, cols, func(c *erd.Column) { c.Primary = true })
+			uniqueSets[key] = append(uniqueSets[key], cols)
+		case "u":
+			if len(cols) == 1 {
+				markColumns(t, cols, func(c *erd.Column) { c.Unique = true })
+			}
+			uniqueSets[key] = append(uniqueSets[key], cols)
+		case "f":
+			markColumns(t, cols, func(c *erd.Column) { c.Foreign = true })
+			foreign = append(foreign, erd.Relation{
+				Name:        name,
+				From:        key,
+				FromColumns: cols,
+				To:          refSchema + "." + refTable,
+				ToColumns:   refCols,
+			})
+		}
+	}
+	if err := crows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range foreign {
+		t := &diagram.Tables[index[r.From]]
+		r.Identifying = len(primary[r.From]) > 0
+		for _, c := range t.Columns {
+			if !containsString(r.FromColumns, c.Name) {
+				continue
+			}
+			if c.Nullable {
+				r.Optional = true
+			}
+			if !primary[r.From][c.Name] {
+				r.Identifying = false
+			}
+		}
+		for _, set := range uniqueSets[r.From] {
+			if sameColumns(set, r.FromColumns) {
+				r.OneToOne = true
+			}
+		}
+		diagram.Relations = append(diagram.Relations, r)
+	}
+
+	diagram.Prune()
+	return diagram, nil
+}
+
+func markColumns(t *erd.Table, names []string, mark func(*erd.Column)) {
+	for i := range t.Columns {
+		if containsString(names, t.Columns[i].Name) {
+			mark(&t.Columns[i])
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sameColumns reports whether a and b hold the same columns in any order
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, c := range a {
+		if !containsString(b, c) {
+			return false
+		}
+	}
+	return true
+}
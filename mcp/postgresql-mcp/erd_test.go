@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func erdAnswer(query string, args []driver.NamedValue) (*fakeResult, error) {
+	switch {
+	case strings.Contains(query, "JOIN pg_attribute a ON a.attrelid = c.oid"):
+		return &fakeResult{
+			columns: []string{"nspname", "relname", "attname", "type", "nullable"},
+			rows: [][]driver.Value{
+				{"finance", "invoices", "id", "bigint", false},
+				{"finance", "invoices", "shipment_id", "bigint", false},
+				{"inventory", "items", "id", "bigint", false},
+				{"inventory", "items", "sku", "text", false},
+				{"inventory", "items", "name", "text", true},
+				{"logistics", "shipments", "id", "bigint", false},
+				{"logistics", "shipments", "item_id", "bigint", true},
+			},
+		}, nil
+	case strings.Contains(query, "FROM pg_constraint con"):
+		return &fakeResult{
+			columns: []string{"conname", "contype", "nspname", "relname", "conkey", "fnsp", "frel", "confkey"},
+			rows: [][]driver.Value{
+				{"invoices_pkey", "p", "finance", "invoices", []byte("{id}"), "", "", []byte("{}")},
+				{"invoices_shipment_id_fkey", "f", "finance", "invoices", []byte("{shipment_id}"), "logistics", "shipments", []byte("{id}")},
+				{"items_pkey", "p", "inventory", "items", []byte("{id}"), "", "", []byte("{}")},
+				{"items_sku_key", "u", "inventory", "items", []byte("{sku}"), "", "", []byte("{}")},
+				{"shipments_item_id_fkey", "f", "logistics", "shipments", []byte("{item_id}"), "inventory", "items", []byte("{id}")},
+				{"shipments_pkey", "p", "logistics", "shipments", []byte("{id}"), "", "", []byte("{}")},
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+func TestERDiagramHandlerMermaid(t *testing.T) {
+	withFakeDB(t, "secondary_db", erdAnswer)
+
+	resp := erDiagramHandler(map[string]interface{}{"schema_pattern": "inventory,logistics"})
+	r := decodeResponse(t, resp)
+	if !r.OK {
+		t.Fatalf("export failed: %s", r.Error)
+	}
+	data := r.Data.(map[string]interface{})
+	if data["tables"].(float64) != 2 || data["relations"].(float64) != 1 {
+		t.Errorf("unexpected counts: %v", data)
+	}
+	diagram := data["diagram"].(string)
+	for _, want := range []string{
+		"    inventory_items {\n        bigint id PK\n        text sku UK\n    }\n",
+		"        bigint item_id FK\n",
+		`    inventory_items |o..o{ logistics_shipments : "shipments_item_id_fkey"`,
+	} {
+		if !strings.Contains(diagram, want) {
+			t.Errorf("missing %q in:\n%s", want, diagram)
+		}
+	}
+	if strings.Contains(diagram, "finance") {
+		t.Errorf("finance schema should be filtered out:\n%s", diagram)
+	}
+}
+
+func TestERDiagramHandlerDOT(t *testing.T) {
+	withFakeDB(t, "secondary_db", erdAnswer)
+
+	resp := erDiagramHandler(map[string]interface{}{"format": "dot", "table_pattern": "invoices,shipments", "all_columns": true})
+	r := decodeResponse(t, resp)
+	if !r.OK {
+		t.Fatalf("export failed: %s", r.Error)
+	}
+	diagram := r.Data.(map[string]interface{})["diagram"].(string)
+	want := `"finance.invoices":"shipment_id" -> "logistics.shipments":"id" [label="invoices_shipment_id_fkey", arrowhead=tee, arrowtail=crowodot, dir=both, style=dashed];`
+	if !strings.Contains(diagram, want) {
+		t.Errorf("missing edge in:\n%s", diagram)
+	}
+	if strings.Contains(diagram, "inventory.items\"") {
+		t.Errorf("items should be filtered out:\n%s", diagram)
+	}
+
+	if r := decodeResponse(t, erDiagramHandler(map[string]interface{}{"format": "svg"})); r.OK {
+		t.Errorf("expected unsupported format to be rejected")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/explain"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/sqlguard"
+)
+
+// explainableKinds are the statements EXPLAIN accepts that we are willing to plan
+var explainableKinds = map[sqlguard.Kind]bool{
+	sqlguard.KindSelect:     true,
+	sqlguard.KindValues:     true,
+	sqlguard.KindTable:      true,
+	sqlguard.KindSelectLock: true,
+	sqlguard.KindInsert:     true,
+	sqlguard.KindUpdate:     true,
+	sqlguard.KindDelete:     true,
+	sqlguard.KindMerge:      true,
+}
+
+func explainHandler(args map[string]interface{}) map[string]interface{} {
+	sqlQuery, ok := args["sql"].(string)
+	if !ok {
+		return errResponse("sql is required")
+	}
+
+	params, err := paramsArg(args)
+	if err != nil {
+		return invalidParamsResponse(err)
+	}
+
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+
+	analyze := false
+	if a, exists := args["analyze"]; exists {
+		if aBool, ok := a.(bool); ok {
+			analyze = aBool
+		}
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	var opts explain.Options
+	if v, ok := args["seq_scan_rows"].(float64); ok {
+		opts.SeqScanRows = v
+	}
+	if v, ok := args["misestimate_factor"].(float64); ok {
+		opts.MisestimateFactor = v
+	}
+
+	stmt, err := sqlguard.ParseSingle(sqlQuery)
+	if err != nil {
+		var rej *sqlguard.Rejection
+		if errors.As(err, &rej) {
+			return errResponseWithDetail(fmt.Sprintf("Explain rejected: %s", rej.Reason), rej)
+		}
+		return errResponse(fmt.Sprintf("Explain rejected: %s", err))
+	}
+	if !explainableKinds[stmt.Kind] && !(stmt.Kind == sqlguard.KindFunction && !analyze) {
+		rej := stmt.Reject()
+		return errResponseWithDetail(fmt.Sprintf("Explain rejected: %s", rej.Reason), rej)
+	}
+
+	options := "FORMAT JSON, VERBOSE, BUFFERS"
+	mode := modeReadOnly
+	if analyze {
+		// ANALYZE executes the statement; the surrounding transaction is always rolled back
+		options = "ANALYZE, " + options
+		mode = modeRollback
+	}
+	explainSQL := fmt.Sprintf("EXPLAIN (%s) %s", options, stmt.Text)
+
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkStatementAccess(t.database, stmt.Text); err != nil {
+		return accessDeniedResponse(err)
+	}
+
+	var result *explain.Result
+	err = t.run(timeoutMs, mode, func(ctx context.Context, q queryer) error {
+		var raw []byte
+		if err := q.QueryRowContext(ctx, explainSQL, params...).Scan(&raw); err != nil {
+			return err
+		}
+		var err error
+		result, err = explain.Parse(raw)
+		if err != nil {
+			return err
+		}
+		opts.RelationRows, err = relationRowEstimates(ctx, q, result.Relations())
+		return err
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Explain failed: %s", err))
+	}
+
+	result.DetectHotspots(opts)
+	return okResponse(result, nil)
+}
+
+// relationRowEstimates looks up pg_class.reltuples for schema-qualified relation names
+func relationRowEstimates(ctx context.Context, q queryer, relations []string) (map[string]float64, error) {
+	out := make(map[string]float64)
+	if len(relations) == 0 {
+		return out, nil
+	}
+	rows, err := q.QueryContext(ctx, `
+            SELECT n.nspname || '.' || c.relname, c.reltuples::float8
+            FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE n.nspname || '.' || c.relname = ANY($1)
+    `, pq.Array(relations))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table sizes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var tuples float64
+		if err := rows.Scan(&name, &tuples); err != nil {
+			return nil, err
+		}
+		out[name] = tuples
+	}
+	return out, rows.Err()
+}
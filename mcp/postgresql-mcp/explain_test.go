@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+const fakePlan = `[{"Plan": {"Node Type": "ModifyTable", "Operation": "Delete", "Relation Name": "orders", "Schema": "public", "Total Cost": 10, "Plan Rows": 0, "Actual Rows": 0, "Actual Loops": 1,
+  "Plans": [{"Node Type": "Seq Scan", "Relation Name": "orders", "Schema": "public", "Total Cost": 9, "Plan Rows": 1, "Actual Rows": 500, "Actual Loops": 1}]}, "Execution Time": 1.5}]`
+
+func TestExplainAnalyzeIsRolledBack(t *testing.T) {
+	d := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		switch {
+		case strings.HasPrefix(query, "EXPLAIN"):
+			return &fakeResult{columns: []string{"QUERY PLAN"}, rows: [][]driver.Value{{[]byte(fakePlan)}}}, nil
+		case strings.Contains(query, "reltuples"):
+			return &fakeResult{columns: []string{"name", "reltuples"}, rows: [][]driver.Value{{"public.orders", float64(250000)}}}, nil
+		}
+		return nil, nil
+	})
+
+	r := decodeResponse(t, explainHandler(map[string]interface{}{
+		"sql":      "DELETE FROM orders WHERE status = $1;",
+		"params":   []interface{}{"pending"},
+		"database": "primary_db",
+		"analyze":  true,
+	}))
+	if !r.OK {
+		t.Fatalf("explain failed: %s", r.Error)
+	}
+
+	stmts := d.statements()
+	if stmts[0] != "BEGIN" || stmts[len(stmts)-1] != "ROLLBACK" {
+		t.Errorf("expected explain analyze inside a rolled back transaction, got %v", stmts)
+	}
+	if !containsStatement(stmts, "EXPLAIN (ANALYZE, FORMAT JSON, VERBOSE, BUFFERS) DELETE FROM orders WHERE status = $1") {
+		t.Errorf("unexpected statements: %v", stmts)
+	}
+
+	data := r.Data.(map[string]interface{})
+	hotspots := data["hotspots"].([]interface{})
+	kinds := map[string]bool{}
+	for _, h := range hotspots {
+		kinds[h.(map[string]interface{})["kind"].(string)] = true
+	}
+	if !kinds["seq_scan_large_table"] || !kinds["row_misestimate"] {
+		t.Errorf("unexpected hotspots: %v", hotspots)
+	}
+}
+
+func TestExplainRejectsNonExplainable(t *testing.T) {
+	withFakeDB(t, "primary_db", nil)
+	for _, sql := range []string{"DROP TABLE users", "SELECT 1; SELECT 2", "COMMIT"} {
+		if r := decodeResponse(t, explainHandler(map[string]interface{}{"sql": sql})); r.OK {
+			t.Errorf("expected %q to be rejected", sql)
+		}
+	}
+}
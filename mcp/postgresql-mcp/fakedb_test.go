@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDriver is a minimal database/sql driver that records every statement and
+// answers queries through a caller-provided function.
+type fakeDriver struct {
+	mu     sync.Mutex
+	log    []string
+	answer func(query string, args []driver.NamedValue) (*fakeResult, error)
+}
+
+type fakeResult struct {
+	columns []string
+	// types are the database type names reported for columns, e.g. "NUMERIC"
+	types []string
+	rows  [][]driver.Value
+	// affected is reported by Exec
+	affected int64
+}
+
+type fakeConnector struct{ d *fakeDriver }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{d: c.d}, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return c.d }
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d: d}, nil }
+
+func (d *fakeDriver) record(q string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, q)
+}
+
+// statements returns the recorded statements
+func (d *fakeDriver) statements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.log...)
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+// Prepare records the statement once and is answered with nil args; every Exec
+// of it is answered with its arguments, which is how COPY rows arrive. The
+// final COPY flush has empty, non-nil args.
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.record(query)
+	if _, err := c.d.respond(query, nil); err != nil {
+		return nil, err
+	}
+	return &fakeStmt{d: c.d, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		c.d.record("BEGIN READ ONLY")
+	} else {
+		c.d.record("BEGIN")
+	}
+	return &fakeTx{d: c.d}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.d.record(query)
+	res, err := c.d.respond(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.record(query)
+	res, err := c.d.respond(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{res: res}, nil
+}
+
+func (d *fakeDriver) respond(query string, args []driver.NamedValue) (*fakeResult, error) {
+	if d.answer == nil {
+		return &fakeResult{}, nil
+	}
+	res, err := d.answer(query, args)
+	if res == nil && err == nil {
+		res = &fakeResult{}
+	}
+	return res, err
+}
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	res, err := s.d.respond(s.query, named)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("query on a prepared statement is not supported")
+}
+
+type fakeTx struct{ d *fakeDriver }
+
+func (t *fakeTx) Commit() error   { t.d.record("COMMIT"); return nil }
+func (t *fakeTx) Rollback() error { t.d.record("ROLLBACK"); return nil }
+
+type fakeRows struct {
+	res *fakeResult
+	pos int
+}
+
+func (r *fakeRows) Columns() []string { return r.res.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.res.types) {
+		return r.res.types[index]
+	}
+	return ""
+}
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.res.rows) {
+		return io.EOF
+	}
+	copy(dest, r.res.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+// withFakeDB registers a fake connection under name on a fresh dbManager and
+// restores the previous manager when the test ends.
+func withFakeDB(t *testing.T, name string, answer func(query string, args []driver.NamedValue) (*fakeResult, error)) *fakeDriver {
+	t.Helper()
+	d := &fakeDriver{answer: answer}
+	db := sql.OpenDB(fakeConnector{d: d})
+
+	prev := dbManager
+	dbManager = NewPostgreSQLManager()
+	dbManager.connections[name] = db
+	dbManager.configs[name] = "postgresql://fake@localhost/" + name
+	t.Cleanup(func() {
+		txManager.RollbackDatabase("")
+		db.Close()
+		dbManager = prev
+	})
+	return d
+}
+
+// addFakeDB registers another fake connection on the dbManager installed by withFakeDB
+func addFakeDB(t *testing.T, name string, answer func(query string, args []driver.NamedValue) (*fakeResult, error)) *fakeDriver {
+	t.Helper()
+	d := &fakeDriver{answer: answer}
+	db := sql.OpenDB(fakeConnector{d: d})
+	dbManager.connections[name] = db
+	dbManager.configs[name] = "postgresql://fake@localhost/" + name
+	t.Cleanup(func() { db.Close() })
+	return d
+}
+
+// responseText unwraps the JSON text of a tool response
+func responseText(t *testing.T, resp map[string]interface{}) string {
+	t.Helper()
+	content := resp["content"].([]map[string]interface{})
+	return content[0]["text"].(string)
+}
+
+func containsStatement(stmts []string, want string) bool {
+	for _, s := range stmts {
+		if strings.Contains(s, want) {
+			return true
+		}
+	}
+	return false
+}
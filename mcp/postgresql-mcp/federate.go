@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/federate"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/format"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/sqlguard"
+)
+
+const defaultMaxRowsPerSource = 10000
+
+// federatedSource is one sub-query of a federated query
+type federatedSource struct {
+	Name     string        `json:"name"`
+	Database string        `json:"database"`
+	SQL      string        `json:"sql"`
+	Params   []interface{} `json:"params"`
+}
+
+func federatedQueryHandler(args map[string]interface{}) map[string]interface{} {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return errResponse(fmt.Sprintf("invalid arguments: %s", err))
+	}
+	var req struct {
+		Sources []federatedSource `json:"sources"`
+		federate.Spec
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errResponse(fmt.Sprintf("invalid arguments: %s", err))
+	}
+	if len(req.Sources) == 0 {
+		return errResponse("sources is required")
+	}
+
+	maxRows := defaultMaxRowsPerSource
+	if m, exists := args["max_rows_per_source"]; exists {
+		if mFloat, ok := m.(float64); ok && mFloat > 0 {
+			maxRows = int(mFloat)
+		}
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	outputFormat, err := formatArg(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	var conv pgparam.Converter
+	seen := map[string]bool{}
+	for i, src := range req.Sources {
+		if src.Name == "" || strings.Contains(src.Name, ".") {
+			return errResponse(fmt.Sprintf("sources[%d]: name is required and must not contain a dot", i))
+		}
+		if seen[src.Name] {
+			return errResponse(fmt.Sprintf("sources[%d]: duplicate name %q", i, src.Name))
+		}
+		seen[src.Name] = true
+		if src.Database == "" {
+			return errResponse(fmt.Sprintf("sources[%d]: database is required", i))
+		}
+		if err := sqlguard.EnsureReadOnly(src.SQL); err != nil {
+			var rej *sqlguard.Rejection
+			if errors.As(err, &rej) {
+				return errResponseWithDetail(fmt.Sprintf("Query rejected for source %s: %s", src.Name, rej.Reason), rej)
+			}
+			return errResponse(fmt.Sprintf("Query rejected for source %s: %s", src.Name, err))
+		}
+		if err := checkStatementAccess(src.Database, src.SQL); err != nil {
+			return accessDeniedResponse(fmt.Errorf("source %s: %w", src.Name, err))
+		}
+		for j, p := range src.Params {
+			req.Sources[i].Params[j] = conv.Convert(fmt.Sprintf("%s.$%d", src.Name, j+1), p)
+		}
+	}
+	if err := conv.Err(); err != nil {
+		return invalidParamsResponse(err)
+	}
+	if req.From == "" {
+		req.From = req.Sources[0].Name
+	}
+
+	// Each sub-query runs on its own connection, so they can run concurrently
+	tables := make(map[string]*federate.Table, len(req.Sources))
+	errs := make([]error, len(req.Sources))
+	results := make([]*federate.Table, len(req.Sources))
+	var wg sync.WaitGroup
+	for i, src := range req.Sources {
+		wg.Add(1)
+		go func(i int, src federatedSource) {
+			defer wg.Done()
+			results[i], errs[i] = runSource(src, maxRows, timeoutMs)
+		}(i, src)
+	}
+	wg.Wait()
+
+	summary := make([]map[string]interface{}, len(req.Sources))
+	for i, src := range req.Sources {
+		if errs[i] != nil {
+			return errResponse(fmt.Sprintf("Source %s (%s) failed: %s", src.Name, src.Database, errs[i]))
+		}
+		tables[src.Name] = results[i]
+		summary[i] = map[string]interface{}{
+			"name":     src.Name,
+			"database": src.Database,
+			"rows":     len(results[i].Rows),
+		}
+	}
+
+	res, err := federate.Execute(req.Spec, tables)
+	if err != nil {
+		return errResponse(fmt.Sprintf("Federated query failed: %s", err))
+	}
+	if outputFormat != format.JSON {
+		count := len(res.Rows)
+		rs := &rowSet{columns: res.Columns, rows: res.Rows}
+		return okTableResponse(outputFormat, rs, map[string]interface{}{"sources": summary}, &count, "", false)
+	}
+
+	rows := make([]map[string]interface{}, len(res.Rows))
+	for r, values := range res.Rows {
+		row := make(map[string]interface{}, len(res.Columns))
+		for i, col := range res.Columns {
+			row[col] = values[i]
+		}
+		rows[r] = row
+	}
+	count := len(rows)
+	return okResponse(map[string]interface{}{
+		"columns": res.Columns,
+		"rows":    rows,
+		"sources": summary,
+	}, &count)
+}
+
+// runSource executes one read-only sub-query and reads at most maxRows rows
+func runSource(src federatedSource, maxRows int, timeoutMs *int) (*federate.Table, error) {
+	t, err := resolveTarget(map[string]interface{}{}, src.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	var table *federate.Table
+	err = t.run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+		rows, err := q.QueryContext(ctx, src.SQL, src.Params...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		table, err = scanTable(rows, maxRows)
+		return err
+	})
+	if err == nil {
+		// Masked before the join, so masked values cannot leak through it
+		maskQueryRows(src.Database, src.SQL, table.Columns, table.Rows)
+	}
+	return table, err
+}
+
+// scanTable reads rows positionally and fails once more than maxRows arrive
+func scanTable(rows *sql.Rows, maxRows int) (*federate.Table, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+	table := &federate.Table{Columns: columns}
+	for rows.Next() {
+		if len(table.Rows) >= maxRows {
+			return nil, fmt.Errorf("%w: more than %d rows; narrow the sub-query or raise max_rows_per_source", federate.ErrRowCap, maxRows)
+		}
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		decodeRow(columnTypes, values)
+		table.Rows = append(table.Rows, values)
+	}
+	return table, rows.Err()
+}
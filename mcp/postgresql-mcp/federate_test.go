@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestFederatedQueryJoinsAcrossConnections(t *testing.T) {
+	primary := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		return &fakeResult{
+			columns: []string{"id", "email"},
+			rows:    [][]driver.Value{{int64(1), "alice@example.com"}, {int64(2), "bob@example.com"}},
+		}, nil
+	})
+	analytics := addFakeDB(t, "analytics_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		return &fakeResult{
+			columns: []string{"user_id", "event_type"},
+			rows: [][]driver.Value{
+				{int64(1), []byte("login")},
+				{int64(1), []byte("purchase")},
+				{int64(2), []byte("login")},
+			},
+		}, nil
+	})
+
+	resp := federatedQueryHandler(map[string]interface{}{
+		"sources": []interface{}{
+			map[string]interface{}{"name": "e", "database": "analytics_db", "sql": "SELECT user_id, event_type FROM analytics.user_events WHERE created_at > $1", "params": []interface{}{"2025-01-01"}},
+			map[string]interface{}{"name": "u", "database": "primary_db", "sql": "SELECT id, email FROM users"},
+		},
+		"join":       []interface{}{map[string]interface{}{"source": "u", "on": []interface{}{map[string]interface{}{"left": "e.user_id", "right": "u.id"}}}},
+		"group_by":   []interface{}{"u.email"},
+		"aggregates": []interface{}{map[string]interface{}{"func": "count", "column": "*", "as": "events"}},
+		"order_by":   []interface{}{map[string]interface{}{"column": "events", "desc": true}},
+	})
+	r := decodeResponse(t, resp)
+	if !r.OK {
+		t.Fatalf("federated query failed: %s", r.Error)
+	}
+	if r.RowCount == nil || *r.RowCount != 2 {
+		t.Fatalf("unexpected row count: %v", r.RowCount)
+	}
+	data := r.Data.(map[string]interface{})
+	first := data["rows"].([]interface{})[0].(map[string]interface{})
+	if first["u.email"] != "alice@example.com" || first["events"].(float64) != 2 {
+		t.Errorf("unexpected first row: %v", first)
+	}
+
+	for name, d := range map[string]*fakeDriver{"primary_db": primary, "analytics_db": analytics} {
+		if !containsStatement(d.statements(), "BEGIN READ ONLY") {
+			t.Errorf("%s: expected a read-only transaction, got %v", name, d.statements())
+		}
+	}
+	if containsStatement(primary.statements(), "user_events") || containsStatement(analytics.statements(), "FROM users") {
+		t.Errorf("sub-queries ran on the wrong connection")
+	}
+}
+
+func TestFederatedQueryRejectsWritesAndCapsRows(t *testing.T) {
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		return &fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}}}, nil
+	})
+
+	resp := federatedQueryHandler(map[string]interface{}{
+		"sources": []interface{}{map[string]interface{}{"name": "u", "database": "primary_db", "sql": "DELETE FROM users"}},
+	})
+	if r := decodeResponse(t, resp); r.OK || !strings.Contains(r.Error, "rejected") {
+		t.Errorf("expected rejection, got %s", responseText(t, resp))
+	}
+
+	resp = federatedQueryHandler(map[string]interface{}{
+		"sources":             []interface{}{map[string]interface{}{"name": "u", "database": "primary_db", "sql": "SELECT id FROM users"}},
+		"max_rows_per_source": float64(2),
+	})
+	if r := decodeResponse(t, resp); r.OK || !strings.Contains(r.Error, "max_rows_per_source") {
+		t.Errorf("expected row cap error, got %s", responseText(t, resp))
+	}
+
+	resp = federatedQueryHandler(map[string]interface{}{
+		"sources": []interface{}{
+			map[string]interface{}{"name": "u", "database": "primary_db", "sql": "SELECT 1"},
+			map[string]interface{}{"name": "u", "database": "primary_db", "sql": "SELECT 2"},
+		},
+	})
+	if r := decodeResponse(t, resp); r.OK || !strings.Contains(r.Error, "duplicate") {
+		t.Errorf("expected duplicate name error, got %s", responseText(t, resp))
+	}
+}
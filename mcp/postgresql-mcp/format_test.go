@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestQueryFormatsCSVWithHeader(t *testing.T) {
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if !strings.HasPrefix(query, "FETCH ") {
+			return nil, nil
+		}
+		return &fakeResult{
+			columns: []string{"name", "id", "note"},
+			rows: [][]driver.Value{
+				{"Smith, \"Jo\"", int64(2), nil},
+				{"Lee", int64(1), "a\nb"},
+			},
+		}, nil
+	})
+
+	resp := queryHandler(map[string]interface{}{"sql": "SELECT name, id, note FROM users", "format": "csv"})
+	header := decodeResponse(t, resp)
+	if !header.OK || *header.RowCount != 2 || header.Format != "csv" || header.Data != nil {
+		t.Fatalf("unexpected header: %+v", header)
+	}
+	var names []string
+	for _, c := range header.Columns {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "name,id,note" {
+		t.Errorf("expected column order to be kept, got %v", names)
+	}
+
+	content := resp["content"].([]map[string]interface{})
+	if len(content) != 2 {
+		t.Fatalf("expected a header and a body, got %d items", len(content))
+	}
+	want := "name,id,note\n\"Smith, \"\"Jo\"\"\",2,\nLee,1,\"a\nb\"\n"
+	if body := content[1]["text"].(string); body != want {
+		t.Errorf("unexpected CSV body:\n%q\nwant\n%q", body, want)
+	}
+}
+
+func TestQueryRejectsUnknownFormat(t *testing.T) {
+	d := withFakeDB(t, "primary_db", func(string, []driver.NamedValue) (*fakeResult, error) { return nil, nil })
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT 1", "format": "xml"}))
+	if r.OK || !strings.Contains(r.Error, "unsupported format") {
+		t.Fatalf("expected the format to be rejected: %+v", r)
+	}
+	if len(d.statements()) != 0 {
+		t.Errorf("expected nothing to run, got %v", d.statements())
+	}
+}
+
+func TestInsertReturningMarkdown(t *testing.T) {
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		return &fakeResult{columns: []string{"id", "email"}, rows: [][]driver.Value{{int64(7), "a|b@example.com"}}}, nil
+	})
+
+	resp := insertHandler(map[string]interface{}{"table": "users", "data": map[string]interface{}{"email": "a|b@example.com"}, "format": "markdown"})
+	if r := decodeResponse(t, resp); !r.OK || *r.RowCount != 1 {
+		t.Fatalf("unexpected header: %+v", r)
+	}
+	want := "| id | email |\n| --- | --- |\n| 7 | a\\|b@example.com |\n"
+	if body := resp["content"].([]map[string]interface{})[1]["text"].(string); body != want {
+		t.Errorf("unexpected Markdown body:\n%s\nwant\n%s", body, want)
+	}
+
+	// JSON keeps returning the inserted row as an object
+	r := decodeResponse(t, insertHandler(map[string]interface{}{"table": "users", "data": map[string]interface{}{"email": "x@example.com"}}))
+	if row, ok := r.Data.(map[string]interface{}); !ok || row["id"].(float64) != 7 {
+		t.Errorf("expected the inserted row, got %+v", r.Data)
+	}
+}
+
+func TestQueryEncodesByColumnType(t *testing.T) {
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if !strings.HasPrefix(query, "FETCH ") {
+			return nil, nil
+		}
+		return &fakeResult{
+			columns: []string{"price", "attrs", "tags", "avatar", "status"},
+			types:   []string{"NUMERIC", "JSONB", "_TEXT", "BYTEA", ""},
+			rows: [][]driver.Value{
+				{[]byte("1299.99"), []byte(`{"color": "red"}`), []byte(`{sale,"new arrival"}`), []byte{0xff, 0x00}, []byte("active")},
+			},
+		}, nil
+	})
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT * FROM products"}))
+	if !r.OK {
+		t.Fatalf("query failed: %s", r.Error)
+	}
+	var types []string
+	for _, c := range r.Columns {
+		types = append(types, c.Type)
+	}
+	if got := strings.Join(types, ","); got != "numeric,jsonb,text[],bytea,user-defined" {
+		t.Errorf("unexpected column types: %s", got)
+	}
+
+	row := r.Data.([]interface{})[0].(map[string]interface{})
+	if row["price"] != "1299.99" {
+		t.Errorf("expected numeric as a decimal string, got %#v", row["price"])
+	}
+	if attrs, ok := row["attrs"].(map[string]interface{}); !ok || attrs["color"] != "red" {
+		t.Errorf("expected jsonb as nested JSON, got %#v", row["attrs"])
+	}
+	if tags, ok := row["tags"].([]interface{}); !ok || len(tags) != 2 || tags[1] != "new arrival" {
+		t.Errorf("expected the array as a JSON array, got %#v", row["tags"])
+	}
+	if row["avatar"] != "/wA=" || row["status"] != "active" {
+		t.Errorf("unexpected bytea or enum value: %#v, %#v", row["avatar"], row["status"])
+	}
+}
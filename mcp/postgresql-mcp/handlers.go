@@ -1,9 +1,18 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/filter"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/format"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/sqlguard"
 )
 
 // Tool handlers
@@ -18,11 +27,16 @@ func connectHandler(args map[string]interface{}) map[string]interface{} {
 		return errResponse("connection_string is required")
 	}
 
-	if err := dbManager.AddConnection(name, dsn); err != nil {
+	mode, err := modeArg(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	if err := dbManager.AddConnection(name, dsn, mode); err != nil {
 		return errResponse(fmt.Sprintf("Failed to connect: %s", err))
 	}
 
-	return okResponse(map[string]string{"name": name}, nil)
+	return okResponse(map[string]string{"name": name, "mode": string(mode)}, nil)
 }
 
 func disconnectHandler(args map[string]interface{}) map[string]interface{} {
@@ -38,7 +52,14 @@ func disconnectHandler(args map[string]interface{}) map[string]interface{} {
 }
 
 func listConnectionsHandler(args map[string]interface{}) map[string]interface{} {
-	return okResponse(dbManager.ListConnections(), nil)
+	names := dbManager.ListConnections()
+	sort.Strings(names)
+	connections := make([]map[string]string, len(names))
+	for i, name := range names {
+		connections[i] = map[string]string{"name": name, "mode": string(dbManager.Mode(name))}
+	}
+	count := len(connections)
+	return okResponse(connections, &count)
 }
 
 func queryHandler(args map[string]interface{}) map[string]interface{} {
@@ -47,11 +68,9 @@ func queryHandler(args map[string]interface{}) map[string]interface{} {
 		return errResponse("sql is required")
 	}
 
-	var params []interface{}
-	if p, exists := args["params"]; exists {
-		if paramSlice, ok := p.([]interface{}); ok {
-			params = paramSlice
-		}
+	params, err := paramsArg(args)
+	if err != nil {
+		return invalidParamsResponse(err)
 	}
 
 	var database string
@@ -61,11 +80,10 @@ func queryHandler(args map[string]interface{}) map[string]interface{} {
 		}
 	}
 
-	var limit *int
+	var limit int
 	if l, exists := args["limit"]; exists {
 		if limitFloat, ok := l.(float64); ok {
-			limitInt := int(limitFloat)
-			limit = &limitInt
+			limit = int(limitFloat)
 		}
 	}
 
@@ -77,57 +95,91 @@ func queryHandler(args map[string]interface{}) map[string]interface{} {
 		}
 	}
 
-	// Wrap with LIMIT if requested
-	if limit != nil && *limit > 0 {
-		sqlQuery = fmt.Sprintf("SELECT * FROM (%s) AS __q LIMIT %d", sqlQuery, *limit)
+	idleTimeout := defaultCursorIdleTimeout
+	if t, exists := args["cursor_idle_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok && timeoutFloat > 0 {
+			idleTimeout = time.Duration(timeoutFloat) * time.Millisecond
+		}
 	}
 
-	if !dbManager.HasConnection(database) {
-		return errResponse("No database connection available")
+	outputFormat, err := formatArg(args)
+	if err != nil {
+		return errResponse(err.Error())
 	}
 
-	db := dbManager.GetConnection(database)
-	if db == nil {
-		return errResponse("No database connection available")
+	// Classify before anything runs; only read-only statements are accepted
+	if err := sqlguard.EnsureReadOnly(sqlQuery); err != nil {
+		var rej *sqlguard.Rejection
+		if errors.As(err, &rej) {
+			return errResponseWithDetail(fmt.Sprintf("Query rejected: %s", rej.Reason), rej)
+		}
+		return errResponse(fmt.Sprintf("Query rejected: %s", err))
 	}
-
-	rows, err := execWithTimeout(db, timeoutMs, sqlQuery, params...)
+	stmt, err := sqlguard.ParseSingle(sqlQuery)
 	if err != nil {
-		return errResponse(fmt.Sprintf("Query failed: %s", err))
+		return errResponse(fmt.Sprintf("Query rejected: %s", err))
 	}
-	defer rows.Close()
 
-	columns, err := rows.Columns()
+	t, err := resolveTarget(args, database)
 	if err != nil {
-		return errResponse(fmt.Sprintf("Failed to get columns: %s", err))
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkStatementAccess(t.database, stmt.Text); err != nil {
+		return accessDeniedResponse(err)
 	}
 
-	var result []map[string]interface{}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
+	pageSize := pageSizeArg(args)
 
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return errResponse(fmt.Sprintf("Failed to scan row: %s", err))
-		}
+	// SELECT, VALUES and TABLE are paged through a server-side cursor
+	if stmt.Kind == sqlguard.KindSelect || stmt.Kind == sqlguard.KindValues || stmt.Kind == sqlguard.KindTable {
+		return pagedQuery(t, outputFormat, stmt.Text, params, limit, pageSize, idleTimeout, timeoutMs)
+	}
+
+	// SHOW and EXPLAIN cannot be declared as cursors; their output is small
+	var result *rowSet
+	err = t.run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+		var err error
+		result, err = queryRowSet(ctx, q, stmt.Text, params...)
+		return err
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Query failed: %s", err))
+	}
+	maskQueryRows(t.database, stmt.Text, result.columns, result.rows)
+	if limit > 0 && limit < pageSize {
+		pageSize = limit
+	}
+	truncated := len(result.rows) > pageSize
+	if truncated {
+		result.rows = result.rows[:pageSize]
+	}
+	return okRowsResponse(outputFormat, result, "", truncated)
+}
 
-		row := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if b, ok := val.([]byte); ok {
-				row[col] = string(b)
-			} else {
-				row[col] = val
-			}
+// pagedQuery returns the first page of a row-returning query. Outside a
+// session the rest stays open behind a cursor token; inside one, rows past
+// the page are cut off because the cursor could not outlive the call.
+func pagedQuery(t *target, outputFormat, query string, params []interface{}, limit, pageSize int, idleTimeout time.Duration, timeoutMs *int) map[string]interface{} {
+	if t.session == nil {
+		page, next, err := cursorManager.Open(t.database, t.db, query, params, limit, pageSize, idleTimeout, timeoutMs)
+		if err != nil {
+			return errResponse(fmt.Sprintf("Query failed: %s", err))
 		}
-		result = append(result, row)
+		return okRowsResponse(outputFormat, page, next, false)
 	}
 
-	rowCount := len(result)
-	return okResponse(result, &rowCount)
+	var page *rowSet
+	var truncated bool
+	err := t.run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+		var err error
+		page, truncated, err = readPage(ctx, q, t.database, query, params, limit, pageSize)
+		return err
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Query failed: %s", err))
+	}
+	return okRowsResponse(outputFormat, page, "", truncated)
 }
 
 func insertHandler(args map[string]interface{}) map[string]interface{} {
@@ -163,13 +215,21 @@ func insertHandler(args map[string]interface{}) map[string]interface{} {
 		}
 	}
 
-	if !dbManager.HasConnection(database) {
-		return errResponse("No database connection available")
+	outputFormat, err := formatArg(args)
+	if err != nil {
+		return errResponse(err.Error())
 	}
 
-	db := dbManager.GetConnection(database)
-	if db == nil {
-		return errResponse("No database connection available")
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkWritable(t.database); err != nil {
+		return errResponse(err.Error())
+	}
+	if err := checkAccess(t.database, table, acl.Insert); err != nil {
+		return accessDeniedResponse(err)
 	}
 
 	// Build INSERT query
@@ -181,6 +241,7 @@ func insertHandler(args map[string]interface{}) map[string]interface{} {
 	var columns []string
 	var placeholders []string
 	var values []interface{}
+	var conv pgparam.Converter
 	i := 1
 	for col, val := range data {
 		quotedCol, err := qIdent(col)
@@ -189,59 +250,37 @@ func insertHandler(args map[string]interface{}) map[string]interface{} {
 		}
 		columns = append(columns, quotedCol)
 		placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-		values = append(values, val)
+		values = append(values, conv.Convert("data."+col, val))
 		i++
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		quotedTable,
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "))
-
-	if returning {
-		query += " RETURNING *"
-		rows, err := execWithTimeout(db, timeoutMs, query, values...)
-		if err != nil {
-			return errResponse(fmt.Sprintf("Insert failed: %s", err))
-		}
-		defer rows.Close()
-
-		columns, err := rows.Columns()
-		if err != nil {
-			return errResponse(fmt.Sprintf("Failed to get columns: %s", err))
-		}
-
-		if rows.Next() {
-			rowValues := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
-			for i := range rowValues {
-				valuePtrs[i] = &rowValues[i]
-			}
-
-			if err := rows.Scan(valuePtrs...); err != nil {
-				return errResponse(fmt.Sprintf("Failed to scan row: %s", err))
-			}
-
-			row := make(map[string]interface{})
-			for i, col := range columns {
-				val := rowValues[i]
-				if b, ok := val.([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = val
-				}
-			}
-			rowCount := 1
-			return okResponse(row, &rowCount)
-		}
-	} else {
-		if err := execWithTimeoutSingle(db, timeoutMs, query, values...); err != nil {
-			return errResponse(fmt.Sprintf("Insert failed: %s", err))
-		}
+	if err := conv.Err(); err != nil {
+		return invalidParamsResponse(err)
 	}
 
-	rowCount := 1
-	return okResponse(nil, &rowCount)
+	mut := &mutation{
+		tool:  "insert",
+		table: quotedTable,
+		name:  table,
+		query: fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			quotedTable,
+			strings.Join(columns, ", "),
+			strings.Join(placeholders, ", ")),
+		values: values,
+	}
+	result, count, err := runMutation(t, timeoutMs, mut, returning, "")
+	if err != nil {
+		return errResponse(fmt.Sprintf("Insert failed: %s", err))
+	}
+	if !returning {
+		return okResponse(nil, &count)
+	}
+	// JSON returns the inserted row itself rather than a list
+	var row interface{}
+	if outputFormat == format.JSON && len(result.rows) > 0 {
+		row = result.maps()[0]
+	}
+	return okTableResponse(outputFormat, result, row, &count, "", false)
 }
 
 func updateHandler(args map[string]interface{}) map[string]interface{} {
@@ -255,9 +294,9 @@ func updateHandler(args map[string]interface{}) map[string]interface{} {
 		return errResponse("data must be a non-empty object")
 	}
 
-	where, ok := args["where"].(map[string]interface{})
-	if !ok || len(where) == 0 {
-		return errResponse("where must be a non-empty object")
+	where := args["where"]
+	if filter.IsEmpty(where) {
+		return errResponse("where must be a non-empty filter")
 	}
 
 	var database string
@@ -274,6 +313,20 @@ func updateHandler(args map[string]interface{}) map[string]interface{} {
 		}
 	}
 
+	dryRunMode := false
+	if d, exists := args["dry_run"]; exists {
+		if dBool, ok := d.(bool); ok {
+			dryRunMode = dBool
+		}
+	}
+
+	var token string
+	if c, exists := args["confirmation_token"]; exists {
+		if cStr, ok := c.(string); ok {
+			token = cStr
+		}
+	}
+
 	var timeoutMs *int
 	if t, exists := args["statement_timeout_ms"]; exists {
 		if timeoutFloat, ok := t.(float64); ok {
@@ -282,13 +335,21 @@ func updateHandler(args map[string]interface{}) map[string]interface{} {
 		}
 	}
 
-	if !dbManager.HasConnection(database) {
-		return errResponse("No database connection available")
+	outputFormat, err := formatArg(args)
+	if err != nil {
+		return errResponse(err.Error())
 	}
 
-	db := dbManager.GetConnection(database)
-	if db == nil {
-		return errResponse("No database connection available")
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkWritable(t.database); err != nil {
+		return errResponse(err.Error())
+	}
+	if err := checkAccess(t.database, table, acl.Update); err != nil {
+		return accessDeniedResponse(err)
 	}
 
 	// Build UPDATE query
@@ -297,86 +358,62 @@ func updateHandler(args map[string]interface{}) map[string]interface{} {
 		return errResponse(err.Error())
 	}
 
+	// SET clause, in column order so a dry run and the real call match
+	columns := make([]string, 0, len(data))
+	for col := range data {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
 	var setClauses []string
-	var whereClauses []string
 	var values []interface{}
-	i := 1
-
-	// SET clause
-	for col, val := range data {
+	var conv pgparam.Converter
+	for i, col := range columns {
 		quotedCol, err := qIdent(col)
 		if err != nil {
 			return errResponse(err.Error())
 		}
-		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", quotedCol, i))
-		values = append(values, val)
-		i++
+		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", quotedCol, i+1))
+		values = append(values, conv.Convert("data."+col, data[col]))
 	}
 
-	// WHERE clause
-	for col, val := range where {
-		quotedCol, err := qIdent(col)
-		if err != nil {
-			return errResponse(err.Error())
-		}
-		whereClauses = append(whereClauses, fmt.Sprintf("%s = $%d", quotedCol, i))
-		values = append(values, val)
-		i++
+	if err := conv.Err(); err != nil {
+		return invalidParamsResponse(err)
 	}
 
-	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
-		quotedTable,
-		strings.Join(setClauses, ", "),
-		strings.Join(whereClauses, " AND "))
-
-	if returning {
-		query += " RETURNING *"
-		rows, err := execWithTimeout(db, timeoutMs, query, values...)
-		if err != nil {
-			return errResponse(fmt.Sprintf("Update failed: %s", err))
-		}
-		defer rows.Close()
-
-		columns, err := rows.Columns()
-		if err != nil {
-			return errResponse(fmt.Sprintf("Failed to get columns: %s", err))
-		}
+	// WHERE clause; its placeholders follow the SET values
+	whereClause, values, err := compileWhere(where, values)
+	if err != nil {
+		return whereErrResponse(err)
+	}
 
-		var result []map[string]interface{}
-		for rows.Next() {
-			rowValues := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
-			for i := range rowValues {
-				valuePtrs[i] = &rowValues[i]
-			}
-
-			if err := rows.Scan(valuePtrs...); err != nil {
-				return errResponse(fmt.Sprintf("Failed to scan row: %s", err))
-			}
-
-			row := make(map[string]interface{})
-			for i, col := range columns {
-				val := rowValues[i]
-				if b, ok := val.([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = val
-				}
-			}
-			result = append(result, row)
-		}
+	mut := &mutation{
+		tool:  "update",
+		table: quotedTable,
+		name:  table,
+		query: fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+			quotedTable,
+			strings.Join(setClauses, ", "),
+			whereClause),
+		values:  values,
+		confirm: true,
+	}
+	// Previews and before images select the matching rows with the filter on its own
+	if mut.where, mut.whereValues, err = compileWhere(where, nil); err != nil {
+		return whereErrResponse(err)
+	}
+	if dryRunMode {
+		return dryRun(t, timeoutMs, mut, sampleSizeArg(args))
+	}
 
-		rowCount := len(result)
-		return okResponse(result, &rowCount)
-	} else {
-		result, err := db.Exec(query, values...)
-		if err != nil {
-			return errResponse(fmt.Sprintf("Update failed: %s", err))
-		}
-		rowsAffected, _ := result.RowsAffected()
-		count := int(rowsAffected)
-		return okResponse(nil, &count)
+	result, count, err := runMutation(t, timeoutMs, mut, returning, token)
+	if err != nil {
+		return mutationErrResponse("Update", err)
+	}
+	if returning {
+		return okRowsResponse(outputFormat, result, "", false)
 	}
+	return okResponse(nil, &count)
 }
 
 func deleteHandler(args map[string]interface{}) map[string]interface{} {
@@ -385,9 +422,9 @@ func deleteHandler(args map[string]interface{}) map[string]interface{} {
 		return errResponse("table is required")
 	}
 
-	where, ok := args["where"].(map[string]interface{})
-	if !ok || len(where) == 0 {
-		return errResponse("where must be a non-empty object")
+	where := args["where"]
+	if filter.IsEmpty(where) {
+		return errResponse("where must be a non-empty filter")
 	}
 
 	var database string
@@ -404,6 +441,20 @@ func deleteHandler(args map[string]interface{}) map[string]interface{} {
 		}
 	}
 
+	dryRunMode := false
+	if d, exists := args["dry_run"]; exists {
+		if dBool, ok := d.(bool); ok {
+			dryRunMode = dBool
+		}
+	}
+
+	var token string
+	if c, exists := args["confirmation_token"]; exists {
+		if cStr, ok := c.(string); ok {
+			token = cStr
+		}
+	}
+
 	var timeoutMs *int
 	if t, exists := args["statement_timeout_ms"]; exists {
 		if timeoutFloat, ok := t.(float64); ok {
@@ -412,13 +463,21 @@ func deleteHandler(args map[string]interface{}) map[string]interface{} {
 		}
 	}
 
-	if !dbManager.HasConnection(database) {
-		return errResponse("No database connection available")
+	outputFormat, err := formatArg(args)
+	if err != nil {
+		return errResponse(err.Error())
 	}
 
-	db := dbManager.GetConnection(database)
-	if db == nil {
-		return errResponse("No database connection available")
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkWritable(t.database); err != nil {
+		return errResponse(err.Error())
+	}
+	if err := checkAccess(t.database, table, acl.Delete); err != nil {
+		return accessDeniedResponse(err)
 	}
 
 	// Build DELETE query
@@ -427,72 +486,61 @@ func deleteHandler(args map[string]interface{}) map[string]interface{} {
 		return errResponse(err.Error())
 	}
 
-	var whereClauses []string
-	var values []interface{}
-	i := 1
-
-	for col, val := range where {
-		quotedCol, err := qIdent(col)
-		if err != nil {
-			return errResponse(err.Error())
-		}
-		whereClauses = append(whereClauses, fmt.Sprintf("%s = $%d", quotedCol, i))
-		values = append(values, val)
-		i++
+	whereClause, values, err := compileWhere(where, nil)
+	if err != nil {
+		return whereErrResponse(err)
 	}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE %s",
-		quotedTable,
-		strings.Join(whereClauses, " AND "))
+	mut := &mutation{
+		tool:        "delete",
+		table:       quotedTable,
+		name:        table,
+		query:       fmt.Sprintf("DELETE FROM %s WHERE %s", quotedTable, whereClause),
+		values:      values,
+		where:       whereClause,
+		whereValues: values,
+		confirm:     true,
+	}
+	if dryRunMode {
+		return dryRun(t, timeoutMs, mut, sampleSizeArg(args))
+	}
 
+	result, count, err := runMutation(t, timeoutMs, mut, returning, token)
+	if err != nil {
+		return mutationErrResponse("Delete", err)
+	}
 	if returning {
-		query += " RETURNING *"
-		rows, err := execWithTimeout(db, timeoutMs, query, values...)
-		if err != nil {
-			return errResponse(fmt.Sprintf("Delete failed: %s", err))
-		}
-		defer rows.Close()
-
-		columns, err := rows.Columns()
-		if err != nil {
-			return errResponse(fmt.Sprintf("Failed to get columns: %s", err))
-		}
+		return okRowsResponse(outputFormat, result, "", false)
+	}
+	return okResponse(nil, &count)
+}
 
-		var result []map[string]interface{}
-		for rows.Next() {
-			rowValues := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
-			for i := range rowValues {
-				valuePtrs[i] = &rowValues[i]
-			}
-
-			if err := rows.Scan(valuePtrs...); err != nil {
-				return errResponse(fmt.Sprintf("Failed to scan row: %s", err))
-			}
-
-			row := make(map[string]interface{})
-			for i, col := range columns {
-				val := rowValues[i]
-				if b, ok := val.([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = val
-				}
-			}
-			result = append(result, row)
-		}
+// mutationErrResponse reports a failed update or delete, with the counts when
+// it was rolled back for lack of confirmation
+func mutationErrResponse(action string, err error) map[string]interface{} {
+	var ce *confirmationError
+	if errors.As(err, &ce) {
+		return errResponseWithDetail(fmt.Sprintf("%s rolled back: %s", action, err), ce)
+	}
+	return errResponse(fmt.Sprintf("%s failed: %s", action, err))
+}
 
-		rowCount := len(result)
-		return okResponse(result, &rowCount)
-	} else {
-		result, err := db.Exec(query, values...)
+// execWrite runs a data-modifying statement on q, reading the RETURNING rows
+// when returning is set
+func execWrite(ctx context.Context, q queryer, returning bool, query string, values ...interface{}) (*rowSet, int, error) {
+	if returning {
+		rows, err := queryRowSet(ctx, q, query, values...)
 		if err != nil {
-			return errResponse(fmt.Sprintf("Delete failed: %s", err))
+			return &rowSet{}, 0, err
 		}
-		rowsAffected, _ := result.RowsAffected()
-		count := int(rowsAffected)
-		return okResponse(nil, &count)
+		return rows, len(rows.rows), nil
 	}
+	res, err := q.ExecContext(ctx, query, values...)
+	if err != nil {
+		return &rowSet{}, 0, err
+	}
+	affected, _ := res.RowsAffected()
+	return &rowSet{}, int(affected), nil
 }
 
 func listSchemasHandler(args map[string]interface{}) map[string]interface{} {
@@ -599,61 +647,23 @@ func describeTableHandler(args map[string]interface{}) map[string]interface{} {
 		return errResponse("table is required")
 	}
 
-	if !dbManager.HasConnection(database) {
-		return errResponse("No database connection available")
-	}
-
-	db := dbManager.GetConnection(database)
-	if db == nil {
-		return errResponse("No database connection available")
-	}
-
-	// Split schema.table if provided
-	parts := strings.Split(table, ".")
-	var schema, tableName string
-	if len(parts) == 2 {
-		schema = parts[0]
-		tableName = parts[1]
-	} else {
-		schema = "public"
-		tableName = parts[0]
-	}
-
-	query := `
-            SELECT column_name, data_type, is_nullable, column_default
-            FROM information_schema.columns
-            WHERE table_schema = $1 AND table_name = $2
-            ORDER BY ordinal_position
-    `
+	schema, tableName := splitTableName(table)
 
-	rows, err := db.Query(query, schema, tableName)
+	t, err := resolveTarget(args, database)
 	if err != nil {
-		return errResponse(fmt.Sprintf("Query failed: %s", err))
+		return errResponse(err.Error())
 	}
-	defer rows.Close()
-
-	var columns []map[string]interface{}
-	for rows.Next() {
-		var columnName, dataType, isNullable string
-		var columnDefault sql.NullString
-
-		if err := rows.Scan(&columnName, &dataType, &isNullable, &columnDefault); err != nil {
-			return errResponse(fmt.Sprintf("Failed to scan row: %s", err))
-		}
+	defer t.release()
 
-		column := map[string]interface{}{
-			"column_name":    columnName,
-			"data_type":      dataType,
-			"is_nullable":    isNullable,
-			"column_default": nil,
-		}
-
-		if columnDefault.Valid {
-			column["column_default"] = columnDefault.String
-		}
-
-		columns = append(columns, column)
+	var info *TableInfo
+	err = t.run(nil, modeReadOnly, func(ctx context.Context, q queryer) error {
+		var err error
+		info, err = describeTable(ctx, q, schema, tableName)
+		return err
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Describe failed: %s", err))
 	}
 
-	return okResponse(columns, nil)
+	return okResponse(info, nil)
 }
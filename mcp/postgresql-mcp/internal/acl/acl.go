@@ -0,0 +1,178 @@
+// Package acl decides which operations a connection may run on which tables.
+package acl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Operation is a class of statement the policy grants or denies
+type Operation string
+
+const (
+	Read   Operation = "read"
+	Insert Operation = "insert"
+	Update Operation = "update"
+	Delete Operation = "delete"
+	DDL    Operation = "ddl"
+)
+
+var operations = map[Operation]bool{Read: true, Insert: true, Update: true, Delete: true, DDL: true}
+
+// Rule matches tables by connection name, schema and table, which are
+// case-insensitive * globs; an empty field matches anything. Operations
+// lists what the rule grants or denies; empty means every operation.
+type Rule struct {
+	Connection string      `json:"connection,omitempty"`
+	Schema     string      `json:"schema,omitempty"`
+	Table      string      `json:"table,omitempty"`
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+// Policy grants the operations of its allow rules, except those of matching
+// deny rules. Anything no allow rule grants is denied.
+type Policy struct {
+	Allow []Rule `json:"allow"`
+	Deny  []Rule `json:"deny,omitempty"`
+}
+
+// Load reads a JSON access policy file
+func Load(file string) (*Policy, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read access policy: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse access policy %s: %w", file, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("parse access policy %s: %w", file, err)
+	}
+	return &p, nil
+}
+
+// Validate checks the operation names and globs of every rule
+func (p *Policy) Validate() error {
+	for kind, rules := range map[string][]Rule{"allow": p.Allow, "deny": p.Deny} {
+		for i, r := range rules {
+			for _, op := range r.Operations {
+				if !operations[op] {
+					return fmt.Errorf("%s[%d]: unknown operation %q (want read, insert, update, delete or ddl)", kind, i, op)
+				}
+			}
+			for _, glob := range []string{r.Connection, r.Schema, r.Table} {
+				if _, err := path.Match(glob, ""); err != nil {
+					return fmt.Errorf("%s[%d]: invalid glob %q", kind, i, glob)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Error explains why an operation was refused
+type Error struct {
+	Connection string    `json:"connection"`
+	Schema     string    `json:"schema"`
+	Table      string    `json:"table"`
+	Operation  Operation `json:"operation"`
+	// Rule is the deny rule that matched, e.g. "deny[0]", or "allow" when no
+	// allow rule grants the operation
+	Rule string `json:"rule"`
+}
+
+func (e *Error) Error() string {
+	if e.Rule == "allow" {
+		return fmt.Sprintf("%s on %s.%s is not allowed for connection %s", e.Operation, e.Schema, e.Table, e.Connection)
+	}
+	return fmt.Sprintf("%s on %s.%s is denied for connection %s (%s)", e.Operation, e.Schema, e.Table, e.Connection, e.Rule)
+}
+
+// Check returns an *Error unless op on schema.table is allowed for connection
+func (p *Policy) Check(connection, schema, table string, op Operation) error {
+	for i, r := range p.Deny {
+		if r.matches(connection, schema, table) && r.grants(op) {
+			return &Error{Connection: connection, Schema: schema, Table: table, Operation: op, Rule: fmt.Sprintf("deny[%d]", i)}
+		}
+	}
+	for _, r := range p.Allow {
+		if r.matches(connection, schema, table) && r.grants(op) {
+			return nil
+		}
+	}
+	return &Error{Connection: connection, Schema: schema, Table: table, Operation: op, Rule: "allow"}
+}
+
+// CheckConnection returns an *Error unless op is allowed on every table of
+// connection, as statements without a known set of tables, like migrations,
+// need. Any deny rule for op on the connection refuses it.
+func (p *Policy) CheckConnection(connection string, op Operation) error {
+	for i, r := range p.Deny {
+		if globMatch(r.Connection, connection) && r.grants(op) {
+			return &Error{Connection: connection, Schema: "*", Table: "*", Operation: op, Rule: fmt.Sprintf("deny[%d]", i)}
+		}
+	}
+	for _, r := range p.Allow {
+		if globMatch(r.Connection, connection) && isAny(r.Schema) && isAny(r.Table) && r.grants(op) {
+			return nil
+		}
+	}
+	return &Error{Connection: connection, Schema: "*", Table: "*", Operation: op, Rule: "allow"}
+}
+
+// Permits reports whether op is allowed on some table of some connection,
+// which decides whether the tools that need it are offered at all
+func (p *Policy) Permits(op Operation) bool {
+	for _, r := range p.Allow {
+		if r.grants(op) && !p.deniesAll(r, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniesAll reports whether a deny rule takes op away from every table the
+// allow rule a matches
+func (p *Policy) deniesAll(a Rule, op Operation) bool {
+	for _, d := range p.Deny {
+		if d.grants(op) && isAny(d.Schema) && isAny(d.Table) && (isAny(d.Connection) || globMatch(d.Connection, a.Connection)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(connection, schema, table string) bool {
+	return globMatch(r.Connection, connection) && globMatch(r.Schema, schema) && globMatch(r.Table, table)
+}
+
+func (r Rule) grants(op Operation) bool {
+	if len(r.Operations) == 0 {
+		return true
+	}
+	for _, o := range r.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func isAny(glob string) bool {
+	return glob == "" || glob == "*"
+}
+
+func globMatch(glob, s string) bool {
+	if glob == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(glob), strings.ToLower(s))
+	return ok
+}
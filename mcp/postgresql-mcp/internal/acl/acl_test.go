@@ -0,0 +1,95 @@
+package acl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testPolicy() *Policy {
+	return &Policy{
+		Allow: []Rule{
+			{Connection: "primary_db", Operations: []Operation{Read, Insert, Update}},
+			{Connection: "primary_db", Schema: "public", Table: "sessions", Operations: []Operation{Delete}},
+			{Connection: "analytics_*", Operations: []Operation{Read}},
+		},
+		Deny: []Rule{
+			{Table: "secrets"},
+			{Connection: "primary_db", Schema: "audit", Operations: []Operation{Insert, Update}},
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := testPolicy()
+	for _, tc := range []struct {
+		connection, schema, table string
+		op                        Operation
+		rule                      string
+	}{
+		{"primary_db", "public", "users", Read, ""},
+		{"primary_db", "public", "users", Update, ""},
+		{"primary_db", "public", "users", Delete, "allow"},
+		{"primary_db", "public", "sessions", Delete, ""},
+		{"primary_db", "public", "Secrets", Read, "deny[0]"},
+		{"primary_db", "audit", "log", Insert, "deny[1]"},
+		{"primary_db", "audit", "log", Read, ""},
+		{"analytics_db", "public", "events", Read, ""},
+		{"analytics_db", "public", "events", Insert, "allow"},
+		{"other_db", "public", "users", Read, "allow"},
+		{"primary_db", "public", "users", DDL, "allow"},
+	} {
+		err := p.Check(tc.connection, tc.schema, tc.table, tc.op)
+		var aerr *Error
+		switch {
+		case tc.rule == "" && err != nil:
+			t.Errorf("%s %s.%s on %s: unexpected error %v", tc.op, tc.schema, tc.table, tc.connection, err)
+		case tc.rule != "" && (!errors.As(err, &aerr) || aerr.Rule != tc.rule):
+			t.Errorf("%s %s.%s on %s: expected rule %s, got %v", tc.op, tc.schema, tc.table, tc.connection, tc.rule, err)
+		}
+	}
+}
+
+func TestPermits(t *testing.T) {
+	p := testPolicy()
+	for op, want := range map[Operation]bool{Read: true, Insert: true, Update: true, Delete: true, DDL: false} {
+		if got := p.Permits(op); got != want {
+			t.Errorf("Permits(%s) = %v, want %v", op, got, want)
+		}
+	}
+	p.Deny = append(p.Deny, Rule{Table: "*", Operations: []Operation{Delete}})
+	if p.Permits(Delete) {
+		t.Errorf("a deny rule for every table should take delete away")
+	}
+}
+
+func TestCheckConnection(t *testing.T) {
+	p := &Policy{
+		Allow: []Rule{
+			{Connection: "primary_db", Operations: []Operation{Read, DDL}},
+			{Connection: "analytics_db", Table: "events", Operations: []Operation{DDL}},
+		},
+		Deny: []Rule{{Connection: "primary_db", Table: "audit_*", Operations: []Operation{Delete}}},
+	}
+	if err := p.CheckConnection("primary_db", DDL); err != nil {
+		t.Errorf("expected ddl on primary_db: %v", err)
+	}
+	if err := p.CheckConnection("analytics_db", DDL); err == nil {
+		t.Errorf("ddl on one table should not allow every table")
+	}
+	var aerr *Error
+	if err := p.CheckConnection("primary_db", Delete); !errors.As(err, &aerr) || aerr.Rule != "deny[0]" {
+		t.Errorf("a deny rule on some tables should refuse the whole connection: %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownOperation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.json")
+	if err := os.WriteFile(file, []byte(`{"allow": [{"connection": "primary_db", "operations": ["write"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Errorf("expected an error for an unknown operation")
+	}
+}
@@ -0,0 +1,185 @@
+// Package audit keeps an append-only JSON Lines log of data changes with the
+// row images captured around each change.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
+)
+
+// Entry is one data-modifying statement
+type Entry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Tool is the tool that ran the statement; Operation is its SQL verb
+	Tool        string `json:"tool"`
+	Operation   string `json:"operation"`
+	Connection  string `json:"connection"`
+	DSN         string `json:"dsn"`
+	Transaction string `json:"transaction,omitempty"`
+	Table       string `json:"table"`
+	SQL         string `json:"sql"`
+	// Params are the values bound to SQL, after conversion
+	Params   []interface{} `json:"params"`
+	Affected int           `json:"affected"`
+	// Columns describes the columns of the row images
+	Columns []pgvalue.Column         `json:"columns,omitempty"`
+	Before  []map[string]interface{} `json:"before,omitempty"`
+	After   []map[string]interface{} `json:"after,omitempty"`
+}
+
+// Query selects entries; zero fields match everything
+type Query struct {
+	ID         string
+	Since      time.Time
+	Until      time.Time
+	Table      string
+	Operation  string
+	Connection string
+	// Limit keeps the newest entries, or all of them when zero
+	Limit int
+}
+
+// Log appends entries to a file opened in append mode
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// Open opens or creates the log at path
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{path: path, file: f}, nil
+}
+
+// Path returns the file the log is written to
+func (l *Log) Path() string { return l.path }
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Append assigns e an ID and time when unset and writes it as one line that
+// is synced to disk before Append returns
+func (l *Log) Append(e *Entry) error {
+	if e.ID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		e.ID = "aud_" + hex.EncodeToString(b)
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Search reads the log and returns the matching entries, newest first
+func (l *Log) Search(q Query) ([]*Entry, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+
+	var matches []*Entry
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A line without its newline is still being written
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("audit log line %d: %w", n, err)
+		}
+		if q.matches(&e) {
+			matches = append(matches, &e)
+		}
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	return matches, nil
+}
+
+func (q *Query) matches(e *Entry) bool {
+	switch {
+	case q.ID != "" && e.ID != q.ID:
+		return false
+	case !q.Since.IsZero() && e.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !e.Time.Before(q.Until):
+		return false
+	case q.Operation != "" && !strings.EqualFold(q.Operation, e.Operation) && !strings.EqualFold(q.Operation, e.Tool):
+		return false
+	case q.Connection != "" && q.Connection != e.Connection:
+		return false
+	case q.Table != "" && !SameTable(q.Table, e.Table):
+		return false
+	}
+	return true
+}
+
+// SameTable reports whether two table names refer to the same table. An
+// unqualified name matches any schema, since the search path is not known.
+func SameTable(a, b string) bool {
+	if a == b {
+		return true
+	}
+	aSchema, aName := splitTable(a)
+	bSchema, bName := splitTable(b)
+	if aName != bName {
+		return false
+	}
+	return aSchema == "" || bSchema == "" || aSchema == bSchema
+}
+
+func splitTable(table string) (string, string) {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return "", table
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T) *Log {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestAppendAndSearch(t *testing.T) {
+	l := openTemp(t)
+	base := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, e := range []*Entry{
+		{Tool: "insert", Operation: "insert", Connection: "primary_db", Table: "users"},
+		{Tool: "update", Operation: "update", Connection: "primary_db", Table: "public.users", Before: []map[string]interface{}{{"id": 1.0}}},
+		{Tool: "delete", Operation: "delete", Connection: "analytics_db", Table: "events"},
+		{Tool: "upsert", Operation: "insert", Connection: "primary_db", Table: "sales.users"},
+	} {
+		e.Time = base.Add(time.Duration(i) * time.Hour)
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(e.ID, "aud_") {
+			t.Fatalf("expected an ID, got %q", e.ID)
+		}
+	}
+
+	for _, tc := range []struct {
+		q    Query
+		want []string
+	}{
+		{Query{}, []string{"upsert", "delete", "update", "insert"}},
+		{Query{Table: "users"}, []string{"upsert", "update", "insert"}},
+		{Query{Table: "public.users"}, []string{"update", "insert"}},
+		{Query{Operation: "insert"}, []string{"upsert", "insert"}},
+		{Query{Operation: "UPSERT"}, []string{"upsert"}},
+		{Query{Since: base.Add(time.Hour), Until: base.Add(3 * time.Hour)}, []string{"delete", "update"}},
+		{Query{Connection: "primary_db", Limit: 2}, []string{"upsert", "update"}},
+	} {
+		entries, err := l.Search(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Tool)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Search(%+v) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	entries, _ := l.Search(Query{Operation: "update"})
+	if len(entries[0].Before) != 1 || entries[0].Before[0]["id"] != 1.0 {
+		t.Errorf("expected the before image to round-trip: %+v", entries[0])
+	}
+}
+
+func TestSearchSkipsPartialLine(t *testing.T) {
+	l := openTemp(t)
+	if err := l.Append(&Entry{Tool: "insert", Table: "users"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(l.Path(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"aud_partial","tool":"del`)
+	f.Close()
+
+	entries, err := l.Search(Query{})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the complete entry only, got %v, %v", entries, err)
+	}
+}
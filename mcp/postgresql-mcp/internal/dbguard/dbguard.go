@@ -3,16 +3,13 @@ package dbguard
 import (
 	"errors"
 	"fmt"
-	"net"
 	"net/url"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
-var (
-	allowedSocketRoots = []string{"/var/run/postgresql", "/tmp", "/var/pgsql_socket"}
-	errRemote          = errors.New("remote database is not allowed (local only)")
-)
+var allowedSocketRoots = []string{"/var/run/postgresql", "/tmp", "/var/pgsql_socket"}
 
 // LoadPostgresURLsFromArgs loads comma-separated PostgreSQL URLs from command line argument
 func LoadPostgresURLsFromArgs(urlString string) ([]string, error) {
@@ -34,7 +31,7 @@ func LoadPostgresURLsFromArgs(urlString string) ([]string, error) {
 	return out, nil
 }
 
-// EnforceLocalForURLs validates URLs are local and returns the original DSNs
+// EnforceLocalForURLs validates URLs against DefaultPolicy and returns the original DSNs
 func EnforceLocalForURLs(urls []string) ([]string, error) {
 	out := make([]string, 0, len(urls))
 	for _, dsn := range urls {
@@ -42,7 +39,7 @@ func EnforceLocalForURLs(urls []string) ([]string, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid URL: %q: %w", RedactDSN(dsn), err)
 		}
-		if err := validateLocalURL(u); err != nil {
+		if err := DefaultPolicy().Evaluate(u); err != nil {
 			return nil, fmt.Errorf("%w: %s", err, RedactDSN(dsn))
 		}
 		out = append(out, dsn)
@@ -50,82 +47,6 @@ func EnforceLocalForURLs(urls []string) ([]string, error) {
 	return out, nil
 }
 
-func validateLocalURL(u *url.URL) error {
-	if hosts := u.Query()["host"]; len(hosts) > 0 {
-		for _, h := range hosts {
-			if strings.HasPrefix(h, "/cloudsql/") {
-				return fmt.Errorf("%w: cloud sql unix socket is forbidden: %q", errRemote, h)
-			}
-			if !strings.HasPrefix(h, "/") || !isUnderAllowedSocketRoots(h) {
-				return fmt.Errorf("%w: unix socket outside allowed roots: %q", errRemote, h)
-			}
-		}
-		return nil
-	}
-
-	rawHost := u.Host
-	if rawHost == "" {
-		return fmt.Errorf("missing host")
-	}
-	for _, hp := range splitHostListPreservingIPv6(rawHost) {
-		h := hp
-		if strings.Contains(hp, ":") {
-			if hh, _, err := net.SplitHostPort(hp); err == nil {
-				h = hh
-			} else {
-				return fmt.Errorf("invalid host:port: %q", hp)
-			}
-		}
-		h = strings.Trim(h, "[]")
-
-		if strings.HasPrefix(h, "/") {
-			if strings.HasPrefix(h, "/cloudsql/") {
-				return fmt.Errorf("%w: cloud sql unix socket is forbidden: %q", errRemote, h)
-			}
-			if !isUnderAllowedSocketRoots(h) {
-				return fmt.Errorf("%w: unix socket outside allowed roots: %q", errRemote, h)
-			}
-			continue
-		}
-
-		if ip := net.ParseIP(h); ip != nil {
-			if !ip.IsLoopback() {
-				return fmt.Errorf("%w: non-loopback ip: %s", errRemote, ip.String())
-			}
-			continue
-		}
-
-		ips, err := net.LookupIP(h)
-		if err != nil || len(ips) == 0 {
-			return fmt.Errorf("host resolve failed: %q", h)
-		}
-		for _, ip := range ips {
-			if !ip.IsLoopback() {
-				return fmt.Errorf("%w: host resolves to non-loopback: %s -> %s", errRemote, h, ip.String())
-			}
-		}
-	}
-	return nil
-}
-
-func isUnderAllowedSocketRoots(sock string) bool {
-	real, err := filepath.EvalSymlinks(sock)
-	if err != nil {
-		return false
-	}
-	abs, err := filepath.Abs(real)
-	if err != nil {
-		return false
-	}
-	for _, root := range allowedSocketRoots {
-		rabs, _ := filepath.Abs(root)
-		if within(abs, rabs) {
-			return true
-		}
-	}
-	return false
-}
-
 func within(path, root string) bool {
 	rel, err := filepath.Rel(root, path)
 	if err != nil {
@@ -187,14 +108,33 @@ func splitCommaRespectingEscape(s string) []string {
 	return out
 }
 
+// passwordKVRe matches the password of a keyword/value DSN, quoted or not
+var passwordKVRe = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// RedactDSN masks the password of a URL DSN, given as user info or as a
+// password query parameter, or of a keyword/value DSN
 func RedactDSN(dsn string) string {
+	if !strings.Contains(dsn, "://") {
+		return passwordKVRe.ReplaceAllString(dsn, "${1}***")
+	}
 	u, err := url.Parse(dsn)
-	if err != nil || u.User == nil {
+	if err != nil {
 		return dsn
 	}
-	if _, has := u.User.Password(); has {
-		u.User = url.UserPassword(u.User.Username(), "***")
-		return strings.Replace(u.String(), "%2A%2A%2A", "***", 1)
+	redacted := false
+	if u.User != nil {
+		if _, has := u.User.Password(); has {
+			u.User = url.UserPassword(u.User.Username(), "***")
+			redacted = true
+		}
+	}
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", "***")
+		u.RawQuery = q.Encode()
+		redacted = true
+	}
+	if !redacted {
+		return dsn
 	}
-	return u.String()
+	return strings.ReplaceAll(u.String(), "%2A%2A%2A", "***")
 }
@@ -0,0 +1,363 @@
+package dbguard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/cloudguard"
+)
+
+// ErrRejected is wrapped by every error returned from Guard.Check
+var ErrRejected = errors.New("connection rejected by policy")
+
+// Rules lists the hosts, ports, socket directories and database names a rule set matches.
+// Hosts may be host names (with * globs), IP addresses or CIDR ranges.
+// Databases may use * globs.
+type Rules struct {
+	Hosts     []string `json:"hosts,omitempty"`
+	Ports     []int    `json:"ports,omitempty"`
+	Sockets   []string `json:"sockets,omitempty"`
+	Databases []string `json:"databases,omitempty"`
+}
+
+// Policy decides which DSNs may be connected to. Deny rules win over allow rules.
+// An empty allow list keeps the built-in default for that dimension: loopback
+// hosts only, the standard socket roots, and any port or database.
+type Policy struct {
+	Allow Rules `json:"allow"`
+	Deny  Rules `json:"deny"`
+}
+
+// DefaultPolicy is the local-only policy used when no policy file is configured
+func DefaultPolicy() *Policy {
+	return &Policy{}
+}
+
+// LoadPolicy reads a JSON policy file
+func LoadPolicy(file string) (*Policy, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", file, err)
+	}
+	for _, h := range append(append([]string{}, p.Allow.Hosts...), p.Deny.Hosts...) {
+		if strings.Contains(h, "/") {
+			if _, _, err := net.ParseCIDR(h); err != nil {
+				return nil, fmt.Errorf("parse policy %s: invalid CIDR %q", file, h)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// PolicyError explains which rule rejected a DSN
+type PolicyError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", ErrRejected, e.Reason, e.Rule)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrRejected
+}
+
+func deny(rule, format string, args ...interface{}) error {
+	return &PolicyError{Rule: rule, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Guard is the single pipeline every connection path goes through: command line
+// DSNs, the connect_database tool and any other configuration source.
+type Guard struct {
+	policy   *Policy
+	logf     func(format string, args ...interface{})
+	lookupIP func(host string) ([]net.IP, error)
+}
+
+// NewGuard returns a guard enforcing policy. A nil policy means DefaultPolicy.
+// logf receives one line per decision; the DSN is always redacted.
+func NewGuard(policy *Policy, logf func(format string, args ...interface{})) *Guard {
+	if policy == nil {
+		policy = DefaultPolicy()
+	}
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	return &Guard{policy: policy, logf: logf, lookupIP: net.LookupIP}
+}
+
+// Check validates dsn against the Cloud SQL detection and the policy
+func (g *Guard) Check(dsn string) error {
+	err := g.check(dsn)
+	if err != nil {
+		g.logf("guard: deny dsn=%s reason=%s", RedactDSN(dsn), err)
+		return err
+	}
+	g.logf("guard: allow dsn=%s", RedactDSN(dsn))
+	return nil
+}
+
+func (g *Guard) check(dsn string) error {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return deny("dsn", "DSN must be a postgres:// or postgresql:// URL")
+	}
+	if err := cloudguard.ValidateConnection(dsn); err != nil {
+		var cerr *cloudguard.CloudSQLProxyError
+		if errors.As(err, &cerr) {
+			return deny("cloudguard", "%s", cerr.Reason)
+		}
+		return deny("cloudguard", "%s", err)
+	}
+	return g.policy.evaluate(u, g.lookupIP)
+}
+
+// Evaluate checks a parsed DSN against the policy, resolving host names with net.LookupIP
+func (p *Policy) Evaluate(u *url.URL) error {
+	return p.evaluate(u, net.LookupIP)
+}
+
+// evaluate checks every database, host and port the DSN names. Drivers
+// differ in which of a setting repeated in the URL and its query they use;
+// lib/pq, for one, takes the last after sorting. Checking every value keeps a
+// harmless first value from hiding the one the driver connects with.
+func (p *Policy) evaluate(u *url.URL, lookup func(string) ([]net.IP, error)) error {
+	q := u.Query()
+
+	var dbs []string
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		dbs = append(dbs, db)
+	}
+	for _, v := range q["dbname"] {
+		if v != "" {
+			dbs = append(dbs, v)
+		}
+	}
+	if len(dbs) == 0 {
+		dbs = []string{"postgres"}
+	}
+	for _, db := range dbs {
+		if matchAnyGlob(p.Deny.Databases, db) {
+			return deny("deny.databases", "database %q is denied", db)
+		}
+		if len(p.Allow.Databases) > 0 && !matchAnyGlob(p.Allow.Databases, db) {
+			return deny("allow.databases", "database %q is not in the allow list", db)
+		}
+	}
+
+	var ports []int
+	for _, v := range q["port"] {
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return deny("dsn", "invalid port %q", v)
+		}
+		ports = append(ports, n)
+	}
+
+	var hosts []string
+	for _, h := range q["host"] {
+		if h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if u.Host == "" && len(hosts) == 0 {
+		return deny("dsn", "missing host")
+	}
+	if u.Host != "" {
+		for _, hp := range splitHostListPreservingIPv6(u.Host) {
+			h := hp
+			if strings.Contains(hp, ":") {
+				hh, ps, err := net.SplitHostPort(hp)
+				switch {
+				case err == nil:
+					h = hh
+					n, err := strconv.Atoi(ps)
+					if err != nil {
+						return deny("dsn", "invalid port %q", ps)
+					}
+					ports = append(ports, n)
+				case strings.HasPrefix(hp, "[") && strings.HasSuffix(hp, "]"):
+					// Bracketed IPv6 literal without a port
+				default:
+					return deny("dsn", "invalid host:port: %q", hp)
+				}
+			}
+			hosts = append(hosts, strings.Trim(h, "[]"))
+		}
+	}
+	if len(ports) == 0 {
+		ports = []int{5432}
+	}
+
+	for _, h := range hosts {
+		if strings.HasPrefix(h, "/") {
+			if err := p.checkSocket(h); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, port := range ports {
+			if err := p.checkNetworkHost(h, port, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Policy) checkSocket(sock string) error {
+	if strings.HasPrefix(sock, "/cloudsql/") {
+		return deny("builtin", "cloud sql unix socket is forbidden: %q", sock)
+	}
+	if isUnderRoots(sock, p.Deny.Sockets) {
+		return deny("deny.sockets", "unix socket %q is denied", sock)
+	}
+	roots := p.Allow.Sockets
+	if len(roots) == 0 {
+		roots = allowedSocketRoots
+	}
+	if !isUnderRoots(sock, roots) {
+		return deny("allow.sockets", "unix socket outside allowed roots: %q", sock)
+	}
+	return nil
+}
+
+func (p *Policy) checkNetworkHost(host string, port int, lookup func(string) ([]net.IP, error)) error {
+	if containsInt(p.Deny.Ports, port) {
+		return deny("deny.ports", "port %d is denied", port)
+	}
+	if len(p.Allow.Ports) > 0 && !containsInt(p.Allow.Ports, port) {
+		return deny("allow.ports", "port %d is not in the allow list", port)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		resolved, err := lookup(host)
+		if err == nil {
+			ips = resolved
+		}
+	}
+
+	if matchAnyHost(p.Deny.Hosts, host, ips, false) {
+		return deny("deny.hosts", "host %q is denied", host)
+	}
+
+	if len(p.Allow.Hosts) == 0 {
+		if len(ips) == 0 {
+			return deny("allow.hosts", "host resolve failed: %q", host)
+		}
+		for _, ip := range ips {
+			if !ip.IsLoopback() {
+				return deny("allow.hosts", "remote database is not allowed (local only): %s -> %s", host, ip)
+			}
+		}
+		return nil
+	}
+	if !matchAnyHost(p.Allow.Hosts, host, ips, true) {
+		return deny("allow.hosts", "host %q is not in the allow list", host)
+	}
+	return nil
+}
+
+// matchAnyHost reports whether host matches one of the patterns by name or by
+// address. With all set, every resolved address must match for an address match;
+// otherwise a single matching address is enough.
+func matchAnyHost(patterns []string, host string, ips []net.IP, all bool) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for _, pat := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pat), strings.ToLower(host)); ok {
+			return true
+		}
+	}
+	if len(ips) == 0 {
+		return false
+	}
+	matched := 0
+	for _, ip := range ips {
+		if ipMatches(patterns, ip) {
+			matched++
+			if !all {
+				return true
+			}
+		}
+	}
+	return all && matched == len(ips)
+}
+
+func ipMatches(patterns []string, ip net.IP) bool {
+	for _, pat := range patterns {
+		if strings.Contains(pat, "/") {
+			if _, cidr, err := net.ParseCIDR(pat); err == nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if pip := net.ParseIP(pat); pip != nil && pip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyGlob(patterns []string, s string) bool {
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isUnderRoots(sock string, roots []string) bool {
+	if len(roots) == 0 {
+		return false
+	}
+	// A socket path that cannot be resolved, like a dangling link, is refused
+	real, err := filepath.EvalSymlinks(sock)
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(real)
+	if err != nil {
+		return false
+	}
+	for _, root := range roots {
+		rabs, _ := filepath.Abs(root)
+		if within(abs, rabs) {
+			return true
+		}
+	}
+	return false
+}
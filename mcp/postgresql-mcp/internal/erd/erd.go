@@ -0,0 +1,293 @@
+package erd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Column is one attribute of an entity
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	Primary  bool   `json:"primary"`
+	Foreign  bool   `json:"foreign"`
+	Unique   bool   `json:"unique"`
+}
+
+// IsKey reports whether the column takes part in a primary, unique or foreign key
+func (c Column) IsKey() bool {
+	return c.Primary || c.Foreign || c.Unique
+}
+
+// keyMarkers returns the PK, FK and UK markers for the column
+func (c Column) keyMarkers() []string {
+	var keys []string
+	if c.Primary {
+		keys = append(keys, "PK")
+	}
+	if c.Foreign {
+		keys = append(keys, "FK")
+	}
+	if c.Unique && !c.Primary {
+		keys = append(keys, "UK")
+	}
+	return keys
+}
+
+// Table is one entity
+type Table struct {
+	Schema  string   `json:"schema"`
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+}
+
+// QualifiedName returns "schema.table"
+func (t *Table) QualifiedName() string {
+	return t.Schema + "." + t.Name
+}
+
+// Relation is a foreign key edge from the referencing table to the referenced table
+type Relation struct {
+	Name        string   `json:"name"`
+	From        string   `json:"from"`
+	FromColumns []string `json:"from_columns"`
+	To          string   `json:"to"`
+	ToColumns   []string `json:"to_columns"`
+	// Optional is set when any referencing column is nullable
+	Optional bool `json:"optional"`
+	// OneToOne is set when the referencing columns are themselves unique
+	OneToOne bool `json:"one_to_one"`
+	// Identifying is set when the referencing columns are part of the primary key
+	Identifying bool `json:"identifying"`
+}
+
+// Diagram is the set of entities and relations to render
+type Diagram struct {
+	Tables    []Table    `json:"tables"`
+	Relations []Relation `json:"relations"`
+}
+
+// Options controls rendering
+type Options struct {
+	// AllColumns renders every column instead of only key columns
+	AllColumns bool
+}
+
+// Filter selects schemas and tables with shell-style patterns ("*", "?", "[...]").
+// Empty patterns match everything.
+type Filter struct {
+	SchemaPattern string
+	TablePattern  string
+}
+
+// Match reports whether schema.table passes the filter. The table pattern is
+// matched against the bare name and, when it contains a dot, the qualified name.
+func (f Filter) Match(schema, table string) bool {
+	if f.SchemaPattern != "" && !globMatch(f.SchemaPattern, schema) {
+		return false
+	}
+	if f.TablePattern == "" {
+		return true
+	}
+	if strings.Contains(f.TablePattern, ".") {
+		return globMatch(f.TablePattern, schema+"."+table)
+	}
+	return globMatch(f.TablePattern, table)
+}
+
+// Validate checks that both patterns are well formed
+func (f Filter) Validate() error {
+	for _, p := range []string{f.SchemaPattern, f.TablePattern} {
+		for _, alt := range strings.Split(p, ",") {
+			if _, err := path.Match(strings.TrimSpace(alt), ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", p)
+			}
+		}
+	}
+	return nil
+}
+
+// globMatch matches any of the comma-separated patterns
+func globMatch(patterns, name string) bool {
+	for _, p := range strings.Split(patterns, ",") {
+		if ok, _ := path.Match(strings.TrimSpace(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune keeps only relations whose both ends are in the diagram and sorts
+// tables and relations for stable output
+func (d *Diagram) Prune() {
+	present := make(map[string]bool, len(d.Tables))
+	for i := range d.Tables {
+		present[d.Tables[i].QualifiedName()] = true
+	}
+	kept := d.Relations[:0]
+	for _, r := range d.Relations {
+		if present[r.From] && present[r.To] {
+			kept = append(kept, r)
+		}
+	}
+	d.Relations = kept
+	sort.Slice(d.Tables, func(i, j int) bool {
+		return d.Tables[i].QualifiedName() < d.Tables[j].QualifiedName()
+	})
+	sort.Slice(d.Relations, func(i, j int) bool {
+		if d.Relations[i].From != d.Relations[j].From {
+			return d.Relations[i].From < d.Relations[j].From
+		}
+		return d.Relations[i].Name < d.Relations[j].Name
+	})
+}
+
+func (t *Table) visibleColumns(opts Options) []Column {
+	if opts.AllColumns {
+		return t.Columns
+	}
+	var out []Column
+	for _, c := range t.Columns {
+		if c.IsKey() {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+var (
+	mermaidNameRe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+	mermaidTypeRe = regexp.MustCompile(`[^A-Za-z0-9_\-\[\]()]`)
+)
+
+// mermaidName turns schema.table into an entity name Mermaid accepts
+func mermaidName(qualified string) string {
+	return mermaidNameRe.ReplaceAllString(qualified, "_")
+}
+
+// Mermaid renders the diagram as a Mermaid erDiagram
+func (d *Diagram) Mermaid(opts Options) string {
+	var b strings.Builder
+	b.WriteString("erDiagram\n")
+	for i := range d.Tables {
+		t := &d.Tables[i]
+		cols := t.visibleColumns(opts)
+		if len(cols) == 0 {
+			fmt.Fprintf(&b, "    %s {\n    }\n", mermaidName(t.QualifiedName()))
+			continue
+		}
+		fmt.Fprintf(&b, "    %s {\n", mermaidName(t.QualifiedName()))
+		for _, c := range cols {
+			typ := mermaidTypeRe.ReplaceAllString(c.Type, "_")
+			if typ == "" {
+				typ = "unknown"
+			}
+			fmt.Fprintf(&b, "        %s %s", typ, mermaidNameRe.ReplaceAllString(c.Name, "_"))
+			if keys := c.keyMarkers(); len(keys) > 0 {
+				b.WriteString(" " + strings.Join(keys, ", "))
+			}
+			if mermaidNameRe.MatchString(c.Name) {
+				// Keep the real name visible when it had to be rewritten
+				fmt.Fprintf(&b, " %q", strings.ReplaceAll(c.Name, `"`, "'"))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("    }\n")
+	}
+	for _, r := range d.Relations {
+		left := "||"
+		if r.Optional {
+			left = "|o"
+		}
+		right := "o{"
+		if r.OneToOne {
+			right = "o|"
+		}
+		line := ".."
+		if r.Identifying {
+			line = "--"
+		}
+		fmt.Fprintf(&b, "    %s %s%s%s %s : %q\n", mermaidName(r.To), left, line, right, mermaidName(r.From), strings.ReplaceAll(r.Name, `"`, "'"))
+	}
+	return b.String()
+}
+
+// DOT renders the diagram as a Graphviz digraph with one cluster per schema
+func (d *Diagram) DOT(opts Options) string {
+	var b strings.Builder
+	b.WriteString("digraph erd {\n")
+	b.WriteString("    graph [rankdir=LR];\n")
+	b.WriteString("    node [shape=plaintext, fontname=\"Helvetica\"];\n")
+	b.WriteString("    edge [fontname=\"Helvetica\", fontsize=10];\n")
+
+	var schemas []string
+	bySchema := map[string][]*Table{}
+	for i := range d.Tables {
+		t := &d.Tables[i]
+		if _, ok := bySchema[t.Schema]; !ok {
+			schemas = append(schemas, t.Schema)
+		}
+		bySchema[t.Schema] = append(bySchema[t.Schema], t)
+	}
+	sort.Strings(schemas)
+
+	for _, schema := range schemas {
+		fmt.Fprintf(&b, "    subgraph %s {\n", dotID("cluster_"+schema))
+		fmt.Fprintf(&b, "        label=%s;\n", dotID(schema))
+		for _, t := range bySchema[schema] {
+			fmt.Fprintf(&b, "        %s [label=<\n", dotID(t.QualifiedName()))
+			b.WriteString("            <table border=\"0\" cellborder=\"1\" cellspacing=\"0\">\n")
+			fmt.Fprintf(&b, "            <tr><td bgcolor=\"lightgrey\"><b>%s</b></td></tr>\n", htmlEscape(t.Name))
+			for _, c := range t.visibleColumns(opts) {
+				label := htmlEscape(c.Name) + " : " + htmlEscape(c.Type)
+				if keys := c.keyMarkers(); len(keys) > 0 {
+					label += " (" + strings.Join(keys, ", ") + ")"
+				}
+				fmt.Fprintf(&b, "            <tr><td port=\"%s\" align=\"left\">%s</td></tr>\n", htmlEscape(c.Name), label)
+			}
+			b.WriteString("            </table>>];\n")
+		}
+		b.WriteString("    }\n")
+	}
+
+	for _, r := range d.Relations {
+		head := "tee"
+		if r.Optional {
+			head = "teeodot"
+		}
+		tail := "crowodot"
+		if r.OneToOne {
+			tail = "teeodot"
+		}
+		style := "dashed"
+		if r.Identifying {
+			style = "solid"
+		}
+		fmt.Fprintf(&b, "    %s -> %s [label=%s, arrowhead=%s, arrowtail=%s, dir=both, style=%s];\n",
+			endpoint(r.From, r.FromColumns), endpoint(r.To, r.ToColumns), dotID(r.Name), head, tail, style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// endpoint attaches single-column edges to the column port. Key columns are
+// always rendered, so the port exists.
+func endpoint(table string, columns []string) string {
+	if len(columns) == 1 {
+		return dotID(table) + ":" + dotID(columns[0])
+	}
+	return dotID(table)
+}
+
+// dotID quotes a Graphviz identifier
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func htmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
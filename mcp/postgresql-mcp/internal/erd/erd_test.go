@@ -0,0 +1,113 @@
+package erd
+
+import (
+	"strings"
+	"testing"
+)
+
+func sampleDiagram() *Diagram {
+	return &Diagram{
+		Tables: []Table{
+			{Schema: "logistics", Name: "shipments", Columns: []Column{
+				{Name: "id", Type: "bigint", Primary: true},
+				{Name: "item_id", Type: "bigint", Foreign: true, Nullable: true},
+				{Name: "tracking code", Type: "character varying(64)", Unique: true},
+				{Name: "shipped_at", Type: "timestamp with time zone", Nullable: true},
+			}},
+			{Schema: "inventory", Name: "items", Columns: []Column{
+				{Name: "id", Type: "bigint", Primary: true},
+				{Name: "price", Type: "numeric(10,2)"},
+			}},
+			{Schema: "inventory", Name: "item_details", Columns: []Column{
+				{Name: "item_id", Type: "bigint", Primary: true, Foreign: true},
+			}},
+		},
+		Relations: []Relation{
+			{Name: "shipments_item_id_fkey", From: "logistics.shipments", FromColumns: []string{"item_id"}, To: "inventory.items", ToColumns: []string{"id"}, Optional: true},
+			{Name: "item_details_item_id_fkey", From: "inventory.item_details", FromColumns: []string{"item_id"}, To: "inventory.items", ToColumns: []string{"id"}, OneToOne: true, Identifying: true},
+			{Name: "shipments_carrier_fkey", From: "logistics.shipments", FromColumns: []string{"carrier_id"}, To: "finance.carriers", ToColumns: []string{"id"}},
+		},
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		filter        Filter
+		schema, table string
+		want          bool
+	}{
+		{Filter{}, "inventory", "items", true},
+		{Filter{SchemaPattern: "inventory"}, "logistics", "shipments", false},
+		{Filter{SchemaPattern: "inventory, logistics"}, "logistics", "shipments", true},
+		{Filter{TablePattern: "item*"}, "inventory", "item_details", true},
+		{Filter{TablePattern: "item*"}, "logistics", "shipments", false},
+		{Filter{TablePattern: "logistics.*"}, "logistics", "shipments", true},
+		{Filter{TablePattern: "logistics.*"}, "inventory", "items", false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(tt.schema, tt.table); got != tt.want {
+			t.Errorf("%+v.Match(%s, %s) = %v, want %v", tt.filter, tt.schema, tt.table, got, tt.want)
+		}
+	}
+	if err := (Filter{TablePattern: "[abc"}).Validate(); err == nil {
+		t.Errorf("expected malformed pattern to be rejected")
+	}
+}
+
+func TestPruneDropsDanglingEdgesAndSorts(t *testing.T) {
+	d := sampleDiagram()
+	d.Prune()
+	if len(d.Relations) != 2 {
+		t.Fatalf("expected the edge to the missing finance table to be dropped, got %+v", d.Relations)
+	}
+	if d.Tables[0].QualifiedName() != "inventory.item_details" || d.Relations[0].From != "inventory.item_details" {
+		t.Errorf("unexpected order: %+v %+v", d.Tables, d.Relations)
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	d := sampleDiagram()
+	d.Prune()
+	out := d.Mermaid(Options{})
+
+	for _, want := range []string{
+		"erDiagram\n",
+		"    logistics_shipments {\n",
+		"        bigint id PK\n",
+		"        bigint item_id FK\n",
+		`        character_varying(64) tracking_code UK "tracking code"` + "\n",
+		`    inventory_items |o..o{ logistics_shipments : "shipments_item_id_fkey"` + "\n",
+		`    inventory_items ||--o| inventory_item_details : "item_details_item_id_fkey"` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "shipped_at") || strings.Contains(out, "price") {
+		t.Errorf("non-key columns should be hidden by default:\n%s", out)
+	}
+	if all := d.Mermaid(Options{AllColumns: true}); !strings.Contains(all, "timestamp_with_time_zone shipped_at") || !strings.Contains(all, "numeric(10_2) price") {
+		t.Errorf("expected every column with AllColumns:\n%s", all)
+	}
+}
+
+func TestDOT(t *testing.T) {
+	d := sampleDiagram()
+	d.Prune()
+	out := d.DOT(Options{})
+
+	for _, want := range []string{
+		"digraph erd {\n",
+		`subgraph "cluster_inventory" {`,
+		`label="logistics";`,
+		`<td port="tracking code" align="left">tracking code : character varying(64) (UK)</td>`,
+		`"logistics.shipments":"item_id" -> "inventory.items":"id" [label="shipments_item_id_fkey", arrowhead=teeodot, arrowtail=crowodot, dir=both, style=dashed];`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "}\n") {
+		t.Errorf("unterminated graph:\n%s", out)
+	}
+}
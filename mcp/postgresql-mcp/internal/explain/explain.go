@@ -0,0 +1,279 @@
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Node is one normalized plan node
+type Node struct {
+	Path         string   `json:"path"`
+	NodeType     string   `json:"node_type"`
+	Relationship string   `json:"parent_relationship,omitempty"`
+	Relation     string   `json:"relation,omitempty"`
+	Alias        string   `json:"alias,omitempty"`
+	Index        string   `json:"index,omitempty"`
+	JoinType     string   `json:"join_type,omitempty"`
+	Strategy     string   `json:"strategy,omitempty"`
+	Condition    string   `json:"condition,omitempty"`
+	Filter       string   `json:"filter,omitempty"`
+	Output       []string `json:"output,omitempty"`
+	SortMethod   string   `json:"sort_method,omitempty"`
+	SortSpace    string   `json:"sort_space_type,omitempty"`
+
+	StartupCost float64 `json:"startup_cost"`
+	TotalCost   float64 `json:"total_cost"`
+	PlanRows    float64 `json:"plan_rows"`
+	PlanWidth   int     `json:"plan_width"`
+
+	ActualRows        *float64 `json:"actual_rows,omitempty"`
+	ActualLoops       *float64 `json:"actual_loops,omitempty"`
+	ActualTotalTimeMs *float64 `json:"actual_total_time_ms,omitempty"`
+	RowsRemoved       *float64 `json:"rows_removed_by_filter,omitempty"`
+	SharedHitBlocks   *float64 `json:"shared_hit_blocks,omitempty"`
+	SharedReadBlocks  *float64 `json:"shared_read_blocks,omitempty"`
+
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Hotspot flags a node worth looking at
+type Hotspot struct {
+	Path     string  `json:"path"`
+	NodeType string  `json:"node_type"`
+	Relation string  `json:"relation,omitempty"`
+	Kind     string  `json:"kind"`
+	Detail   string  `json:"detail"`
+	Value    float64 `json:"value"`
+}
+
+// Hotspot kinds
+const (
+	HotSeqScan     = "seq_scan_large_table"
+	HotMisestimate = "row_misestimate"
+	HotFilterWaste = "rows_removed_by_filter"
+	HotDiskReads   = "shared_read_blocks"
+	HotSortOnDisk  = "sort_spilled_to_disk"
+	HotNestedLoops = "nested_loop_many_loops"
+)
+
+const (
+	defaultSeqRows = 10000
+	defaultFactor  = 10
+	// misestimates are only reported once either side reaches this many rows
+	misestimateRows = 100
+	diskReadBlocks  = 10000
+)
+
+// Options tunes hotspot detection
+type Options struct {
+	// SeqScanRows is the table size at which a sequential scan is flagged
+	SeqScanRows float64
+	// MisestimateFactor is the ratio between estimated and actual rows that is flagged
+	MisestimateFactor float64
+	// RelationRows holds pg_class.reltuples per "schema.table", used to size sequential scans
+	RelationRows map[string]float64
+}
+
+// Result is the normalized output of EXPLAIN (FORMAT JSON)
+type Result struct {
+	Analyzed        bool      `json:"analyzed"`
+	TotalCost       float64   `json:"total_cost"`
+	PlanRows        float64   `json:"plan_rows"`
+	PlanningTimeMs  *float64  `json:"planning_time_ms,omitempty"`
+	ExecutionTimeMs *float64  `json:"execution_time_ms,omitempty"`
+	Plan            *Node     `json:"plan"`
+	Hotspots        []Hotspot `json:"hotspots"`
+}
+
+// Parse decodes the JSON document returned by EXPLAIN (FORMAT JSON) into a
+// normalized tree. Call DetectHotspots to fill Hotspots.
+func Parse(raw []byte) (*Result, error) {
+	var doc []map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("invalid EXPLAIN output: %w", err)
+	}
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("empty EXPLAIN output")
+	}
+	top := doc[0]
+	planObj, ok := top["Plan"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("EXPLAIN output has no Plan")
+	}
+
+	res := &Result{Hotspots: []Hotspot{}}
+	res.Plan = normalize(planObj, "0")
+	res.TotalCost = res.Plan.TotalCost
+	res.PlanRows = res.Plan.PlanRows
+	res.Analyzed = res.Plan.ActualRows != nil
+	res.PlanningTimeMs = optFloat(top, "Planning Time")
+	res.ExecutionTimeMs = optFloat(top, "Execution Time")
+	return res, nil
+}
+
+// DetectHotspots flags large sequential scans, row misestimates and other
+// expensive nodes
+func (r *Result) DetectHotspots(opts Options) {
+	if opts.SeqScanRows <= 0 {
+		opts.SeqScanRows = defaultSeqRows
+	}
+	if opts.MisestimateFactor <= 0 {
+		opts.MisestimateFactor = defaultFactor
+	}
+	r.Hotspots = []Hotspot{}
+	walk(r.Plan, func(n *Node) {
+		r.Hotspots = append(r.Hotspots, detect(n, opts)...)
+	})
+}
+
+func normalize(obj map[string]interface{}, path string) *Node {
+	n := &Node{
+		Path:         path,
+		NodeType:     str(obj, "Node Type"),
+		Relationship: str(obj, "Parent Relationship"),
+		Alias:        str(obj, "Alias"),
+		Index:        str(obj, "Index Name"),
+		JoinType:     str(obj, "Join Type"),
+		Strategy:     str(obj, "Strategy"),
+		Filter:       str(obj, "Filter"),
+		StartupCost:  num(obj, "Startup Cost"),
+		TotalCost:    num(obj, "Total Cost"),
+		PlanRows:     num(obj, "Plan Rows"),
+		PlanWidth:    int(num(obj, "Plan Width")),
+	}
+	if rel := str(obj, "Relation Name"); rel != "" {
+		if schema := str(obj, "Schema"); schema != "" {
+			rel = schema + "." + rel
+		}
+		n.Relation = rel
+	}
+	for _, key := range []string{"Index Cond", "Hash Cond", "Merge Cond", "Join Filter", "Recheck Cond"} {
+		if c := str(obj, key); c != "" {
+			n.Condition = c
+			break
+		}
+	}
+	if out, ok := obj["Output"].([]interface{}); ok {
+		for _, o := range out {
+			if s, ok := o.(string); ok {
+				n.Output = append(n.Output, s)
+			}
+		}
+	}
+	n.ActualRows = optFloat(obj, "Actual Rows")
+	n.ActualLoops = optFloat(obj, "Actual Loops")
+	n.ActualTotalTimeMs = optFloat(obj, "Actual Total Time")
+	n.RowsRemoved = optFloat(obj, "Rows Removed by Filter")
+	n.SharedHitBlocks = optFloat(obj, "Shared Hit Blocks")
+	n.SharedReadBlocks = optFloat(obj, "Shared Read Blocks")
+
+	n.SortMethod = str(obj, "Sort Method")
+	n.SortSpace = str(obj, "Sort Space Type")
+
+	if plans, ok := obj["Plans"].([]interface{}); ok {
+		for i, p := range plans {
+			if child, ok := p.(map[string]interface{}); ok {
+				n.Children = append(n.Children, normalize(child, path+"."+strconv.Itoa(i)))
+			}
+		}
+	}
+	return n
+}
+
+func detect(n *Node, opts Options) []Hotspot {
+	var out []Hotspot
+	add := func(kind, detail string, value float64) {
+		out = append(out, Hotspot{Path: n.Path, NodeType: n.NodeType, Relation: n.Relation, Kind: kind, Detail: detail, Value: value})
+	}
+
+	if n.NodeType == "Seq Scan" {
+		size := n.PlanRows
+		if n.ActualRows != nil {
+			size = *n.ActualRows
+		}
+		if n.RowsRemoved != nil {
+			size += *n.RowsRemoved
+		}
+		if rows, ok := opts.RelationRows[n.Relation]; ok && rows > size {
+			size = rows
+		}
+		if size >= opts.SeqScanRows {
+			add(HotSeqScan, fmt.Sprintf("sequential scan over ~%.0f rows of %s", size, n.Relation), size)
+		}
+	}
+
+	if n.ActualRows != nil && (n.ActualLoops == nil || *n.ActualLoops > 0) {
+		actual := *n.ActualRows
+		est := n.PlanRows
+		hi, lo := math.Max(actual, est), math.Min(actual, est)
+		if hi >= misestimateRows {
+			factor := hi / math.Max(lo, 1)
+			if factor >= opts.MisestimateFactor {
+				dir := "underestimated"
+				if est > actual {
+					dir = "overestimated"
+				}
+				add(HotMisestimate, fmt.Sprintf("rows %s by %.1fx (estimated %.0f, actual %.0f per loop)", dir, factor, est, actual), factor)
+			}
+		}
+	}
+
+	if n.RowsRemoved != nil && n.ActualRows != nil && *n.RowsRemoved >= opts.SeqScanRows && *n.RowsRemoved > 10*math.Max(*n.ActualRows, 1) {
+		add(HotFilterWaste, fmt.Sprintf("filter discarded %.0f rows to return %.0f", *n.RowsRemoved, *n.ActualRows), *n.RowsRemoved)
+	}
+
+	if n.SortSpace == "Disk" {
+		add(HotSortOnDisk, fmt.Sprintf("sort spilled to disk (%s)", n.SortMethod), 1)
+	}
+
+	if n.NodeType == "Nested Loop" && len(n.Children) == 2 {
+		inner := n.Children[1]
+		if inner.ActualLoops != nil && *inner.ActualLoops >= opts.SeqScanRows {
+			add(HotNestedLoops, fmt.Sprintf("inner side executed %.0f times", *inner.ActualLoops), *inner.ActualLoops)
+		}
+	}
+
+	if n.SharedReadBlocks != nil && *n.SharedReadBlocks >= diskReadBlocks {
+		add(HotDiskReads, fmt.Sprintf("read %.0f blocks from disk", *n.SharedReadBlocks), *n.SharedReadBlocks)
+	}
+	return out
+}
+
+func walk(n *Node, fn func(*Node)) {
+	fn(n)
+	for _, c := range n.Children {
+		walk(c, fn)
+	}
+}
+
+// Relations returns every relation scanned by the plan
+func (r *Result) Relations() []string {
+	seen := map[string]bool{}
+	var out []string
+	walk(r.Plan, func(n *Node) {
+		if n.Relation != "" && !seen[n.Relation] {
+			seen[n.Relation] = true
+			out = append(out, n.Relation)
+		}
+	})
+	return out
+}
+
+func str(obj map[string]interface{}, key string) string {
+	s, _ := obj[key].(string)
+	return s
+}
+
+func num(obj map[string]interface{}, key string) float64 {
+	f, _ := obj[key].(float64)
+	return f
+}
+
+func optFloat(obj map[string]interface{}, key string) *float64 {
+	if f, ok := obj[key].(float64); ok {
+		return &f
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package explain
+
+import "testing"
+
+const analyzedPlan = `[
+  {
+    "Plan": {
+      "Node Type": "Hash Join",
+      "Join Type": "Inner",
+      "Startup Cost": 10.5,
+      "Total Cost": 5000.25,
+      "Plan Rows": 50,
+      "Plan Width": 64,
+      "Actual Total Time": 120.5,
+      "Actual Rows": 48000,
+      "Actual Loops": 1,
+      "Hash Cond": "(o.user_id = u.id)",
+      "Plans": [
+        {
+          "Node Type": "Seq Scan",
+          "Parent Relationship": "Outer",
+          "Relation Name": "orders",
+          "Schema": "public",
+          "Alias": "o",
+          "Startup Cost": 0,
+          "Total Cost": 4000,
+          "Plan Rows": 200000,
+          "Plan Width": 32,
+          "Actual Rows": 200000,
+          "Actual Loops": 1,
+          "Shared Hit Blocks": 10,
+          "Shared Read Blocks": 20000
+        },
+        {
+          "Node Type": "Hash",
+          "Parent Relationship": "Inner",
+          "Startup Cost": 1,
+          "Total Cost": 2,
+          "Plan Rows": 3,
+          "Plan Width": 32,
+          "Actual Rows": 3,
+          "Actual Loops": 1,
+          "Plans": [
+            {
+              "Node Type": "Index Scan",
+              "Relation Name": "users",
+              "Schema": "public",
+              "Index Name": "users_pkey",
+              "Startup Cost": 0,
+              "Total Cost": 1,
+              "Plan Rows": 3,
+              "Plan Width": 32,
+              "Actual Rows": 3,
+              "Actual Loops": 1
+            }
+          ]
+        }
+      ]
+    },
+    "Planning Time": 0.3,
+    "Execution Time": 121.0
+  }
+]`
+
+func TestParseAnalyzed(t *testing.T) {
+	res, err := Parse([]byte(analyzedPlan))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.DetectHotspots(Options{})
+
+	if !res.Analyzed || res.ExecutionTimeMs == nil || *res.ExecutionTimeMs != 121.0 {
+		t.Errorf("unexpected summary: %+v", res)
+	}
+	if res.Plan.Condition != "(o.user_id = u.id)" || len(res.Plan.Children) != 2 {
+		t.Errorf("unexpected root: %+v", res.Plan)
+	}
+	idx := res.Plan.Children[1].Children[0]
+	if idx.Path != "0.1.0" || idx.Index != "users_pkey" || idx.Relation != "public.users" {
+		t.Errorf("unexpected index node: %+v", idx)
+	}
+
+	kinds := map[string]string{}
+	for _, h := range res.Hotspots {
+		kinds[h.Kind] = h.Path
+	}
+	if kinds[HotSeqScan] != "0.0" {
+		t.Errorf("expected seq scan hotspot on 0.0, got %v", res.Hotspots)
+	}
+	if kinds[HotMisestimate] != "0" {
+		t.Errorf("expected misestimate hotspot on root, got %v", res.Hotspots)
+	}
+	if kinds[HotDiskReads] != "0.0" {
+		t.Errorf("expected disk read hotspot on 0.0, got %v", res.Hotspots)
+	}
+	if rels := res.Relations(); len(rels) != 2 {
+		t.Errorf("unexpected relations: %v", rels)
+	}
+}
+
+func TestSeqScanUsesRelationRows(t *testing.T) {
+	plan := `[{"Plan": {"Node Type": "Seq Scan", "Relation Name": "user_events", "Schema": "public", "Total Cost": 10, "Plan Rows": 5}}]`
+	res, err := Parse([]byte(plan))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.DetectHotspots(Options{})
+	if len(res.Hotspots) != 0 {
+		t.Fatalf("expected no hotspots without table size, got %v", res.Hotspots)
+	}
+	res.DetectHotspots(Options{RelationRows: map[string]float64{"public.user_events": 1e6}})
+	if len(res.Hotspots) != 1 || res.Hotspots[0].Kind != HotSeqScan {
+		t.Fatalf("expected seq scan hotspot, got %v", res.Hotspots)
+	}
+	if res.Analyzed {
+		t.Errorf("plan without actuals should not be marked analyzed")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, raw := range []string{"", "[]", `[{"NoPlan": {}}]`} {
+		if _, err := Parse([]byte(raw)); err == nil {
+			t.Errorf("expected error for %q", raw)
+		}
+	}
+}
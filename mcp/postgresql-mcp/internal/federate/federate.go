@@ -0,0 +1,678 @@
+package federate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrRowCap is returned when a join produces more rows than Spec.MaxRows
+var ErrRowCap = errors.New("row cap exceeded")
+
+// DefaultMaxRows bounds intermediate and final results when Spec.MaxRows is not set
+const DefaultMaxRows = 100000
+
+// Table is a result set with ordered columns and positional rows
+type Table struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Join adds Source to the rows accumulated so far
+type Join struct {
+	Source string `json:"source"`
+	// Type is inner (default), left, right or full
+	Type string `json:"type"`
+	On   []On   `json:"on"`
+}
+
+// On pairs a column of the accumulated rows with a column of the joined source
+type On struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// Condition is one filter predicate, or an AND/OR group of predicates
+type Condition struct {
+	Column string      `json:"column,omitempty"`
+	Op     string      `json:"op,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+	And    []Condition `json:"and,omitempty"`
+	Or     []Condition `json:"or,omitempty"`
+}
+
+// Aggregate computes Func over Column for each group
+type Aggregate struct {
+	// Func is count, count_distinct, sum, avg, min or max
+	Func   string `json:"func"`
+	Column string `json:"column"`
+	As     string `json:"as"`
+}
+
+// Order sorts the result by Column
+type Order struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+// Spec declares how the sources are combined. Column references are either
+// qualified with the source name ("u.email") or bare when unambiguous.
+type Spec struct {
+	From       string      `json:"from"`
+	Joins      []Join      `json:"join"`
+	Where      []Condition `json:"where"`
+	GroupBy    []string    `json:"group_by"`
+	Aggregates []Aggregate `json:"aggregates"`
+	Select     []string    `json:"select"`
+	OrderBy    []Order     `json:"order_by"`
+	Limit      int         `json:"limit"`
+	// MaxRows caps the rows produced by every join step
+	MaxRows int `json:"max_result_rows"`
+}
+
+// Execute joins, filters, aggregates, orders and projects the named sources
+func Execute(spec Spec, sources map[string]*Table) (*Table, error) {
+	maxRows := spec.MaxRows
+	if maxRows <= 0 {
+		maxRows = DefaultMaxRows
+	}
+
+	base, ok := sources[spec.From]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q", spec.From)
+	}
+	cur := qualify(spec.From, base)
+	if len(cur.Rows) > maxRows {
+		return nil, fmt.Errorf("%w: source %s has %d rows, limit is %d", ErrRowCap, spec.From, len(cur.Rows), maxRows)
+	}
+
+	joined := map[string]bool{spec.From: true}
+	for i, j := range spec.Joins {
+		src, ok := sources[j.Source]
+		if !ok {
+			return nil, fmt.Errorf("join %d: unknown source %q", i, j.Source)
+		}
+		if joined[j.Source] {
+			return nil, fmt.Errorf("join %d: source %q is already joined", i, j.Source)
+		}
+		joined[j.Source] = true
+		var err error
+		if cur, err = hashJoin(cur, qualify(j.Source, src), j, maxRows); err != nil {
+			return nil, fmt.Errorf("join %d (%s): %w", i, j.Source, err)
+		}
+	}
+
+	if len(spec.Where) > 0 {
+		pred, err := compileAll(cur.Columns, spec.Where, true)
+		if err != nil {
+			return nil, fmt.Errorf("where: %w", err)
+		}
+		kept := cur.Rows[:0]
+		for _, row := range cur.Rows {
+			if pred(row) {
+				kept = append(kept, row)
+			}
+		}
+		cur.Rows = kept
+	}
+
+	if len(spec.GroupBy) > 0 || len(spec.Aggregates) > 0 {
+		var err error
+		if cur, err = aggregate(cur, spec.GroupBy, spec.Aggregates); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(spec.OrderBy) > 0 {
+		if err := orderBy(cur, spec.OrderBy); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(spec.Select) > 0 {
+		var err error
+		if cur, err = project(cur, spec.Select); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Limit > 0 && len(cur.Rows) > spec.Limit {
+		cur.Rows = cur.Rows[:spec.Limit]
+	}
+	return cur, nil
+}
+
+// qualify prefixes every column with the source name
+func qualify(name string, t *Table) *Table {
+	cols := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		cols[i] = name + "." + c
+	}
+	return &Table{Columns: cols, Rows: t.Rows}
+}
+
+// Resolve finds the index of ref in columns. A bare reference matches a
+// qualified column when exactly one source has it.
+func Resolve(columns []string, ref string) (int, error) {
+	for i, c := range columns {
+		if c == ref {
+			return i, nil
+		}
+	}
+	found := -1
+	for i, c := range columns {
+		if strings.HasSuffix(c, "."+ref) {
+			if found >= 0 {
+				return -1, fmt.Errorf("ambiguous column %q (%s, %s)", ref, columns[found], c)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("unknown column %q", ref)
+	}
+	return found, nil
+}
+
+func hashJoin(left, right *Table, j Join, maxRows int) (*Table, error) {
+	if len(j.On) == 0 {
+		return nil, fmt.Errorf("join needs at least one on condition")
+	}
+	kind := strings.ToLower(j.Type)
+	if kind == "" {
+		kind = "inner"
+	}
+	if kind != "inner" && kind != "left" && kind != "right" && kind != "full" {
+		return nil, fmt.Errorf("unsupported join type %q", j.Type)
+	}
+
+	leftIdx := make([]int, len(j.On))
+	rightIdx := make([]int, len(j.On))
+	for i, on := range j.On {
+		var err error
+		if leftIdx[i], err = Resolve(left.Columns, on.Left); err != nil {
+			return nil, err
+		}
+		if rightIdx[i], err = Resolve(right.Columns, on.Right); err != nil {
+			return nil, err
+		}
+	}
+
+	buckets := map[string][]int{}
+	for r, row := range right.Rows {
+		if k, ok := joinKey(row, rightIdx); ok {
+			buckets[k] = append(buckets[k], r)
+		}
+	}
+
+	out := &Table{Columns: append(append([]string{}, left.Columns...), right.Columns...)}
+	matched := make([]bool, len(right.Rows))
+	emit := func(l, r []interface{}) error {
+		if len(out.Rows) >= maxRows {
+			return fmt.Errorf("%w: more than %d rows", ErrRowCap, maxRows)
+		}
+		row := make([]interface{}, 0, len(out.Columns))
+		if l == nil {
+			l = make([]interface{}, len(left.Columns))
+		}
+		if r == nil {
+			r = make([]interface{}, len(right.Columns))
+		}
+		out.Rows = append(out.Rows, append(append(row, l...), r...))
+		return nil
+	}
+
+	for _, lrow := range left.Rows {
+		var hits []int
+		if k, ok := joinKey(lrow, leftIdx); ok {
+			hits = buckets[k]
+		}
+		for _, r := range hits {
+			matched[r] = true
+			if err := emit(lrow, right.Rows[r]); err != nil {
+				return nil, err
+			}
+		}
+		if len(hits) == 0 && (kind == "left" || kind == "full") {
+			if err := emit(lrow, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if kind == "right" || kind == "full" {
+		for r, ok := range matched {
+			if !ok {
+				if err := emit(nil, right.Rows[r]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// joinKey builds the hash key for the given columns; NULLs never match
+func joinKey(row []interface{}, idx []int) (string, bool) {
+	parts := make([]string, len(idx))
+	for i, c := range idx {
+		k, ok := keyOf(row[c])
+		if !ok {
+			return "", false
+		}
+		parts[i] = k
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// keyOf canonicalizes a value so equal values from different drivers match,
+// e.g. int64(5) and float64(5)
+func keyOf(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano), true
+	case bool:
+		return strconv.FormatBool(x), true
+	}
+	if f, ok := toFloat(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64), true
+	}
+	return fmt.Sprint(v), true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// numeric converts numbers and numeric strings (e.g. NUMERIC columns) to float64
+func numeric(v interface{}) (float64, bool) {
+	if f, ok := toFloat(v); ok {
+		return f, true
+	}
+	if s, ok := v.(string); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compare orders two non-NULL values: numerically when either side is a
+// number, chronologically for times, otherwise as strings
+func compare(a, b interface{}) int {
+	_, aNum := toFloat(a)
+	_, bNum := toFloat(b)
+	if aNum || bNum {
+		fa, okA := numeric(a)
+		fb, okB := numeric(b)
+		if okA && okB {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	ta, aTime := asTime(a)
+	tb, bTime := asTime(b)
+	if (aTime || bTime) && ta != nil && tb != nil {
+		return ta.Compare(*tb)
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// asTime reports whether v is a time.Time and parses RFC3339 strings for comparison with one
+func asTime(v interface{}) (*time.Time, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return &x, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, x); err == nil {
+				return &t, false
+			}
+		}
+	}
+	return nil, false
+}
+
+type predicate func(row []interface{}) bool
+
+func compileAll(columns []string, conds []Condition, and bool) (predicate, error) {
+	preds := make([]predicate, len(conds))
+	for i, c := range conds {
+		var err error
+		if preds[i], err = compile(columns, c); err != nil {
+			return nil, err
+		}
+	}
+	return func(row []interface{}) bool {
+		for _, p := range preds {
+			if p(row) != and {
+				return !and
+			}
+		}
+		return and
+	}, nil
+}
+
+func compile(columns []string, c Condition) (predicate, error) {
+	switch {
+	case len(c.And) > 0:
+		return compileAll(columns, c.And, true)
+	case len(c.Or) > 0:
+		return compileAll(columns, c.Or, false)
+	}
+
+	idx, err := Resolve(columns, c.Column)
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(c.Op)
+	switch op {
+	case "is_null":
+		return func(row []interface{}) bool { return row[idx] == nil }, nil
+	case "is_not_null":
+		return func(row []interface{}) bool { return row[idx] != nil }, nil
+	case "in", "not_in":
+		list, ok := c.Value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s on %s needs an array value", op, c.Column)
+		}
+		want := op == "in"
+		return func(row []interface{}) bool {
+			if row[idx] == nil {
+				return false
+			}
+			for _, v := range list {
+				if v != nil && compare(row[idx], v) == 0 {
+					return want
+				}
+			}
+			return !want
+		}, nil
+	case "like", "ilike":
+		pattern, ok := c.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s on %s needs a string value", op, c.Column)
+		}
+		re, err := likeRegexp(pattern, op == "ilike")
+		if err != nil {
+			return nil, err
+		}
+		return func(row []interface{}) bool {
+			return row[idx] != nil && re.MatchString(fmt.Sprint(row[idx]))
+		}, nil
+	}
+
+	var test func(int) bool
+	switch op {
+	case "=", "eq":
+		test = func(n int) bool { return n == 0 }
+	case "!=", "<>", "ne":
+		test = func(n int) bool { return n != 0 }
+	case "<", "lt":
+		test = func(n int) bool { return n < 0 }
+	case "<=", "lte":
+		test = func(n int) bool { return n <= 0 }
+	case ">", "gt":
+		test = func(n int) bool { return n > 0 }
+	case ">=", "gte":
+		test = func(n int) bool { return n >= 0 }
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", c.Op)
+	}
+	if c.Value == nil {
+		return nil, fmt.Errorf("%s on %s needs a value; use is_null for NULL checks", op, c.Column)
+	}
+	return func(row []interface{}) bool {
+		return row[idx] != nil && test(compare(row[idx], c.Value))
+	}, nil
+}
+
+// likeRegexp translates a SQL LIKE pattern with backslash escapes
+func likeRegexp(pattern string, fold bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if fold {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+type accumulator struct {
+	count    int64
+	sum      float64
+	allInt   bool
+	min, max interface{}
+	distinct map[string]bool
+}
+
+func aggregate(t *Table, groupBy []string, aggs []Aggregate) (*Table, error) {
+	groupIdx := make([]int, len(groupBy))
+	for i, g := range groupBy {
+		var err error
+		if groupIdx[i], err = Resolve(t.Columns, g); err != nil {
+			return nil, fmt.Errorf("group_by: %w", err)
+		}
+	}
+	aggIdx := make([]int, len(aggs))
+	out := &Table{Columns: append([]string{}, groupBy...)}
+	for i, a := range aggs {
+		fn := strings.ToLower(a.Func)
+		switch fn {
+		case "count", "count_distinct", "sum", "avg", "min", "max":
+		default:
+			return nil, fmt.Errorf("unsupported aggregate %q", a.Func)
+		}
+		aggIdx[i] = -1
+		if a.Column != "" && a.Column != "*" {
+			var err error
+			if aggIdx[i], err = Resolve(t.Columns, a.Column); err != nil {
+				return nil, fmt.Errorf("aggregate %s: %w", fn, err)
+			}
+		} else if fn != "count" {
+			return nil, fmt.Errorf("aggregate %s needs a column", fn)
+		}
+		name := a.As
+		if name == "" {
+			name = fn
+			if aggIdx[i] >= 0 {
+				name = fn + "_" + a.Column
+			}
+		}
+		out.Columns = append(out.Columns, name)
+	}
+
+	type group struct {
+		key  []interface{}
+		accs []*accumulator
+	}
+	var order []string
+	groups := map[string]*group{}
+	for _, row := range t.Rows {
+		parts := make([]string, len(groupIdx))
+		key := make([]interface{}, len(groupIdx))
+		for i, c := range groupIdx {
+			key[i] = row[c]
+			if k, ok := keyOf(row[c]); ok {
+				parts[i] = "v" + k
+			} else {
+				parts[i] = "n"
+			}
+		}
+		k := strings.Join(parts, "\x00")
+		g, ok := groups[k]
+		if !ok {
+			g = &group{key: key, accs: make([]*accumulator, len(aggs))}
+			for i := range g.accs {
+				g.accs[i] = &accumulator{allInt: true, distinct: map[string]bool{}}
+			}
+			groups[k] = g
+			order = append(order, k)
+		}
+		for i, acc := range g.accs {
+			if aggIdx[i] < 0 {
+				acc.count++
+				continue
+			}
+			v := row[aggIdx[i]]
+			if v == nil {
+				continue
+			}
+			acc.count++
+			if k, ok := keyOf(v); ok {
+				acc.distinct[k] = true
+			}
+			if f, ok := numeric(v); ok {
+				acc.sum += f
+				if _, isString := v.(string); isString || f != math.Trunc(f) {
+					acc.allInt = false
+				}
+			}
+			if acc.min == nil || compare(v, acc.min) < 0 {
+				acc.min = v
+			}
+			if acc.max == nil || compare(v, acc.max) > 0 {
+				acc.max = v
+			}
+		}
+	}
+
+	// An aggregate without GROUP BY always yields one row, like SQL
+	if len(groupBy) == 0 && len(order) == 0 {
+		g := &group{accs: make([]*accumulator, len(aggs))}
+		for i := range g.accs {
+			g.accs[i] = &accumulator{allInt: true, distinct: map[string]bool{}}
+		}
+		groups[""] = g
+		order = append(order, "")
+	}
+
+	for _, k := range order {
+		g := groups[k]
+		row := append([]interface{}{}, g.key...)
+		for i, a := range aggs {
+			acc := g.accs[i]
+			var v interface{}
+			switch strings.ToLower(a.Func) {
+			case "count":
+				v = acc.count
+			case "count_distinct":
+				v = int64(len(acc.distinct))
+			case "sum":
+				if acc.count > 0 {
+					v = acc.sum
+					if acc.allInt {
+						v = int64(acc.sum)
+					}
+				}
+			case "avg":
+				if acc.count > 0 {
+					v = acc.sum / float64(acc.count)
+				}
+			case "min":
+				v = acc.min
+			case "max":
+				v = acc.max
+			}
+			row = append(row, v)
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	return out, nil
+}
+
+func orderBy(t *Table, orders []Order) error {
+	idx := make([]int, len(orders))
+	for i, o := range orders {
+		var err error
+		if idx[i], err = Resolve(t.Columns, o.Column); err != nil {
+			return fmt.Errorf("order_by: %w", err)
+		}
+	}
+	sort.SliceStable(t.Rows, func(a, b int) bool {
+		for i, o := range orders {
+			va, vb := t.Rows[a][idx[i]], t.Rows[b][idx[i]]
+			var n int
+			switch {
+			case va == nil && vb == nil:
+				n = 0
+			case va == nil:
+				// NULLs sort last ascending, first descending, as in PostgreSQL
+				n = 1
+			case vb == nil:
+				n = -1
+			default:
+				n = compare(va, vb)
+			}
+			if o.Desc {
+				n = -n
+			}
+			if n != 0 {
+				return n < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+func project(t *Table, columns []string) (*Table, error) {
+	idx := make([]int, len(columns))
+	for i, c := range columns {
+		var err error
+		if idx[i], err = Resolve(t.Columns, c); err != nil {
+			return nil, fmt.Errorf("select: %w", err)
+		}
+	}
+	out := &Table{Columns: append([]string{}, columns...), Rows: make([][]interface{}, len(t.Rows))}
+	for r, row := range t.Rows {
+		projected := make([]interface{}, len(idx))
+		for i, c := range idx {
+			projected[i] = row[c]
+		}
+		out.Rows[r] = projected
+	}
+	return out, nil
+}
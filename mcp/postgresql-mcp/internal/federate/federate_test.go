@@ -0,0 +1,180 @@
+package federate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sources() map[string]*Table {
+	return map[string]*Table{
+		"u": {
+			Columns: []string{"id", "email"},
+			Rows: [][]interface{}{
+				{int64(1), "alice@example.com"},
+				{int64(2), "bob@example.com"},
+				{int64(3), "carol@other.org"},
+			},
+		},
+		"e": {
+			Columns: []string{"user_id", "event_type", "amount", "created_at"},
+			Rows: [][]interface{}{
+				{int64(1), "purchase", "10.50", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{int64(1), "login", nil, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+				{float64(2), "purchase", "4.50", time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+				{int64(9), "login", nil, time.Date(2025, 1, 4, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+	}
+}
+
+func TestInnerJoinAcrossNumericTypes(t *testing.T) {
+	res, err := Execute(Spec{
+		From:    "e",
+		Joins:   []Join{{Source: "u", On: []On{{Left: "user_id", Right: "u.id"}}}},
+		Select:  []string{"u.email", "event_type"},
+		OrderBy: []Order{{Column: "created_at"}},
+	}, sources())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := [][]interface{}{
+		{"alice@example.com", "purchase"},
+		{"alice@example.com", "login"},
+		{"bob@example.com", "purchase"},
+	}
+	if !reflect.DeepEqual(res.Rows, want) || !reflect.DeepEqual(res.Columns, []string{"u.email", "event_type"}) {
+		t.Errorf("unexpected result: %v %v", res.Columns, res.Rows)
+	}
+}
+
+func TestOuterJoins(t *testing.T) {
+	for _, tt := range []struct {
+		kind string
+		rows int
+	}{{"left", 4}, {"right", 4}, {"full", 5}} {
+		res, err := Execute(Spec{
+			From:  "e",
+			Joins: []Join{{Source: "u", Type: tt.kind, On: []On{{Left: "e.user_id", Right: "id"}}}},
+		}, sources())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.kind, err)
+		}
+		if len(res.Rows) != tt.rows {
+			t.Errorf("%s join: expected %d rows, got %d", tt.kind, tt.rows, len(res.Rows))
+		}
+	}
+}
+
+func TestWhereGroupAndAggregate(t *testing.T) {
+	res, err := Execute(Spec{
+		From:  "u",
+		Joins: []Join{{Source: "e", Type: "left", On: []On{{Left: "u.id", Right: "user_id"}}}},
+		Where: []Condition{
+			{Or: []Condition{
+				{Column: "email", Op: "like", Value: "%@example.com"},
+				{Column: "email", Op: "ilike", Value: "CAROL%"},
+			}},
+		},
+		GroupBy: []string{"u.email"},
+		Aggregates: []Aggregate{
+			{Func: "count", Column: "event_type", As: "events"},
+			{Func: "sum", Column: "amount", As: "total"},
+			{Func: "max", Column: "created_at"},
+		},
+		OrderBy: []Order{{Column: "events", Desc: true}, {Column: "u.email"}},
+	}, sources())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(res.Columns, []string{"u.email", "events", "total", "max_created_at"}) {
+		t.Fatalf("unexpected columns: %v", res.Columns)
+	}
+	want := [][]interface{}{
+		{"alice@example.com", int64(2), 10.5, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"bob@example.com", int64(1), 4.5, time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"carol@other.org", int64(0), nil, nil},
+	}
+	if !reflect.DeepEqual(res.Rows, want) {
+		t.Errorf("unexpected rows:\n got %v\nwant %v", res.Rows, want)
+	}
+}
+
+func TestFilterOperators(t *testing.T) {
+	tests := []struct {
+		cond Condition
+		rows int
+	}{
+		{Condition{Column: "user_id", Op: "in", Value: []interface{}{float64(1), float64(9)}}, 3},
+		{Condition{Column: "user_id", Op: "not_in", Value: []interface{}{float64(1)}}, 2},
+		{Condition{Column: "amount", Op: "is_null"}, 2},
+		{Condition{Column: "amount", Op: ">=", Value: float64(5)}, 1},
+		{Condition{Column: "created_at", Op: "<", Value: "2025-01-03T00:00:00Z"}, 2},
+		{Condition{And: []Condition{{Column: "event_type", Op: "=", Value: "login"}, {Column: "user_id", Op: "!=", Value: float64(1)}}}, 1},
+	}
+	for _, tt := range tests {
+		res, err := Execute(Spec{From: "e", Where: []Condition{tt.cond}}, sources())
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tt.cond, err)
+		}
+		if len(res.Rows) != tt.rows {
+			t.Errorf("%+v: expected %d rows, got %d", tt.cond, tt.rows, len(res.Rows))
+		}
+	}
+}
+
+func TestCountWithoutGroupsAndLimit(t *testing.T) {
+	res, err := Execute(Spec{
+		From:       "e",
+		Where:      []Condition{{Column: "event_type", Op: "=", Value: "refund"}},
+		Aggregates: []Aggregate{{Func: "count", Column: "*"}},
+	}, sources())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(res.Rows, [][]interface{}{{int64(0)}}) {
+		t.Errorf("expected a single zero count, got %v", res.Rows)
+	}
+
+	res, err = Execute(Spec{From: "u", Limit: 2}, sources())
+	if err != nil || len(res.Rows) != 2 {
+		t.Errorf("expected limit to apply: %v %v", res, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []Spec{
+		{From: "missing"},
+		{From: "e", Joins: []Join{{Source: "u", On: []On{{Left: "nope", Right: "id"}}}}},
+		{From: "e", Joins: []Join{{Source: "u", Type: "cross", On: []On{{Left: "user_id", Right: "id"}}}}},
+		{From: "e", Joins: []Join{{Source: "e", On: []On{{Left: "user_id", Right: "user_id"}}}}},
+		{From: "e", Where: []Condition{{Column: "amount", Op: "~", Value: "x"}}},
+		{From: "e", Aggregates: []Aggregate{{Func: "median", Column: "amount"}}},
+		{From: "e", Select: []string{"ghost"}},
+	}
+	for _, spec := range tests {
+		if _, err := Execute(spec, sources()); err == nil {
+			t.Errorf("expected error for %+v", spec)
+		}
+	}
+
+	// "id" is ambiguous once both sides expose it
+	srcs := sources()
+	srcs["v"] = &Table{Columns: []string{"id"}, Rows: [][]interface{}{{int64(1)}}}
+	_, err := Execute(Spec{From: "u", Joins: []Join{{Source: "v", On: []On{{Left: "u.id", Right: "id"}}}}, Select: []string{"id"}}, srcs)
+	if err == nil {
+		t.Errorf("expected ambiguous column error")
+	}
+}
+
+func TestRowCap(t *testing.T) {
+	_, err := Execute(Spec{
+		From:    "e",
+		Joins:   []Join{{Source: "u", On: []On{{Left: "user_id", Right: "id"}}}},
+		MaxRows: 2,
+	}, sources())
+	if !errors.Is(err, ErrRowCap) {
+		t.Errorf("expected row cap error, got %v", err)
+	}
+}
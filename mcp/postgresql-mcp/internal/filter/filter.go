@@ -0,0 +1,233 @@
+// Package filter compiles JSON filter conditions into parameterized SQL
+// boolean expressions.
+//
+// A filter is one of:
+//   - a condition {"column": "status", "op": "in", "value": ["a", "b"]},
+//     optionally with "type" to convert the value like a typed parameter
+//   - a group {"and": [...]}, {"or": [...]} or {"not": filter}
+//   - an array of filters, all of which must hold
+//   - a shorthand map {"id": 1, "status": "active"} of equality conditions
+//
+// Column names are validated and quoted by the caller's Quote function and
+// values are always bound as parameters.
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+)
+
+// comparisons maps comparison ops to their SQL operator
+var comparisons = map[string]string{
+	"=": "=", "eq": "=",
+	"!=": "<>", "<>": "<>", "ne": "<>",
+	"<": "<", "lt": "<",
+	"<=": "<=", "lte": "<=",
+	">": ">", "gt": ">",
+	">=": ">=", "gte": ">=",
+	"like": "LIKE", "not_like": "NOT LIKE",
+	"ilike": "ILIKE", "not_ilike": "NOT ILIKE",
+}
+
+// Compiler turns filters into SQL. Args holds the bound values; placeholders
+// continue after any values already in Args, so a compiler can follow e.g.
+// the SET values of an UPDATE.
+type Compiler struct {
+	// Quote validates and quotes a column name
+	Quote func(string) (string, error)
+	Args  []interface{}
+	conv  pgparam.Converter
+}
+
+// Compile returns the SQL expression for where. Every value that fails to
+// convert is reported together as pgparam.Errors.
+func (c *Compiler) Compile(where interface{}) (string, error) {
+	expr, err := c.node(where, "where")
+	if err != nil {
+		return "", err
+	}
+	if err := c.conv.Err(); err != nil {
+		return "", err
+	}
+	return expr, nil
+}
+
+// IsEmpty reports whether where has no conditions at all
+func IsEmpty(where interface{}) bool {
+	switch w := where.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(w) == 0
+	case map[string]interface{}:
+		return len(w) == 0
+	}
+	return false
+}
+
+func (c *Compiler) node(v interface{}, path string) (string, error) {
+	switch x := v.(type) {
+	case []interface{}:
+		return c.group(x, "AND", path)
+	case map[string]interface{}:
+		for _, key := range []string{"and", "or"} {
+			if items, ok := x[key]; ok {
+				if len(x) != 1 {
+					return "", fmt.Errorf("%s: %q cannot be combined with other keys", path, key)
+				}
+				list, ok := items.([]interface{})
+				if !ok {
+					return "", fmt.Errorf("%s.%s must be an array", path, key)
+				}
+				return c.group(list, strings.ToUpper(key), path+"."+key)
+			}
+		}
+		if inner, ok := x["not"]; ok {
+			if len(x) != 1 {
+				return "", fmt.Errorf(`%s: "not" cannot be combined with other keys`, path)
+			}
+			expr, err := c.node(inner, path+".not")
+			if err != nil {
+				return "", err
+			}
+			return "NOT (" + expr + ")", nil
+		}
+		if _, ok := x["column"]; ok {
+			return c.condition(x, path)
+		}
+		return c.shorthand(x, path)
+	}
+	return "", fmt.Errorf("%s must be an object or an array", path)
+}
+
+func (c *Compiler) group(items []interface{}, joiner, path string) (string, error) {
+	if len(items) == 0 {
+		return "", fmt.Errorf("%s must not be empty", path)
+	}
+	exprs := make([]string, len(items))
+	for i, item := range items {
+		var err error
+		if exprs[i], err = c.node(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return "", err
+		}
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return "(" + strings.Join(exprs, " "+joiner+" ") + ")", nil
+}
+
+// shorthand compiles {"col": value, ...} as equalities; an array value is IN
+func (c *Compiler) shorthand(m map[string]interface{}, path string) (string, error) {
+	if len(m) == 0 {
+		return "", fmt.Errorf("%s must not be empty", path)
+	}
+	cols := make([]string, 0, len(m))
+	for col := range m {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	exprs := make([]string, len(cols))
+	for i, col := range cols {
+		op := "="
+		if _, ok := m[col].([]interface{}); ok {
+			op = "in"
+		}
+		var err error
+		exprs[i], err = c.condition(map[string]interface{}{"column": col, "op": op, "value": m[col]}, path+"."+col)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return "(" + strings.Join(exprs, " AND ") + ")", nil
+}
+
+func (c *Compiler) condition(m map[string]interface{}, path string) (string, error) {
+	column, ok := m["column"].(string)
+	if !ok {
+		return "", fmt.Errorf("%s.column must be a string", path)
+	}
+	col, err := c.Quote(column)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	op := "="
+	if o, exists := m["op"]; exists {
+		if op, ok = o.(string); !ok {
+			return "", fmt.Errorf("%s.op must be a string", path)
+		}
+		op = strings.ToLower(op)
+	}
+	typeName, _ := m["type"].(string)
+	value, hasValue := m["value"]
+
+	switch op {
+	case "is_null":
+		return col + " IS NULL", nil
+	case "is_not_null":
+		return col + " IS NOT NULL", nil
+
+	case "in", "not_in":
+		list, ok := value.([]interface{})
+		if !ok {
+			return "", fmt.Errorf("%s: %s needs an array value", path, op)
+		}
+		if len(list) == 0 {
+			// Nothing is IN an empty list
+			if op == "in" {
+				return "FALSE", nil
+			}
+			return "TRUE", nil
+		}
+		placeholders := make([]string, len(list))
+		for i, v := range list {
+			if v == nil {
+				return "", fmt.Errorf("%s.value[%d]: NULL never matches; use is_null", path, i)
+			}
+			placeholders[i] = c.bind(fmt.Sprintf("%s.value[%d]", path, i), v, typeName)
+		}
+		sqlOp := "IN"
+		if op == "not_in" {
+			sqlOp = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", col, sqlOp, strings.Join(placeholders, ", ")), nil
+
+	case "between", "not_between":
+		bounds, ok := value.([]interface{})
+		if !ok || len(bounds) != 2 || bounds[0] == nil || bounds[1] == nil {
+			return "", fmt.Errorf("%s: %s needs a [low, high] value", path, op)
+		}
+		low := c.bind(path+".value[0]", bounds[0], typeName)
+		high := c.bind(path+".value[1]", bounds[1], typeName)
+		sqlOp := "BETWEEN"
+		if op == "not_between" {
+			sqlOp = "NOT BETWEEN"
+		}
+		return fmt.Sprintf("%s %s %s AND %s", col, sqlOp, low, high), nil
+	}
+
+	sqlOp, ok := comparisons[op]
+	if !ok {
+		return "", fmt.Errorf("%s: unsupported op %q", path, op)
+	}
+	if !hasValue || value == nil {
+		return "", fmt.Errorf("%s: %s needs a value; use is_null or is_not_null for NULL", path, op)
+	}
+	return fmt.Sprintf("%s %s %s", col, sqlOp, c.bind(path+".value", value, typeName)), nil
+}
+
+// bind converts v, typed when typeName is set, and returns its placeholder
+func (c *Compiler) bind(name string, v interface{}, typeName string) string {
+	if typeName != "" {
+		v = map[string]interface{}{"value": v, "type": typeName}
+	}
+	c.Args = append(c.Args, c.conv.Convert(name, v))
+	return fmt.Sprintf("$%d", len(c.Args))
+}
@@ -0,0 +1,117 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+)
+
+func quote(name string) (string, error) {
+	if strings.ContainsAny(name, `"; `) {
+		return "", fmt.Errorf("invalid identifier: %q", name)
+	}
+	return `"` + name + `"`, nil
+}
+
+func compile(t *testing.T, where interface{}, args ...interface{}) (string, []interface{}) {
+	t.Helper()
+	c := Compiler{Quote: quote, Args: args}
+	expr, err := c.Compile(where)
+	if err != nil {
+		t.Fatalf("Compile(%v) failed: %v", where, err)
+	}
+	return expr, c.Args
+}
+
+func TestCompile(t *testing.T) {
+	for _, tc := range []struct {
+		where interface{}
+		want  string
+		args  []interface{}
+	}{
+		{
+			map[string]interface{}{"id": float64(1)},
+			`"id" = $1`, []interface{}{int64(1)},
+		},
+		{
+			map[string]interface{}{"status": "active", "id": []interface{}{float64(1), float64(2)}},
+			`("id" IN ($1, $2) AND "status" = $3)`, []interface{}{int64(1), int64(2), "active"},
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{"column": "age", "op": ">=", "value": float64(18)},
+				map[string]interface{}{"or": []interface{}{
+					map[string]interface{}{"column": "email", "op": "ilike", "value": "%@example.com"},
+					map[string]interface{}{"column": "deleted_at", "op": "is_null"},
+				}},
+			},
+			`("age" >= $1 AND ("email" ILIKE $2 OR "deleted_at" IS NULL))`, []interface{}{int64(18), "%@example.com"},
+		},
+		{
+			map[string]interface{}{"not": map[string]interface{}{"column": "created_at", "op": "between", "type": "date", "value": []interface{}{"2025-01-01", "2025-02-01"}}},
+			`NOT ("created_at" BETWEEN $1 AND $2)`, []interface{}{"2025-01-01", "2025-02-01"},
+		},
+		{
+			map[string]interface{}{"column": "id", "op": "in", "value": []interface{}{}},
+			`FALSE`, nil,
+		},
+		{
+			map[string]interface{}{"column": "tags", "op": "=", "value": map[string]interface{}{"value": []interface{}{"a"}, "type": "text[]"}},
+			`"tags" = $1`, []interface{}{`{"a"}`},
+		},
+	} {
+		got, args := compile(t, tc.where)
+		if got != tc.want {
+			t.Errorf("Compile(%v) = %s, want %s", tc.where, got, tc.want)
+		}
+		if !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("Compile(%v) bound %#v, want %#v", tc.where, args, tc.args)
+		}
+	}
+}
+
+func TestCompileContinuesPlaceholders(t *testing.T) {
+	got, args := compile(t, map[string]interface{}{"id": float64(7)}, "new name")
+	if got != `"id" = $2` || len(args) != 2 {
+		t.Errorf("expected the filter to follow existing args, got %s with %v", got, args)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		where interface{}
+		want  string
+	}{
+		{map[string]interface{}{"id": nil}, "where.id: = needs a value; use is_null"},
+		{[]interface{}{}, "where must not be empty"},
+		{map[string]interface{}{"column": "id", "op": "~", "value": "x"}, `where: unsupported op "~"`},
+		{map[string]interface{}{"column": "id; DROP TABLE users", "value": float64(1)}, "invalid identifier"},
+		{map[string]interface{}{"or": []interface{}{map[string]interface{}{"column": "id", "op": "between", "value": []interface{}{float64(1)}}}}, "where.or[0]: between needs a [low, high] value"},
+		{map[string]interface{}{"and": []interface{}{}, "id": float64(1)}, `"and" cannot be combined`},
+		{"id = 1", "where must be an object or an array"},
+	} {
+		c := Compiler{Quote: quote}
+		if _, err := c.Compile(tc.where); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Compile(%v) error = %v, want %q", tc.where, err, tc.want)
+		}
+	}
+}
+
+func TestCompileReportsEveryBadValue(t *testing.T) {
+	c := Compiler{Quote: quote}
+	_, err := c.Compile([]interface{}{
+		map[string]interface{}{"column": "id", "type": "int", "value": "one"},
+		map[string]interface{}{"column": "day", "op": "in", "type": "date", "value": []interface{}{"2025-01-01", "someday"}},
+	})
+	var errs pgparam.Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected two conversion errors, got %v", err)
+	}
+	if errs[0].Param != "where[0].value" || errs[1].Param != "where[1].value[1]" {
+		t.Errorf("unexpected params %q and %q", errs[0].Param, errs[1].Param)
+	}
+}
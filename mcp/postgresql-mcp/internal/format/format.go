@@ -0,0 +1,168 @@
+package format
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported formats. JSON is the default and is rendered by the caller as
+// part of the regular response.
+const (
+	JSON     = "json"
+	CSV      = "csv"
+	Markdown = "markdown"
+	NDJSON   = "ndjson"
+)
+
+// Validate returns the normalized format name, or an error for unknown formats
+func Validate(format string) (string, error) {
+	switch f := strings.ToLower(format); f {
+	case "":
+		return JSON, nil
+	case JSON, CSV, Markdown, NDJSON:
+		return f, nil
+	case "md":
+		return Markdown, nil
+	case "jsonl":
+		return NDJSON, nil
+	}
+	return "", fmt.Errorf("unsupported format %q (use json, csv, markdown or ndjson)", format)
+}
+
+// Render writes rows in format, keeping the column order
+func Render(format string, columns []string, rows [][]interface{}) (string, error) {
+	switch format {
+	case CSV:
+		return renderCSV(columns, rows)
+	case Markdown:
+		return renderMarkdown(columns, rows), nil
+	case NDJSON:
+		return renderNDJSON(columns, rows)
+	}
+	return "", fmt.Errorf("format %q is not rendered as text", format)
+}
+
+// Cell formats a scalar for text output; NULL becomes ok=false
+func Cell(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return x, true
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x), true
+	case json.RawMessage:
+		return string(x), true
+	case time.Time:
+		return x.Format(time.RFC3339Nano), true
+	case bool:
+		return strconv.FormatBool(x), true
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case int:
+		return strconv.Itoa(x), true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32), true
+	case fmt.Stringer:
+		return x.String(), true
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v), true
+	}
+	return string(b), true
+}
+
+// renderCSV writes an RFC 4180 header and rows; NULL is an empty field
+func renderCSV(columns []string, rows [][]interface{}) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return "", err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i := range record {
+			record[i], _ = Cell(row[i])
+		}
+		if err := w.Write(record); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
+
+// renderMarkdown writes a GitHub-flavored Markdown table; NULL is rendered as *NULL*
+func renderMarkdown(columns []string, rows [][]interface{}) string {
+	var b strings.Builder
+	b.WriteString("|")
+	for _, c := range columns {
+		b.WriteString(" " + markdownEscaper.Replace(c) + " |")
+	}
+	b.WriteString("\n|")
+	for range columns {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+	for _, row := range rows {
+		b.WriteString("|")
+		for i := range columns {
+			s, ok := Cell(row[i])
+			if !ok {
+				s = "*NULL*"
+			} else {
+				s = markdownEscaper.Replace(s)
+			}
+			b.WriteString(" " + s + " |")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// renderNDJSON writes one JSON object per row with keys in column order
+func renderNDJSON(columns []string, rows [][]interface{}) (string, error) {
+	keys := make([][]byte, len(columns))
+	for i, c := range columns {
+		k, err := json.Marshal(c)
+		if err != nil {
+			return "", err
+		}
+		keys[i] = k
+	}
+	var buf bytes.Buffer
+	for _, row := range rows {
+		buf.WriteByte('{')
+		for i := range columns {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(keys[i])
+			buf.WriteByte(':')
+			v, err := json.Marshal(row[i])
+			if err != nil {
+				return "", fmt.Errorf("column %s: %w", columns[i], err)
+			}
+			buf.Write(v)
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,63 @@
+package format
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var (
+	columns = []string{"id", "name", "note", "created_at"}
+	rows    = [][]interface{}{
+		{int64(1), "Alice, \"Al\"", "line1\nline2 | pipe", time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)},
+		{int64(2), "Bob", nil, nil},
+	}
+)
+
+func TestValidate(t *testing.T) {
+	for in, want := range map[string]string{"": JSON, "CSV": CSV, "md": Markdown, "jsonl": NDJSON, "ndjson": NDJSON} {
+		if got, err := Validate(in); err != nil || got != want {
+			t.Errorf("Validate(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := Validate("xml"); err == nil {
+		t.Errorf("expected unknown format to fail")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	out, err := Render(CSV, columns, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "id,name,note,created_at\n" +
+		"1,\"Alice, \"\"Al\"\"\",\"line1\nline2 | pipe\",2025-03-01T09:30:00Z\n" +
+		"2,Bob,,\n"
+	if out != want {
+		t.Errorf("unexpected CSV:\n%q\nwant\n%q", out, want)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	out, err := Render(Markdown, columns, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "| id | name | note | created_at |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| 1 | Alice, \"Al\" | line1<br>line2 \\| pipe | 2025-03-01T09:30:00Z |\n" +
+		"| 2 | Bob | *NULL* | *NULL* |\n"
+	if out != want {
+		t.Errorf("unexpected Markdown:\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestNDJSONKeepsColumnOrder(t *testing.T) {
+	out, err := Render(NDJSON, []string{"z", "a"}, [][]interface{}{{json.RawMessage(`{"k":[1,2]}`), nil}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"z":{"k":[1,2]},"a":null}` + "\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
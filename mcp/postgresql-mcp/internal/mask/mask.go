@@ -0,0 +1,235 @@
+// Package mask hides sensitive column values in query results according to a
+// policy keyed by connection, schema, table and column.
+package mask
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strategy is how a masked value is replaced
+type Strategy string
+
+const (
+	// Redact replaces the value with a fixed marker
+	Redact Strategy = "redact"
+	// Hash replaces the value with a short digest, so equal values stay equal
+	Hash Strategy = "hash"
+	// Partial keeps the first character and, for e-mail addresses, the domain
+	Partial Strategy = "partial"
+	// Null replaces the value with NULL
+	Null Strategy = "null"
+)
+
+// Redacted is the value that replaces redacted columns
+const Redacted = "[REDACTED]"
+
+// strength orders strategies by how much they hide, for columns that more
+// than one rule applies to
+var strength = map[Strategy]int{Partial: 1, Hash: 2, Redact: 3, Null: 4}
+
+// Rule masks the columns it matches. Connection, schema, table and column are
+// case-insensitive * globs; an empty field matches anything. Pattern is a
+// case-insensitive regular expression on the column name.
+type Rule struct {
+	Connection string   `json:"connection,omitempty"`
+	Schema     string   `json:"schema,omitempty"`
+	Table      string   `json:"table,omitempty"`
+	Column     string   `json:"column,omitempty"`
+	Pattern    string   `json:"pattern,omitempty"`
+	Strategy   Strategy `json:"strategy"`
+
+	re *regexp.Regexp
+}
+
+// Policy lists masking rules. For one source column the first matching rule
+// wins.
+type Policy struct {
+	// HashSalt is mixed into hashed values so they cannot be looked up
+	HashSalt string `json:"hash_salt,omitempty"`
+	Rules    []Rule `json:"rules"`
+}
+
+// Source is a table column a result column was read from. Empty schema or
+// table means it could not be traced, which matches every rule for the
+// column. Column "*" stands for the whole row, which matches every rule for
+// the table.
+type Source struct {
+	Schema string `json:"schema,omitempty"`
+	Table  string `json:"table,omitempty"`
+	Column string `json:"column"`
+}
+
+// Load reads a JSON masking policy file
+func Load(file string) (*Policy, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read masking policy: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse masking policy %s: %w", file, err)
+	}
+	if err := p.Compile(); err != nil {
+		return nil, fmt.Errorf("parse masking policy %s: %w", file, err)
+	}
+	return &p, nil
+}
+
+// Compile validates the rules and prepares their patterns
+func (p *Policy) Compile() error {
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if _, ok := strength[r.Strategy]; !ok {
+			return fmt.Errorf("rules[%d]: unknown strategy %q (want redact, hash, partial or null)", i, r.Strategy)
+		}
+		if r.Column == "" && r.Pattern == "" {
+			return fmt.Errorf("rules[%d]: column or pattern is required", i)
+		}
+		for _, glob := range []string{r.Connection, r.Schema, r.Table, r.Column} {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("rules[%d]: invalid glob %q", i, glob)
+			}
+		}
+		if r.Pattern != "" {
+			re, err := regexp.Compile("(?i)" + r.Pattern)
+			if err != nil {
+				return fmt.Errorf("rules[%d]: invalid pattern: %w", i, err)
+			}
+			r.re = re
+		}
+	}
+	return nil
+}
+
+// matches reports whether r applies to src on connection
+func (r *Rule) matches(connection string, src Source) bool {
+	if !globMatch(r.Connection, connection) {
+		return false
+	}
+	if src.Column != "*" && !globMatch(r.Column, src.Column) {
+		return false
+	}
+	if src.Table != "" && !globMatch(r.Table, src.Table) {
+		return false
+	}
+	if src.Schema != "" && !globMatch(r.Schema, src.Schema) {
+		return false
+	}
+	return r.re == nil || src.Column == "*" || r.re.MatchString(src.Column)
+}
+
+func globMatch(glob, s string) bool {
+	if glob == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(glob), strings.ToLower(s))
+	return ok
+}
+
+// Plan returns the strategy for each result column given the sources it may
+// have been read from, or "" for columns left as they are. A column traced
+// to several sources gets the strongest strategy among them, and a whole row
+// the strongest of every rule for its table.
+func (p *Policy) Plan(connection string, sources [][]Source) []Strategy {
+	plan := make([]Strategy, len(sources))
+	for i, srcs := range sources {
+		for _, src := range srcs {
+			for j := range p.Rules {
+				if r := &p.Rules[j]; r.matches(connection, src) {
+					if strength[r.Strategy] > strength[plan[i]] {
+						plan[i] = r.Strategy
+					}
+					if src.Column != "*" {
+						break
+					}
+				}
+			}
+		}
+	}
+	return plan
+}
+
+// Any reports whether plan masks at least one column
+func Any(plan []Strategy) bool {
+	for _, s := range plan {
+		if s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Rows masks rows in place, their values in the column order of plan
+func (p *Policy) Rows(plan []Strategy, rows [][]interface{}) {
+	if !Any(plan) {
+		return
+	}
+	for _, row := range rows {
+		for i, s := range plan {
+			if s != "" && i < len(row) {
+				row[i] = p.Value(s, row[i])
+			}
+		}
+	}
+}
+
+// Value returns v masked with s. NULL stays NULL.
+func (p *Policy) Value(s Strategy, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch s {
+	case Redact:
+		return Redacted
+	case Hash:
+		sum := sha256.Sum256([]byte(p.HashSalt + text(v)))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	case Partial:
+		return partial(text(v))
+	case Null:
+		return nil
+	}
+	return v
+}
+
+// text is the string form of a decoded value
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// partial keeps the first character of s and the domain of an e-mail
+// address: "jane@example.com" becomes "j***@example.com"
+func partial(s string) string {
+	if at := strings.LastIndexByte(s, '@'); at > 0 {
+		first, _ := utf8.DecodeRuneInString(s)
+		return string(first) + "***" + s[at:]
+	}
+	if utf8.RuneCountInString(s) <= 3 {
+		return "***"
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	last, _ := utf8.DecodeLastRuneInString(s)
+	return string(first) + "***" + string(last)
+}
@@ -0,0 +1,248 @@
+package mask
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPolicy(t *testing.T) *Policy {
+	t.Helper()
+	p := &Policy{HashSalt: "s", Rules: []Rule{
+		{Connection: "primary_db", Schema: "public", Table: "users", Column: "email", Strategy: Partial},
+		{Table: "users", Column: "password_hash", Strategy: Null},
+		{Pattern: `^(ssn|tax_id)$`, Strategy: Redact},
+		{Column: "*phone*", Strategy: Hash},
+	}}
+	if err := p.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPlanMatchesRules(t *testing.T) {
+	p := testPolicy(t)
+	for _, tc := range []struct {
+		connection string
+		src        Source
+		want       Strategy
+	}{
+		{"primary_db", Source{Schema: "public", Table: "users", Column: "email"}, Partial},
+		{"primary_db", Source{Table: "USERS", Column: "Email"}, Partial},
+		{"primary_db", Source{Column: "email"}, Partial},
+		{"analytics_db", Source{Schema: "public", Table: "users", Column: "email"}, ""},
+		{"primary_db", Source{Schema: "sales", Table: "users", Column: "email"}, ""},
+		{"primary_db", Source{Schema: "public", Table: "orders", Column: "email"}, ""},
+		{"analytics_db", Source{Schema: "sales", Table: "users", Column: "password_hash"}, Null},
+		{"analytics_db", Source{Table: "people", Column: "SSN"}, Redact},
+		{"analytics_db", Source{Table: "people", Column: "ssn_checked"}, ""},
+		{"analytics_db", Source{Table: "people", Column: "mobile_phone"}, Hash},
+	} {
+		if got := p.Plan(tc.connection, [][]Source{{tc.src}})[0]; got != tc.want {
+			t.Errorf("Plan(%s, %+v) = %q, want %q", tc.connection, tc.src, got, tc.want)
+		}
+	}
+
+	// A whole row takes the strongest rule that may apply to any of its columns
+	plan := p.Plan("primary_db", [][]Source{{{Schema: "public", Table: "users", Column: "*"}}, {{Table: "orders", Column: "*"}}})
+	if plan[0] != Null || plan[1] != Redact {
+		t.Errorf("expected null for a users row and redact for an orders row, got %q", plan)
+	}
+
+	// The strongest strategy wins across sources
+	plan = p.Plan("primary_db", [][]Source{{{Table: "users", Column: "email"}, {Table: "users", Column: "password_hash"}}})
+	if plan[0] != Null {
+		t.Errorf("expected null to win over partial, got %q", plan[0])
+	}
+}
+
+func TestValue(t *testing.T) {
+	p := testPolicy(t)
+	for _, tc := range []struct {
+		s    Strategy
+		in   interface{}
+		want interface{}
+	}{
+		{Partial, "jane@example.com", "j***@example.com"},
+		{Partial, "090-1234-5678", "0***8"},
+		{Partial, "abc", "***"},
+		{Partial, int64(123456), "1***6"},
+		{Redact, "secret", Redacted},
+		{Null, "secret", nil},
+		{Redact, nil, nil},
+	} {
+		if got := p.Value(tc.s, tc.in); got != tc.want {
+			t.Errorf("Value(%s, %v) = %v, want %v", tc.s, tc.in, got, tc.want)
+		}
+	}
+
+	a, b := p.Value(Hash, "090-1234-5678"), p.Value(Hash, "090-1234-5678")
+	if a != b || !strings.HasPrefix(a.(string), "sha256:") || a == (&Policy{}).Value(Hash, "090-1234-5678") {
+		t.Errorf("expected a stable salted hash, got %v, %v", a, b)
+	}
+}
+
+func TestRowsMasksInPlace(t *testing.T) {
+	p := testPolicy(t)
+	rows := [][]interface{}{{int64(1), "jane@example.com", "x"}}
+	p.Rows([]Strategy{"", Partial, Null}, rows)
+	if !reflect.DeepEqual(rows[0], []interface{}{int64(1), "j***@example.com", nil}) {
+		t.Errorf("unexpected masked row: %v", rows[0])
+	}
+}
+
+func TestLoadRejectsBadRules(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"strategy": `{"rules": [{"column": "email", "strategy": "scramble"}]}`,
+		"column":   `{"rules": [{"table": "users", "strategy": "redact"}]}`,
+		"pattern":  `{"rules": [{"pattern": "(", "strategy": "redact"}]}`,
+		"unknown":  `{"rules": [{"column": "email", "strategy": "redact", "mode": "x"}]}`,
+	} {
+		file := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(file, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(file); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		sql     string
+		columns []string
+		want    map[string][]Source
+	}{
+		{
+			name:    "star",
+			sql:     `SELECT * FROM public.users WHERE id = $1`,
+			columns: []string{"id", "email"},
+			want:    map[string][]Source{"email": {{Schema: "public", Table: "users", Column: "email"}}},
+		},
+		{
+			name:    "aliases",
+			sql:     `SELECT u.email AS contact, lower(o.note) note, o.id FROM users u JOIN orders o ON o.user_id = u.id`,
+			columns: []string{"contact", "note", "id"},
+			want: map[string][]Source{
+				"contact": {{Table: "users", Column: "email"}},
+				"note":    {{Table: "orders", Column: "note"}},
+				"id":      {{Table: "orders", Column: "id"}},
+			},
+		},
+		{
+			name:    "unqualified column counts for every table",
+			sql:     `SELECT email FROM users, orders`,
+			columns: []string{"email"},
+			want:    map[string][]Source{"email": {{Table: "users", Column: "email"}, {Table: "orders", Column: "email"}}},
+		},
+		{
+			name:    "cte renames",
+			sql:     `WITH c AS (SELECT email AS contact FROM users) SELECT c.contact FROM c`,
+			columns: []string{"contact"},
+			want:    map[string][]Source{"contact": {{Table: "users", Column: "email"}}},
+		},
+		{
+			name:    "subquery renames",
+			sql:     `SELECT x.* FROM (SELECT "Email" AS addr FROM "Users") x`,
+			columns: []string{"addr"},
+			want:    map[string][]Source{"addr": {{Table: "Users", Column: "Email"}}},
+		},
+		{
+			name:    "returning",
+			sql:     `INSERT INTO "users" ("email") VALUES ($1) RETURNING *, (xmax = 0) AS mcp_inserted`,
+			columns: []string{"id", "email", "mcp_inserted"},
+			want: map[string][]Source{
+				"email":        {{Table: "users", Column: "email"}},
+				"mcp_inserted": {{Table: "users", Column: "xmax"}},
+			},
+		},
+		{
+			name:    "dry run preview",
+			sql:     `WITH mcp_changed AS (UPDATE "users" SET "email" = $1 WHERE "id" = $2 RETURNING *) SELECT count(*) OVER () AS mcp_affected, * FROM mcp_changed LIMIT 5`,
+			columns: []string{"mcp_affected", "email"},
+			want:    map[string][]Source{"email": {{Table: "users", Column: "email"}}},
+		},
+		{
+			name:    "table",
+			sql:     `TABLE users`,
+			columns: []string{"email"},
+			want:    map[string][]Source{"email": {{Table: "users", Column: "email"}}},
+		},
+		{
+			name:    "untraced",
+			sql:     `VALUES ('a@example.com')`,
+			columns: []string{"column1"},
+			want:    map[string][]Source{"column1": {{Column: "column1"}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Trace(tc.sql, tc.columns)
+			for i, col := range tc.columns {
+				want, ok := tc.want[col]
+				if !ok {
+					continue
+				}
+				for _, w := range want {
+					if !containsSource(got[i], w) {
+						t.Errorf("%s: expected source %+v in %+v", col, w, got[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTraceDoesNotMixTables(t *testing.T) {
+	got := Trace(`SELECT o.email, u.id FROM orders o JOIN users u ON u.id = o.user_id`, []string{"email", "id"})
+	if containsSource(got[0], Source{Table: "users", Column: "email"}) {
+		t.Errorf("o.email must not trace to users: %+v", got[0])
+	}
+}
+
+func TestTraceMasksIndirectReads(t *testing.T) {
+	p := testPolicy(t)
+	for _, sql := range []string{
+		`SELECT row_to_json(u) FROM users u`,
+		`SELECT u FROM users u`,
+		`SELECT jsonb_agg(u) FROM users u`,
+		`SELECT to_jsonb(users.*) FROM users`,
+		`SELECT id FROM users UNION ALL SELECT email FROM users`,
+		`WITH s(e) AS (SELECT email FROM users) SELECT e FROM s`,
+		`SELECT s.c FROM (SELECT * FROM users) s(a, c)`,
+		`SELECT t.x FROM users u, LATERAL unnest(ARRAY[u.email]) t(x)`,
+		`SELECT row_to_json(s) FROM (SELECT email FROM users) s`,
+		`SELECT v FROM users u, LATERAL (SELECT u.email) AS s(v)`,
+		`SELECT x FROM users CROSS JOIN LATERAL unnest(ARRAY[email]) AS x`,
+		`SELECT unnest FROM users, unnest(ARRAY[email])`,
+		`SELECT n FROM users JOIN ROWS FROM (unnest(ARRAY[email])) WITH ORDINALITY n ON true`,
+	} {
+		if plan := p.Plan("primary_db", Trace(sql, []string{"value"})); plan[0] == "" {
+			t.Errorf("expected %q to be masked", sql)
+		}
+	}
+
+	for _, sql := range []string{
+		`SELECT id FROM users`,
+		`SELECT count(*) FROM users`,
+		`SELECT id FROM users WINDOW w AS (ORDER BY id)`,
+		`SELECT e FROM (SELECT lower(nickname) AS e FROM users) s`,
+	} {
+		if plan := p.Plan("primary_db", Trace(sql, []string{"id"})); plan[0] != "" {
+			t.Errorf("expected %q not to be masked, got %q", sql, plan[0])
+		}
+	}
+}
+
+func containsSource(list []Source, s Source) bool {
+	for _, have := range list {
+		if have == s {
+			return true
+		}
+	}
+	return false
+}
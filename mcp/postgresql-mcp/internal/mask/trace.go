@@ -0,0 +1,650 @@
+package mask
+
+import (
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/sqlguard"
+)
+
+// clauseWords are keywords that are neither aliases nor column references
+var clauseWords = map[string]bool{
+	"WHERE": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"OUTER": true, "CROSS": true, "NATURAL": true, "ON": true, "USING": true, "GROUP": true,
+	"ORDER": true, "LIMIT": true, "OFFSET": true, "HAVING": true, "WINDOW": true, "UNION": true,
+	"INTERSECT": true, "EXCEPT": true, "FETCH": true, "FOR": true, "SET": true, "RETURNING": true,
+	"VALUES": true, "DEFAULT": true, "SELECT": true, "TABLESAMPLE": true, "DO": true, "WHEN": true,
+	"THEN": true, "ELSE": true, "END": true, "OF": true, "AND": true, "OR": true, "NOT": true,
+	"AS": true, "OVERRIDING": true, "WITH": true, "ONLY": true, "LATERAL": true, "INTO": true,
+	"FROM": true, "IS": true, "IN": true, "NULL": true, "TRUE": true, "FALSE": true, "CASE": true,
+	"BY": true, "ASC": true, "DESC": true, "NULLS": true, "LIKE": true, "ILIKE": true,
+	"BETWEEN": true, "DISTINCT": true, "OVER": true, "FILTER": true, "PARTITION": true,
+	"INTERVAL": true, "ARRAY": true, "ROW": true, "ALL": true, "ANY": true, "SOME": true,
+	"EXISTS": true, "CONFLICT": true, "NOWAIT": true, "SKIP": true,
+}
+
+// ref is a column reference, qualified by a table name or alias
+type ref struct {
+	qualifier, column string
+}
+
+// item is one entry of a select or RETURNING list
+type item struct {
+	// name is the output column name, empty when it cannot be told
+	name string
+	// star is set for * and qualifier.*
+	star      bool
+	qualifier string
+	refs      []ref
+}
+
+// tracer resolves result columns of one statement to table columns
+type tracer struct {
+	tokens    []sqlguard.Token
+	depth     []int
+	relations []sqlguard.Relation
+	// derived maps names given to expressions anywhere in the statement to
+	// the columns they were computed from
+	derived map[string][]Source
+	// rowNames are the names of subqueries and CTEs, whose whole rows
+	// cannot be traced to columns
+	rowNames map[string]bool
+}
+
+// Trace returns the table columns each result column of sql may have been
+// read from. Unqualified columns count as read from every table the
+// statement references, names given to expressions in subqueries and CTEs
+// carry their sources along, and every branch of a set operation counts, so
+// the result errs towards more sources. A whole-row reference reads every
+// column of its table, which a source with column "*" stands for. Columns
+// that cannot be traced count as read from every column of every table the
+// statement references.
+func Trace(sql string, columns []string) [][]Source {
+	tokens, err := sqlguard.Tokenize(sql)
+	if err != nil || len(tokens) == 0 {
+		return untraced(columns)
+	}
+	t := &tracer{tokens: tokens, relations: sqlguard.Relations(tokens), derived: map[string][]Source{}, rowNames: map[string]bool{}}
+	t.scan()
+	t.findRowNames()
+
+	top := t.topList()
+	var lists [][]item
+	for i, tok := range tokens {
+		if tok.IsKeyword("SELECT", "RETURNING") {
+			lists = append(lists, t.selectList(i+1))
+		}
+	}
+	// Two rounds let a name given in one subquery resolve through another
+	for round := 0; round < 2; round++ {
+		for _, list := range lists {
+			for _, it := range list {
+				if it.name != "" && !it.star {
+					t.derived[it.name] = appendSources(t.derived[it.name], t.itemSources(it)...)
+				}
+			}
+		}
+		t.aliasColumns()
+	}
+
+	var branches [][]item
+	if top >= 0 {
+		branches = append(branches, t.selectList(top+1))
+		branches = append(branches, t.setBranches(top)...)
+	} else {
+		// TABLE, VALUES and statements without a list return whole rows
+		branches = [][]item{{{star: true}}}
+	}
+
+	sources := make([][]Source, len(columns))
+	for i, col := range columns {
+		for b, items := range branches {
+			sources[i] = appendSources(sources[i], t.columnSources(items, len(columns), i, col, b == 0)...)
+		}
+	}
+	return sources
+}
+
+// columnSources returns the sources of result column i, named col, of a
+// list of items. Columns of the first branch of a set operation are found by
+// name; other branches only line up by position.
+func (t *tracer) columnSources(items []item, n, i int, col string, named bool) []Source {
+	positional := len(items) == n
+	for _, it := range items {
+		if it.star {
+			positional = false
+		}
+	}
+	if positional {
+		return t.itemSources(items[i])
+	}
+	var sources []Source
+	for _, it := range items {
+		switch {
+		case it.star && named:
+			sources = appendSources(sources, t.starSources(it.qualifier, col)...)
+		case it.star:
+			sources = appendSources(sources, t.refSources(ref{qualifier: it.qualifier, column: "*"})...)
+		case it.name == col || !named:
+			sources = appendSources(sources, t.itemSources(it)...)
+		}
+	}
+	if len(sources) == 0 {
+		return t.fallback(col)
+	}
+	return sources
+}
+
+// setBranches returns the lists of the branches of UNION, INTERSECT and
+// EXCEPT after the list at top. Branches without a list, TABLE or VALUES,
+// return whole rows.
+func (t *tracer) setBranches(top int) [][]item {
+	var branches [][]item
+	for i := top + 1; i < len(t.tokens); i++ {
+		if !t.tokens[i].IsKeyword("UNION", "INTERSECT", "EXCEPT") || t.depth[i] > t.depth[top] {
+			continue
+		}
+		j := i + 1
+		for j < len(t.tokens) && (t.tokens[j].IsKeyword("ALL", "DISTINCT") || t.tokens[j].IsPunct("(")) {
+			j++
+		}
+		if j < len(t.tokens) && t.tokens[j].IsKeyword("SELECT") {
+			branches = append(branches, t.selectList(j+1))
+		} else {
+			branches = append(branches, []item{{star: true}})
+		}
+	}
+	return branches
+}
+
+// TableSources returns the sources of columns read directly from
+// schema.table; schema may be empty when it was not given
+func TableSources(schema, table string, columns []string) [][]Source {
+	sources := make([][]Source, len(columns))
+	for i, col := range columns {
+		sources[i] = []Source{{Schema: schema, Table: table, Column: col}}
+	}
+	return sources
+}
+
+// fallback returns the sources of a column named col that cannot be traced:
+// every column of every table the statement references
+func (t *tracer) fallback(col string) []Source {
+	var sources []Source
+	if col != "*" {
+		sources = []Source{{Column: col}}
+	}
+	for _, rel := range t.relations {
+		sources = appendSources(sources, Source{Schema: rel.Schema, Table: rel.Name, Column: "*"})
+	}
+	return sources
+}
+
+func untraced(columns []string) [][]Source {
+	sources := make([][]Source, len(columns))
+	for i, col := range columns {
+		sources[i] = []Source{{Column: col}}
+	}
+	return sources
+}
+
+func appendSources(list []Source, add ...Source) []Source {
+	for _, s := range add {
+		found := false
+		for _, have := range list {
+			if have == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// scan records paren depths
+func (t *tracer) scan() {
+	t.depth = make([]int, len(t.tokens))
+	depth := 0
+	for i, tok := range t.tokens {
+		if tok.IsPunct(")") {
+			depth--
+		}
+		t.depth[i] = depth
+		if tok.IsPunct("(") {
+			depth++
+		}
+	}
+}
+
+// topList returns the index of the keyword that starts the statement's own
+// result list: RETURNING, else the outermost SELECT, or -1
+func (t *tracer) topList() int {
+	best := -1
+	for i, tok := range t.tokens {
+		if tok.IsKeyword("RETURNING") && t.depth[i] == 0 {
+			return i
+		}
+		if tok.IsKeyword("SELECT") && (best < 0 || t.depth[i] < t.depth[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// selectList splits the list starting at i into its items
+func (t *tracer) selectList(i int) []item {
+	for i < len(t.tokens) && t.tokens[i].IsKeyword("DISTINCT", "ALL") {
+		if t.tokens[i].IsKeyword("DISTINCT") && i+1 < len(t.tokens) && t.tokens[i+1].IsKeyword("ON") && t.punct(i+2, "(") {
+			i = t.matchParen(i + 2)
+		}
+		i++
+	}
+	if i >= len(t.tokens) {
+		return nil
+	}
+	base := t.depth[i]
+	var items []item
+	start := i
+	for ; i <= len(t.tokens); i++ {
+		end := i == len(t.tokens)
+		if !end {
+			tok := t.tokens[i]
+			if t.depth[i] < base || tok.IsPunct(";") {
+				end = true
+			} else if t.depth[i] == base && tok.Type == sqlguard.TokenWord && isListEnd(tok) {
+				end = true
+			}
+		}
+		if end || (t.depth[i] == base && t.tokens[i].IsPunct(",")) {
+			if i > start {
+				items = append(items, parseItem(t.tokens[start:i]))
+			}
+			if end {
+				break
+			}
+			start = i + 1
+		}
+	}
+	return items
+}
+
+func isListEnd(tok sqlguard.Token) bool {
+	return tok.IsKeyword("FROM", "INTO", "WHERE", "GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT",
+		"OFFSET", "UNION", "INTERSECT", "EXCEPT", "FETCH", "FOR")
+}
+
+// parseItem reads the output name and column references of one list item
+func parseItem(tokens []sqlguard.Token) item {
+	n := len(tokens)
+	if n == 1 && tokens[0].Value == "*" {
+		return item{star: true}
+	}
+	if n == 3 && isIdent(tokens[0]) && tokens[1].IsPunct(".") && tokens[2].Value == "*" {
+		return item{star: true, qualifier: sqlguard.IdentName(tokens[0])}
+	}
+
+	var it item
+	expr := tokens
+	last := tokens[n-1]
+	switch {
+	case n >= 2 && tokens[n-2].IsKeyword("AS") && isIdent(last):
+		it.name = sqlguard.IdentName(last)
+		expr = tokens[:n-2]
+	case n >= 2 && isIdent(last) && !isClauseWord(last) && endsOperand(tokens[n-2]):
+		it.name = sqlguard.IdentName(last)
+		expr = tokens[:n-1]
+	}
+
+	for i, tok := range expr {
+		if !isIdent(tok) || isClauseWord(tok) {
+			continue
+		}
+		if i > 0 && (expr[i-1].IsPunct(".") || expr[i-1].Value == "::") {
+			continue
+		}
+		if i+1 < len(expr) && expr[i+1].IsPunct("(") {
+			continue
+		}
+		if i+2 < len(expr) && expr[i+1].IsPunct(".") {
+			// Only the last two parts of schema.table.column matter
+			j := i
+			for j+2 < len(expr) && expr[j+1].IsPunct(".") && isIdent(expr[j+2]) {
+				j += 2
+			}
+			switch {
+			case j+2 < len(expr) && expr[j+1].IsPunct(".") && expr[j+2].Value == "*":
+				// A whole row inside an expression, e.g. to_jsonb(u.*)
+				it.refs = append(it.refs, ref{qualifier: sqlguard.IdentName(expr[j]), column: "*"})
+			case j > i:
+				it.refs = append(it.refs, ref{qualifier: sqlguard.IdentName(expr[j-2]), column: sqlguard.IdentName(expr[j])})
+			}
+			continue
+		}
+		it.refs = append(it.refs, ref{column: sqlguard.IdentName(tok)})
+	}
+
+	if it.name == "" {
+		it.name = outputName(expr)
+	}
+	return it
+}
+
+// endsOperand reports whether tok can end an expression, so that an
+// identifier after it is an alias given without AS
+func endsOperand(tok sqlguard.Token) bool {
+	switch tok.Type {
+	case sqlguard.TokenString, sqlguard.TokenNumber, sqlguard.TokenParam, sqlguard.TokenQuotedIdent:
+		return true
+	case sqlguard.TokenWord:
+		return !isClauseWord(tok) || tok.IsKeyword("END", "NULL", "TRUE", "FALSE")
+	}
+	return tok.IsPunct(")") || tok.IsPunct("]")
+}
+
+// outputName is the column name PostgreSQL gives an expression without an
+// alias: the column of a plain or cast column reference, or the function name
+func outputName(expr []sqlguard.Token) string {
+	for i, tok := range expr {
+		if tok.Value == "::" {
+			expr = expr[:i]
+			break
+		}
+	}
+	if len(expr) == 0 || !isIdent(expr[0]) {
+		return ""
+	}
+	if len(expr) > 1 && expr[1].IsPunct("(") {
+		return sqlguard.IdentName(expr[0])
+	}
+	for i := 1; i < len(expr); i += 2 {
+		if !expr[i].IsPunct(".") || i+1 >= len(expr) || !isIdent(expr[i+1]) {
+			return ""
+		}
+	}
+	return sqlguard.IdentName(expr[len(expr)-1])
+}
+
+// itemSources resolves the column references of one list item
+func (t *tracer) itemSources(it item) []Source {
+	var sources []Source
+	for _, r := range it.refs {
+		sources = appendSources(sources, t.refSources(r)...)
+	}
+	return sources
+}
+
+// refSources returns the table columns a reference may read. References to
+// subqueries, CTEs or unknown qualifiers also take the sources of any
+// expression given the same name, or fall back to every table. A bare
+// table name or alias, like u in row_to_json(u), reads the whole row.
+func (t *tracer) refSources(r ref) []Source {
+	var sources []Source
+	if r.qualifier == "" && r.column != "*" {
+		for _, rel := range t.relations {
+			if rel.Alias == r.column || (rel.Alias == "" && rel.Name == r.column) {
+				sources = append(sources, Source{Schema: rel.Schema, Table: rel.Name, Column: "*"})
+			}
+		}
+		if t.rowNames[r.column] {
+			sources = appendSources(sources, t.fallback(r.column)...)
+		}
+	}
+	for _, rel := range t.relations {
+		if r.qualifier == "" || rel.Alias == r.qualifier || (rel.Alias == "" && rel.Name == r.qualifier) {
+			sources = appendSources(sources, Source{Schema: rel.Schema, Table: rel.Name, Column: r.column})
+		}
+	}
+	if r.column != "*" {
+		sources = appendSources(sources, t.derived[r.column]...)
+	}
+	if len(sources) == 0 {
+		return t.fallback(r.column)
+	}
+	return sources
+}
+
+// starSources returns the sources of column col expanded from qualifier.*
+func (t *tracer) starSources(qualifier, col string) []Source {
+	return t.refSources(ref{qualifier: qualifier, column: col})
+}
+
+// findRowNames records the names of CTEs and the aliases of subqueries and
+// of functions in FROM. A function without an alias is named after itself.
+func (t *tracer) findRowNames() {
+	for i, tok := range t.tokens {
+		switch {
+		case isIdent(tok) && !isClauseWord(tok):
+			if body := t.cteBody(i); body >= 0 {
+				t.rowNames[sqlguard.IdentName(tok)] = true
+			}
+		case tok.IsPunct(")") && t.isQuery(t.openParen(i)):
+			if alias := t.aliasAfter(i + 1); alias != "" {
+				t.rowNames[alias] = true
+			}
+		case tok.IsPunct(")") && t.fromFunction(t.openParen(i)):
+			j := i + 1
+			if j+1 < len(t.tokens) && t.tokens[j].IsKeyword("WITH") && t.tokens[j+1].IsKeyword("ORDINALITY") {
+				j += 2
+			}
+			alias := t.aliasAfter(j)
+			if alias == "" {
+				alias = sqlguard.IdentName(t.tokens[t.openParen(i)-1])
+			}
+			t.rowNames[alias] = true
+		}
+	}
+}
+
+// aliasAfter returns the alias, with or without AS, starting at i, or ""
+func (t *tracer) aliasAfter(i int) string {
+	if i < len(t.tokens) && t.tokens[i].IsKeyword("AS") {
+		i++
+	}
+	if i < len(t.tokens) && isIdent(t.tokens[i]) && !isClauseWord(t.tokens[i]) {
+		return sqlguard.IdentName(t.tokens[i])
+	}
+	return ""
+}
+
+// fromFunction reports whether the parentheses opening at open hold the
+// arguments of a function in a FROM list, including ROWS FROM (...)
+func (t *tracer) fromFunction(open int) bool {
+	j := open - 1
+	if j < 1 || !isIdent(t.tokens[j]) {
+		return false
+	}
+	if t.tokens[j].IsKeyword("FROM") {
+		return t.tokens[j-1].IsKeyword("ROWS")
+	}
+	if isClauseWord(t.tokens[j]) {
+		return false
+	}
+	for j >= 2 && t.tokens[j-1].IsPunct(".") {
+		j -= 2
+	}
+	for j--; j >= 0 && t.tokens[j].IsKeyword("LATERAL"); j-- {
+	}
+	switch {
+	case j < 0:
+		return false
+	case t.tokens[j].IsKeyword("FROM", "JOIN"):
+		return true
+	case !t.tokens[j].IsPunct(","):
+		return false
+	}
+	// A comma continues a FROM list unless a clause of its own comes first
+	for k := j - 1; k >= 0 && t.depth[k] >= t.depth[j]; k-- {
+		switch {
+		case t.depth[k] != t.depth[j]:
+		case t.tokens[k].IsKeyword("FROM", "JOIN", "USING"):
+			return true
+		case t.tokens[k].IsKeyword("SELECT", "WHERE", "SET", "VALUES", "RETURNING", "GROUP", "ORDER", "HAVING", "WINDOW"):
+			return false
+		}
+	}
+	return false
+}
+
+// aliasColumns gives the names of column alias lists, as in
+// WITH s(e) AS (...), (SELECT ...) s(a, b), unnest(...) t(x) or users u(a, b),
+// the sources of what they rename
+func (t *tracer) aliasColumns() {
+	for i, tok := range t.tokens {
+		if !isIdent(tok) || isClauseWord(tok) || !t.punct(i+1, "(") {
+			continue
+		}
+		names, end := t.nameList(i + 1)
+		if names == nil {
+			continue
+		}
+
+		var sources [][]Source
+		prev := i - 1
+		if prev >= 0 && t.tokens[prev].IsKeyword("AS") {
+			prev--
+		}
+		switch body := t.cteBodyAfter(end + 1); {
+		case body >= 0:
+			sources = t.groupSources(body, len(names))
+		case prev >= 0 && t.tokens[prev].IsPunct(")"):
+			open := t.openParen(prev)
+			if t.isQuery(open) {
+				sources = t.groupSources(open, len(names))
+			} else {
+				// Columns a function returns may come from any of its arguments
+				sources = make([][]Source, len(names))
+				all := t.itemSources(parseItem(t.tokens[open+1 : prev]))
+				for k := range sources {
+					sources[k] = all
+				}
+			}
+		case prev >= 0 && isIdent(t.tokens[prev]):
+			alias := sqlguard.IdentName(tok)
+			for _, rel := range t.relations {
+				if rel.Alias == alias {
+					sources = make([][]Source, len(names))
+					for k := range sources {
+						sources[k] = []Source{{Schema: rel.Schema, Table: rel.Name, Column: "*"}}
+					}
+				}
+			}
+		}
+		for k, name := range names {
+			if k < len(sources) {
+				t.derived[name] = appendSources(t.derived[name], sources[k]...)
+			}
+		}
+	}
+}
+
+// nameList reads the identifiers of a parenthesised, comma-separated list
+// opening at open, or returns nil when it is anything else
+func (t *tracer) nameList(open int) ([]string, int) {
+	end := t.matchParen(open)
+	var names []string
+	for j := open + 1; j < end; j++ {
+		tok := t.tokens[j]
+		if (j-open)%2 == 1 {
+			if !isIdent(tok) || isClauseWord(tok) {
+				return nil, end
+			}
+			names = append(names, sqlguard.IdentName(tok))
+		} else if !tok.IsPunct(",") {
+			return nil, end
+		}
+	}
+	return names, end
+}
+
+// groupSources returns the sources of n columns of the query in the
+// parentheses opening at open. Columns line up by position with the query's
+// list; when they cannot, each takes the sources of the whole list.
+func (t *tracer) groupSources(open, n int) [][]Source {
+	start := open + 1
+	for start < len(t.tokens) && t.tokens[start].IsPunct("(") {
+		start++
+	}
+	if start >= len(t.tokens) || !t.tokens[start].IsKeyword("SELECT") {
+		sources := make([][]Source, n)
+		for k := range sources {
+			sources[k] = t.fallback("*")
+		}
+		return sources
+	}
+	branches := append([][]item{t.selectList(start + 1)}, t.setBranches(start)...)
+	sources := make([][]Source, n)
+	for k := range sources {
+		for _, items := range branches {
+			sources[k] = appendSources(sources[k], t.columnSources(items, n, k, "", false)...)
+		}
+	}
+	return sources
+}
+
+// cteBody returns the index of the parenthesis opening the body of the CTE
+// named at i, as in name AS (...) or name (columns) AS (...), or -1
+func (t *tracer) cteBody(i int) int {
+	j := i + 1
+	if t.punct(j, "(") {
+		j = t.matchParen(j) + 1
+	}
+	return t.cteBodyAfter(j)
+}
+
+// cteBodyAfter returns the index of the parenthesis opening a CTE body when
+// AS [NOT] [MATERIALIZED] ( with a query starts at j, or -1. Window
+// definitions, WINDOW w AS (ORDER BY ...), have no query.
+func (t *tracer) cteBodyAfter(j int) int {
+	if j >= len(t.tokens) || !t.tokens[j].IsKeyword("AS") {
+		return -1
+	}
+	for j++; j < len(t.tokens) && t.tokens[j].IsKeyword("NOT", "MATERIALIZED"); j++ {
+	}
+	if !t.punct(j, "(") || !t.isQuery(j) {
+		return -1
+	}
+	return j
+}
+
+// isQuery reports whether the parentheses opening at open hold a query
+func (t *tracer) isQuery(open int) bool {
+	j := open + 1
+	for j < len(t.tokens) && t.tokens[j].IsPunct("(") {
+		j++
+	}
+	return open >= 0 && j < len(t.tokens) && t.tokens[j].IsKeyword("SELECT", "VALUES", "WITH", "TABLE", "INSERT", "UPDATE", "DELETE")
+}
+
+// openParen returns the index of the parenthesis opening the one closing at
+// close, or -1
+func (t *tracer) openParen(close int) int {
+	for i := close - 1; i >= 0; i-- {
+		if t.tokens[i].IsPunct("(") && t.depth[i] == t.depth[close] {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *tracer) punct(i int, p string) bool {
+	return i < len(t.tokens) && t.tokens[i].IsPunct(p)
+}
+
+// matchParen returns the index of the parenthesis closing the one at open,
+// or the last index when it is unbalanced
+func (t *tracer) matchParen(open int) int {
+	for i := open + 1; i < len(t.tokens); i++ {
+		if t.tokens[i].IsPunct(")") && t.depth[i] == t.depth[open] {
+			return i
+		}
+	}
+	return len(t.tokens) - 1
+}
+
+func isIdent(tok sqlguard.Token) bool {
+	return tok.Type == sqlguard.TokenWord || tok.Type == sqlguard.TokenQuotedIdent
+}
+
+func isClauseWord(tok sqlguard.Token) bool {
+	return tok.Type == sqlguard.TokenWord && clauseWords[tok.Upper]
+}
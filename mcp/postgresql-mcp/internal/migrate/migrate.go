@@ -0,0 +1,195 @@
+// Package migrate reads versioned SQL migration files and compares them with
+// the versions recorded as applied.
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// fileName matches NNN_name.up.sql and NNN_name.down.sql
+var fileName = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_\-]+)\.(up|down)\.sql$`)
+
+// Migration is one version read from the migrations directory
+type Migration struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"`
+	// Down is empty when there is no down file
+	Down string `json:"-"`
+	// Checksum is the SHA-256 of the up file
+	Checksum string `json:"checksum"`
+}
+
+// Applied is a version recorded in the tracking table
+type Applied struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Checksum  string    `json:"checksum"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// State is how a version stands between the directory and the database
+type State string
+
+const (
+	// StatePending has a file but was not applied
+	StatePending State = "pending"
+	// StateApplied was applied from the file as it is now
+	StateApplied State = "applied"
+	// StateChanged was applied, but its up file changed since
+	StateChanged State = "changed"
+	// StateMissing was applied, but its files are gone
+	StateMissing State = "missing"
+)
+
+// Status is one line of the comparison
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	State     State      `json:"state"`
+	Checksum  string     `json:"checksum,omitempty"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	HasDown   bool       `json:"has_down"`
+}
+
+// Load reads the migrations in dir, ordered by version. Files that do not
+// look like migrations are ignored; every version needs an up file.
+func Load(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid version: %w", e.Name(), err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migrations: %w", err)
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("version %d is used by both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+			sum := sha256.Sum256(b)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Checksum == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Compare lines up the migrations with the applied versions, ordered by version
+func Compare(migrations []Migration, applied []Applied) []Status {
+	done := make(map[int64]Applied, len(applied))
+	for _, a := range applied {
+		done[a.Version] = a
+	}
+	var statuses []Status
+	for _, m := range migrations {
+		s := Status{Version: m.Version, Name: m.Name, State: StatePending, Checksum: m.Checksum, HasDown: m.Down != ""}
+		if a, ok := done[m.Version]; ok {
+			at := a.AppliedAt
+			s.AppliedAt = &at
+			s.State = StateApplied
+			if a.Checksum != m.Checksum {
+				s.State = StateChanged
+			}
+			delete(done, m.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, a := range done {
+		at := a.AppliedAt
+		statuses = append(statuses, Status{Version: a.Version, Name: a.Name, State: StateMissing, Checksum: a.Checksum, AppliedAt: &at})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses
+}
+
+// Verify returns an error naming the first applied version whose file
+// changed since it was applied
+func Verify(statuses []Status) error {
+	for _, s := range statuses {
+		if s.State == StateChanged {
+			return fmt.Errorf("migration %d_%s changed after it was applied", s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// Up returns the pending migrations up to and including target, in order;
+// a target of 0 means every pending migration
+func Up(migrations []Migration, statuses []Status, target int64) []Migration {
+	pending := map[int64]bool{}
+	for _, s := range statuses {
+		if s.State == StatePending {
+			pending[s.Version] = true
+		}
+	}
+	var plan []Migration
+	for _, m := range migrations {
+		if pending[m.Version] && (target == 0 || m.Version <= target) {
+			plan = append(plan, m)
+		}
+	}
+	return plan
+}
+
+// Down returns the applied migrations to revert, newest first: those above
+// target when target is set, otherwise the last steps. Applied versions
+// without files cannot be reverted and are returned as an error.
+func Down(migrations []Migration, statuses []Status, steps int, target *int64) ([]Migration, error) {
+	files := make(map[int64]Migration, len(migrations))
+	for _, m := range migrations {
+		files[m.Version] = m
+	}
+	var plan []Migration
+	for i := len(statuses) - 1; i >= 0; i-- {
+		s := statuses[i]
+		if s.State == StatePending {
+			continue
+		}
+		if target != nil && s.Version <= *target || target == nil && len(plan) == steps {
+			break
+		}
+		m, ok := files[s.Version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d_%s is applied but its files are missing", s.Version, s.Name)
+		}
+		if m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+		plan = append(plan, m)
+	}
+	return plan, nil
+}
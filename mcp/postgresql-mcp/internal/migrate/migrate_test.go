@@ -0,0 +1,90 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeMigrations(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"002_add_email.up.sql":      "ALTER TABLE users ADD COLUMN email text;",
+		"002_add_email.down.sql":    "ALTER TABLE users DROP COLUMN email;",
+		"001_create_users.up.sql":   "CREATE TABLE users (id int);",
+		"001_create_users.down.sql": "DROP TABLE users;",
+		"010_backfill.up.sql":       "UPDATE users SET email = '';",
+		"README.md":                 "not a migration",
+	})
+	migrations, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 || migrations[0].Name != "create_users" || migrations[2].Version != 10 {
+		t.Fatalf("unexpected migrations: %+v", migrations)
+	}
+	if migrations[0].Down != "DROP TABLE users;" || migrations[2].Down != "" || len(migrations[0].Checksum) != 64 {
+		t.Errorf("unexpected files: %+v", migrations)
+	}
+
+	if _, err := Load(writeMigrations(t, map[string]string{"001_a.down.sql": "SELECT 1"})); err == nil {
+		t.Errorf("expected an error for a version without an up file")
+	}
+	if _, err := Load(writeMigrations(t, map[string]string{"001_a.up.sql": "SELECT 1", "001_b.up.sql": "SELECT 1"})); err == nil {
+		t.Errorf("expected an error for a version used twice")
+	}
+}
+
+func TestCompareAndPlan(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "create_users", Up: "a", Down: "x", Checksum: "c1"},
+		{Version: 2, Name: "add_email", Up: "b", Down: "y", Checksum: "c2"},
+		{Version: 3, Name: "add_index", Up: "c", Checksum: "c3"},
+		{Version: 4, Name: "backfill", Up: "d", Checksum: "c4"},
+	}
+	now := time.Now()
+	applied := []Applied{{Version: 1, Checksum: "c1", AppliedAt: now}, {Version: 2, Checksum: "c2", AppliedAt: now}}
+
+	statuses := Compare(migrations, applied)
+	if statuses[1].State != StateApplied || statuses[2].State != StatePending || Verify(statuses) != nil {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+	if plan := Up(migrations, statuses, 3); len(plan) != 1 || plan[0].Version != 3 {
+		t.Errorf("expected to apply up to 3: %+v", plan)
+	}
+	if plan := Up(migrations, statuses, 0); len(plan) != 2 {
+		t.Errorf("expected every pending migration: %+v", plan)
+	}
+
+	plan, err := Down(migrations, statuses, 2, nil)
+	if err != nil || len(plan) != 2 || plan[0].Version != 2 || plan[1].Version != 1 {
+		t.Errorf("expected to revert 2 then 1: %+v %v", plan, err)
+	}
+	target := int64(1)
+	if plan, err := Down(migrations, statuses, 0, &target); err != nil || len(plan) != 1 || plan[0].Version != 2 {
+		t.Errorf("expected to revert down to 1: %+v %v", plan, err)
+	}
+
+	applied = append(applied, Applied{Version: 3, Checksum: "c3", AppliedAt: now})
+	if _, err := Down(migrations, Compare(migrations, applied), 1, nil); err == nil {
+		t.Errorf("expected an error for a migration without a down file")
+	}
+
+	applied[0].Checksum = "edited"
+	applied = append(applied, Applied{Version: 7, Name: "gone", Checksum: "c7", AppliedAt: now})
+	statuses = Compare(migrations, applied)
+	if statuses[0].State != StateChanged || statuses[len(statuses)-1].State != StateMissing || Verify(statuses) == nil {
+		t.Errorf("expected a changed and a missing migration: %+v", statuses)
+	}
+}
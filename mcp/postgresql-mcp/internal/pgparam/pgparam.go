@@ -0,0 +1,427 @@
+// Package pgparam validates and converts JSON tool arguments into values that
+// bind reliably as PostgreSQL statement parameters.
+//
+// A parameter is either a plain JSON value or a typed parameter of the form
+// {"value": ..., "type": "int[]"}. Plain values are bound as they are, except
+// that whole numbers become integers, objects become JSON text and arrays
+// become array literals. Typed values are checked against the declared type
+// and converted before binding.
+package pgparam
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error reports a parameter that could not be converted
+type Error struct {
+	// Param names the parameter, e.g. "$2" or "data.email"
+	Param  string `json:"param"`
+	Type   string `json:"type,omitempty"`
+	Reason string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Param, e.Type, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s", e.Param, e.Reason)
+}
+
+// Errors collects the failures of every parameter of one call
+type Errors []*Error
+
+func (es Errors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Converter converts the parameters of one call and collects every failure,
+// so that all bad parameters are reported at once
+type Converter struct {
+	errs Errors
+}
+
+// Convert converts p, recording a failure under name, e.g. "$2" or "data.email"
+func (c *Converter) Convert(name string, p interface{}) interface{} {
+	v, err := Convert(name, p)
+	if err != nil {
+		c.errs = append(c.errs, err.(*Error))
+	}
+	return v
+}
+
+// Err returns the collected failures as Errors, or nil
+func (c *Converter) Err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs
+}
+
+// ConvertAll converts positional parameters; failures are named $1, $2, ...
+func ConvertAll(params []interface{}) ([]interface{}, error) {
+	var c Converter
+	out := make([]interface{}, len(params))
+	for i, p := range params {
+		out[i] = c.Convert(fmt.Sprintf("$%d", i+1), p)
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Convert converts one parameter; name labels the error, which is an *Error
+func Convert(name string, p interface{}) (interface{}, error) {
+	if obj, ok := p.(map[string]interface{}); ok {
+		if typeName, ok := obj["type"].(string); ok {
+			if value, ok := obj["value"]; ok && len(obj) == 2 {
+				t, err := parseType(typeName)
+				if err != nil {
+					return nil, &Error{Param: name, Type: typeName, Reason: err.Error()}
+				}
+				v, err := t.convert(value)
+				if err != nil {
+					return nil, &Error{Param: name, Type: typeName, Reason: err.Error()}
+				}
+				return v, nil
+			}
+		}
+	}
+	v, err := untyped(p)
+	if err != nil {
+		return nil, &Error{Param: name, Reason: err.Error()}
+	}
+	return v, nil
+}
+
+// untyped binds a plain JSON value
+func untyped(p interface{}) (interface{}, error) {
+	switch x := p.(type) {
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return int64(x), nil
+		}
+		return x, nil
+	case map[string]interface{}:
+		return jsonText(x)
+	case []interface{}:
+		return arrayLiteral(x, func(v interface{}) (interface{}, error) { return untyped(v) })
+	}
+	return p, nil
+}
+
+// pgType is a declared parameter type
+type pgType struct {
+	name  string
+	array bool
+}
+
+var typeAliases = map[string]string{
+	"smallint": "int2", "int2": "int2",
+	"integer": "int4", "int": "int4", "int4": "int4",
+	"bigint": "int8", "int8": "int8",
+	"real": "float4", "float4": "float4",
+	"double precision": "float8", "float": "float8", "float8": "float8",
+	"numeric": "numeric", "decimal": "numeric",
+	"text": "text", "varchar": "text", "character varying": "text", "char": "text", "character": "text", "bpchar": "text", "name": "text", "citext": "text",
+	"boolean": "bool", "bool": "bool",
+	"json": "json", "jsonb": "json",
+	"uuid": "uuid",
+	"date": "date",
+	"time": "time", "time without time zone": "time",
+	"timestamp": "timestamp", "timestamp without time zone": "timestamp",
+	"timestamptz": "timestamptz", "timestamp with time zone": "timestamptz",
+	"interval": "interval", "bytea": "bytea",
+}
+
+// Supports reports whether typeName can be given as the type of a typed value
+func Supports(typeName string) bool {
+	_, err := parseType(typeName)
+	return err == nil
+}
+
+// parseType resolves a type name such as "int[]", "_int4" or "timestamp with time zone"
+func parseType(s string) (pgType, error) {
+	name := strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	var t pgType
+	switch {
+	case strings.HasSuffix(name, "[]"):
+		t.array = true
+		name = strings.TrimSpace(strings.TrimRight(name, "[]"))
+	case strings.HasPrefix(name, "_"):
+		t.array = true
+		name = name[1:]
+	}
+	// Type modifiers such as varchar(20) or numeric(10,2) do not affect binding
+	if i := strings.Index(name, "("); i > 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	canonical, ok := typeAliases[name]
+	if !ok {
+		return t, fmt.Errorf("unsupported type %q", s)
+	}
+	t.name = canonical
+	return t, nil
+}
+
+func (t pgType) convert(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if !t.array {
+		return convertScalar(t.name, v)
+	}
+	elems, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %s", describe(v))
+	}
+	return arrayLiteral(elems, func(e interface{}) (interface{}, error) { return convertScalar(t.name, e) })
+}
+
+var (
+	uuidRe    = regexp.MustCompile(`^(?i)\{?[0-9a-f]{8}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{12}\}?$`)
+	numericRe = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+)
+
+var intRanges = map[string][2]int64{
+	"int2": {math.MinInt16, math.MaxInt16},
+	"int4": {math.MinInt32, math.MaxInt32},
+	"int8": {math.MinInt64, math.MaxInt64},
+}
+
+// convertScalar converts a non-null value to the Go value bound for typeName
+func convertScalar(typeName string, v interface{}) (interface{}, error) {
+	switch typeName {
+	case "int2", "int4", "int8":
+		var n int64
+		switch x := v.(type) {
+		case float64:
+			if x != math.Trunc(x) || math.Abs(x) >= 1<<63 {
+				return nil, fmt.Errorf("%v is not an integer", x)
+			}
+			n = int64(x)
+		case string:
+			var err error
+			if n, err = strconv.ParseInt(strings.TrimSpace(x), 10, 64); err != nil {
+				return nil, fmt.Errorf("%q is not an integer", x)
+			}
+		default:
+			return nil, fmt.Errorf("expected an integer, got %s", describe(v))
+		}
+		r := intRanges[typeName]
+		if n < r[0] || n > r[1] {
+			return nil, fmt.Errorf("%d is out of range for %s", n, typeName)
+		}
+		return n, nil
+
+	case "float4", "float8":
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case string:
+			switch s := strings.TrimSpace(x); strings.ToLower(s) {
+			case "nan", "infinity", "-infinity":
+				return s, nil
+			default:
+				f, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%q is not a number", x)
+				}
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("expected a number, got %s", describe(v))
+
+	case "numeric":
+		switch x := v.(type) {
+		case float64:
+			// Already rounded by JSON decoding; pass a string for exact values
+			return strconv.FormatFloat(x, 'f', -1, 64), nil
+		case string:
+			s := strings.TrimSpace(x)
+			if !numericRe.MatchString(s) && !strings.EqualFold(s, "NaN") {
+				return nil, fmt.Errorf("%q is not a decimal number", x)
+			}
+			return s, nil
+		}
+		return nil, fmt.Errorf("expected a decimal string or number, got %s", describe(v))
+
+	case "text":
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case float64:
+			return strconv.FormatFloat(x, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(x), nil
+		}
+		return nil, fmt.Errorf("expected a string, got %s", describe(v))
+
+	case "bool":
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(x)) {
+			case "true", "t", "yes", "y", "on", "1":
+				return true, nil
+			case "false", "f", "no", "n", "off", "0":
+				return false, nil
+			}
+			return nil, fmt.Errorf("%q is not a boolean", x)
+		}
+		return nil, fmt.Errorf("expected a boolean, got %s", describe(v))
+
+	case "json":
+		// The value is the document itself; a string becomes a JSON string
+		return jsonText(v)
+
+	case "uuid":
+		s, ok := v.(string)
+		if !ok || !uuidRe.MatchString(s) {
+			return nil, fmt.Errorf("expected a UUID string, got %s", describe(v))
+		}
+		return strings.ToLower(s), nil
+
+	case "date":
+		return timeText(v, "2006-01-02")
+	case "time":
+		return timeText(v, "15:04:05.999999999", "15:04")
+	case "timestamp", "timestamptz":
+		return timeText(v, time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999", "2006-01-02")
+
+	case "interval":
+		s, ok := v.(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			return nil, fmt.Errorf("expected an interval string such as \"1 day 02:00:00\" or \"P1DT2H\", got %s", describe(v))
+		}
+		return s, nil
+
+	case "bytea":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a base64 string, got %s", describe(v))
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %s", err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported type %q", typeName)
+}
+
+// timeText checks s against layouts and binds it as text for the server to parse
+func timeText(v interface{}, layouts ...string) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string, got %s", describe(v))
+	}
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return s, nil
+		}
+	}
+	switch strings.ToLower(s) {
+	case "infinity", "-infinity", "now", "today", "allballs":
+		return s, nil
+	}
+	return nil, fmt.Errorf("%q does not match %s", s, layouts[0])
+}
+
+func jsonText(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// arrayLiteral converts each element with conv and writes a PostgreSQL array
+// literal; nested arrays become additional dimensions
+func arrayLiteral(elems []interface{}, conv func(interface{}) (interface{}, error)) (string, error) {
+	var b strings.Builder
+	if err := writeArray(&b, elems, conv, ""); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeArray(b *strings.Builder, elems []interface{}, conv func(interface{}) (interface{}, error), path string) error {
+	b.WriteByte('{')
+	for i, e := range elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		at := fmt.Sprintf("%s[%d]", path, i)
+		if nested, ok := e.([]interface{}); ok {
+			if err := writeArray(b, nested, conv, at); err != nil {
+				return err
+			}
+			continue
+		}
+		if e == nil {
+			b.WriteString("NULL")
+			continue
+		}
+		v, err := conv(e)
+		if err != nil {
+			return fmt.Errorf("element %s: %s", at, err)
+		}
+		b.WriteString(quoteElement(v))
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+// quoteElement writes an array element as a double-quoted string
+func quoteElement(v interface{}) string {
+	var s string
+	switch x := v.(type) {
+	case string:
+		s = x
+	case []byte:
+		s = `\x` + fmt.Sprintf("%x", x)
+	case int64:
+		s = strconv.FormatInt(x, 10)
+	case float64:
+		s = strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		s = strconv.FormatBool(x)
+	default:
+		s = fmt.Sprint(x)
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// describe names the JSON type of v for error messages
+func describe(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string %q", x)
+	case float64:
+		return fmt.Sprintf("number %v", x)
+	case bool:
+		return fmt.Sprintf("boolean %v", x)
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
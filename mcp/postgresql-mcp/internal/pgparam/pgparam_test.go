@@ -0,0 +1,94 @@
+package pgparam
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func typed(value interface{}, typ string) map[string]interface{} {
+	return map[string]interface{}{"value": value, "type": typ}
+}
+
+func TestConvertTyped(t *testing.T) {
+	for _, tc := range []struct {
+		param interface{}
+		want  interface{}
+	}{
+		{typed([]interface{}{float64(1), "2"}, "int[]"), `{"1","2"}`},
+		{typed([]interface{}{[]interface{}{"a", nil}, []interface{}{`q"u\o`, "b"}}, "text[]"), `{{"a",NULL},{"q\"u\\o","b"}}`},
+		{typed(float64(42), "bigint"), int64(42)},
+		{typed("123456789012345678901234.5", "numeric(30,1)"), "123456789012345678901234.5"},
+		{typed("yes", "boolean"), true},
+		{typed(map[string]interface{}{"a": []interface{}{float64(1)}}, "jsonb"), `{"a":[1]}`},
+		{typed("plain", "json"), `"plain"`},
+		{typed(nil, "int4"), nil},
+		{typed("3q2+7w==", "bytea"), []byte{0xde, 0xad, 0xbe, 0xef}},
+		{typed("A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11", "uuid"), "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{typed("2025-03-01T09:30:00+09:00", "timestamp with time zone"), "2025-03-01T09:30:00+09:00"},
+		{typed("2025-03-01", "date"), "2025-03-01"},
+		{typed([]interface{}{map[string]interface{}{"k": "v"}}, "_jsonb"), `{"{\"k\":\"v\"}"}`},
+	} {
+		got, err := Convert("$1", tc.param)
+		if err != nil {
+			t.Errorf("Convert(%v) failed: %v", tc.param, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Convert(%v) = %#v, want %#v", tc.param, got, tc.want)
+		}
+	}
+}
+
+func TestConvertUntyped(t *testing.T) {
+	got, err := ConvertAll([]interface{}{float64(3), 2.5, "x", true, nil, map[string]interface{}{"a": "b"}, []interface{}{float64(1), "two"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []interface{}{int64(3), 2.5, "x", true, nil, `{"a":"b"}`, `{"1","two"}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	// An object with other keys is a JSON document, not a typed parameter
+	doc := map[string]interface{}{"type": "text", "value": "v", "extra": true}
+	if v, err := Convert("$1", doc); err != nil || v != `{"extra":true,"type":"text","value":"v"}` {
+		t.Errorf("expected a JSON document, got %#v, %v", v, err)
+	}
+}
+
+func TestConvertAllReportsEveryParameter(t *testing.T) {
+	_, err := ConvertAll([]interface{}{
+		typed("abc", "int"),
+		"ok",
+		typed(float64(70000), "smallint"),
+		typed([]interface{}{float64(1), "x"}, "int[]"),
+		typed("v", "money"),
+		typed("2025-13-01", "date"),
+	})
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	var params []string
+	for _, e := range errs {
+		params = append(params, e.Param)
+	}
+	if got := strings.Join(params, ","); got != "$1,$3,$4,$5,$6" {
+		t.Errorf("unexpected failing params %s: %v", got, err)
+	}
+	for _, want := range []string{`"abc" is not an integer`, "70000 is out of range for int2", `element [1]: "x" is not an integer`, `unsupported type "money"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %q", want, err.Error())
+		}
+	}
+}
+
+func TestSupports(t *testing.T) {
+	for name, want := range map[string]bool{"int4": true, "_text": true, "timestamp with time zone": true, "money": false} {
+		if got := Supports(name); got != want {
+			t.Errorf("Supports(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package pgvalue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseArray parses the text form of an array, e.g. {1,2} or
+// [0:1]={{"a b",NULL},{c,d}}, into nested slices of decoded elements
+func parseArray(s, elemType string) ([]interface{}, error) {
+	// Arrays with non-default bounds are prefixed with their dimensions
+	if strings.HasPrefix(s, "[") {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed array dimensions: %q", s)
+		}
+		s = s[i+1:]
+	}
+	p := &arrayParser{s: s, elemType: elemType}
+	elems, err := p.array()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("unexpected %q after array", p.s[p.pos:])
+	}
+	return elems, nil
+}
+
+type arrayParser struct {
+	s        string
+	pos      int
+	elemType string
+}
+
+func (p *arrayParser) array() ([]interface{}, error) {
+	if p.pos >= len(p.s) || p.s[p.pos] != '{' {
+		return nil, fmt.Errorf("expected '{' at offset %d", p.pos)
+	}
+	p.pos++
+	elems := []interface{}{}
+	if p.pos < len(p.s) && p.s[p.pos] == '}' {
+		p.pos++
+		return elems, nil
+	}
+	for {
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		var elem interface{}
+		var err error
+		switch p.s[p.pos] {
+		case '{':
+			elem, err = p.array()
+		case '"':
+			var text string
+			text, err = p.quoted()
+			elem = decodeElement(p.elemType, text)
+		default:
+			elem = p.unquoted()
+		}
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return elems, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", p.s[p.pos], p.pos)
+		}
+	}
+}
+
+// quoted reads a double-quoted element, resolving backslash escapes
+func (p *arrayParser) quoted() (string, error) {
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch c := p.s[p.pos]; c {
+		case '\\':
+			p.pos++
+			if p.pos < len(p.s) {
+				b.WriteByte(p.s[p.pos])
+			}
+		case '"':
+			p.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted element")
+}
+
+// unquoted reads a bare element; NULL in any case is SQL NULL
+func (p *arrayParser) unquoted() interface{} {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != ',' && p.s[p.pos] != '}' {
+		p.pos++
+	}
+	text := strings.TrimSpace(p.s[start:p.pos])
+	if strings.EqualFold(text, "NULL") {
+		return nil
+	}
+	return decodeElement(p.elemType, text)
+}
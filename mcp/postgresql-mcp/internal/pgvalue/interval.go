@@ -0,0 +1,99 @@
+package pgvalue
+
+import (
+	"strconv"
+	"strings"
+)
+
+// isoInterval converts an interval in the default "postgres" IntervalStyle,
+// e.g. "1 year 2 mons -3 days +04:05:06.5", to ISO 8601 ("P1Y2M-3DT4H5M6.5S").
+// ok is false for text in any other style.
+func isoInterval(s string) (string, bool) {
+	var date, clock strings.Builder
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			if clock.Len() > 0 || !isoClock(f, &clock) {
+				return "", false
+			}
+			continue
+		}
+		if i+1 >= len(fields) {
+			return "", false
+		}
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return "", false
+		}
+		var designator string
+		switch strings.TrimSuffix(fields[i+1], "s") {
+		case "year":
+			designator = "Y"
+		case "mon":
+			designator = "M"
+		case "day":
+			designator = "D"
+		default:
+			return "", false
+		}
+		i++
+		if n != 0 {
+			date.WriteString(strconv.FormatInt(n, 10) + designator)
+		}
+	}
+	if date.Len() == 0 && clock.Len() == 0 {
+		return "PT0S", true
+	}
+	if clock.Len() > 0 {
+		return "P" + date.String() + "T" + clock.String(), true
+	}
+	return "P" + date.String(), true
+}
+
+// isoClock appends the H, M and S parts of [+-]HH:MM:SS[.ffffff]
+func isoClock(f string, b *strings.Builder) bool {
+	sign := ""
+	switch {
+	case strings.HasPrefix(f, "-"):
+		sign, f = "-", f[1:]
+	case strings.HasPrefix(f, "+"):
+		f = f[1:]
+	}
+	parts := strings.Split(f, ":")
+	if len(parts) != 3 {
+		return false
+	}
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return false
+	}
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	whole, frac, _ := strings.Cut(parts[2], ".")
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return false
+	}
+	if _, err := strconv.ParseUint("0"+frac, 10, 64); err != nil {
+		return false
+	}
+	frac = strings.TrimRight(frac, "0")
+
+	if hours != 0 {
+		b.WriteString(sign + strconv.FormatInt(hours, 10) + "H")
+	}
+	if minutes != 0 {
+		b.WriteString(sign + strconv.FormatInt(minutes, 10) + "M")
+	}
+	if seconds != 0 || frac != "" {
+		b.WriteString(sign + strconv.FormatInt(seconds, 10))
+		if frac != "" {
+			b.WriteString("." + frac)
+		}
+		b.WriteString("S")
+	}
+	return true
+}
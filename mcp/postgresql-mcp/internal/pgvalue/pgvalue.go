@@ -0,0 +1,158 @@
+// Package pgvalue converts values scanned through lib/pq into JSON-faithful Go
+// values, driven by the column's database type name.
+package pgvalue
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxNumericPrecision is the largest precision PostgreSQL accepts for
+// numeric(p,s); lib/pq reports garbage for unconstrained numeric columns
+const maxNumericPrecision = 1000
+
+// Column describes one result column
+type Column struct {
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Length    *int64 `json:"length,omitempty"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+}
+
+// Describe returns the metadata of ct. Types the driver does not know, such as
+// enums, composites and extension types, are reported as "user-defined".
+func Describe(ct *sql.ColumnType) Column {
+	col := Column{Name: ct.Name(), Type: TypeName(ct.DatabaseTypeName())}
+	if length, ok := ct.Length(); ok && length > 0 && length < math.MaxInt64 {
+		col.Length = &length
+	}
+	if precision, scale, ok := ct.DecimalSize(); ok && precision > 0 && precision <= maxNumericPrecision {
+		col.Precision = &precision
+		col.Scale = &scale
+	}
+	return col
+}
+
+// TypeName turns a driver type name such as "_INT4" into "int4[]"
+func TypeName(dbType string) string {
+	if dbType == "" {
+		return "user-defined"
+	}
+	name := strings.ToLower(dbType)
+	if strings.HasPrefix(name, "_") {
+		return name[1:] + "[]"
+	}
+	return name
+}
+
+// Decode converts v, as scanned for a column of dbType, into a value that
+// encodes to JSON without losing meaning:
+//   - numeric and money stay decimal strings
+//   - json and jsonb become json.RawMessage
+//   - arrays become []interface{} with decoded elements
+//   - bytea becomes base64
+//   - timestamps stay time.Time (RFC 3339 with offset); date and time become
+//     their ISO 8601 text
+//   - interval becomes an ISO 8601 duration
+//   - NaN and infinite floats become strings
+//
+// Anything else that arrives as bytes, including uuid and enum labels, becomes
+// a string.
+func Decode(dbType string, v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if dbType == "BYTEA" {
+			return base64.StdEncoding.EncodeToString(x)
+		}
+		return decodeText(dbType, string(x))
+	case string:
+		return decodeText(dbType, x)
+	case float64:
+		return finite(x)
+	case time.Time:
+		switch dbType {
+		case "DATE":
+			return x.Format("2006-01-02")
+		case "TIME":
+			return x.Format("15:04:05.999999")
+		case "TIMETZ":
+			return x.Format("15:04:05.999999Z07:00")
+		}
+		return x
+	}
+	return v
+}
+
+// decodeText converts the text form of a value
+func decodeText(dbType, s string) interface{} {
+	if strings.HasPrefix(dbType, "_") {
+		if elems, err := parseArray(s, dbType[1:]); err == nil {
+			return elems
+		}
+		return s
+	}
+	switch dbType {
+	case "JSON", "JSONB":
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	case "INTERVAL":
+		if d, ok := isoInterval(s); ok {
+			return d
+		}
+	}
+	return s
+}
+
+// decodeElement converts one array element from its text form
+func decodeElement(elemType, s string) interface{} {
+	switch elemType {
+	case "INT2", "INT4", "INT8", "OID":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case "FLOAT4", "FLOAT8":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return finite(f)
+		}
+	case "BOOL":
+		return s == "t"
+	case "BYTEA":
+		if b, err := hex.DecodeString(strings.TrimPrefix(s, `\x`)); err == nil {
+			return base64.StdEncoding.EncodeToString(b)
+		}
+	case "TIMESTAMPTZ":
+		for _, layout := range []string{"2006-01-02 15:04:05.999999999Z07", "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999Z07:00:00"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t
+			}
+		}
+	case "TIMESTAMP":
+		if t, err := time.Parse("2006-01-02 15:04:05.999999999", s); err == nil {
+			return t
+		}
+	}
+	return decodeText(elemType, s)
+}
+
+// finite keeps f as a number unless JSON cannot represent it
+func finite(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
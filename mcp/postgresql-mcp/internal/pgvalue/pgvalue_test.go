@@ -0,0 +1,92 @@
+package pgvalue
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func encode(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal %#v: %v", v, err)
+	}
+	return string(b)
+}
+
+func TestDecode(t *testing.T) {
+	ts := time.Date(2025, 3, 1, 9, 30, 0, 0, time.FixedZone("", 9*3600))
+	for _, tc := range []struct {
+		dbType string
+		in     interface{}
+		want   string
+	}{
+		{"NUMERIC", []byte("1299.990000000000000000001"), `"1299.990000000000000000001"`},
+		{"JSONB", []byte(`{"tags": ["a", "b"], "n": 1}`), `{"tags":["a","b"],"n":1}`},
+		{"JSON", []byte(`not json`), `"not json"`},
+		{"BYTEA", []byte{0xde, 0xad, 0xbe, 0xef}, `"3q2+7w=="`},
+		{"TIMESTAMPTZ", ts, `"2025-03-01T09:30:00+09:00"`},
+		{"DATE", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), `"2025-03-01"`},
+		{"TIME", time.Date(0, 1, 1, 13, 4, 5, 250000000, time.UTC), `"13:04:05.25"`},
+		{"UUID", []byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), `"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"`},
+		{"INTERVAL", []byte("1 year 2 mons -3 days +04:05:06.500"), `"P1Y2M-3DT4H5M6.5S"`},
+		{"", []byte("active"), `"active"`},
+		{"FLOAT8", math.NaN(), `"NaN"`},
+		{"INT8", int64(42), `42`},
+		{"TEXT", nil, `null`},
+	} {
+		if got := encode(t, Decode(tc.dbType, tc.in)); got != tc.want {
+			t.Errorf("Decode(%q, %v) = %s, want %s", tc.dbType, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeArrays(t *testing.T) {
+	for _, tc := range []struct {
+		dbType string
+		in     string
+		want   string
+	}{
+		{"_INT4", "{1,2,NULL}", `[1,2,null]`},
+		{"_TEXT", `{"a b","c\"d",NULL,"NULL",plain}`, `["a b","c\"d",null,"NULL","plain"]`},
+		{"_NUMERIC", "{{1.50,2},{3,4}}", `[["1.50","2"],["3","4"]]`},
+		{"_BOOL", "{t,f}", `[true,false]`},
+		{"_JSONB", `{"{\"a\": 1}","[2]"}`, `[{"a":1},[2]]`},
+		{"_BYTEA", `{"\\xdeadbeef"}`, `["3q2+7w=="]`},
+		{"_TIMESTAMPTZ", `{"2025-03-01 09:30:00+09"}`, `["2025-03-01T09:30:00+09:00"]`},
+		{"_INT4", "[0:1]={7,8}", `[7,8]`},
+		{"_INT4", "{}", `[]`},
+		{"_INT4", "{1,2", `"{1,2"`},
+	} {
+		if got := encode(t, Decode(tc.dbType, []byte(tc.in))); got != tc.want {
+			t.Errorf("Decode(%q, %q) = %s, want %s", tc.dbType, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestIsoInterval(t *testing.T) {
+	for in, want := range map[string]string{
+		"00:00:00":          "PT0S",
+		"3 days":            "P3D",
+		"-1 days +02:00:00": "P-1DT2H",
+		"-00:00:01.25":      "PT-1.25S",
+		"1 mon 00:30:00":    "P1MT30M",
+	} {
+		if got, ok := isoInterval(in); !ok || got != want {
+			t.Errorf("isoInterval(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+	if _, ok := isoInterval("@ 1 day"); ok {
+		t.Errorf("expected postgres_verbose style to be left alone")
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	for in, want := range map[string]string{"_INT4": "int4[]", "NUMERIC": "numeric", "": "user-defined"} {
+		if got := TypeName(in); got != want {
+			t.Errorf("TypeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
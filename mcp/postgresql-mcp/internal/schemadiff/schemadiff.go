@@ -0,0 +1,473 @@
+// Package schemadiff compares two catalog snapshots of a schema and writes
+// the DDL that turns the left one into the right one.
+package schemadiff
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Column is one column of a table
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	// Default is empty when there is none
+	Default string `json:"default,omitempty"`
+	// Identity is "a" for GENERATED ALWAYS, "d" for BY DEFAULT, or empty
+	Identity string `json:"identity,omitempty"`
+	// Generated is the expression of a stored generated column
+	Generated string `json:"generated,omitempty"`
+}
+
+// Constraint is a primary key, unique, check, exclusion or foreign key
+// constraint with its definition from pg_get_constraintdef
+type Constraint struct {
+	Name string `json:"name"`
+	// Type is p, u, c, x or f as in pg_constraint.contype
+	Type       string `json:"type"`
+	Definition string `json:"definition"`
+}
+
+// Index is an index that does not back a constraint
+type Index struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// Table is a table with its columns in order
+type Table struct {
+	Name        string       `json:"name"`
+	Columns     []Column     `json:"columns"`
+	Constraints []Constraint `json:"constraints"`
+	Indexes     []Index      `json:"indexes"`
+}
+
+// View is a view or materialized view
+type View struct {
+	Name         string `json:"name"`
+	Materialized bool   `json:"materialized"`
+	Definition   string `json:"definition"`
+}
+
+// Function is a function or procedure keyed by its signature
+type Function struct {
+	// Signature is the name with its identity arguments, e.g. f(integer, text)
+	Signature  string `json:"signature"`
+	Definition string `json:"definition"`
+}
+
+// Schema is a snapshot of one schema. Definitions are read with the schema
+// first on the search path, so references into it are unqualified and two
+// schemas with different names compare equal.
+type Schema struct {
+	Tables    map[string]*Table
+	Views     map[string]*View
+	Functions map[string]*Function
+}
+
+// NewSchema returns an empty snapshot
+func NewSchema() *Schema {
+	return &Schema{Tables: map[string]*Table{}, Views: map[string]*View{}, Functions: map[string]*Function{}}
+}
+
+// Table returns the table name, adding it when it is new
+func (s *Schema) Table(name string) *Table {
+	t, ok := s.Tables[name]
+	if !ok {
+		t = &Table{Name: name}
+		s.Tables[name] = t
+	}
+	return t
+}
+
+// Action says how the left side has to change
+type Action string
+
+const (
+	// Add is only on the right
+	Add Action = "add"
+	// Drop is only on the left
+	Drop Action = "drop"
+	// Alter is on both sides but differs
+	Alter Action = "alter"
+)
+
+// Change is one difference between the sides
+type Change struct {
+	// Kind is table, column, constraint, index, view or function
+	Kind   string `json:"kind"`
+	Action Action `json:"action"`
+	// Table is set for columns, constraints and indexes
+	Table string `json:"table,omitempty"`
+	Name  string `json:"name"`
+	// Fields lists what differs for an altered column: type, nullable,
+	// default, identity or generated
+	Fields []string    `json:"fields,omitempty"`
+	Left   interface{} `json:"left,omitempty"`
+	Right  interface{} `json:"right,omitempty"`
+}
+
+// Result is the diff with the DDL that migrates left to match right
+type Result struct {
+	Changes []Change `json:"changes"`
+	DDL     []string `json:"ddl"`
+}
+
+// Compare diffs left against right. The DDL runs with schema, the left
+// schema, first on the search path.
+func Compare(left, right *Schema, schema string) *Result {
+	d := &differ{}
+	d.tables(left, right)
+	d.views(left, right)
+	d.functions(left, right)
+
+	r := &Result{Changes: d.changes, DDL: []string{"SET search_path TO " + Ident(schema)}}
+	// Dependents go first and come back last
+	for _, phase := range [][]string{
+		d.dropViews, d.dropForeignKeys, d.dropConstraints, d.dropIndexes, d.dropTables,
+		d.createTables, d.alterColumns, d.createFunctions, d.addConstraints, d.createIndexes,
+		d.addForeignKeys, d.createViews, d.dropFunctions,
+	} {
+		r.DDL = append(r.DDL, phase...)
+	}
+	if len(r.DDL) == 1 {
+		r.DDL = []string{}
+	}
+	if r.Changes == nil {
+		r.Changes = []Change{}
+	}
+	return r
+}
+
+type differ struct {
+	changes []Change
+
+	dropViews, dropForeignKeys, dropConstraints, dropIndexes, dropTables []string
+	createTables, alterColumns, createFunctions, addConstraints          []string
+	createIndexes, addForeignKeys, createViews, dropFunctions            []string
+}
+
+func (d *differ) add(c Change) {
+	d.changes = append(d.changes, c)
+}
+
+func (d *differ) tables(left, right *Schema) {
+	for _, name := range unionKeys(left.Tables, right.Tables) {
+		l, r := left.Tables[name], right.Tables[name]
+		switch {
+		case r == nil:
+			d.add(Change{Kind: "table", Action: Drop, Name: name, Left: l})
+			d.dropTables = append(d.dropTables, "DROP TABLE "+Ident(name))
+			// Its foreign keys go first, so dropped tables that reference each
+			// other can be dropped in any order
+			for _, c := range l.Constraints {
+				if c.Type == "f" {
+					d.dropForeignKeys = append(d.dropForeignKeys, dropConstraint(name, c.Name))
+				}
+			}
+		case l == nil:
+			d.add(Change{Kind: "table", Action: Add, Name: name, Right: r})
+			cols := make([]string, len(r.Columns))
+			for i, c := range r.Columns {
+				cols[i] = "    " + columnDefinition(c)
+			}
+			d.createTables = append(d.createTables, "CREATE TABLE "+Ident(name)+" (\n"+strings.Join(cols, ",\n")+"\n)")
+			for _, c := range r.Constraints {
+				d.addConstraint(name, c)
+			}
+			for _, ix := range r.Indexes {
+				d.createIndexes = append(d.createIndexes, ix.Definition)
+			}
+		default:
+			d.columns(l, r)
+			d.constraints(l, r)
+			d.indexes(l, r)
+		}
+	}
+}
+
+func (d *differ) columns(l, r *Table) {
+	lcols, rcols := map[string]Column{}, map[string]Column{}
+	var names []string
+	for _, c := range l.Columns {
+		lcols[c.Name] = c
+		names = append(names, c.Name)
+	}
+	for _, c := range r.Columns {
+		rcols[c.Name] = c
+		if _, ok := lcols[c.Name]; !ok {
+			names = append(names, c.Name)
+		}
+	}
+	table := Ident(l.Name)
+	for _, name := range names {
+		lc, inLeft := lcols[name]
+		rc, inRight := rcols[name]
+		col := Ident(name)
+		switch {
+		case !inRight:
+			d.add(Change{Kind: "column", Action: Drop, Table: l.Name, Name: name, Left: lc})
+			d.alterColumns = append(d.alterColumns, "ALTER TABLE "+table+" DROP COLUMN "+col)
+		case !inLeft:
+			d.add(Change{Kind: "column", Action: Add, Table: l.Name, Name: name, Right: rc})
+			d.alterColumns = append(d.alterColumns, "ALTER TABLE "+table+" ADD COLUMN "+columnDefinition(rc))
+		default:
+			fields, ddl := alterColumn(table, lc, rc)
+			if len(fields) > 0 {
+				d.add(Change{Kind: "column", Action: Alter, Table: l.Name, Name: name, Fields: fields, Left: lc, Right: rc})
+				d.alterColumns = append(d.alterColumns, ddl...)
+			}
+		}
+	}
+}
+
+// alterColumn returns what differs between two versions of a column and
+// the statements that change l into r. Generated columns cannot be altered
+// in place and are dropped and added again.
+func alterColumn(table string, l, r Column) ([]string, []string) {
+	col := Ident(l.Name)
+	prefix := "ALTER TABLE " + table + " ALTER COLUMN " + col
+	if l.Generated != r.Generated {
+		return []string{"generated"}, []string{
+			"ALTER TABLE " + table + " DROP COLUMN " + col,
+			"ALTER TABLE " + table + " ADD COLUMN " + columnDefinition(r),
+		}
+	}
+	var fields, ddl []string
+	if l.Identity != "" && l.Identity != r.Identity {
+		fields = append(fields, "identity")
+		ddl = append(ddl, prefix+" DROP IDENTITY")
+	}
+	if l.Type != r.Type {
+		fields = append(fields, "type")
+		ddl = append(ddl, prefix+" TYPE "+r.Type+" USING "+col+"::"+r.Type)
+	}
+	if l.Default != r.Default {
+		fields = append(fields, "default")
+		if r.Default == "" {
+			ddl = append(ddl, prefix+" DROP DEFAULT")
+		} else {
+			ddl = append(ddl, prefix+" SET DEFAULT "+r.Default)
+		}
+	}
+	if l.Nullable != r.Nullable {
+		fields = append(fields, "nullable")
+		if r.Nullable {
+			ddl = append(ddl, prefix+" DROP NOT NULL")
+		} else {
+			ddl = append(ddl, prefix+" SET NOT NULL")
+		}
+	}
+	if r.Identity != "" && l.Identity != r.Identity {
+		if l.Identity == "" {
+			fields = append(fields, "identity")
+		}
+		ddl = append(ddl, prefix+" ADD "+identityClause(r.Identity))
+	}
+	return fields, ddl
+}
+
+func (d *differ) constraints(l, r *Table) {
+	lcons, rcons := map[string]Constraint{}, map[string]Constraint{}
+	for _, c := range l.Constraints {
+		lcons[c.Name] = c
+	}
+	for _, c := range r.Constraints {
+		rcons[c.Name] = c
+	}
+	for _, name := range unionKeys(lcons, rcons) {
+		lc, inLeft := lcons[name]
+		rc, inRight := rcons[name]
+		switch {
+		case !inRight:
+			d.add(Change{Kind: "constraint", Action: Drop, Table: l.Name, Name: name, Left: lc})
+			d.dropConstraint(l.Name, lc)
+		case !inLeft:
+			d.add(Change{Kind: "constraint", Action: Add, Table: l.Name, Name: name, Right: rc})
+			d.addConstraint(l.Name, rc)
+		case lc != rc:
+			d.add(Change{Kind: "constraint", Action: Alter, Table: l.Name, Name: name, Left: lc, Right: rc})
+			d.dropConstraint(l.Name, lc)
+			d.addConstraint(l.Name, rc)
+		}
+	}
+}
+
+func (d *differ) dropConstraint(table string, c Constraint) {
+	if c.Type == "f" {
+		d.dropForeignKeys = append(d.dropForeignKeys, dropConstraint(table, c.Name))
+	} else {
+		d.dropConstraints = append(d.dropConstraints, dropConstraint(table, c.Name))
+	}
+}
+
+func (d *differ) addConstraint(table string, c Constraint) {
+	stmt := "ALTER TABLE " + Ident(table) + " ADD CONSTRAINT " + Ident(c.Name) + " " + c.Definition
+	if c.Type == "f" {
+		d.addForeignKeys = append(d.addForeignKeys, stmt)
+	} else {
+		d.addConstraints = append(d.addConstraints, stmt)
+	}
+}
+
+func dropConstraint(table, name string) string {
+	return "ALTER TABLE " + Ident(table) + " DROP CONSTRAINT " + Ident(name)
+}
+
+func (d *differ) indexes(l, r *Table) {
+	lix, rix := map[string]Index{}, map[string]Index{}
+	for _, ix := range l.Indexes {
+		lix[ix.Name] = ix
+	}
+	for _, ix := range r.Indexes {
+		rix[ix.Name] = ix
+	}
+	for _, name := range unionKeys(lix, rix) {
+		li, inLeft := lix[name]
+		ri, inRight := rix[name]
+		switch {
+		case !inRight:
+			d.add(Change{Kind: "index", Action: Drop, Table: l.Name, Name: name, Left: li.Definition})
+			d.dropIndexes = append(d.dropIndexes, "DROP INDEX "+Ident(name))
+		case !inLeft:
+			d.add(Change{Kind: "index", Action: Add, Table: l.Name, Name: name, Right: ri.Definition})
+			d.createIndexes = append(d.createIndexes, ri.Definition)
+		case li.Definition != ri.Definition:
+			d.add(Change{Kind: "index", Action: Alter, Table: l.Name, Name: name, Left: li.Definition, Right: ri.Definition})
+			d.dropIndexes = append(d.dropIndexes, "DROP INDEX "+Ident(name))
+			d.createIndexes = append(d.createIndexes, ri.Definition)
+		}
+	}
+}
+
+func (d *differ) views(left, right *Schema) {
+	for _, name := range unionKeys(left.Views, right.Views) {
+		l, r := left.Views[name], right.Views[name]
+		switch {
+		case r == nil:
+			d.add(Change{Kind: "view", Action: Drop, Name: name, Left: l})
+			d.dropViews = append(d.dropViews, dropView(l))
+		case l == nil:
+			d.add(Change{Kind: "view", Action: Add, Name: name, Right: r})
+			d.createViews = append(d.createViews, createView(r))
+		case *l != *r:
+			d.add(Change{Kind: "view", Action: Alter, Name: name, Left: l, Right: r})
+			d.dropViews = append(d.dropViews, dropView(l))
+			d.createViews = append(d.createViews, createView(r))
+		}
+	}
+}
+
+func dropView(v *View) string {
+	if v.Materialized {
+		return "DROP MATERIALIZED VIEW " + Ident(v.Name)
+	}
+	return "DROP VIEW " + Ident(v.Name)
+}
+
+func createView(v *View) string {
+	kind := "VIEW "
+	if v.Materialized {
+		kind = "MATERIALIZED VIEW "
+	}
+	return "CREATE " + kind + Ident(v.Name) + " AS\n" + strings.TrimSuffix(strings.TrimSpace(v.Definition), ";")
+}
+
+func (d *differ) functions(left, right *Schema) {
+	for _, sig := range unionKeys(left.Functions, right.Functions) {
+		l, r := left.Functions[sig], right.Functions[sig]
+		switch {
+		case r == nil:
+			d.add(Change{Kind: "function", Action: Drop, Name: sig, Left: l.Definition})
+			d.dropFunctions = append(d.dropFunctions, "DROP ROUTINE "+routineName(sig))
+		case l == nil:
+			d.add(Change{Kind: "function", Action: Add, Name: sig, Right: r.Definition})
+			d.createFunctions = append(d.createFunctions, strings.TrimSpace(r.Definition))
+		case l.Definition != r.Definition:
+			d.add(Change{Kind: "function", Action: Alter, Name: sig, Left: l.Definition, Right: r.Definition})
+			d.createFunctions = append(d.createFunctions, strings.TrimSpace(r.Definition))
+		}
+	}
+}
+
+// routineName quotes the name of a signature and keeps its arguments
+func routineName(sig string) string {
+	if i := strings.IndexByte(sig, '('); i > 0 {
+		return Ident(sig[:i]) + sig[i:]
+	}
+	return Ident(sig)
+}
+
+func columnDefinition(c Column) string {
+	def := Ident(c.Name) + " " + c.Type
+	switch {
+	case c.Generated != "":
+		def += " GENERATED ALWAYS AS (" + c.Generated + ") STORED"
+	case c.Identity != "":
+		def += " " + identityClause(c.Identity)
+	case c.Default != "":
+		def += " DEFAULT " + c.Default
+	}
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+func identityClause(identity string) string {
+	if identity == "a" {
+		return "GENERATED ALWAYS AS IDENTITY"
+	}
+	return "GENERATED BY DEFAULT AS IDENTITY"
+}
+
+var plainIdent = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+// Ident quotes name the way PostgreSQL prints it: only when it has to
+func Ident(name string) string {
+	if plainIdent.MatchString(name) && !reserved[name] {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// reserved lists the reserved key words PostgreSQL quotes as identifiers
+var reserved = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true, "array": true, "as": true,
+	"asc": true, "asymmetric": true, "both": true, "case": true, "cast": true, "check": true, "collate": true,
+	"column": true, "constraint": true, "create": true, "current_catalog": true, "current_date": true,
+	"current_role": true, "current_time": true, "current_timestamp": true, "current_user": true,
+	"default": true, "deferrable": true, "desc": true, "distinct": true, "do": true, "else": true,
+	"end": true, "except": true, "false": true, "fetch": true, "for": true, "foreign": true, "from": true,
+	"grant": true, "group": true, "having": true, "in": true, "initially": true, "intersect": true,
+	"into": true, "lateral": true, "leading": true, "limit": true, "localtime": true,
+	"localtimestamp": true, "not": true, "null": true, "offset": true, "on": true, "only": true,
+	"or": true, "order": true, "placing": true, "primary": true, "references": true, "returning": true,
+	"select": true, "session_user": true, "some": true, "symmetric": true, "system_user": true,
+	"table": true, "then": true, "to": true, "trailing": true, "true": true, "union": true,
+	"unique": true, "user": true, "using": true, "variadic": true, "when": true, "where": true,
+	"window": true, "with": true,
+}
+
+// Unqualify removes references to schema from a catalog definition, for the
+// functions like pg_get_indexdef that qualify names even when they are on
+// the search path
+func Unqualify(definition, schema string) string {
+	return strings.ReplaceAll(definition, Ident(schema)+".", "")
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,97 @@
+package schemadiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func sampleSchema() *Schema {
+	s := NewSchema()
+	users := s.Table("users")
+	users.Columns = []Column{
+		{Name: "id", Type: "integer", Identity: "a"},
+		{Name: "email", Type: "character varying(100)", Nullable: true},
+		{Name: "created_at", Type: "timestamp with time zone", Default: "now()"},
+	}
+	users.Constraints = []Constraint{{Name: "users_pkey", Type: "p", Definition: "PRIMARY KEY (id)"}}
+	users.Indexes = []Index{{Name: "users_email", Definition: "CREATE INDEX users_email ON users USING btree (email)"}}
+	orders := s.Table("orders")
+	orders.Columns = []Column{{Name: "id", Type: "integer"}, {Name: "user_id", Type: "integer"}}
+	orders.Constraints = []Constraint{{Name: "orders_user_id_fkey", Type: "f", Definition: "FOREIGN KEY (user_id) REFERENCES users(id)"}}
+	s.Views["active_users"] = &View{Name: "active_users", Definition: " SELECT id FROM users;"}
+	s.Functions["touch()"] = &Function{Signature: "touch()", Definition: "CREATE OR REPLACE FUNCTION touch()\n RETURNS trigger\n LANGUAGE plpgsql\nAS $function$ BEGIN RETURN NEW; END $function$\n"}
+	return s
+}
+
+func TestCompareIdentical(t *testing.T) {
+	r := Compare(sampleSchema(), sampleSchema(), "public")
+	if len(r.Changes) != 0 || len(r.DDL) != 0 {
+		t.Errorf("expected no differences: %+v", r)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	left, right := sampleSchema(), sampleSchema()
+	// right: email is longer and required, a new column and index, orders is gone,
+	// the view changed, a new table references users
+	users := right.Tables["users"]
+	users.Columns[1] = Column{Name: "email", Type: "character varying(255)"}
+	users.Columns = append(users.Columns, Column{Name: "Display Name", Type: "text", Nullable: true})
+	users.Indexes = append(users.Indexes, Index{Name: "users_created_at", Definition: "CREATE INDEX users_created_at ON users USING btree (created_at)"})
+	delete(right.Tables, "orders")
+	right.Views["active_users"] = &View{Name: "active_users", Definition: " SELECT id, email FROM users;"}
+	tags := right.Table("tags")
+	tags.Columns = []Column{{Name: "user_id", Type: "integer"}}
+	tags.Constraints = []Constraint{{Name: "tags_user_id_fkey", Type: "f", Definition: "FOREIGN KEY (user_id) REFERENCES users(id)"}}
+
+	r := Compare(left, right, "app")
+	got := map[string]Change{}
+	for _, c := range r.Changes {
+		got[c.Kind+" "+string(c.Action)+" "+c.Table+"."+c.Name] = c
+	}
+	for _, key := range []string{
+		"table drop .orders", "table add .tags", "column add users.Display Name",
+		"index add users.users_created_at", "view alter .active_users",
+	} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("missing change %s in %+v", key, r.Changes)
+		}
+	}
+	if c := got["column alter users.email"]; strings.Join(c.Fields, ",") != "type,nullable" {
+		t.Errorf("expected email type and nullability to differ: %+v", c)
+	}
+
+	ddl := strings.Join(r.DDL, ";\n")
+	order := []string{
+		"SET search_path TO app",
+		"DROP VIEW active_users",
+		"ALTER TABLE orders DROP CONSTRAINT orders_user_id_fkey",
+		"DROP TABLE orders",
+		"CREATE TABLE tags (\n    user_id integer NOT NULL\n)",
+		"ALTER TABLE users ALTER COLUMN email TYPE character varying(255) USING email::character varying(255)",
+		"ALTER TABLE users ALTER COLUMN email SET NOT NULL",
+		`ALTER TABLE users ADD COLUMN "Display Name" text`,
+		"CREATE INDEX users_created_at ON users USING btree (created_at)",
+		"ALTER TABLE tags ADD CONSTRAINT tags_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id)",
+		"CREATE VIEW active_users AS\nSELECT id, email FROM users",
+	}
+	pos := 0
+	for _, stmt := range order {
+		i := strings.Index(ddl[pos:], stmt)
+		if i < 0 {
+			t.Fatalf("expected %q after position %d in:\n%s", stmt, pos, ddl)
+		}
+		pos += i + len(stmt)
+	}
+}
+
+func TestIdent(t *testing.T) {
+	for in, want := range map[string]string{"users": "users", "Users": `"Users"`, "order": `"order"`, `a"b`: `"a""b"`} {
+		if got := Ident(in); got != want {
+			t.Errorf("Ident(%q) = %s, want %s", in, got, want)
+		}
+	}
+	if got := Unqualify("CREATE INDEX i ON app.users USING btree (id)", "app"); got != "CREATE INDEX i ON users USING btree (id)" {
+		t.Errorf("unexpected Unqualify: %s", got)
+	}
+}
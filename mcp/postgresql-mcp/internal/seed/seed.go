@@ -0,0 +1,419 @@
+// Package seed generates synthetic rows for tables described by the catalog:
+// values follow the column types and names, unique constraints hold within
+// the generated rows, and foreign keys reference generated or existing rows.
+package seed
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// maxAttempts bounds the retries for a row that breaks a unique constraint
+const maxAttempts = 20
+
+// Column is a column of a table to fill
+type Column struct {
+	Name string
+	// Type is the formatted type, e.g. "character varying(255)", and
+	// TypeName the name of the base type, e.g. "varchar"
+	Type     string
+	TypeName string
+	Nullable bool
+	// HasDefault is set when the column has a default expression
+	HasDefault bool
+	// Serial is set for serial and identity columns, which get explicit
+	// values from Start on
+	Serial bool
+	// Generated columns are computed by the database and left out
+	Generated bool
+	Enum      []string
+	// Start, when positive, makes an integer column count up from it
+	Start int64
+}
+
+// ForeignKey references RefColumns of RefTable, a schema.name
+type ForeignKey struct {
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	// Existing holds rows of RefColumns already in the database, used when
+	// RefTable is not generated
+	Existing [][]interface{}
+}
+
+// Table is a table to fill
+type Table struct {
+	// Schema and Name locate the table
+	Schema  string
+	Name    string
+	Columns []Column
+	// Unique lists the column sets of the primary key and unique constraints
+	Unique      [][]string
+	ForeignKeys []ForeignKey
+	// Rows is the number of rows to generate
+	Rows int
+	// Offset numbers generated values after rows already in the table
+	Offset int
+}
+
+// QualifiedName returns schema.name
+func (t *Table) QualifiedName() string {
+	return t.Schema + "." + t.Name
+}
+
+// Options control generation
+type Options struct {
+	Seed int64
+	// Until is the latest generated timestamp; timestamps fall within the
+	// year before it
+	Until time.Time
+}
+
+// Result holds the rows generated for one table
+type Result struct {
+	Table   *Table
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Generate fills tables in foreign key order. Values are nil for NULL,
+// int64, bool, or the text form of the value as a string.
+func Generate(tables []*Table, opts Options) ([]*Result, error) {
+	g := &generator{
+		rng:       rand.New(rand.NewSource(opts.Seed)),
+		until:     opts.Until,
+		generated: map[string]*Result{},
+	}
+	var results []*Result
+	for _, t := range Order(tables) {
+		r, err := g.table(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.QualifiedName(), err)
+		}
+		g.generated[t.QualifiedName()] = r
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Order sorts tables so that every table comes after the tables it references.
+// References to tables outside tables and to the table itself are ignored;
+// tables in a reference cycle keep their relative order.
+func Order(tables []*Table) []*Table {
+	index := make(map[string]int, len(tables))
+	for i, t := range tables {
+		index[t.QualifiedName()] = i
+	}
+	done := make([]bool, len(tables))
+	ready := func(t *Table) bool {
+		for _, fk := range t.ForeignKeys {
+			if j, ok := index[fk.RefTable]; ok && fk.RefTable != t.QualifiedName() && !done[j] {
+				return false
+			}
+		}
+		return true
+	}
+	var ordered []*Table
+	for len(ordered) < len(tables) {
+		progress := false
+		for i, t := range tables {
+			if !done[i] && ready(t) {
+				done[i], progress = true, true
+				ordered = append(ordered, t)
+			}
+		}
+		if !progress {
+			// Break the cycle at the first remaining table
+			for i, t := range tables {
+				if !done[i] {
+					done[i] = true
+					ordered = append(ordered, t)
+					break
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+type generator struct {
+	rng       *rand.Rand
+	until     time.Time
+	generated map[string]*Result
+}
+
+// rowGen generates one row of a table
+type rowGen struct {
+	g      *generator
+	t      *Table
+	r      *Result
+	index  map[string]int
+	fkOf   map[string]int
+	values []interface{}
+	i      int
+}
+
+func (g *generator) table(t *Table) (*Result, error) {
+	r := &Result{Table: t}
+	index := map[string]int{}
+	var unknown []string
+	for _, c := range t.Columns {
+		if c.Generated {
+			continue
+		}
+		if !known(c) {
+			// The database fills what cannot be generated
+			if c.Nullable || c.HasDefault {
+				continue
+			}
+			unknown = append(unknown, fmt.Sprintf("%s (%s)", c.Name, c.Type))
+			continue
+		}
+		index[c.Name] = len(r.Columns)
+		r.Columns = append(r.Columns, c.Name)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("cannot generate values for %s", strings.Join(unknown, ", "))
+	}
+
+	rg := &rowGen{g: g, t: t, r: r, index: index, fkOf: map[string]int{}}
+	for f, fk := range t.ForeignKeys {
+		for _, col := range fk.Columns {
+			if _, ok := index[col]; !ok {
+				return nil, fmt.Errorf("foreign key column %s cannot be generated", col)
+			}
+			rg.fkOf[col] = f
+		}
+	}
+	seen := make([]map[string]bool, len(t.Unique))
+	for u := range seen {
+		seen[u] = map[string]bool{}
+	}
+
+	for i := 0; i < t.Rows; i++ {
+		rg.i = i
+		rg.values = make([]interface{}, len(r.Columns))
+		for f := range t.ForeignKeys {
+			if err := rg.foreignKey(f, 0); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range t.Columns {
+			if _, ok := index[c.Name]; ok {
+				if _, isFK := rg.fkOf[c.Name]; !isFK {
+					rg.values[index[c.Name]] = rg.g.value(t, c, i, 0)
+				}
+			}
+		}
+		// Redraw the columns of a conflicting constraint until no constraint conflicts
+		for attempt := 1; ; attempt++ {
+			conflict := -1
+			for u, cols := range t.Unique {
+				if key, ok := rg.uniqueKey(cols); ok && seen[u][key] {
+					conflict = u
+					break
+				}
+			}
+			if conflict < 0 {
+				break
+			}
+			if attempt == maxAttempts {
+				return nil, fmt.Errorf("could not generate unique values for (%s) after %d rows", strings.Join(t.Unique[conflict], ", "), i)
+			}
+			if err := rg.regenerate(t.Unique[conflict], attempt); err != nil {
+				return nil, err
+			}
+		}
+		for u, cols := range t.Unique {
+			if key, ok := rg.uniqueKey(cols); ok {
+				seen[u][key] = true
+			}
+		}
+		r.Rows = append(r.Rows, rg.values)
+	}
+	return r, nil
+}
+
+// uniqueKey returns the key of cols in the current row; rows with a NULL in
+// cols never conflict
+func (rg *rowGen) uniqueKey(cols []string) (string, bool) {
+	parts := make([]string, len(cols))
+	for i, col := range cols {
+		j, ok := rg.index[col]
+		if !ok || rg.values[j] == nil {
+			return "", false
+		}
+		parts[i] = fmt.Sprint(rg.values[j])
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// regenerate draws new values for cols, and for whole foreign keys they belong to
+func (rg *rowGen) regenerate(cols []string, attempt int) error {
+	for _, col := range cols {
+		if f, ok := rg.fkOf[col]; ok {
+			if err := rg.foreignKey(f, attempt); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, c := range rg.t.Columns {
+			if c.Name == col {
+				rg.values[rg.index[col]] = rg.g.value(rg.t, c, rg.i, attempt)
+			}
+		}
+	}
+	return nil
+}
+
+// foreignKey picks the referenced row of foreign key f: a generated row of
+// the referenced table, an earlier row of the same table for a self
+// reference, or an existing row. Nullable keys without candidates are NULL.
+func (rg *rowGen) foreignKey(f int, attempt int) error {
+	fk := rg.t.ForeignKeys[f]
+	var candidates [][]interface{}
+	if fk.RefTable == rg.t.QualifiedName() {
+		for _, row := range rg.r.Rows {
+			candidates = append(candidates, pick(rg.r.Columns, row, fk.RefColumns))
+		}
+		candidates = append(candidates, fk.Existing...)
+	} else if ref, ok := rg.g.generated[fk.RefTable]; ok {
+		for _, row := range ref.Rows {
+			candidates = append(candidates, pick(ref.Columns, row, fk.RefColumns))
+		}
+	} else {
+		candidates = fk.Existing
+	}
+
+	if len(candidates) == 0 {
+		for _, col := range fk.Columns {
+			if !rg.column(col).Nullable {
+				return fmt.Errorf("no rows of %s to reference from (%s)", fk.RefTable, strings.Join(fk.Columns, ", "))
+			}
+			rg.values[rg.index[col]] = nil
+		}
+		return nil
+	}
+	// Spread references evenly first, then at random
+	n := rg.i + attempt
+	if attempt > 0 || rg.i >= len(candidates) {
+		n = rg.g.rng.Intn(len(candidates))
+	}
+	chosen := candidates[n%len(candidates)]
+	for i, col := range fk.Columns {
+		rg.values[rg.index[col]] = chosen[i]
+	}
+	return nil
+}
+
+func (rg *rowGen) column(name string) Column {
+	for _, c := range rg.t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return Column{}
+}
+
+// pick returns the values of want from row, whose columns are columns
+func pick(columns []string, row []interface{}, want []string) []interface{} {
+	values := make([]interface{}, len(want))
+	for i, w := range want {
+		for j, c := range columns {
+			if c == w {
+				values[i] = row[j]
+			}
+		}
+	}
+	return values
+}
+
+// Serial returns the serial and identity columns among the generated columns of r
+func (r *Result) Serial() []string {
+	var cols []string
+	for _, c := range r.Table.Columns {
+		if c.Serial && containsString(r.Columns, c.Name) {
+			cols = append(cols, c.Name)
+		}
+	}
+	return cols
+}
+
+// Script writes results as a SQL script of multi-row INSERTs of up to
+// batchSize rows in one transaction. Sequences of serial and identity
+// columns are moved past the inserted values.
+func Script(results []*Result, batchSize int) string {
+	var b strings.Builder
+	b.WriteString("BEGIN;\n")
+	for _, r := range results {
+		if len(r.Rows) == 0 {
+			continue
+		}
+		table := QuoteIdent(r.Table.Schema) + "." + QuoteIdent(r.Table.Name)
+		cols := make([]string, len(r.Columns))
+		for i, col := range r.Columns {
+			cols[i] = QuoteIdent(col)
+		}
+		serial := r.Serial()
+		overriding := ""
+		if len(serial) > 0 {
+			overriding = " OVERRIDING SYSTEM VALUE"
+		}
+		for start := 0; start < len(r.Rows); start += batchSize {
+			end := start + batchSize
+			if end > len(r.Rows) {
+				end = len(r.Rows)
+			}
+			fmt.Fprintf(&b, "\nINSERT INTO %s (%s)%s VALUES\n", table, strings.Join(cols, ", "), overriding)
+			for i, row := range r.Rows[start:end] {
+				values := make([]string, len(row))
+				for j, v := range row {
+					values[j] = Literal(v)
+				}
+				sep := ","
+				if start+i == end-1 {
+					sep = ";"
+				}
+				fmt.Fprintf(&b, "  (%s)%s\n", strings.Join(values, ", "), sep)
+			}
+		}
+		for _, col := range serial {
+			fmt.Fprintf(&b, "SELECT setval(pg_get_serial_sequence(%s, %s), (SELECT max(%s) FROM %s));\n",
+				Literal(table), Literal(col), QuoteIdent(col), table)
+		}
+	}
+	b.WriteString("\nCOMMIT;\n")
+	return b.String()
+}
+
+// QuoteIdent double-quotes an identifier
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Literal writes a generated value as a SQL literal
+func Literal(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return fmt.Sprint(x)
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	}
+	return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
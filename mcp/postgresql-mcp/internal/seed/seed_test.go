@@ -0,0 +1,191 @@
+package seed
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shopTables describes users, products, orders and order_items, listed
+// children first
+func shopTables(rows int) []*Table {
+	users := &Table{Schema: "public", Name: "users", Rows: rows, Columns: []Column{
+		{Name: "id", Type: "bigint", TypeName: "int8", Serial: true, HasDefault: true, Start: 1},
+		{Name: "email", Type: "character varying(255)", TypeName: "varchar"},
+		{Name: "name", Type: "text", TypeName: "text"},
+		{Name: "role", Type: "user_role", TypeName: "user_role", Enum: []string{"admin", "member"}},
+		{Name: "manager_id", Type: "bigint", TypeName: "int8", Nullable: true},
+		{Name: "created_at", Type: "timestamp with time zone", TypeName: "timestamptz", HasDefault: true},
+		{Name: "search", Type: "tsvector", TypeName: "tsvector", Nullable: true},
+	}, Unique: [][]string{{"id"}, {"email"}}, ForeignKeys: []ForeignKey{
+		{Columns: []string{"manager_id"}, RefTable: "public.users", RefColumns: []string{"id"}},
+	}}
+	products := &Table{Schema: "public", Name: "products", Rows: rows, Columns: []Column{
+		{Name: "id", Type: "integer", TypeName: "int4", Serial: true, Start: 101},
+		{Name: "name", Type: "character varying(20)", TypeName: "varchar"},
+		{Name: "sku", Type: "text", TypeName: "text"},
+		{Name: "price", Type: "numeric(6,2)", TypeName: "numeric"},
+	}, Unique: [][]string{{"id"}, {"sku"}}}
+	orders := &Table{Schema: "public", Name: "orders", Rows: rows * 2, Columns: []Column{
+		{Name: "id", Type: "integer", TypeName: "int4", Serial: true, Start: 1},
+		{Name: "user_id", Type: "bigint", TypeName: "int8"},
+		{Name: "total", Type: "numeric(10,2)", TypeName: "numeric"},
+	}, Unique: [][]string{{"id"}}, ForeignKeys: []ForeignKey{
+		{Columns: []string{"user_id"}, RefTable: "public.users", RefColumns: []string{"id"}},
+	}}
+	items := &Table{Schema: "public", Name: "order_items", Rows: rows * 3, Columns: []Column{
+		{Name: "order_id", Type: "integer", TypeName: "int4"},
+		{Name: "product_id", Type: "integer", TypeName: "int4"},
+		{Name: "quantity", Type: "integer", TypeName: "int4"},
+	}, Unique: [][]string{{"order_id", "product_id"}}, ForeignKeys: []ForeignKey{
+		{Columns: []string{"order_id"}, RefTable: "public.orders", RefColumns: []string{"id"}},
+		{Columns: []string{"product_id"}, RefTable: "public.products", RefColumns: []string{"id"}},
+	}}
+	return []*Table{items, orders, products, users}
+}
+
+func column(r *Result, name string) []interface{} {
+	var values []interface{}
+	for _, row := range r.Rows {
+		values = append(values, pick(r.Columns, row, []string{name})[0])
+	}
+	return values
+}
+
+func TestGenerate(t *testing.T) {
+	until := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	results, err := Generate(shopTables(10), Options{Seed: 42, Until: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]*Result{}
+	var order []string
+	for _, r := range results {
+		byName[r.Table.Name] = r
+		order = append(order, r.Table.Name)
+	}
+	if want := []string{"products", "users", "orders", "order_items"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+
+	users := byName["users"]
+	if containsString(users.Columns, "search") {
+		t.Errorf("a nullable column of an unknown type should be left to the database: %v", users.Columns)
+	}
+	emailRe := regexp.MustCompile(`^[a-z]+\.[a-z]+\d+@example\.com$`)
+	seen := map[interface{}]bool{}
+	for i, email := range column(users, "email") {
+		if !emailRe.MatchString(email.(string)) || seen[email] {
+			t.Errorf("row %d: bad or repeated email %v", i, email)
+		}
+		seen[email] = true
+	}
+	ids := column(users, "id")
+	if ids[0] != int64(1) || ids[9] != int64(10) {
+		t.Errorf("serial ids should count up from Start: %v", ids)
+	}
+	if managers := column(users, "manager_id"); managers[0] != nil {
+		t.Errorf("the first row has no earlier row to reference: %v", managers)
+	}
+	for _, ts := range column(users, "created_at") {
+		parsed, err := time.Parse("2006-01-02 15:04:05-07", ts.(string))
+		if err != nil || parsed.After(until) || parsed.Before(until.AddDate(-1, 0, 0)) {
+			t.Errorf("timestamp %v out of range: %v", ts, err)
+		}
+	}
+
+	priceRe := regexp.MustCompile(`^\d{1,4}\.\d{2}$`)
+	for _, price := range column(byName["products"], "price") {
+		if !priceRe.MatchString(price.(string)) {
+			t.Errorf("price %v does not fit numeric(6,2)", price)
+		}
+	}
+	for _, name := range column(byName["products"], "name") {
+		if len(name.(string)) > 20 {
+			t.Errorf("name %q is longer than varchar(20)", name)
+		}
+	}
+
+	userIDs := map[interface{}]bool{}
+	for _, id := range ids {
+		userIDs[id] = true
+	}
+	for _, id := range column(byName["orders"], "user_id") {
+		if !userIDs[id] {
+			t.Errorf("order references missing user %v", id)
+		}
+	}
+	pairs := map[string]bool{}
+	items := byName["order_items"]
+	for _, row := range items.Rows {
+		key := fmt.Sprint(row[0], row[1])
+		if pairs[key] {
+			t.Errorf("order item %s repeats the primary key", key)
+		}
+		pairs[key] = true
+		if q := row[2].(int64); q < 1 || q > 100 {
+			t.Errorf("quantity %d out of range", q)
+		}
+	}
+
+	again, err := Generate(shopTables(10), Options{Seed: 42, Until: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range results {
+		if !reflect.DeepEqual(results[i].Rows, again[i].Rows) {
+			t.Errorf("%s: the same seed should generate the same rows", results[i].Table.Name)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	table := &Table{Schema: "public", Name: "shapes", Rows: 1, Columns: []Column{{Name: "area", Type: "polygon", TypeName: "polygon"}}}
+	if _, err := Generate([]*Table{table}, Options{}); err == nil || !strings.Contains(err.Error(), "area (polygon)") {
+		t.Errorf("expected a required column of an unknown type to be refused: %v", err)
+	}
+
+	flags := &Table{Schema: "public", Name: "flags", Rows: 3, Columns: []Column{{Name: "on", Type: "boolean", TypeName: "bool"}}, Unique: [][]string{{"on"}}}
+	if _, err := Generate([]*Table{flags}, Options{}); err == nil || !strings.Contains(err.Error(), "unique") {
+		t.Errorf("expected three unique booleans to be impossible: %v", err)
+	}
+
+	orders := &Table{Schema: "public", Name: "orders", Rows: 1, Columns: []Column{{Name: "user_id", Type: "integer", TypeName: "int4"}},
+		ForeignKeys: []ForeignKey{{Columns: []string{"user_id"}, RefTable: "public.users", RefColumns: []string{"id"}}}}
+	if _, err := Generate([]*Table{orders}, Options{}); err == nil || !strings.Contains(err.Error(), "no rows of public.users") {
+		t.Errorf("expected a required reference without rows to be refused: %v", err)
+	}
+	orders.ForeignKeys[0].Existing = [][]interface{}{{int64(7)}}
+	results, err := Generate([]*Table{orders}, Options{})
+	if err != nil || results[0].Rows[0][0] != int64(7) {
+		t.Errorf("expected the existing user to be referenced: %v %v", results, err)
+	}
+}
+
+func TestScript(t *testing.T) {
+	table := &Table{Schema: "public", Name: "users", Columns: []Column{
+		{Name: "id", TypeName: "int4", Serial: true},
+		{Name: "name", TypeName: "text"},
+	}}
+	script := Script([]*Result{{Table: table, Columns: []string{"id", "name"}, Rows: [][]interface{}{
+		{int64(1), "O'Brien"}, {int64(2), nil}, {int64(3), "Ann"},
+	}}}, 2)
+	want := `BEGIN;
+
+INSERT INTO "public"."users" ("id", "name") OVERRIDING SYSTEM VALUE VALUES
+  (1, 'O''Brien'),
+  (2, NULL);
+
+INSERT INTO "public"."users" ("id", "name") OVERRIDING SYSTEM VALUE VALUES
+  (3, 'Ann');
+SELECT setval(pg_get_serial_sequence('"public"."users"', 'id'), (SELECT max("id") FROM "public"."users"));
+
+COMMIT;
+`
+	if script != want {
+		t.Errorf("unexpected script:\n%s", script)
+	}
+}
@@ -0,0 +1,375 @@
+package seed
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	firstNames = []string{"Olivia", "Liam", "Emma", "Noah", "Ava", "Oliver", "Sophia", "Elijah", "Mia", "Lucas",
+		"Amelia", "Mateo", "Harper", "Kenji", "Aiko", "Ravi", "Priya", "Chen", "Fatima", "Diego"}
+	lastNames = []string{"Smith", "Johnson", "Garcia", "Brown", "Tanaka", "Suzuki", "Miller", "Davis", "Rodriguez", "Martinez",
+		"Kim", "Nguyen", "Patel", "Wilson", "Anderson", "Thomas", "Moore", "Sato", "Lopez", "Clark"}
+	productAdjectives = []string{"Classic", "Ultra", "Compact", "Wireless", "Organic", "Premium", "Smart", "Portable", "Eco", "Deluxe"}
+	productNouns      = []string{"Headphones", "Backpack", "Coffee Maker", "Desk Lamp", "Water Bottle", "Keyboard", "Sneakers",
+		"Notebook", "Blender", "Jacket", "Monitor", "Chair"}
+	categories = []string{"Electronics", "Books", "Home", "Garden", "Toys", "Sports", "Clothing", "Beauty", "Grocery", "Office"}
+	companies  = []string{"Acme", "Globex", "Initech", "Umbrella", "Stark", "Wayne", "Hooli", "Vandelay", "Soylent", "Tyrell"}
+	cities     = []string{"Tokyo", "Osaka", "New York", "London", "Paris", "Berlin", "Toronto", "Sydney", "Seoul", "Madrid"}
+	countries  = []string{"Japan", "United States", "United Kingdom", "France", "Germany", "Canada", "Australia", "South Korea", "Spain", "Brazil"}
+	streets    = []string{"Main St", "Oak Ave", "Maple Rd", "Cedar Ln", "Park Blvd", "Elm St", "Hill Rd", "Lake Dr"}
+	statuses   = []string{"active", "pending", "inactive"}
+	currencies = []string{"USD", "EUR", "JPY", "GBP"}
+	colors     = []string{"red", "blue", "green", "black", "white", "yellow", "purple", "orange"}
+	words      = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do",
+		"eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua", "enim"}
+)
+
+// lengthRe finds the length, or precision and scale, of a formatted type
+var lengthRe = regexp.MustCompile(`\((\d+)(?:,(\d+))?\)`)
+
+// typeClass groups base type names by how their values are generated
+func typeClass(c Column) string {
+	if len(c.Enum) > 0 {
+		return "enum"
+	}
+	switch c.TypeName {
+	case "int2", "int4", "int8":
+		return "int"
+	case "numeric", "float4", "float8", "money":
+		return "decimal"
+	case "bool":
+		return "bool"
+	case "text", "varchar", "bpchar", "citext", "name":
+		return "text"
+	case "date":
+		return "date"
+	case "timestamp", "timestamptz":
+		return "timestamp"
+	case "time", "timetz":
+		return "time"
+	case "interval":
+		return "interval"
+	case "uuid":
+		return "uuid"
+	case "json", "jsonb":
+		return "json"
+	case "bytea":
+		return "bytea"
+	case "inet", "cidr":
+		return "inet"
+	}
+	if strings.HasPrefix(c.TypeName, "_") {
+		return "array"
+	}
+	return ""
+}
+
+// known reports whether values of c can be generated
+func known(c Column) bool {
+	return typeClass(c) != ""
+}
+
+// has reports whether the lower-cased column name contains any of parts
+func has(name string, parts ...string) bool {
+	for _, p := range parts {
+		if strings.Contains(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// word reports whether one of the underscore-separated words of the
+// lower-cased column name is any of words, for names too short to match
+// inside other words
+func word(name string, words ...string) bool {
+	for _, w := range strings.Split(name, "_") {
+		for _, want := range words {
+			if w == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// value generates the value of c in row i of t. Later attempts at a unique
+// value vary it further.
+func (g *generator) value(t *Table, c Column, i, attempt int) interface{} {
+	name := strings.ToLower(c.Name)
+	table := strings.ToLower(t.Name)
+	// n numbers the row among the rows already in the table
+	n := t.Offset + i + 1
+	unique := attempt > 0 || g.isUnique(t, c.Name)
+	if attempt > 0 {
+		n += attempt * (t.Offset + t.Rows)
+	}
+
+	switch typeClass(c) {
+	case "enum":
+		if unique {
+			return c.Enum[(i+attempt)%len(c.Enum)]
+		}
+		return c.Enum[g.rng.Intn(len(c.Enum))]
+	case "int":
+		return g.integer(c, name, i, n, unique)
+	case "decimal":
+		return g.decimal(c, name)
+	case "bool":
+		if has(name, "active", "enabled", "verified", "available", "visible") {
+			return g.rng.Intn(10) < 8
+		}
+		return g.rng.Intn(2) == 0
+	case "date":
+		if has(name, "birth", "dob") {
+			return g.birthDate().Format("2006-01-02")
+		}
+		return g.timestamp().Format("2006-01-02")
+	case "timestamp":
+		ts := g.timestamp()
+		if c.TypeName == "timestamptz" {
+			return ts.Format("2006-01-02 15:04:05-07")
+		}
+		return ts.Format("2006-01-02 15:04:05")
+	case "time":
+		return fmt.Sprintf("%02d:%02d:00", 8+g.rng.Intn(12), g.rng.Intn(4)*15)
+	case "interval":
+		return fmt.Sprintf("%d days", 1+g.rng.Intn(30))
+	case "uuid":
+		b := make([]byte, 16)
+		g.rng.Read(b)
+		b[6], b[8] = b[6]&0x0f|0x40, b[8]&0x3f|0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	case "json":
+		return fmt.Sprintf(`{"source": "seed", "index": %d}`, n)
+	case "bytea":
+		b := make([]byte, 8)
+		g.rng.Read(b)
+		return fmt.Sprintf(`\x%x`, b)
+	case "inet":
+		return fmt.Sprintf("10.%d.%d.%d", n/65536%256, n/256%256, n%256)
+	case "array":
+		return "{}"
+	}
+	return g.text(c, name, table, n, unique)
+}
+
+// isUnique reports whether column is alone in a unique constraint of t
+func (g *generator) isUnique(t *Table, column string) bool {
+	for _, cols := range t.Unique {
+		if len(cols) == 1 && cols[0] == column {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) integer(c Column, name string, i, n int, unique bool) int64 {
+	if c.Start > 0 {
+		return c.Start + int64(i)
+	}
+	if unique {
+		return int64(n)
+	}
+	limit := int64(math.MaxInt32)
+	if c.TypeName == "int2" {
+		limit = math.MaxInt16
+	}
+	var v int64
+	switch {
+	case has(name, "quantity", "count", "stock") || word(name, "qty"):
+		v = 1 + g.rng.Int63n(100)
+	case word(name, "age"):
+		v = 18 + g.rng.Int63n(63)
+	case word(name, "year"):
+		v = int64(g.until.Year()) - g.rng.Int63n(30)
+	case has(name, "rating", "score", "stars"):
+		v = 1 + g.rng.Int63n(5)
+	case has(name, "price", "amount", "cost", "total"):
+		v = 100 + g.rng.Int63n(99900)
+	default:
+		v = 1 + g.rng.Int63n(1000)
+	}
+	if v > limit {
+		v = limit
+	}
+	return v
+}
+
+// decimal draws a value that fits the precision and scale of c
+func (g *generator) decimal(c Column, name string) string {
+	lo, hi := 0.0, 1000.0
+	switch {
+	case has(name, "salary", "income"):
+		lo, hi = 30000, 150000
+	case has(name, "price", "amount", "cost", "total", "fee", "balance", "subtotal"):
+		lo, hi = 1, 1000
+	case has(name, "rating", "score"):
+		lo, hi = 1, 5
+	case has(name, "discount", "ratio", "percent") || word(name, "rate"):
+		lo, hi = 0, 0.5
+	case has(name, "latitude") || word(name, "lat"):
+		lo, hi = -90, 90
+	case has(name, "longitude") || word(name, "lng", "lon"):
+		lo, hi = -180, 180
+	case has(name, "weight"):
+		lo, hi = 0.1, 50
+	}
+	scale := 2
+	if m := lengthRe.FindStringSubmatch(c.Type); m != nil && c.TypeName == "numeric" {
+		precision, _ := strconv.Atoi(m[1])
+		scale, _ = strconv.Atoi(m[2])
+		if max := math.Pow(10, float64(precision-scale)) - math.Pow(10, -float64(scale)); hi > max {
+			hi = max
+			if lo > hi {
+				lo = 0
+			}
+		}
+	}
+	v := lo + g.rng.Float64()*(hi-lo)
+	if hi > 1 && lo >= 1 && scale >= 2 && has(name, "price") {
+		// Prices end in .99 or .00
+		v = math.Floor(v) + []float64{0.99, 0}[g.rng.Intn(2)]
+		if v > hi {
+			v = math.Floor(hi)
+		}
+	}
+	// Cut rather than round, so the value stays within hi
+	unit := math.Pow(10, float64(scale))
+	return strconv.FormatFloat(math.Floor(v*unit)/unit, 'f', scale, 64)
+}
+
+// timestamp draws a time within the year before until
+func (g *generator) timestamp() time.Time {
+	return g.until.Add(-time.Duration(g.rng.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Second).UTC()
+}
+
+func (g *generator) birthDate() time.Time {
+	return g.until.AddDate(-18-g.rng.Intn(60), 0, -g.rng.Intn(365))
+}
+
+func (g *generator) pickWord(list []string) string {
+	return list[g.rng.Intn(len(list))]
+}
+
+func (g *generator) sentence(min, max int) string {
+	n := min + g.rng.Intn(max-min+1)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = g.pickWord(words)
+	}
+	s := strings.Join(parts, " ")
+	return strings.ToUpper(s[:1]) + s[1:] + "."
+}
+
+// text generates a string from the column and table names, cut to the
+// length of the type. Unique values end in n so they stay unique when cut.
+func (g *generator) text(c Column, name, table string, n int, unique bool) string {
+	first, last := g.pickWord(firstNames), g.pickWord(lastNames)
+	var v string
+	numbered := false
+	switch {
+	case has(name, "email", "mail"):
+		v = fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), n)
+		numbered = true
+	case has(name, "first_name", "firstname", "given_name"):
+		v = first
+	case has(name, "last_name", "lastname", "surname", "family_name"):
+		v = last
+	case has(name, "username", "user_name", "login", "handle", "nickname"):
+		v = fmt.Sprintf("%s%s%d", strings.ToLower(first), strings.ToLower(last[:1]), n)
+		numbered = true
+	case has(name, "phone", "mobile") || word(name, "tel", "fax"):
+		v = fmt.Sprintf("+1-555-%03d-%04d", g.rng.Intn(1000), n%10000)
+	case has(name, "website", "homepage") || word(name, "url", "link"):
+		v = fmt.Sprintf("https://example.com/%s/%d", table, n)
+		numbered = true
+	case has(name, "image", "avatar", "photo", "picture", "thumbnail"):
+		v = fmt.Sprintf("https://example.com/images/%s/%d.jpg", table, n)
+		numbered = true
+	case has(name, "barcode") || word(name, "sku", "code"):
+		v = fmt.Sprintf("%s-%06d", strings.ToUpper(abbreviation(table)), n)
+		numbered = true
+	case has(name, "slug"):
+		v = fmt.Sprintf("%s-%d", strings.ToLower(strings.ReplaceAll(g.productName(), " ", "-")), n)
+		numbered = true
+	case has(name, "city"):
+		v = g.pickWord(cities)
+	case has(name, "country"):
+		v = g.pickWord(countries)
+	case has(name, "address", "street"):
+		v = fmt.Sprintf("%d %s", 1+g.rng.Intn(9999), g.pickWord(streets))
+	case has(name, "postal", "postcode") || word(name, "zip"):
+		v = fmt.Sprintf("%05d", g.rng.Intn(100000))
+	case has(name, "currency"):
+		v = g.pickWord(currencies)
+	case has(name, "color", "colour"):
+		v = g.pickWord(colors)
+	case has(name, "status") || word(name, "state"):
+		v = g.pickWord(statuses)
+	case has(name, "password", "secret") || word(name, "hash", "token", "digest"):
+		b := make([]byte, 16)
+		g.rng.Read(b)
+		v = fmt.Sprintf("%x", b)
+	case has(name, "description", "content", "comment", "note", "summary", "message", "review") || word(name, "body", "bio"):
+		v = g.sentence(8, 16)
+	case has(name, "title", "subject", "headline"):
+		v = strings.TrimSuffix(g.sentence(3, 6), ".")
+	case has(name, "company", "organization", "organisation", "vendor", "supplier", "brand"):
+		v = g.pickWord(companies) + " " + []string{"Inc", "Ltd", "Corp", "LLC"}[g.rng.Intn(4)]
+	case has(name, "category", "genre", "department") || word(name, "tag"):
+		v = g.pickWord(categories)
+	case has(name, "name"):
+		switch {
+		case has(table, "product", "item", "goods", "sku", "catalog"):
+			v = g.productName()
+		case has(table, "categor", "tag", "genre", "department"):
+			v = g.pickWord(categories)
+		case has(table, "compan", "organi", "vendor", "supplier", "brand"):
+			v = g.pickWord(companies) + " " + []string{"Inc", "Ltd", "Corp", "LLC"}[g.rng.Intn(4)]
+		default:
+			v = first + " " + last
+		}
+	default:
+		v = g.pickWord(words) + " " + g.pickWord(words)
+	}
+	suffix := ""
+	if unique && !numbered {
+		suffix = fmt.Sprintf(" %d", n)
+	}
+	if m := lengthRe.FindStringSubmatch(c.Type); m != nil && (c.TypeName == "varchar" || c.TypeName == "bpchar") {
+		if limit, _ := strconv.Atoi(m[1]); len(v)+len(suffix) > limit {
+			if len(suffix) >= limit {
+				return strings.TrimSpace(suffix)[:limit]
+			}
+			v = v[:limit-len(suffix)]
+		}
+	}
+	return v + suffix
+}
+
+func (g *generator) productName() string {
+	return g.pickWord(productAdjectives) + " " + g.pickWord(productNouns)
+}
+
+// abbreviation returns the first three letters of a table name, e.g. PRO
+func abbreviation(table string) string {
+	letters := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, table)
+	if len(letters) > 3 {
+		letters = letters[:3]
+	}
+	if letters == "" {
+		letters = "id"
+	}
+	return letters
+}
@@ -0,0 +1,335 @@
+// Package snapshot reads and writes table snapshots: a directory holding a
+// manifest that describes each table and one data file per table, with every
+// value in its PostgreSQL text form.
+package snapshot
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// ManifestFile is the name of the manifest in a snapshot directory
+const ManifestFile = "manifest.json"
+
+// Version is the manifest version written by this package
+const Version = 1
+
+// Data file formats
+const (
+	JSONL = "jsonl"
+	CSV   = "csv"
+)
+
+// Null stands for NULL in CSV files, as in PostgreSQL's text COPY format
+const Null = `\N`
+
+// nameRe matches snapshot and data file names, which must stay inside the
+// snapshot directory
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.\-]*$`)
+
+// ValidName reports whether name can name a snapshot or a data file
+func ValidName(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+// Column is a column whose values the snapshot holds
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	// Serial is set for serial and identity columns, whose sequence is
+	// moved past the restored values
+	Serial bool `json:"serial,omitempty"`
+}
+
+// ForeignKey references another table
+type ForeignKey struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+}
+
+// Table describes one table of a snapshot
+type Table struct {
+	// Table is schema.name
+	Table string `json:"table"`
+	File  string `json:"file"`
+	// Where is the filter the rows were selected with, as given
+	Where       interface{}  `json:"where,omitempty"`
+	Rows        int          `json:"rows"`
+	Masked      bool         `json:"masked,omitempty"`
+	Columns     []Column     `json:"columns"`
+	PrimaryKey  []string     `json:"primary_key"`
+	ForeignKeys []ForeignKey `json:"foreign_keys"`
+}
+
+// ColumnNames returns the names of the columns of t
+func (t *Table) ColumnNames() []string {
+	names := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// Manifest describes a snapshot. Tables are in foreign key order.
+type Manifest struct {
+	Version    int       `json:"version"`
+	Connection string    `json:"connection"`
+	CreatedAt  time.Time `json:"created_at"`
+	Format     string    `json:"format"`
+	Tables     []Table   `json:"tables"`
+}
+
+// Table returns the table named schema.name, or nil
+func (m *Manifest) Table(name string) *Table {
+	for i := range m.Tables {
+		if m.Tables[i].Table == name {
+			return &m.Tables[i]
+		}
+	}
+	return nil
+}
+
+// WriteManifest writes m into dir
+func WriteManifest(dir string, m *Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), append(b, '\n'), 0o600)
+}
+
+// ReadManifest reads and checks the manifest of the snapshot in dir
+func ReadManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", filepath.Join(dir, ManifestFile), err)
+	}
+	if m.Version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", m.Version)
+	}
+	if m.Format != JSONL && m.Format != CSV {
+		return nil, fmt.Errorf("unsupported snapshot format %q", m.Format)
+	}
+	for _, t := range m.Tables {
+		if !ValidName(t.File) {
+			return nil, fmt.Errorf("table %s: invalid file name %q", t.Table, t.File)
+		}
+		if len(t.Columns) == 0 {
+			return nil, fmt.Errorf("table %s: no columns", t.Table)
+		}
+	}
+	return &m, nil
+}
+
+// Order sorts tables so that every table comes after the tables it references.
+// References outside tables and to the table itself are ignored; tables in a
+// reference cycle keep their relative order.
+func Order(tables []Table) []Table {
+	index := make(map[string]int, len(tables))
+	for i, t := range tables {
+		index[t.Table] = i
+	}
+	done := make([]bool, len(tables))
+	var ordered []Table
+	for len(ordered) < len(tables) {
+		progress := false
+		for i, t := range tables {
+			if done[i] || !refsDone(t, index, done) {
+				continue
+			}
+			done[i], progress = true, true
+			ordered = append(ordered, t)
+		}
+		if !progress {
+			// Break the cycle at the first remaining table
+			for i, t := range tables {
+				if !done[i] {
+					done[i] = true
+					ordered = append(ordered, t)
+					break
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+func refsDone(t Table, index map[string]int, done []bool) bool {
+	for _, fk := range t.ForeignKeys {
+		if j, ok := index[fk.RefTable]; ok && fk.RefTable != t.Table && !done[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// Writer writes the rows of one table to a data file
+type Writer struct {
+	f       *os.File
+	buf     *bufio.Writer
+	columns []string
+	csv     *csv.Writer
+	enc     *json.Encoder
+}
+
+// Create creates the data file at path. CSV files start with a header row.
+func Create(path, format string, columns []string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, buf: bufio.NewWriter(f), columns: columns}
+	switch format {
+	case CSV:
+		w.csv = csv.NewWriter(w.buf)
+		if err := w.csv.Write(columns); err != nil {
+			f.Close()
+			return nil, err
+		}
+	case JSONL:
+		w.enc = json.NewEncoder(w.buf)
+	default:
+		f.Close()
+		return nil, fmt.Errorf("unsupported format %q (use jsonl or csv)", format)
+	}
+	return w, nil
+}
+
+// Write writes one row; nil values are NULL
+func (w *Writer) Write(values []*string) error {
+	if w.csv != nil {
+		record := make([]string, len(values))
+		for i, v := range values {
+			if v == nil {
+				record[i] = Null
+			} else {
+				record[i] = *v
+			}
+		}
+		return w.csv.Write(record)
+	}
+	row := make(map[string]*string, len(values))
+	for i, v := range values {
+		row[w.columns[i]] = v
+	}
+	return w.enc.Encode(row)
+}
+
+// Close flushes and closes the file
+func (w *Writer) Close() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// Reader reads the rows of one table from a data file
+type Reader struct {
+	f       *os.File
+	columns []string
+	index   map[string]int
+	csv     *csv.Reader
+	dec     *json.Decoder
+	row     int
+}
+
+// Open opens the data file at path holding columns. The header of a CSV file
+// must list exactly those columns, in order.
+func Open(path, format string, columns []string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{f: f, columns: columns, index: make(map[string]int, len(columns))}
+	for i, col := range columns {
+		r.index[col] = i
+	}
+	switch format {
+	case CSV:
+		r.csv = csv.NewReader(bufio.NewReader(f))
+		r.csv.FieldsPerRecord = len(columns)
+		header, err := r.csv.Read()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: header: %w", path, err)
+		}
+		for i, col := range header {
+			if col != columns[i] {
+				f.Close()
+				return nil, fmt.Errorf("%s: header column %d is %q, want %q", path, i+1, col, columns[i])
+			}
+		}
+	case JSONL:
+		r.dec = json.NewDecoder(bufio.NewReader(f))
+	default:
+		f.Close()
+		return nil, fmt.Errorf("unsupported format %q (use jsonl or csv)", format)
+	}
+	return r, nil
+}
+
+// Read returns the next row, or io.EOF after the last. Columns missing from
+// a JSONL row are NULL.
+func (r *Reader) Read() ([]*string, error) {
+	r.row++
+	values := make([]*string, len(r.columns))
+	if r.csv != nil {
+		record, err := r.csv.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		for i, s := range record {
+			if s != Null {
+				values[i] = &s
+			}
+		}
+		return values, nil
+	}
+
+	var row map[string]*string
+	if err := r.dec.Decode(&row); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("row %d: %w", r.row, err)
+	}
+	for col, v := range row {
+		i, ok := r.index[col]
+		if !ok {
+			return nil, fmt.Errorf("row %d: unknown column %q", r.row, col)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// Close closes the file
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
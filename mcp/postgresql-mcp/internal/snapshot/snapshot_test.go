@@ -0,0 +1,136 @@
+package snapshot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func str(s string) *string { return &s }
+
+func TestWriteAndRead(t *testing.T) {
+	columns := []string{"id", "note"}
+	rows := [][]*string{
+		{str("1"), str("plain")},
+		{str("2"), nil},
+		{str("3"), str("")},
+		{str("4"), str("comma, \"quote\"\nnewline")},
+	}
+	for _, format := range []string{JSONL, CSV} {
+		path := filepath.Join(t.TempDir(), "public.notes."+format)
+		w, err := Create(path, format, columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := w.Write(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := Open(path, format, columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]*string
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			got = append(got, row)
+		}
+		r.Close()
+		if !reflect.DeepEqual(got, rows) {
+			t.Errorf("%s: rows changed in a round trip: %v", format, got)
+		}
+	}
+}
+
+func TestOpenChecksColumns(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "a.csv")
+	if err := os.WriteFile(csvPath, []byte("id,name\n1,x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(csvPath, CSV, []string{"id", "email"}); err == nil {
+		t.Errorf("expected a header that does not match the manifest to be refused")
+	}
+
+	jsonPath := filepath.Join(dir, "a.jsonl")
+	if err := os.WriteFile(jsonPath, []byte(`{"id": "1", "email": "x"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(jsonPath, JSONL, []string{"id", "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Read(); err == nil {
+		t.Errorf("expected an unknown column to be refused")
+	}
+}
+
+func TestOrder(t *testing.T) {
+	fk := func(ref string) []ForeignKey { return []ForeignKey{{RefTable: ref}} }
+	tables := []Table{
+		{Table: "public.order_items", ForeignKeys: append(fk("public.orders"), fk("public.products")...)},
+		{Table: "public.orders", ForeignKeys: append(fk("public.users"), fk("public.orders")...)},
+		{Table: "public.products", ForeignKeys: fk("public.categories")},
+		{Table: "public.users"},
+	}
+	var names []string
+	for _, t := range Order(tables) {
+		names = append(names, t.Table)
+	}
+	want := []string{"public.products", "public.users", "public.orders", "public.order_items"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Order = %v, want %v", names, want)
+	}
+
+	cycle := []Table{
+		{Table: "public.a", ForeignKeys: fk("public.b")},
+		{Table: "public.b", ForeignKeys: fk("public.a")},
+		{Table: "public.c", ForeignKeys: fk("public.a")},
+	}
+	names = nil
+	for _, t := range Order(cycle) {
+		names = append(names, t.Table)
+	}
+	if want := []string{"public.a", "public.b", "public.c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Order with a cycle = %v, want %v", names, want)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manifest{Version: Version, Connection: "app", Format: CSV, Tables: []Table{
+		{Table: "public.users", File: "public.users.csv", Columns: []Column{{Name: "id", Type: "bigint", Serial: true}}},
+	}}
+	if err := WriteManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Table("public.users") == nil || !got.Tables[0].Columns[0].Serial {
+		t.Errorf("unexpected manifest: %+v", got)
+	}
+
+	m.Tables[0].File = "../users.csv"
+	if err := WriteManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(dir); err == nil {
+		t.Errorf("expected a data file outside the snapshot to be refused")
+	}
+}
@@ -0,0 +1,236 @@
+package sqlguard
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TokenType identifies the lexical class of a token
+type TokenType int
+
+const (
+	TokenWord TokenType = iota
+	TokenQuotedIdent
+	TokenString
+	TokenNumber
+	TokenParam
+	TokenPunct
+	TokenOperator
+)
+
+// Token is one lexical unit of a SQL statement. Comments and whitespace are dropped.
+type Token struct {
+	Type  TokenType
+	Value string
+	// Upper is the upper-cased value for TokenWord, used for keyword matching
+	Upper string
+	Pos   int
+}
+
+// IsKeyword reports whether the token is an unquoted word equal to one of kws
+func (t Token) IsKeyword(kws ...string) bool {
+	if t.Type != TokenWord {
+		return false
+	}
+	for _, kw := range kws {
+		if t.Upper == kw {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPunct reports whether the token is the given punctuation character
+func (t Token) IsPunct(p string) bool {
+	return t.Type == TokenPunct && t.Value == p
+}
+
+// Tokenize splits SQL text into tokens, understanding PostgreSQL quoting rules:
+// single-quoted and E-prefixed escape strings, double-quoted identifiers, dollar-quoted bodies,
+// line comments and nested block comments.
+func Tokenize(sql string) ([]Token, error) {
+	var tokens []Token
+	i := 0
+	n := len(sql)
+	for i < n {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && i+1 < n && sql[i+1] == '-':
+			for i < n && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < n && sql[i+1] == '*':
+			end, err := skipBlockComment(sql, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '\'':
+			end, err := scanQuoted(sql, i, '\'', false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Type: TokenString, Value: sql[i:end], Pos: i})
+			i = end
+		case (c == 'E' || c == 'e') && i+1 < n && sql[i+1] == '\'':
+			end, err := scanQuoted(sql, i+1, '\'', true)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Type: TokenString, Value: sql[i:end], Pos: i})
+			i = end
+		case c == '"':
+			end, err := scanQuoted(sql, i, '"', false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Type: TokenQuotedIdent, Value: sql[i:end], Pos: i})
+			i = end
+		case c == '$':
+			if i+1 < n && sql[i+1] >= '0' && sql[i+1] <= '9' {
+				j := i + 1
+				for j < n && sql[j] >= '0' && sql[j] <= '9' {
+					j++
+				}
+				tokens = append(tokens, Token{Type: TokenParam, Value: sql[i:j], Pos: i})
+				i = j
+				continue
+			}
+			tag, ok := dollarTag(sql, i)
+			if !ok {
+				tokens = append(tokens, Token{Type: TokenOperator, Value: "$", Pos: i})
+				i++
+				continue
+			}
+			closeIdx := strings.Index(sql[i+len(tag):], tag)
+			if closeIdx < 0 {
+				return nil, fmt.Errorf("unterminated dollar-quoted string at offset %d", i)
+			}
+			end := i + len(tag) + closeIdx + len(tag)
+			tokens = append(tokens, Token{Type: TokenString, Value: sql[i:end], Pos: i})
+			i = end
+		case isIdentStart(c):
+			j := i + 1
+			for j < n && isIdentPart(sql[j]) {
+				j++
+			}
+			word := sql[i:j]
+			tokens = append(tokens, Token{Type: TokenWord, Value: word, Upper: strings.ToUpper(word), Pos: i})
+			i = j
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < n && sql[i+1] >= '0' && sql[i+1] <= '9'):
+			j := i + 1
+			for j < n && (sql[j] >= '0' && sql[j] <= '9' || sql[j] == '.' || sql[j] == 'e' || sql[j] == 'E' || sql[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, Token{Type: TokenNumber, Value: sql[i:j], Pos: i})
+			i = j
+		case strings.IndexByte("(),;[].", c) >= 0:
+			tokens = append(tokens, Token{Type: TokenPunct, Value: string(c), Pos: i})
+			i++
+		default:
+			j := i + 1
+			for j < n && strings.IndexByte("+-*/<>=~!@#%^&|`?:", sql[j]) >= 0 {
+				// Stop before the start of a comment
+				if (sql[j] == '-' && j+1 < n && sql[j+1] == '-') || (sql[j] == '/' && j+1 < n && sql[j+1] == '*') {
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, Token{Type: TokenOperator, Value: sql[i:j], Pos: i})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '$'
+}
+
+func skipBlockComment(sql string, start int) (int, error) {
+	depth := 0
+	i := start
+	for i < len(sql) {
+		if i+1 < len(sql) && sql[i] == '/' && sql[i+1] == '*' {
+			depth++
+			i += 2
+			continue
+		}
+		if i+1 < len(sql) && sql[i] == '*' && sql[i+1] == '/' {
+			depth--
+			i += 2
+			if depth == 0 {
+				return i, nil
+			}
+			continue
+		}
+		i++
+	}
+	return 0, fmt.Errorf("unterminated block comment at offset %d", start)
+}
+
+// scanQuoted returns the offset just past the closing quote. Doubled quotes are
+// treated as escapes; backslash escapes are honoured when backslash is true.
+func scanQuoted(sql string, start int, quote byte, backslash bool) (int, error) {
+	i := start + 1
+	for i < len(sql) {
+		c := sql[i]
+		if backslash && c == '\\' {
+			i += 2
+			continue
+		}
+		if c == quote {
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1, nil
+		}
+		i++
+	}
+	return 0, fmt.Errorf("unterminated quoted text at offset %d", start)
+}
+
+// dollarTag returns the opening tag ($$ or $name$) starting at start
+func dollarTag(sql string, start int) (string, bool) {
+	j := start + 1
+	for j < len(sql) && sql[j] != '$' {
+		r := rune(sql[j])
+		if !(r == '_' || unicode.IsLetter(r) || (j > start+1 && unicode.IsDigit(r))) {
+			return "", false
+		}
+		j++
+	}
+	if j >= len(sql) {
+		return "", false
+	}
+	return sql[start : j+1], true
+}
+
+// SplitStatements groups tokens into statements separated by top-level semicolons.
+// Empty statements are dropped.
+func SplitStatements(tokens []Token) [][]Token {
+	var out [][]Token
+	var cur []Token
+	for _, t := range tokens {
+		if t.IsPunct(";") {
+			if len(cur) > 0 {
+				out = append(out, cur)
+			}
+			cur = nil
+			continue
+		}
+		cur = append(cur, t)
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
@@ -0,0 +1,357 @@
+package sqlguard
+
+import "strings"
+
+// Access is how a statement uses a relation
+type Access string
+
+const (
+	AccessRead   Access = "read"
+	AccessInsert Access = "insert"
+	AccessUpdate Access = "update"
+	AccessDelete Access = "delete"
+	// AccessMerge is the target of MERGE, which may insert, update and delete
+	AccessMerge Access = "merge"
+)
+
+// Relation is a table or view a statement references
+type Relation struct {
+	// Schema is empty when the name is not qualified
+	Schema string `json:"schema,omitempty"`
+	Name   string `json:"name"`
+	Alias  string `json:"alias,omitempty"`
+	Access Access `json:"access"`
+}
+
+// relationStop ends a relation name or alias
+var relationStop = map[string]bool{
+	"WHERE": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"OUTER": true, "CROSS": true, "NATURAL": true, "ON": true, "USING": true, "GROUP": true,
+	"ORDER": true, "LIMIT": true, "OFFSET": true, "HAVING": true, "WINDOW": true, "UNION": true,
+	"INTERSECT": true, "EXCEPT": true, "FETCH": true, "FOR": true, "SET": true, "RETURNING": true,
+	"VALUES": true, "DEFAULT": true, "SELECT": true, "TABLESAMPLE": true, "WHEN": true,
+	"AS": true, "OVERRIDING": true, "WITH": true, "ONLY": true, "LATERAL": true, "INTO": true,
+	"FROM": true, "NOWAIT": true, "SKIP": true, "OF": true, "DO": true,
+}
+
+// Relations lists the relations a tokenized statement references, with how
+// it uses each. Names are folded like PostgreSQL folds them: unquoted to
+// lower case. Common table expressions are not relations where they are in
+// scope, but the relations inside them are listed. The target of INSERT ...
+// ON CONFLICT DO UPDATE is listed once for the insert and once for the
+// update. Functions in FROM and relations reached only through functions or
+// views are not seen.
+func Relations(stmt []Token) []Relation {
+	r := &relationScanner{tokens: stmt, depth: make([]int, len(stmt)), queryStarts: map[int]bool{}}
+	depth := 0
+	for i, tok := range stmt {
+		if tok.IsPunct(")") {
+			depth--
+		}
+		r.depth[i] = depth
+		if tok.IsPunct("(") {
+			depth++
+		}
+	}
+
+	insertTarget := -1
+	for i, tok := range stmt {
+		prev := Token{}
+		if i > 0 {
+			prev = stmt[i-1]
+		}
+		switch {
+		case tok.IsKeyword("WITH"):
+			r.withList(i)
+		case tok.IsKeyword("FROM") && prev.IsKeyword("DELETE"):
+			r.list(i+1, AccessDelete, false, false)
+		case tok.IsKeyword("FROM") && !prev.IsKeyword("DISTINCT", "ROWS") && r.inQuery(i):
+			r.fromClause(i)
+		case tok.IsKeyword("JOIN", "USING"):
+			r.list(i+1, AccessRead, true, true)
+		case tok.IsKeyword("INTO") && prev.IsKeyword("INSERT"):
+			n, j := r.list(i+1, AccessInsert, false, false)
+			if n > 0 {
+				insertTarget = len(r.relations) - 1
+			}
+			r.insertSource(j)
+		case tok.IsKeyword("INTO") && prev.IsKeyword("MERGE"):
+			r.list(i+1, AccessMerge, false, false)
+		case tok.IsKeyword("UPDATE") && prev.IsKeyword("DO") && insertTarget >= 0:
+			upsert := r.relations[insertTarget]
+			upsert.Access = AccessUpdate
+			r.add(upsert, r.at[insertTarget])
+		case tok.IsKeyword("UPDATE") && !prev.IsKeyword("FOR", "KEY", "DO", "ON", "THEN"):
+			r.list(i+1, AccessUpdate, false, false)
+		case tok.IsKeyword("TABLE") && r.startsQuery(i):
+			r.list(i+1, AccessRead, false, false)
+		}
+	}
+
+	var relations []Relation
+	for k, rel := range r.relations {
+		if rel.Schema != "" || !r.inCTEScope(rel.Name, r.at[k]) {
+			relations = append(relations, rel)
+		}
+	}
+	return relations
+}
+
+// IdentName folds an unquoted identifier to lower case and unquotes a
+// quoted one
+func IdentName(tok Token) string {
+	if tok.Type == TokenQuotedIdent {
+		return strings.ReplaceAll(tok.Value[1:len(tok.Value)-1], `""`, `"`)
+	}
+	return strings.ToLower(tok.Value)
+}
+
+type relationScanner struct {
+	tokens []Token
+	depth  []int
+	ctes   []cteScope
+	// queryStarts are the indexes of queries that start without a keyword
+	// of their own, like the source of INSERT
+	queryStarts map[int]bool
+	relations   []Relation
+	// at is the index of the name of each relation
+	at []int
+}
+
+// cteScope is a common table expression and the tokens that see it in place
+// of a relation of the same name
+type cteScope struct {
+	name     string
+	from, to int
+}
+
+func (r *relationScanner) add(rel Relation, at int) {
+	r.relations = append(r.relations, rel)
+	r.at = append(r.at, at)
+}
+
+// inCTEScope reports whether name at i refers to a common table expression
+func (r *relationScanner) inCTEScope(name string, i int) bool {
+	for _, c := range r.ctes {
+		if c.name == name && c.from <= i && i <= c.to {
+			return true
+		}
+	}
+	return false
+}
+
+// startsQuery reports whether the token at i starts a query: a statement,
+// a parenthesised query or CTE body, a branch of a set operation, the query
+// EXPLAIN or AS of CREATE ... AS runs, or the source of INSERT
+func (r *relationScanner) startsQuery(i int) bool {
+	if i == 0 || r.queryStarts[i] {
+		return true
+	}
+	prev := r.tokens[i-1]
+	switch {
+	case prev.IsPunct("(") || prev.IsPunct(";"):
+		return true
+	case prev.IsKeyword("UNION", "INTERSECT", "EXCEPT", "EXPLAIN", "ANALYZE", "VERBOSE", "AS"):
+		return true
+	case prev.IsKeyword("ALL", "DISTINCT"):
+		return i >= 2 && r.tokens[i-2].IsKeyword("UNION", "INTERSECT", "EXCEPT")
+	case prev.IsPunct(")"):
+		// EXPLAIN (options) query
+		for j := i - 2; j >= 0; j-- {
+			if r.tokens[j].IsPunct("(") && r.depth[j] == r.depth[i-1] {
+				return j > 0 && r.tokens[j-1].IsKeyword("EXPLAIN")
+			}
+		}
+	}
+	return false
+}
+
+// insertSource marks where the query of an INSERT starts when its target
+// list ends at i: after the column list and OVERRIDING ... VALUE
+func (r *relationScanner) insertSource(i int) {
+	if r.punct(i, "(") {
+		i = r.close(i) + 1
+	}
+	if i < len(r.tokens) && r.tokens[i].IsKeyword("OVERRIDING") {
+		for i < len(r.tokens) && !r.tokens[i].IsKeyword("VALUE") {
+			i++
+		}
+		i++
+	}
+	r.queryStarts[i] = true
+}
+
+func isName(tok Token) bool {
+	return tok.Type == TokenWord || tok.Type == TokenQuotedIdent
+}
+
+func isRelationStop(tok Token) bool {
+	return tok.Type == TokenWord && relationStop[tok.Upper]
+}
+
+func (r *relationScanner) punct(i int, p string) bool {
+	return i < len(r.tokens) && r.tokens[i].IsPunct(p)
+}
+
+// close returns the index of the parenthesis closing the one at open, or the
+// last index when it is unbalanced
+func (r *relationScanner) close(open int) int {
+	if end := matchParen(r.tokens, open); end >= 0 {
+		return end
+	}
+	return len(r.tokens) - 1
+}
+
+// inQuery reports whether the FROM at i belongs to a SELECT or UPDATE at the
+// same parenthesis level, rather than to a function call like
+// EXTRACT(YEAR FROM ts)
+func (r *relationScanner) inQuery(i int) bool {
+	for j := i - 1; j >= 0 && r.depth[j] >= r.depth[i]; j-- {
+		if r.depth[j] == r.depth[i] && r.tokens[j].IsKeyword("SELECT", "UPDATE") {
+			return true
+		}
+	}
+	return false
+}
+
+// fromClause reads the FROM list starting after the keyword at i. Entries
+// after a join condition are reached through the commas at the clause's
+// level; joined tables are read at their JOIN.
+func (r *relationScanner) fromClause(i int) {
+	_, j := r.list(i+1, AccessRead, true, true)
+	for ; j < len(r.tokens) && r.depth[j] >= r.depth[i]; j++ {
+		tok := r.tokens[j]
+		if r.depth[j] > r.depth[i] {
+			continue
+		}
+		if tok.IsPunct(";") || tok.IsKeyword("WHERE", "GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT", "OFFSET",
+			"FETCH", "FOR", "UNION", "INTERSECT", "EXCEPT", "RETURNING") {
+			return
+		}
+		if tok.IsPunct(",") {
+			_, j = r.list(j+1, AccessRead, true, true)
+			j--
+		}
+	}
+}
+
+// withList records the common table expressions listed after the WITH at i.
+// Only names there are CTEs; a WINDOW definition or a table alias of the same
+// shape elsewhere is not. A CTE is seen after its body up to the end of the
+// query the WITH belongs to; with RECURSIVE it is also seen in the bodies of
+// the list.
+func (r *relationScanner) withList(i int) {
+	end := i
+	for end+1 < len(r.tokens) && r.depth[end+1] >= r.depth[i] && !(r.depth[end+1] == r.depth[i] && r.tokens[end+1].IsPunct(";")) {
+		end++
+	}
+	j := i + 1
+	recursive := j < len(r.tokens) && r.tokens[j].IsKeyword("RECURSIVE")
+	if recursive {
+		j++
+	}
+	for j < len(r.tokens) && isName(r.tokens[j]) {
+		body := r.cteBody(j)
+		if body < 0 {
+			return
+		}
+		from := r.close(body) + 1
+		if recursive {
+			from = i
+		}
+		r.ctes = append(r.ctes, cteScope{name: IdentName(r.tokens[j]), from: from, to: end})
+		j = r.close(body) + 1
+		// SEARCH and CYCLE clauses may follow the body
+		for j < len(r.tokens) && !r.tokens[j].IsPunct(",") && r.depth[j] == r.depth[i] && !isStatementStart(r.tokens[j]) && !r.tokens[j].IsPunct("(") {
+			j++
+		}
+		if !r.punct(j, ",") {
+			return
+		}
+		j++
+	}
+}
+
+// cteBody returns the index of the parenthesis opening the body of the common
+// table expression named at i, as in name AS (, name AS [NOT] MATERIALIZED (
+// or name (columns) AS (, or -1 when it names none
+func (r *relationScanner) cteBody(i int) int {
+	j := i + 1
+	if r.punct(j, "(") {
+		j = r.close(j) + 1
+	}
+	if j >= len(r.tokens) || !r.tokens[j].IsKeyword("AS") {
+		return -1
+	}
+	for j++; j < len(r.tokens) && r.tokens[j].IsKeyword("NOT", "MATERIALIZED"); j++ {
+	}
+	if !r.punct(j, "(") {
+		return -1
+	}
+	return j
+}
+
+// list reads the table references starting at i and returns how many it
+// found and the index after them. With comma set it reads a comma-separated
+// list; with functions set a name followed by parentheses is a function call
+// rather than a table and its column list.
+func (r *relationScanner) list(i int, access Access, comma, functions bool) (int, int) {
+	found := 0
+	for i < len(r.tokens) {
+		for i < len(r.tokens) && r.tokens[i].IsKeyword("ONLY", "LATERAL") {
+			i++
+		}
+		if i >= len(r.tokens) {
+			return found, i
+		}
+		var rel *Relation
+		at := i
+		switch tok := r.tokens[i]; {
+		case tok.IsPunct("("):
+			// Subqueries are scanned through their own FROM clauses
+			i = r.close(i) + 1
+		case functions && tok.IsKeyword("ROWS") && i+2 < len(r.tokens) && r.tokens[i+1].IsKeyword("FROM") && r.punct(i+2, "("):
+			// ROWS FROM (f(), g()) is a set of functions
+			i = r.close(i+2) + 1
+		case isName(tok) && !isRelationStop(tok):
+			parts := []string{IdentName(tok)}
+			for i+2 < len(r.tokens) && r.punct(i+1, ".") && isName(r.tokens[i+2]) {
+				parts = append(parts, IdentName(r.tokens[i+2]))
+				i += 2
+			}
+			i++
+			if functions && r.punct(i, "(") {
+				i = r.close(i) + 1
+				break
+			}
+			rel = &Relation{Name: parts[len(parts)-1], Access: access}
+			if len(parts) > 1 {
+				rel.Schema = parts[len(parts)-2]
+			}
+		default:
+			return found, i
+		}
+
+		if i < len(r.tokens) && r.tokens[i].IsKeyword("AS") {
+			i++
+		}
+		if i < len(r.tokens) && isName(r.tokens[i]) && !isRelationStop(r.tokens[i]) {
+			if rel != nil {
+				rel.Alias = IdentName(r.tokens[i])
+			}
+			i++
+			if functions && r.punct(i, "(") {
+				i = r.close(i) + 1
+			}
+		}
+		if rel != nil {
+			r.add(*rel, at)
+			found++
+		}
+		if !comma || !r.punct(i, ",") {
+			return found, i
+		}
+		i++
+	}
+	return found, i
+}
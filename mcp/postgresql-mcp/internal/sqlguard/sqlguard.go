@@ -0,0 +1,376 @@
+package sqlguard
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind is the classified kind of a SQL statement
+type Kind string
+
+const (
+	KindSelect      Kind = "SELECT"
+	KindValues      Kind = "VALUES"
+	KindTable       Kind = "TABLE"
+	KindShow        Kind = "SHOW"
+	KindExplain     Kind = "EXPLAIN"
+	KindSelectInto  Kind = "SELECT INTO"
+	KindSelectLock  Kind = "SELECT FOR UPDATE/SHARE"
+	KindInsert      Kind = "INSERT"
+	KindUpdate      Kind = "UPDATE"
+	KindDelete      Kind = "DELETE"
+	KindMerge       Kind = "MERGE"
+	KindCopy        Kind = "COPY"
+	KindDDL         Kind = "DDL"
+	KindTransaction Kind = "TRANSACTION"
+	KindSession     Kind = "SESSION"
+	KindFunction    Kind = "SIDE-EFFECT FUNCTION"
+	KindMultiple    Kind = "MULTIPLE STATEMENTS"
+	KindEmpty       Kind = "EMPTY"
+	KindUnknown     Kind = "UNKNOWN"
+)
+
+// ReadOnly reports whether statements of this kind never modify data or session state
+func (k Kind) ReadOnly() bool {
+	switch k {
+	case KindSelect, KindValues, KindTable, KindShow, KindExplain:
+		return true
+	}
+	return false
+}
+
+// Rejection describes why a statement was refused
+type Rejection struct {
+	Kind      Kind   `json:"kind"`
+	Statement int    `json:"statement"`
+	Reason    string `json:"reason"`
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("statement %d rejected (%s): %s", r.Statement, r.Kind, r.Reason)
+}
+
+var ddlKeywords = map[string]bool{
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "COMMENT": true,
+	"GRANT": true, "REVOKE": true, "REINDEX": true, "CLUSTER": true, "VACUUM": true,
+	"ANALYZE": true, "ANALYSE": true, "REFRESH": true, "SECURITY": true, "IMPORT": true,
+	"REASSIGN": true,
+}
+
+var transactionKeywords = map[string]bool{
+	"BEGIN": true, "START": true, "COMMIT": true, "END": true, "ROLLBACK": true,
+	"ABORT": true, "SAVEPOINT": true, "RELEASE": true, "PREPARE": true,
+}
+
+var sessionKeywords = map[string]bool{
+	"SET": true, "RESET": true, "DISCARD": true, "LISTEN": true, "UNLISTEN": true,
+	"NOTIFY": true, "LOCK": true, "LOAD": true, "DO": true, "CALL": true,
+	"DECLARE": true, "FETCH": true, "MOVE": true, "CLOSE": true, "EXECUTE": true,
+	"DEALLOCATE": true, "CHECKPOINT": true,
+}
+
+// sideEffectFunctions are functions that act outside the transaction or the
+// database and are therefore not stopped by a read-only transaction.
+var sideEffectFunctions = map[string]bool{
+	"PG_TERMINATE_BACKEND": true, "PG_CANCEL_BACKEND": true, "PG_RELOAD_CONF": true,
+	"PG_ROTATE_LOGFILE": true, "PG_PROMOTE": true, "PG_SWITCH_WAL": true,
+	"PG_CREATE_RESTORE_POINT": true, "PG_ADVISORY_LOCK": true, "PG_ADVISORY_LOCK_SHARED": true,
+	"PG_TRY_ADVISORY_LOCK": true, "PG_TRY_ADVISORY_LOCK_SHARED": true,
+	"LO_IMPORT": true, "LO_EXPORT": true, "LO_UNLINK": true, "DBLINK": true, "DBLINK_EXEC": true,
+	"DBLINK_CONNECT": true, "DBLINK_SEND_QUERY": true, "PG_FILE_WRITE": true, "PG_FILE_UNLINK": true,
+	"PG_FILE_RENAME": true,
+}
+
+// Classify returns the kind of a single tokenized statement. Data-modifying CTEs,
+// SELECT INTO, locking clauses and side-effect function calls are surfaced as
+// their own kinds so that callers can reject them.
+func Classify(stmt []Token) Kind {
+	stmt = trimParens(stmt)
+	if len(stmt) == 0 {
+		return KindEmpty
+	}
+	first := stmt[0]
+	if first.Type != TokenWord {
+		return KindUnknown
+	}
+
+	var kind Kind
+	switch {
+	case first.Upper == "SELECT":
+		kind = classifySelect(stmt)
+	case first.Upper == "VALUES":
+		kind = KindValues
+	case first.Upper == "TABLE":
+		kind = KindTable
+	case first.Upper == "SHOW":
+		return KindShow
+	case first.Upper == "WITH":
+		kind = classifyWith(stmt[1:])
+	case first.Upper == "EXPLAIN":
+		kind = classifyExplain(stmt[1:])
+	case first.Upper == "INSERT":
+		return KindInsert
+	case first.Upper == "UPDATE":
+		return KindUpdate
+	case first.Upper == "DELETE":
+		return KindDelete
+	case first.Upper == "MERGE":
+		return KindMerge
+	case first.Upper == "COPY":
+		return KindCopy
+	case ddlKeywords[first.Upper]:
+		return KindDDL
+	case transactionKeywords[first.Upper]:
+		return KindTransaction
+	case sessionKeywords[first.Upper]:
+		return KindSession
+	default:
+		return KindUnknown
+	}
+
+	if kind.ReadOnly() && callsSideEffectFunction(stmt) {
+		return KindFunction
+	}
+	return kind
+}
+
+func classifySelect(stmt []Token) Kind {
+	depth := 0
+	for i, t := range stmt {
+		switch {
+		case t.IsPunct("("):
+			depth++
+		case t.IsPunct(")"):
+			depth--
+		case depth == 0 && t.IsKeyword("INTO"):
+			return KindSelectInto
+		case t.IsKeyword("FOR") && i+1 < len(stmt) && stmt[i+1].IsKeyword("UPDATE", "SHARE", "NO", "KEY"):
+			return KindSelectLock
+		}
+	}
+	return KindSelect
+}
+
+// classifyWith walks the CTE list after WITH and classifies every CTE body as
+// well as the main statement. The first non read-only kind wins.
+func classifyWith(rest []Token) Kind {
+	i := 0
+	if i < len(rest) && rest[i].IsKeyword("RECURSIVE") {
+		i++
+	}
+	for i < len(rest) {
+		// CTE name
+		i++
+		// Optional column list
+		if i < len(rest) && rest[i].IsPunct("(") {
+			end := matchParen(rest, i)
+			if end < 0 {
+				return KindUnknown
+			}
+			i = end + 1
+		}
+		if i >= len(rest) || !rest[i].IsKeyword("AS") {
+			return KindUnknown
+		}
+		i++
+		for i < len(rest) && rest[i].IsKeyword("NOT", "MATERIALIZED") {
+			i++
+		}
+		if i >= len(rest) || !rest[i].IsPunct("(") {
+			return KindUnknown
+		}
+		end := matchParen(rest, i)
+		if end < 0 {
+			return KindUnknown
+		}
+		if k := Classify(rest[i+1 : end]); !k.ReadOnly() {
+			return k
+		}
+		i = end + 1
+		// Skip SEARCH / CYCLE clauses of recursive CTEs
+		for i < len(rest) && !rest[i].IsPunct(",") && !rest[i].IsPunct("(") && !isStatementStart(rest[i]) {
+			i++
+		}
+		if i < len(rest) && rest[i].IsPunct(",") {
+			i++
+			continue
+		}
+		break
+	}
+	return Classify(rest[i:])
+}
+
+func isStatementStart(t Token) bool {
+	return t.IsKeyword("SELECT", "VALUES", "TABLE", "INSERT", "UPDATE", "DELETE", "MERGE")
+}
+
+// classifyExplain returns KindExplain unless ANALYZE would execute a statement
+// that is not read-only.
+func classifyExplain(rest []Token) Kind {
+	analyze := false
+	i := 0
+	if i < len(rest) && rest[i].IsPunct("(") {
+		end := matchParen(rest, i)
+		if end < 0 {
+			return KindUnknown
+		}
+		opts := rest[i+1 : end]
+		for j, t := range opts {
+			if t.IsKeyword("ANALYZE", "ANALYSE") {
+				analyze = !(j+1 < len(opts) && opts[j+1].IsKeyword("FALSE", "OFF"))
+			}
+		}
+		i = end + 1
+	} else {
+		for i < len(rest) && rest[i].IsKeyword("ANALYZE", "ANALYSE", "VERBOSE") {
+			if rest[i].IsKeyword("ANALYZE", "ANALYSE") {
+				analyze = true
+			}
+			i++
+		}
+	}
+	inner := Classify(rest[i:])
+	if analyze && !inner.ReadOnly() {
+		return inner
+	}
+	if inner == KindFunction {
+		return inner
+	}
+	return KindExplain
+}
+
+// callsSideEffectFunction reports whether stmt calls one of sideEffectFunctions.
+// The name before the parenthesis is the last part of a schema-qualified name,
+// and quoted names are compared too, so "pg_catalog"."pg_cancel_backend"(1)
+// is caught as well.
+func callsSideEffectFunction(stmt []Token) bool {
+	for i := 0; i+1 < len(stmt); i++ {
+		if isName(stmt[i]) && sideEffectFunctions[strings.ToUpper(IdentName(stmt[i]))] && stmt[i+1].IsPunct("(") {
+			return true
+		}
+	}
+	return false
+}
+
+// trimParens strips parentheses that wrap the entire statement, e.g. "(SELECT 1)".
+func trimParens(stmt []Token) []Token {
+	for len(stmt) >= 2 && stmt[0].IsPunct("(") && matchParen(stmt, 0) == len(stmt)-1 {
+		stmt = stmt[1 : len(stmt)-1]
+	}
+	// "(SELECT ...) UNION (SELECT ...)" starts with a parenthesised select
+	for len(stmt) > 0 && stmt[0].IsPunct("(") {
+		stmt = stmt[1:]
+	}
+	return stmt
+}
+
+// matchParen returns the index of the parenthesis closing the one at open, or -1
+func matchParen(tokens []Token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch {
+		case tokens[i].IsPunct("("):
+			depth++
+		case tokens[i].IsPunct(")"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// EnsureReadOnly parses sql and returns a *Rejection unless it consists of exactly
+// one read-only statement.
+func EnsureReadOnly(sql string) error {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return &Rejection{Kind: KindUnknown, Statement: 1, Reason: err.Error()}
+	}
+	stmts := SplitStatements(tokens)
+	if len(stmts) == 0 {
+		return &Rejection{Kind: KindEmpty, Statement: 1, Reason: "no statement found"}
+	}
+	for i, stmt := range stmts {
+		kind := Classify(stmt)
+		if !kind.ReadOnly() {
+			return &Rejection{Kind: kind, Statement: i + 1, Reason: reasonFor(kind, stmt)}
+		}
+	}
+	if len(stmts) > 1 {
+		return &Rejection{Kind: KindMultiple, Statement: 2, Reason: fmt.Sprintf("expected a single statement, found %d", len(stmts))}
+	}
+	return nil
+}
+
+func reasonFor(kind Kind, stmt []Token) string {
+	switch kind {
+	case KindSelectInto:
+		return "SELECT INTO creates a table"
+	case KindSelectLock:
+		return "row-locking clauses are not allowed in read-only queries"
+	case KindFunction:
+		return "statement calls a function with side effects outside the transaction"
+	case KindEmpty:
+		return "empty statement"
+	case KindUnknown:
+		return fmt.Sprintf("unrecognized statement starting with %q", firstWord(stmt))
+	case KindDDL, KindTransaction, KindSession:
+		return fmt.Sprintf("%s statements are not allowed in read-only queries", strings.ToUpper(firstWord(stmt)))
+	}
+	if strings.EqualFold(firstWord(stmt), "WITH") {
+		return fmt.Sprintf("data-modifying %s inside WITH is not allowed in read-only queries", kind)
+	}
+	if strings.EqualFold(firstWord(stmt), "EXPLAIN") {
+		return fmt.Sprintf("EXPLAIN ANALYZE would execute a %s statement", kind)
+	}
+	return fmt.Sprintf("%s statements are not allowed in read-only queries", kind)
+}
+
+func firstWord(stmt []Token) string {
+	for _, t := range stmt {
+		if t.Type == TokenWord {
+			return t.Value
+		}
+	}
+	if len(stmt) > 0 {
+		return stmt[0].Value
+	}
+	return ""
+}
+
+// Statement is a single parsed statement
+type Statement struct {
+	Kind Kind
+	// Text is the statement without surrounding whitespace, comments or the trailing semicolon
+	Text   string
+	Tokens []Token
+}
+
+// ParseSingle parses sql that must contain exactly one statement
+func ParseSingle(sql string) (*Statement, error) {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, &Rejection{Kind: KindUnknown, Statement: 1, Reason: err.Error()}
+	}
+	stmts := SplitStatements(tokens)
+	if len(stmts) == 0 {
+		return nil, &Rejection{Kind: KindEmpty, Statement: 1, Reason: "no statement found"}
+	}
+	if len(stmts) > 1 {
+		return nil, &Rejection{Kind: KindMultiple, Statement: 2, Reason: fmt.Sprintf("expected a single statement, found %d", len(stmts))}
+	}
+	stmt := stmts[0]
+	last := stmt[len(stmt)-1]
+	return &Statement{
+		Kind:   Classify(stmt),
+		Text:   sql[stmt[0].Pos : last.Pos+len(last.Value)],
+		Tokens: stmt,
+	}, nil
+}
+
+// Reject builds the rejection for a statement of a kind the caller does not accept
+func (s *Statement) Reject() *Rejection {
+	return &Rejection{Kind: s.Kind, Statement: 1, Reason: reasonFor(s.Kind, s.Tokens)}
+}
@@ -0,0 +1,167 @@
+package sqlguard
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeQuoting(t *testing.T) {
+	sql := `SELECT 'a;b', E'it\'s', $$x;y$$, $tag$ DROP TABLE t; $tag$, "weird;name" -- trailing; comment
+	/* block /* nested; */ still comment */ FROM t`
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stmts := SplitStatements(tokens); len(stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(stmts))
+	}
+	if last := tokens[len(tokens)-1]; last.Value != "t" {
+		t.Errorf("unexpected last token: %+v", last)
+	}
+}
+
+func TestTokenizeUnterminated(t *testing.T) {
+	for _, sql := range []string{"SELECT 'abc", `SELECT "abc`, "SELECT $$abc", "SELECT /* abc"} {
+		if _, err := Tokenize(sql); err == nil {
+			t.Errorf("expected error for %q", sql)
+		}
+	}
+}
+
+func TestEnsureReadOnlyAllows(t *testing.T) {
+	allowed := []string{
+		"SELECT * FROM users WHERE id = $1",
+		"select 1;",
+		"(SELECT 1) UNION (SELECT 2)",
+		"VALUES (1), (2)",
+		"TABLE users",
+		"SHOW search_path",
+		"EXPLAIN DELETE FROM users",
+		"EXPLAIN ANALYZE SELECT * FROM users",
+		"WITH recent AS (SELECT * FROM orders WHERE created_at > now() - interval '1 day') SELECT * FROM recent",
+		"WITH RECURSIVE t(n) AS (SELECT 1 UNION ALL SELECT n+1 FROM t WHERE n < 5) SELECT * FROM t",
+		"SELECT 'DELETE FROM users' AS s",
+		"SELECT substring(name FOR 3) FROM users",
+	}
+	for _, sql := range allowed {
+		if err := EnsureReadOnly(sql); err != nil {
+			t.Errorf("expected %q to be allowed, got %v", sql, err)
+		}
+	}
+}
+
+func TestEnsureReadOnlyRejects(t *testing.T) {
+	tests := []struct {
+		sql  string
+		kind Kind
+	}{
+		{"DELETE FROM users", KindDelete},
+		{"DROP TABLE users", KindDDL},
+		{"UPDATE users SET email = 'x'", KindUpdate},
+		{"INSERT INTO users(id) VALUES (1)", KindInsert},
+		{"WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", KindDelete},
+		{"WITH s AS (SELECT 1) UPDATE users SET id = 1", KindUpdate},
+		{"SELECT * INTO backup FROM users", KindSelectInto},
+		{"SELECT * FROM users FOR UPDATE", KindSelectLock},
+		{"EXPLAIN ANALYZE DELETE FROM users", KindDelete},
+		{"EXPLAIN (ANALYZE, BUFFERS) UPDATE users SET id = 1", KindUpdate},
+		{"SELECT pg_terminate_backend(123)", KindFunction},
+		{`SELECT "pg_terminate_backend"(123)`, KindFunction},
+		{`SELECT pg_catalog."pg_cancel_backend"(1)`, KindFunction},
+		{"SELECT pg_catalog.pg_reload_conf()", KindFunction},
+		{"SELECT * FROM dblink('host=evil', 'DELETE FROM users') AS t(n int)", KindFunction},
+		{"SELECT public.dblink_exec('DROP TABLE users')", KindFunction},
+		{"COMMIT", KindTransaction},
+		{"SET statement_timeout = 0", KindSession},
+		{"SELECT 1; SELECT 2", KindMultiple},
+		{"SELECT 1; DELETE FROM users", KindDelete},
+		{"   ;  ", KindEmpty},
+		{"FROBNICATE users", KindUnknown},
+	}
+	for _, tt := range tests {
+		err := EnsureReadOnly(tt.sql)
+		var rej *Rejection
+		if !errors.As(err, &rej) {
+			t.Errorf("expected rejection for %q, got %v", tt.sql, err)
+			continue
+		}
+		if rej.Kind != tt.kind {
+			t.Errorf("%q: expected kind %s, got %s (%s)", tt.sql, tt.kind, rej.Kind, rej.Reason)
+		}
+	}
+}
+
+func TestParseSingle(t *testing.T) {
+	stmt, err := ParseSingle("  -- leading comment\n SELECT * FROM users; ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stmt.Kind != KindSelect || stmt.Text != "SELECT * FROM users" {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+
+	stmt, err = ParseSingle("DELETE FROM users WHERE id = $1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rej := stmt.Reject(); rej.Kind != KindDelete {
+		t.Errorf("unexpected rejection: %+v", rej)
+	}
+
+	if _, err := ParseSingle("SELECT 1; SELECT 2"); err == nil {
+		t.Errorf("expected error for multiple statements")
+	}
+}
+
+func TestRelations(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM users", "users:read"},
+		{`SELECT u.id FROM public.users u JOIN "Orders" AS o ON o.user_id = u.id, sales.items`, "public.users u:read,sales.items:read,Orders o:read"},
+		{"SELECT extract(year FROM created_at), substring(name FROM 2) FROM orders WHERE a IS DISTINCT FROM b", "orders:read"},
+		{"SELECT * FROM generate_series(1, 3) g, LATERAL (SELECT * FROM items) i", "items:read"},
+		{"WITH recent AS (SELECT * FROM orders) SELECT * FROM recent JOIN users USING (id)", "orders:read,users:read"},
+		{"WITH a AS (SELECT 1), b (n) AS MATERIALIZED (SELECT * FROM a) SELECT * FROM b, c", "c:read"},
+		{"SELECT * FROM secret WINDOW secret AS (ORDER BY id)", "secret:read"},
+		{"SELECT * FROM (SELECT 1) secret, secret", "secret:read"},
+		{"TABLE users", "users:read"},
+		{"WITH s AS (TABLE secret) SELECT * FROM s", "secret:read"},
+		{"(TABLE secret)", "secret:read"},
+		{"SELECT 1 UNION ALL TABLE secret", "secret:read"},
+		{"EXPLAIN (ANALYZE) TABLE secret", "secret:read"},
+		{"INSERT INTO users (email) OVERRIDING USER VALUE TABLE staging", "users:insert,staging:read"},
+		{"SELECT * FROM secret, (WITH secret AS (SELECT 1) SELECT * FROM secret) q", "secret:read"},
+		{"WITH secret AS (SELECT * FROM secret) SELECT * FROM secret", "secret:read"},
+		{"WITH RECURSIVE t(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM t) SELECT * FROM t", ""},
+		{"SELECT * FROM ROWS FROM (generate_series(1, 3)) AS g(n), users", "users:read"},
+		{"INSERT INTO users (email) SELECT email FROM staging", "users:insert,staging:read"},
+		{"INSERT INTO users AS u (email) VALUES ($1) ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email", "users u:insert,users u:update"},
+		{"UPDATE users SET name = o.name FROM orders o WHERE o.user_id = users.id", "users:update,orders o:read"},
+		{"DELETE FROM users USING banned WHERE users.id = banned.id", "users:delete,banned:read"},
+		{"SELECT * FROM users FOR UPDATE OF users NOWAIT", "users:read"},
+		{"MERGE INTO stock s USING deliveries d ON s.id = d.id WHEN MATCHED THEN UPDATE SET qty = s.qty + d.qty WHEN NOT MATCHED THEN INSERT VALUES (d.id, d.qty)", "stock s:merge,deliveries d:read"},
+	}
+	for _, tt := range tests {
+		tokens, err := Tokenize(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range Relations(tokens) {
+			name := r.Name
+			if r.Schema != "" {
+				name = r.Schema + "." + name
+			}
+			if r.Alias != "" {
+				name += " " + r.Alias
+			}
+			got = append(got, name+":"+string(r.Access))
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("Relations(%q) = %s, want %s", tt.sql, strings.Join(got, ","), tt.want)
+		}
+	}
+}
@@ -1,17 +1,62 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 
 	_ "github.com/lib/pq"
 
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
 	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/dbguard"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/mask"
 )
 
 func main() {
+	policyPath := flag.String("policy", os.Getenv("POSTGRESQL_MCP_POLICY"), "Path to a JSON connection policy file")
+	maskingPath := flag.String("masking-policy", os.Getenv("POSTGRESQL_MCP_MASKING_POLICY"), "Path to a JSON policy of columns masked in results")
+	connectionsPath := flag.String("connections", os.Getenv("POSTGRESQL_MCP_CONNECTIONS"), "Path to a JSON file of named connections with their modes")
+	flag.StringVar(&migrationsDir, "migrations-dir", os.Getenv("POSTGRESQL_MCP_MIGRATIONS_DIR"), "Default directory of SQL migration files")
+	flag.StringVar(&snapshotsDir, "snapshots-dir", os.Getenv("POSTGRESQL_MCP_SNAPSHOTS_DIR"), "Default directory of table snapshots")
+	flag.StringVar(&seedDir, "seed-dir", os.Getenv("POSTGRESQL_MCP_SEED_DIR"), "Directory generate_seed_data writes sql output in")
+	flag.BoolVar(&snapshotsAnyDir, "snapshots-any-dir", os.Getenv("POSTGRESQL_MCP_SNAPSHOTS_ANY_DIR") == "true", "Let snapshot tools use a dir outside -snapshots-dir")
+	aclPath := flag.String("acl", os.Getenv("POSTGRESQL_MCP_ACL"), "Path to a JSON policy of the tables and operations each connection may use")
+	auditDefault := defaultAuditPath()
+	if v, ok := os.LookupEnv("POSTGRESQL_MCP_AUDIT_LOG"); ok {
+		auditDefault = v
+	}
+	auditPath := flag.String("audit-log", auditDefault, "Path of the JSONL audit log of data changes; empty disables auditing")
+	pageSize := defaultMaxPageSize
+	if v := os.Getenv("POSTGRESQL_MCP_MAX_PAGE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			fmt.Println("invalid POSTGRESQL_MCP_MAX_PAGE_SIZE:", v)
+			os.Exit(1)
+		}
+		pageSize = n
+	}
+	flag.IntVar(&maxPageSize, "max-page-size", pageSize, "Default and maximum number of rows per query page")
+	threshold := defaultConfirmThreshold
+	if v := os.Getenv("POSTGRESQL_MCP_CONFIRM_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			fmt.Println("invalid POSTGRESQL_MCP_CONFIRM_THRESHOLD:", v)
+			os.Exit(1)
+		}
+		threshold = n
+	}
+	flag.IntVar(&confirmThreshold, "confirm-threshold", threshold, "Rows an update or delete may affect before it needs a confirmation token from a dry run")
 	flag.Parse()
+	if maxPageSize <= 0 {
+		fmt.Println("invalid -max-page-size:", maxPageSize)
+		os.Exit(1)
+	}
+	if confirmThreshold < 0 {
+		fmt.Println("invalid -confirm-threshold:", confirmThreshold)
+		os.Exit(1)
+	}
 
 	// Get DSN string from command line arguments
 	var dbURLs string
@@ -33,6 +78,11 @@ func main() {
 				"type":        "string",
 				"description": "PostgreSQL connection string",
 			},
+			"mode": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"read-write", "read-only"},
+				"description": "read-only sessions default to read-only transactions and refuse mutating tools (default read-write)",
+			},
 		},
 		"required": []string{"name", "connection_string"},
 	}, connectHandler)
@@ -48,24 +98,21 @@ func main() {
 		"required": []string{"name"},
 	}, disconnectHandler)
 
-	server.AddTool("list_connections", "List all connection names", map[string]interface{}{
+	server.AddTool("list_connections", "List all connection names with their mode (read-only or read-write)", map[string]interface{}{
 		"type":       "object",
 		"properties": map[string]interface{}{},
 	}, listConnectionsHandler)
 
-	server.AddTool("query", "Execute a SELECT query safely", map[string]interface{}{
+	server.AddTool("query", "Execute a read-only query (SELECT, VALUES, TABLE, SHOW, EXPLAIN) inside a read-only transaction; results are paged and continued with fetch_more", map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
 			"sql": map[string]interface{}{
 				"type":        "string",
-				"description": "SQL query to execute",
+				"description": "Single read-only SQL statement; data-modifying statements and CTEs are rejected",
 			},
 			"params": map[string]interface{}{
 				"type":        "array",
-				"description": "Query parameters",
-				"items": map[string]interface{}{
-					"type": "string",
-				},
+				"description": "Query parameters for $1, $2, ...: plain JSON values, or typed values such as {\"value\": [1, 2], \"type\": \"int[]\"}",
 			},
 			"database": map[string]interface{}{
 				"type":        "string",
@@ -73,7 +120,24 @@ func main() {
 			},
 			"limit": map[string]interface{}{
 				"type":        "integer",
-				"description": "Limit number of results",
+				"description": "Maximum number of rows to return across all pages",
+			},
+			"page_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per page; defaults to and is capped at the server's maximum page size",
+			},
+			"cursor_idle_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Close the cursor when fetch_more is not called within this many milliseconds (default 120000)",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the statement inside that transaction",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"json", "csv", "markdown", "ndjson"},
+				"description": "Output format: json (default), csv, markdown or ndjson; other than json, a JSON header with ok, rowCount and cursor comes first",
 			},
 			"statement_timeout_ms": map[string]interface{}{
 				"type":        "integer",
@@ -83,6 +147,209 @@ func main() {
 		"required": []string{"sql"},
 	}, queryHandler)
 
+	server.AddTool("fetch_more", "Fetch the next page of a query using the cursor token from the previous page", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"cursor": map[string]interface{}{
+				"type":        "string",
+				"description": "Cursor token returned with the previous page",
+			},
+			"page_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per page; defaults to and is capped at the server's maximum page size",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"json", "csv", "markdown", "ndjson"},
+				"description": "Output format: json (default), csv, markdown or ndjson",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"cursor"},
+	}, fetchMoreHandler)
+
+	server.AddTool("close_cursor", "Close a query cursor before it is exhausted", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"cursor": map[string]interface{}{
+				"type":        "string",
+				"description": "Cursor token",
+			},
+		},
+		"required": []string{"cursor"},
+	}, closeCursorHandler)
+
+	server.AddTool("select", "Read rows from one table with a JSON WHERE filter, column list and ordering; results are paged like query", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"table": map[string]interface{}{
+				"type":        "string",
+				"description": "Table name, optionally schema-qualified",
+			},
+			"columns": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Columns to return (default all)",
+			},
+			"where": map[string]interface{}{
+				"type":        []string{"object", "array"},
+				"description": whereDescription,
+			},
+			"order_by": map[string]interface{}{
+				"type":        "array",
+				"description": "Sort keys: column names or {\"column\": \"created_at\", \"desc\": true}",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of rows to return across all pages",
+			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows to skip before the first returned row",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"page_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per page; defaults to and is capped at the server's maximum page size",
+			},
+			"cursor_idle_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Close the cursor when fetch_more is not called within this many milliseconds (default 120000)",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the statement inside that transaction",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"json", "csv", "markdown", "ndjson"},
+				"description": "Output format: json (default), csv, markdown or ndjson",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"table"},
+	}, selectHandler)
+
+	server.AddTool("explain", "Show the query plan of a statement as a normalized tree with hotspots", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"sql": map[string]interface{}{
+				"type":        "string",
+				"description": "Single SELECT, INSERT, UPDATE, DELETE or MERGE statement to explain",
+			},
+			"params": map[string]interface{}{
+				"type":        "array",
+				"description": "Query parameters for $1, $2, ...: plain JSON values, or typed values such as {\"value\": [1, 2], \"type\": \"int[]\"}",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction",
+			},
+			"analyze": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Execute the statement to collect actual rows and timings; always rolled back",
+			},
+			"seq_scan_rows": map[string]interface{}{
+				"type":        "integer",
+				"description": "Flag sequential scans over tables with at least this many rows (default 10000)",
+			},
+			"misestimate_factor": map[string]interface{}{
+				"type":        "number",
+				"description": "Flag nodes whose estimated and actual rows differ by this factor (default 10)",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"sql"},
+	}, explainHandler)
+
+	server.AddTool("federated_query", "Run read-only sub-queries on several named connections and join, filter and aggregate the results in memory", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"sources": map[string]interface{}{
+				"type":        "array",
+				"description": "Sub-queries, each {name, database, sql, params}; columns are referenced as name.column",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"name":     map[string]interface{}{"type": "string"},
+						"database": map[string]interface{}{"type": "string"},
+						"sql":      map[string]interface{}{"type": "string"},
+						"params":   map[string]interface{}{"type": "array"},
+					},
+					"required": []string{"name", "database", "sql"},
+				},
+			},
+			"from": map[string]interface{}{
+				"type":        "string",
+				"description": "Source the joins start from (default the first source)",
+			},
+			"join": map[string]interface{}{
+				"type":        "array",
+				"description": "Joins applied in order, each {source, type: inner|left|right|full, on: [{left, right}]}",
+			},
+			"where": map[string]interface{}{
+				"type":        "array",
+				"description": "Conditions ANDed together, each {column, op, value} or {or: [...]} / {and: [...]}; ops are =, !=, <, <=, >, >=, in, not_in, like, ilike, is_null, is_not_null",
+			},
+			"group_by": map[string]interface{}{
+				"type":        "array",
+				"description": "Columns to group by",
+				"items":       map[string]interface{}{"type": "string"},
+			},
+			"aggregates": map[string]interface{}{
+				"type":        "array",
+				"description": "Aggregates, each {func: count|count_distinct|sum|avg|min|max, column, as}",
+			},
+			"select": map[string]interface{}{
+				"type":        "array",
+				"description": "Columns to return (default all)",
+				"items":       map[string]interface{}{"type": "string"},
+			},
+			"order_by": map[string]interface{}{
+				"type":        "array",
+				"description": "Sort keys, each {column, desc}",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of rows to return",
+			},
+			"max_rows_per_source": map[string]interface{}{
+				"type":        "integer",
+				"description": "Fail when a sub-query returns more rows than this (default 10000)",
+			},
+			"max_result_rows": map[string]interface{}{
+				"type":        "integer",
+				"description": "Fail when a join step produces more rows than this (default 100000)",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"json", "csv", "markdown", "ndjson"},
+				"description": "Output format: json (default), csv, markdown or ndjson",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds for each sub-query",
+			},
+		},
+		"required": []string{"sources"},
+	}, federatedQueryHandler)
+
 	server.AddTool("insert", "INSERT with validated identifiers", map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
@@ -92,7 +359,7 @@ func main() {
 			},
 			"data": map[string]interface{}{
 				"type":        "object",
-				"description": "Data to insert",
+				"description": "Column values to insert; a value may be typed as {\"value\": ..., \"type\": \"jsonb\"}",
 			},
 			"database": map[string]interface{}{
 				"type":        "string",
@@ -102,6 +369,15 @@ func main() {
 				"type":        "boolean",
 				"description": "Return inserted rows",
 			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the statement inside that transaction",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"json", "csv", "markdown", "ndjson"},
+				"description": "Output format of the returned row: json (default), csv, markdown or ndjson",
+			},
 			"statement_timeout_ms": map[string]interface{}{
 				"type":        "integer",
 				"description": "Statement timeout in milliseconds",
@@ -110,7 +386,97 @@ func main() {
 		"required": []string{"table", "data"},
 	}, insertHandler)
 
-	server.AddTool("update", "UPDATE with validated identifiers and WHERE map", map[string]interface{}{
+	server.AddTool("bulk_insert", "Load many rows in one transaction through COPY FROM STDIN, falling back to batched multi-row INSERTs", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"table": map[string]interface{}{
+				"type":        "string",
+				"description": "Table name, optionally schema-qualified",
+			},
+			"rows": map[string]interface{}{
+				"type":        "array",
+				"description": "Rows as objects keyed by column, or as arrays matching columns; values may be typed as {\"value\": ..., \"type\": \"jsonb\"}",
+			},
+			"columns": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Column order; required for array rows, defaults to the keys of the first object row",
+			},
+			"method": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"auto", "copy", "insert"},
+				"description": "auto (default) uses COPY and falls back to INSERT when the server refuses COPY",
+			},
+			"batch_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per INSERT statement when inserting (default 500)",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the load inside that transaction",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Timeout in milliseconds for the whole load",
+			},
+		},
+		"required": []string{"table", "rows"},
+	}, bulkInsertHandler)
+
+	server.AddTool("upsert", "INSERT ... ON CONFLICT with validated identifiers; reports whether each row was inserted or updated", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"table": map[string]interface{}{
+				"type":        "string",
+				"description": "Table name",
+			},
+			"data": map[string]interface{}{
+				"type":        []string{"object", "array"},
+				"description": "Row object, or an array of row objects with the same keys; values may be typed as {\"value\": ..., \"type\": \"jsonb\"}",
+			},
+			"conflict_columns": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Columns of the unique index or constraint that detects conflicts",
+			},
+			"conflict_constraint": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of the unique or exclusion constraint that detects conflicts, instead of conflict_columns",
+			},
+			"update_columns": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Columns to overwrite on conflict (default: every column in data except conflict_columns)",
+			},
+			"do_nothing": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Skip conflicting rows instead of updating them",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"returning": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Return the inserted or updated rows (default true)",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the statement inside that transaction",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"table", "data"},
+	}, upsertHandler)
+
+	server.AddTool("update", "UPDATE with validated identifiers and a JSON WHERE filter", map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
 			"table": map[string]interface{}{
@@ -119,11 +485,11 @@ func main() {
 			},
 			"data": map[string]interface{}{
 				"type":        "object",
-				"description": "Data to update",
+				"description": "Column values to set; a value may be typed as {\"value\": ..., \"type\": \"jsonb\"}",
 			},
 			"where": map[string]interface{}{
-				"type":        "object",
-				"description": "WHERE conditions",
+				"type":        []string{"object", "array"},
+				"description": whereDescription,
 			},
 			"database": map[string]interface{}{
 				"type":        "string",
@@ -133,6 +499,27 @@ func main() {
 				"type":        "boolean",
 				"description": "Return updated rows",
 			},
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Run the update in a transaction that is rolled back and return the affected count, sample rows before and after, and a confirmation token",
+			},
+			"sample_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows in each dry-run sample (default 5)",
+			},
+			"confirmation_token": map[string]interface{}{
+				"type":        "string",
+				"description": "Token from a dry run of the same statement; required when the update affects more rows than the server's confirmation threshold",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the statement inside that transaction",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"json", "csv", "markdown", "ndjson"},
+				"description": "Output format of the returned rows: json (default), csv, markdown or ndjson",
+			},
 			"statement_timeout_ms": map[string]interface{}{
 				"type":        "integer",
 				"description": "Statement timeout in milliseconds",
@@ -141,7 +528,7 @@ func main() {
 		"required": []string{"table", "data", "where"},
 	}, updateHandler)
 
-	server.AddTool("delete", "DELETE with validated identifiers and WHERE map", map[string]interface{}{
+	server.AddTool("delete", "DELETE with validated identifiers and a JSON WHERE filter", map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
 			"table": map[string]interface{}{
@@ -149,8 +536,8 @@ func main() {
 				"description": "Table name",
 			},
 			"where": map[string]interface{}{
-				"type":        "object",
-				"description": "WHERE conditions",
+				"type":        []string{"object", "array"},
+				"description": whereDescription,
 			},
 			"database": map[string]interface{}{
 				"type":        "string",
@@ -160,6 +547,27 @@ func main() {
 				"type":        "boolean",
 				"description": "Return deleted rows",
 			},
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Run the delete in a transaction that is rolled back and return the affected count, a sample of the deleted rows and a confirmation token",
+			},
+			"sample_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows in the dry-run sample (default 5)",
+			},
+			"confirmation_token": map[string]interface{}{
+				"type":        "string",
+				"description": "Token from a dry run of the same statement; required when the delete affects more rows than the server's confirmation threshold",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the statement inside that transaction",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"json", "csv", "markdown", "ndjson"},
+				"description": "Output format of the returned rows: json (default), csv, markdown or ndjson",
+			},
 			"statement_timeout_ms": map[string]interface{}{
 				"type":        "integer",
 				"description": "Statement timeout in milliseconds",
@@ -168,6 +576,214 @@ func main() {
 		"required": []string{"table", "where"},
 	}, deleteHandler)
 
+	server.AddTool("begin_transaction", "Begin a transaction pinned to one connection and return its handle", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"read_only": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Begin a READ ONLY transaction",
+			},
+			"isolation_level": map[string]interface{}{
+				"type":        "string",
+				"description": "Isolation level",
+				"enum":        []string{"read_committed", "repeatable_read", "serializable"},
+			},
+			"idle_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Roll back automatically after this much inactivity (default 300000)",
+			},
+		},
+	}, beginTransactionHandler)
+
+	server.AddTool("commit", "Commit a transaction", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle",
+			},
+		},
+		"required": []string{"transaction"},
+	}, commitHandler)
+
+	server.AddTool("rollback", "Roll back a transaction, or only to a savepoint", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle",
+			},
+			"savepoint": map[string]interface{}{
+				"type":        "string",
+				"description": "Roll back to this savepoint and keep the transaction open",
+			},
+		},
+		"required": []string{"transaction"},
+	}, rollbackHandler)
+
+	server.AddTool("savepoint", "Create or release a savepoint inside a transaction", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle",
+			},
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Savepoint name",
+			},
+			"release": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Release the savepoint instead of creating it",
+			},
+		},
+		"required": []string{"transaction", "name"},
+	}, savepointHandler)
+
+	server.AddTool("list_transactions", "List open transaction handles", map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{},
+	}, listTransactionsHandler)
+
+	server.AddTool("get_audit_log", "Read the audit log of data changes, newest first, with before and after row images", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"since": map[string]interface{}{
+				"type":        "string",
+				"description": "Only entries at or after this RFC 3339 time",
+			},
+			"until": map[string]interface{}{
+				"type":        "string",
+				"description": "Only entries before this RFC 3339 time",
+			},
+			"table": map[string]interface{}{
+				"type":        "string",
+				"description": "Only entries for this table; an unqualified name matches any schema",
+			},
+			"operation": map[string]interface{}{
+				"type":        "string",
+				"description": "Only entries with this SQL operation (insert, update, delete) or tool name (upsert, bulk_insert)",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Only entries for this connection name",
+			},
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "Only the entry with this ID",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of entries to return (default 100)",
+			},
+		},
+	}, getAuditLogHandler)
+
+	server.AddTool("undo", "Revert audited changes from their row images in one transaction; previews the compensating statements unless apply is true. Rows loaded by bulk_insert, restore_snapshot and generate_seed_data have no row images and cannot be reverted", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "Audit entry ID to revert",
+			},
+			"ids": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Audit entry IDs to revert together; newer entries are reverted first",
+			},
+			"apply": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Apply the compensating statements (default false: run them and roll back as a preview)",
+			},
+			"transaction": map[string]interface{}{
+				"type":        "string",
+				"description": "Transaction handle from begin_transaction; runs the statements inside that transaction",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+	}, undoHandler)
+
+	server.AddTool("migrate_status", "Compare the migration files of a directory with the versions recorded in schema_migrations", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"dir": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory of NNN_name.up.sql and NNN_name.down.sql files, relative to or inside -migrations-dir (default -migrations-dir)",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Timeout for the whole run in milliseconds",
+			},
+		},
+	}, migrateStatusHandler)
+
+	server.AddTool("migrate_up", "Apply pending migrations in version order, each in its own transaction under an advisory lock", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"dir": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory of NNN_name.up.sql and NNN_name.down.sql files, relative to or inside -migrations-dir (default -migrations-dir)",
+			},
+			"target": map[string]interface{}{
+				"type":        "integer",
+				"description": "Apply pending migrations up to and including this version (default all)",
+			},
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "List the migrations that would run with their SQL without running them",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Timeout for the whole run in milliseconds",
+			},
+		},
+	}, migrateUpHandler)
+
+	server.AddTool("migrate_down", "Revert applied migrations newest first with their down files, each in its own transaction under an advisory lock", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"dir": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory of NNN_name.up.sql and NNN_name.down.sql files, relative to or inside -migrations-dir (default -migrations-dir)",
+			},
+			"steps": map[string]interface{}{
+				"type":        "integer",
+				"description": "Number of migrations to revert (default 1)",
+			},
+			"target": map[string]interface{}{
+				"type":        "integer",
+				"description": "Revert every migration above this version instead; 0 reverts all",
+			},
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "List the migrations that would run with their SQL without running them",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Timeout for the whole run in milliseconds",
+			},
+		},
+	}, migrateDownHandler)
+
 	server.AddTool("list_schemas", "List non-system schemas", map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
@@ -192,7 +808,7 @@ func main() {
 		},
 	}, listTablesHandler)
 
-	server.AddTool("describe_table", "Describe columns for a table", map[string]interface{}{
+	server.AddTool("describe_table", "Describe a table from pg_catalog: columns with comments, primary key, unique and check constraints, foreign keys in both directions, indexes, triggers and partitioning", map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
 			"table": map[string]interface{}{
@@ -207,33 +823,311 @@ func main() {
 		"required": []string{"table"},
 	}, describeTableHandler)
 
-	// Auto-connect via command line arguments with local-only enforcement
-	var urls []string
-	var err error
+	server.AddTool("export_er_diagram", "Export an entity-relationship diagram of every schema as Mermaid erDiagram or Graphviz DOT text", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"mermaid", "dot"},
+				"description": "Diagram format (default mermaid)",
+			},
+			"schema_pattern": map[string]interface{}{
+				"type":        "string",
+				"description": "Comma-separated glob patterns for schemas, e.g. \"inventory,log*\"",
+			},
+			"table_pattern": map[string]interface{}{
+				"type":        "string",
+				"description": "Comma-separated glob patterns for tables; patterns with a dot match schema.table",
+			},
+			"all_columns": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Include every column instead of only primary, unique and foreign key columns",
+			},
+		},
+	}, erDiagramHandler)
 
-	if dbURLs == "" {
-		fmt.Println("no database URLs provided: pass DSN string as argument")
-		os.Exit(1)
+	server.AddTool("schema_diff", "Compare the tables, columns, constraints, indexes, views and functions of two schemas on one or two connections, with the DDL that migrates the left side to match the right", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"left_database": map[string]interface{}{
+				"type":        "string",
+				"description": "Connection of the side to migrate",
+			},
+			"left_schema": map[string]interface{}{
+				"type":        "string",
+				"description": "Schema of the left side (default public)",
+			},
+			"right_database": map[string]interface{}{
+				"type":        "string",
+				"description": "Connection of the side to match (default left_database)",
+			},
+			"right_schema": map[string]interface{}{
+				"type":        "string",
+				"description": "Schema of the right side (default left_schema)",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+	}, schemaDiffHandler)
+
+	server.AddTool("data_diff", "Compare the rows of a table on two connections, or of two tables on one, by primary key or given key columns: rows only on the right (inserted), only on the left (deleted) and changed rows with their differing columns", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"left_database": map[string]interface{}{
+				"type":        "string",
+				"description": "Connection of the left side",
+			},
+			"left_table": map[string]interface{}{
+				"type":        "string",
+				"description": "Table of the left side",
+			},
+			"right_database": map[string]interface{}{
+				"type":        "string",
+				"description": "Connection of the right side (default left_database)",
+			},
+			"right_table": map[string]interface{}{
+				"type":        "string",
+				"description": "Table of the right side (default left_table)",
+			},
+			"key_columns": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Columns identifying a row (default the primary key of the left table)",
+			},
+			"columns": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Columns to compare (default the columns both tables have)",
+			},
+			"chunk_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per hashed key range (default 1000)",
+			},
+			"max_rows": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows returned per kind of difference (default 100)",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"left_table"},
+	}, dataDiffHandler)
+
+	server.AddTool("snapshot_tables", "Write the rows of tables, optionally filtered, to a snapshot directory of JSONL or CSV files with a manifest of their columns, keys and foreign keys", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Snapshot name, the subdirectory of dir it is written to",
+			},
+			"tables": map[string]interface{}{
+				"type":        "array",
+				"description": "Tables to snapshot: names, or {\"table\": \"orders\", \"where\": filter} to keep only matching rows",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"description": "Data file format: jsonl (default) or csv",
+			},
+			"dir": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory of snapshots (default -snapshots-dir); must be inside -snapshots-dir when it is set",
+			},
+			"overwrite": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Replace an existing snapshot of the same name",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"name", "tables"},
+	}, snapshotTablesHandler)
+
+	server.AddTool("restore_snapshot", "Load a snapshot back into its tables in foreign key order, in one transaction: truncate the tables first, or upsert by primary key", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Snapshot name",
+			},
+			"mode": map[string]interface{}{
+				"type":        "string",
+				"description": "truncate (default) empties the tables before loading; upsert inserts or updates rows by primary key and keeps other rows",
+			},
+			"tables": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Tables of the snapshot to restore (default all)",
+			},
+			"dir": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory of snapshots (default -snapshots-dir); must be inside -snapshots-dir when it is set",
+			},
+			"batch_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per INSERT statement (default 500)",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"name"},
+	}, restoreSnapshotHandler)
+
+	server.AddTool("generate_seed_data", "Generate realistic rows for tables from their column types, enums, unique constraints and foreign keys, reproducibly from a seed, and insert them or write them to a SQL file", map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"tables": map[string]interface{}{
+				"type":        "array",
+				"description": "Tables to fill: names, or {\"table\": \"orders\", \"rows\": 1000} to set the row count of one table. Tables are filled in foreign key order.",
+			},
+			"rows": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per table (default 10)",
+			},
+			"seed": map[string]interface{}{
+				"type":        "integer",
+				"description": "Random seed; the same seed generates the same rows for the same table contents (default random, reported back)",
+			},
+			"until": map[string]interface{}{
+				"type":        "string",
+				"description": "Latest generated timestamp, RFC 3339 or YYYY-MM-DD; timestamps fall within the year before it (default 2025-01-01)",
+			},
+			"output": map[string]interface{}{
+				"type":        "string",
+				"description": "insert (default) inserts the rows in one transaction; sql writes them to file",
+			},
+			"file": map[string]interface{}{
+				"type":        "string",
+				"description": "SQL file to write for output sql, relative to or inside -seed-dir",
+			},
+			"overwrite": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Replace an existing file",
+			},
+			"batch_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Rows per INSERT statement (default 500)",
+			},
+			"database": map[string]interface{}{
+				"type":        "string",
+				"description": "Database connection name",
+			},
+			"statement_timeout_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": "Statement timeout in milliseconds",
+			},
+		},
+		"required": []string{"tables"},
+	}, generateSeedDataHandler)
+
+	// Every connection, including runtime connect_database calls, is checked against the policy
+	if *policyPath != "" {
+		policy, err := dbguard.LoadPolicy(*policyPath)
+		if err != nil {
+			fmt.Println("invalid policy:", err)
+			os.Exit(1)
+		}
+		dbManager.SetGuard(dbguard.NewGuard(policy, logger.Printf))
+		logger.Printf("Loaded connection policy from %s", *policyPath)
 	}
 
-	urls, err = dbguard.LoadPostgresURLsFromArgs(dbURLs)
-	if err != nil {
-		fmt.Println("invalid database URLs:", err)
-		os.Exit(1)
+	// Every row returned to the client goes through the masking policy
+	if *maskingPath != "" {
+		mp, err := mask.Load(*maskingPath)
+		if err != nil {
+			fmt.Println("invalid masking policy:", err)
+			os.Exit(1)
+		}
+		maskPolicy = mp
+		logger.Printf("Loaded masking policy from %s", *maskingPath)
+	}
+
+	// Tools are offered and statements run only as far as the access policy allows
+	if *aclPath != "" {
+		ap, err := acl.Load(*aclPath)
+		if err != nil {
+			fmt.Println("invalid access policy:", err)
+			os.Exit(1)
+		}
+		accessPolicy = ap
+		server.SetToolFilter(toolVisible)
+		logger.Printf("Loaded access policy from %s", *aclPath)
+	}
+
+	if *auditPath != "" {
+		al, err := openAuditLog(*auditPath)
+		if err != nil {
+			fmt.Println("invalid audit log:", err)
+			os.Exit(1)
+		}
+		defer al.Close()
+		auditLog = al
+		logger.Printf("Recording data changes to %s", *auditPath)
 	}
-	dsns, err := dbguard.EnforceLocalForURLs(urls)
-	if err != nil {
-		fmt.Println("database URL rejected:", err)
+
+	if dbURLs == "" && *connectionsPath == "" {
+		fmt.Println("no database URLs provided: pass DSN string as argument")
 		os.Exit(1)
 	}
-	for _, dsn := range dsns {
-		name := extractDatabaseName(dsn)
-		if name == "" {
-			logger.Printf("Failed to extract database name from URL: %s", dbguard.RedactDSN(dsn))
-			continue
+
+	// Connections from the config file first, then the DSN list
+	var configs []connectionConfig
+	if *connectionsPath != "" {
+		loaded, err := loadConnectionsConfig(*connectionsPath)
+		if err != nil {
+			fmt.Println("invalid connections config:", err)
+			os.Exit(1)
 		}
-		if err := dbManager.AddConnection(name, dsn); err != nil {
-			logger.Printf("Failed to auto-connect %s: %v", name, err)
+		configs = loaded
+	}
+	if dbURLs != "" {
+		urls, err := dbguard.LoadPostgresURLsFromArgs(dbURLs)
+		if err != nil {
+			fmt.Println("invalid database URLs:", err)
+			os.Exit(1)
+		}
+		for _, dsn := range urls {
+			dsn, mode, err := splitDSNMode(dsn)
+			if err != nil {
+				fmt.Println("invalid database URLs:", err)
+				os.Exit(1)
+			}
+			name := extractDatabaseName(dsn)
+			if name == "" {
+				logger.Printf("Failed to extract database name from URL: %s", dbguard.RedactDSN(dsn))
+				continue
+			}
+			configs = append(configs, connectionConfig{Name: name, DSN: dsn, Mode: string(mode)})
+		}
+	}
+	for _, c := range configs {
+		mode, _ := parseConnectionMode(c.Mode)
+		if err := dbManager.AddConnection(c.Name, c.DSN, mode); err != nil {
+			if errors.Is(err, dbguard.ErrRejected) {
+				dbManager.CloseAll()
+				fmt.Println("database URL rejected:", err)
+				os.Exit(1)
+			}
+			logger.Printf("Failed to auto-connect %s: %v", c.Name, err)
 		}
 	}
 
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/mask"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
+)
+
+// maskPolicy hides sensitive columns in every row returned to the client;
+// nil disables masking
+var maskPolicy *mask.Policy
+
+// maskQueryRows masks rows of query run on database, tracing each column
+// back to the tables it was read from
+func maskQueryRows(database, query string, columns []string, rows [][]interface{}) {
+	if maskPolicy == nil || len(rows) == 0 {
+		return
+	}
+	maskPolicy.Rows(maskPolicy.Plan(database, mask.Trace(query, columns)), rows)
+}
+
+// maskTableRows masks rows read from table, a name as given by the client
+func maskTableRows(database, table string, columns []string, rows [][]interface{}) {
+	if maskPolicy == nil || len(rows) == 0 {
+		return
+	}
+	maskPolicy.Rows(tablePlan(database, table, columns), rows)
+}
+
+// maskTableMaps masks row images of table and reports whether the policy
+// masks any of their columns
+func maskTableMaps(database, table string, rows []map[string]interface{}) bool {
+	if maskPolicy == nil {
+		return false
+	}
+	var columns []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for col := range row {
+			if !seen[col] {
+				seen[col] = true
+				columns = append(columns, col)
+			}
+		}
+	}
+	plan := tablePlan(database, table, columns)
+	for _, row := range rows {
+		for i, col := range columns {
+			if _, ok := row[col]; ok && plan[i] != "" {
+				row[col] = maskPolicy.Value(plan[i], row[col])
+			}
+		}
+	}
+	return mask.Any(plan)
+}
+
+// tableMasked reports whether the policy masks any of columns of table
+func tableMasked(database, table string, columns []string) bool {
+	return maskPolicy != nil && mask.Any(tablePlan(database, table, columns))
+}
+
+// maskParams redacts statement parameters, which cannot be told apart by
+// column, when their table has masked columns
+func maskParams(params []interface{}) []interface{} {
+	masked := make([]interface{}, len(params))
+	for i, p := range params {
+		masked[i] = maskPolicy.Value(mask.Redact, p)
+	}
+	return masked
+}
+
+// columnNames returns the names of cols
+func columnNames(cols []pgvalue.Column) []string {
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.Name
+	}
+	return names
+}
+
+func tablePlan(database, table string, columns []string) []mask.Strategy {
+	schema, name := "", strings.ReplaceAll(table, `"`, "")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		schema, name = name[:i], name[i+1:]
+	}
+	return maskPolicy.Plan(database, mask.TableSources(schema, name, columns))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/audit"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/mask"
+)
+
+// withMaskPolicy masks users.email partially and password hashes to NULL
+func withMaskPolicy(t *testing.T) {
+	t.Helper()
+	p := &mask.Policy{Rules: []mask.Rule{
+		{Table: "users", Column: "email", Strategy: mask.Partial},
+		{Pattern: "password", Strategy: mask.Null},
+	}}
+	if err := p.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	prev := maskPolicy
+	maskPolicy = p
+	t.Cleanup(func() { maskPolicy = prev })
+}
+
+// userAnswer returns one user for FETCH, SELECT and RETURNING statements
+func userAnswer(query string, args []driver.NamedValue) (*fakeResult, error) {
+	if strings.HasPrefix(query, "DECLARE") || strings.HasPrefix(query, "SAVEPOINT") || strings.HasPrefix(query, "RELEASE") {
+		return nil, nil
+	}
+	return &fakeResult{
+		columns: []string{"id", "contact", "password_hash"},
+		types:   []string{"INT4", "TEXT", "TEXT"},
+		rows:    [][]driver.Value{{int64(1), "jane@example.com", "$2a$10$abc"}},
+	}, nil
+}
+
+func TestQueryResultsAreMasked(t *testing.T) {
+	withMaskPolicy(t)
+	withFakeDB(t, "primary_db", userAnswer)
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT u.id, u.email AS contact, password_hash FROM users u"}))
+	if !r.OK {
+		t.Fatalf("query failed: %+v", r)
+	}
+	row := r.Data.([]interface{})[0].(map[string]interface{})
+	if row["contact"] != "j***@example.com" || row["password_hash"] != nil || row["id"].(float64) != 1 {
+		t.Errorf("expected the traced columns to be masked: %+v", row)
+	}
+
+	// Another table's email is left alone
+	r = decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT o.id, o.email AS contact FROM orders o"}))
+	if row := r.Data.([]interface{})[0].(map[string]interface{}); row["contact"] != "jane@example.com" {
+		t.Errorf("orders.email is not in the policy: %+v", row)
+	}
+}
+
+func TestFunctionsInFromAreMasked(t *testing.T) {
+	withMaskPolicy(t)
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if strings.HasPrefix(query, "DECLARE") || strings.HasPrefix(query, "SAVEPOINT") || strings.HasPrefix(query, "RELEASE") {
+			return nil, nil
+		}
+		return &fakeResult{columns: []string{"x"}, types: []string{"TEXT"}, rows: [][]driver.Value{{"$2a$10$abc"}}}, nil
+	})
+
+	// The function's alias is not a column of users, so x cannot be traced
+	r := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT x FROM users CROSS JOIN LATERAL unnest(ARRAY[password_hash]) AS x"}))
+	if !r.OK {
+		t.Fatalf("query failed: %+v", r)
+	}
+	if row := r.Data.([]interface{})[0].(map[string]interface{}); row["x"] != nil {
+		t.Errorf("expected the strongest rule for users to mask x: %+v", row)
+	}
+}
+
+func TestReturningAndAuditLogAreMasked(t *testing.T) {
+	withMaskPolicy(t)
+	l := withAuditLog(t)
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		return &fakeResult{columns: []string{"id", "email"}, types: []string{"INT4", "TEXT"}, rows: [][]driver.Value{{int64(1), "jane@example.com"}}}, nil
+	})
+
+	r := decodeResponse(t, updateHandler(map[string]interface{}{
+		"table":     "users",
+		"data":      map[string]interface{}{"email": "jane@example.com"},
+		"where":     map[string]interface{}{"id": float64(1)},
+		"returning": true,
+	}))
+	if !r.OK {
+		t.Fatalf("update failed: %+v", r)
+	}
+	if row := r.Data.([]interface{})[0].(map[string]interface{}); row["email"] != "j***@example.com" {
+		t.Errorf("expected RETURNING to be masked: %+v", row)
+	}
+
+	// The log itself keeps the real values for undo
+	entries, _ := l.Search(audit.Query{})
+	if entries[0].After[0]["email"] != "jane@example.com" {
+		t.Errorf("the audit log must not be masked: %+v", entries[0].After)
+	}
+	log := decodeResponse(t, getAuditLogHandler(map[string]interface{}{}))
+	e := log.Data.([]interface{})[0].(map[string]interface{})
+	after := e["after"].([]interface{})[0].(map[string]interface{})
+	params := e["params"].([]interface{})
+	if after["email"] != "j***@example.com" || params[0] != mask.Redacted {
+		t.Errorf("expected get_audit_log to mask images and params: %+v", e)
+	}
+}
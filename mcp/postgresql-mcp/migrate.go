@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/migrate"
+)
+
+// migrationsDir is the directory used when a call passes no dir
+var migrationsDir string
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint PRIMARY KEY,
+    name text NOT NULL,
+    checksum text NOT NULL,
+    applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// migrationLock is a session-level advisory lock, so it is held across the
+// transaction of every migration of a run
+const migrationLock = "hashtext('schema_migrations')"
+
+func migrateStatusHandler(args map[string]interface{}) map[string]interface{} {
+	database, dir, timeoutMs, err := migrationArgs(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	migrations, err := migrate.Load(dir)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	t, err := resolveTarget(map[string]interface{}{}, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+
+	var statuses []migrate.Status
+	err = t.run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+		applied, err := appliedMigrations(ctx, q)
+		statuses = migrate.Compare(migrations, applied)
+		return err
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Migration status failed: %s", err))
+	}
+
+	version, pending := int64(0), 0
+	for _, s := range statuses {
+		if s.State == migrate.StatePending {
+			pending++
+		} else if s.Version > version {
+			version = s.Version
+		}
+	}
+	if statuses == nil {
+		statuses = []migrate.Status{}
+	}
+	count := len(statuses)
+	return okResponse(map[string]interface{}{
+		"connection": t.database,
+		"dir":        dir,
+		"version":    version,
+		"pending":    pending,
+		"migrations": statuses,
+	}, &count)
+}
+
+func migrateUpHandler(args map[string]interface{}) map[string]interface{} {
+	var target int64
+	if v, exists := args["target"]; exists {
+		if f, ok := v.(float64); ok {
+			target = int64(f)
+		}
+	}
+	return runMigrations(args, "up", func(migrations []migrate.Migration, statuses []migrate.Status) ([]migrate.Migration, error) {
+		return migrate.Up(migrations, statuses, target), nil
+	})
+}
+
+func migrateDownHandler(args map[string]interface{}) map[string]interface{} {
+	steps := 1
+	if v, exists := args["steps"]; exists {
+		if f, ok := v.(float64); ok {
+			steps = int(f)
+		}
+	}
+	if steps < 1 {
+		return errResponse("steps must be at least 1")
+	}
+	var target *int64
+	if v, exists := args["target"]; exists {
+		if f, ok := v.(float64); ok {
+			n := int64(f)
+			target = &n
+		}
+	}
+	return runMigrations(args, "down", func(migrations []migrate.Migration, statuses []migrate.Status) ([]migrate.Migration, error) {
+		return migrate.Down(migrations, statuses, steps, target)
+	})
+}
+
+// runMigrations applies the migrations plan picks in direction up or down,
+// each in its own transaction, while holding the migration lock. A dry run
+// only reports them with their SQL.
+func runMigrations(args map[string]interface{}, direction string, plan func([]migrate.Migration, []migrate.Status) ([]migrate.Migration, error)) map[string]interface{} {
+	database, dir, timeoutMs, err := migrationArgs(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	dryRun := false
+	if v, exists := args["dry_run"]; exists {
+		if b, ok := v.(bool); ok {
+			dryRun = b
+		}
+	}
+	migrations, err := migrate.Load(dir)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	t, err := resolveTarget(map[string]interface{}{}, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if !dryRun {
+		if err := checkWritable(t.database); err != nil {
+			return errResponse(err.Error())
+		}
+		if err := checkConnectionAccess(t.database, acl.DDL); err != nil {
+			return accessDeniedResponse(err)
+		}
+	}
+
+	ctx, cancel := timeoutContext(timeoutMs)
+	defer cancel()
+	conn, err := t.db.Conn(ctx)
+	if err != nil {
+		return errResponse(fmt.Sprintf("Migration failed: %s", err))
+	}
+	defer conn.Close()
+
+	if !dryRun {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock("+migrationLock+")").Scan(&locked); err != nil {
+			return errResponse(fmt.Sprintf("Migration failed: %s", err))
+		}
+		if !locked {
+			return errResponse(fmt.Sprintf("another migration is running on %s", t.database))
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock("+migrationLock+")")
+		if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+			return errResponse(fmt.Sprintf("Migration failed: %s", err))
+		}
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return errResponse(fmt.Sprintf("Migration failed: %s", err))
+	}
+	statuses := migrate.Compare(migrations, applied)
+	if err := migrate.Verify(statuses); err != nil {
+		return errResponseWithDetail(fmt.Sprintf("Migration refused: %s", err), statuses)
+	}
+	steps, err := plan(migrations, statuses)
+	if err != nil {
+		return errResponseWithDetail(fmt.Sprintf("Migration refused: %s", err), statuses)
+	}
+
+	// DDL is allowed for the whole connection, but the data a migration reads
+	// or changes is checked table by table before any of them runs
+	for _, m := range steps {
+		if err := checkStatementAccess(t.database, migrationSQL(m, direction)); err != nil {
+			return errResponseWithDetail(fmt.Sprintf("Access denied: migration %d_%s: %s", m.Version, m.Name, err), map[string]interface{}{"version": m.Version})
+		}
+	}
+
+	if dryRun {
+		preview := make([]map[string]interface{}, len(steps))
+		for i, m := range steps {
+			preview[i] = map[string]interface{}{"version": m.Version, "name": m.Name, "checksum": m.Checksum, "sql": migrationSQL(m, direction)}
+		}
+		count := len(preview)
+		return okResponse(map[string]interface{}{"dry_run": true, "direction": direction, "migrations": preview}, &count)
+	}
+
+	done := []map[string]interface{}{}
+	for _, m := range steps {
+		if err := applyMigration(ctx, conn, m, direction); err != nil {
+			return errResponseWithDetail(fmt.Sprintf("Migration %d_%s failed, later migrations were not run: %s", m.Version, m.Name, err), map[string]interface{}{
+				"direction": direction,
+				"completed": done,
+				"failed":    m.Version,
+			})
+		}
+		logger.Printf("Migrated %s %d_%s on %s", direction, m.Version, m.Name, t.database)
+		done = append(done, map[string]interface{}{"version": m.Version, "name": m.Name})
+	}
+	count := len(done)
+	return okResponse(map[string]interface{}{"direction": direction, "migrations": done}, &count)
+}
+
+// applyMigration runs one migration and records it in its own transaction
+func applyMigration(ctx context.Context, conn *sql.Conn, m migrate.Migration, direction string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migrationSQL(m, direction)); err != nil {
+		return err
+	}
+	if direction == "up" {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)", m.Version, m.Name, m.Checksum); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// migrationSQL returns the SQL of m for direction up or down
+func migrationSQL(m migrate.Migration, direction string) string {
+	if direction == "down" {
+		return m.Down
+	}
+	return m.Up
+}
+
+// appliedMigrations reads the tracking table, which may not exist yet
+func appliedMigrations(ctx context.Context, q queryer) ([]migrate.Applied, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	rows, err := q.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var applied []migrate.Applied
+	for rows.Next() {
+		var a migrate.Applied
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// migrationArgs reads the arguments every migration tool takes
+func migrationArgs(args map[string]interface{}) (string, string, *int, error) {
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+	// Migrations run with DDL rights on the whole connection, so only files
+	// the operator put under -migrations-dir are run
+	if migrationsDir == "" {
+		return "", "", nil, fmt.Errorf("migrations need -migrations-dir")
+	}
+	dir := migrationsDir
+	if d, exists := args["dir"]; exists {
+		if dirStr, ok := d.(string); ok && dirStr != "" {
+			dir = dirStr
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(migrationsDir, dir)
+			}
+			if err := checkWithin(dir, migrationsDir); err != nil {
+				return "", "", nil, fmt.Errorf("dir must be inside -migrations-dir: %w", err)
+			}
+		}
+	}
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+	return database, dir, timeoutMs, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+)
+
+// withMigrations writes three migrations and answers as if the first was applied
+// with the given checksum
+func withMigrations(t *testing.T, appliedChecksum string) (string, *fakeDriver) {
+	t.Helper()
+	dir := t.TempDir()
+	prev := migrationsDir
+	migrationsDir = dir
+	t.Cleanup(func() { migrationsDir = prev })
+	for name, body := range map[string]string{
+		"001_create_users.up.sql":   "CREATE TABLE users (id int)",
+		"001_create_users.down.sql": "DROP TABLE users",
+		"002_add_email.up.sql":      "ALTER TABLE users ADD COLUMN email text",
+		"002_add_email.down.sql":    "ALTER TABLE users DROP COLUMN email",
+		"003_add_index.up.sql":      "CREATE INDEX users_email ON users (email)",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		switch {
+		case strings.Contains(query, "pg_try_advisory_lock"), strings.Contains(query, "to_regclass"):
+			return &fakeResult{columns: []string{"ok"}, rows: [][]driver.Value{{true}}}, nil
+		case strings.HasPrefix(query, "SELECT version, name, checksum"):
+			return &fakeResult{
+				columns: []string{"version", "name", "checksum", "applied_at"},
+				rows:    [][]driver.Value{{int64(1), "create_users", appliedChecksum, time.Now()}},
+			}, nil
+		}
+		return nil, nil
+	})
+	return dir, d
+}
+
+func checksumOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMigrateStatus(t *testing.T) {
+	dir, _ := withMigrations(t, checksumOf("CREATE TABLE users (id int)"))
+
+	r := decodeResponse(t, migrateStatusHandler(map[string]interface{}{"dir": dir}))
+	if !r.OK {
+		t.Fatalf("status failed: %+v", r)
+	}
+	data := r.Data.(map[string]interface{})
+	migrations := data["migrations"].([]interface{})
+	if data["version"].(float64) != 1 || data["pending"].(float64) != 2 || migrations[0].(map[string]interface{})["state"] != "applied" {
+		t.Errorf("unexpected status: %+v", data)
+	}
+}
+
+func TestMigrateUp(t *testing.T) {
+	dir, d := withMigrations(t, checksumOf("CREATE TABLE users (id int)"))
+
+	r := decodeResponse(t, migrateUpHandler(map[string]interface{}{"dir": dir, "target": float64(2), "dry_run": true}))
+	if !r.OK || *r.RowCount != 1 {
+		t.Fatalf("dry run failed: %+v", r)
+	}
+	if preview := r.Data.(map[string]interface{})["migrations"].([]interface{})[0].(map[string]interface{}); preview["sql"] != "ALTER TABLE users ADD COLUMN email text" {
+		t.Errorf("expected the SQL of 002 in the preview: %+v", preview)
+	}
+	if containsStatement(d.statements(), "ALTER TABLE") || containsStatement(d.statements(), "pg_try_advisory_lock") {
+		t.Errorf("a dry run must not run or lock anything: %v", d.statements())
+	}
+
+	r = decodeResponse(t, migrateUpHandler(map[string]interface{}{"dir": dir}))
+	if !r.OK || *r.RowCount != 2 {
+		t.Fatalf("migrate_up failed: %+v", r)
+	}
+	stmts := d.statements()
+	want := []string{"pg_try_advisory_lock", "CREATE TABLE IF NOT EXISTS schema_migrations", "BEGIN", "ALTER TABLE users ADD COLUMN email", "INSERT INTO schema_migrations", "COMMIT", "BEGIN", "CREATE INDEX users_email", "INSERT INTO schema_migrations", "COMMIT", "pg_advisory_unlock"}
+	i := 0
+	for _, s := range stmts {
+		if i < len(want) && strings.Contains(s, want[i]) {
+			i++
+		}
+	}
+	if i != len(want) {
+		t.Errorf("expected each migration in its own transaction under the lock, missing %q in %v", want[i], stmts)
+	}
+}
+
+func TestMigrateRefusesChangedMigration(t *testing.T) {
+	dir, d := withMigrations(t, checksumOf("CREATE TABLE users (id bigint)"))
+
+	r := decodeResponse(t, migrateUpHandler(map[string]interface{}{"dir": dir}))
+	if r.OK || !strings.Contains(r.Error, "migration 1_create_users changed after it was applied") {
+		t.Errorf("expected a checksum refusal: %+v", r)
+	}
+	if containsStatement(d.statements(), "BEGIN") {
+		t.Errorf("nothing should run: %v", d.statements())
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	dir, d := withMigrations(t, checksumOf("CREATE TABLE users (id int)"))
+
+	r := decodeResponse(t, migrateDownHandler(map[string]interface{}{"dir": dir}))
+	if !r.OK || *r.RowCount != 1 {
+		t.Fatalf("migrate_down failed: %+v", r)
+	}
+	if !containsStatement(d.statements(), "DROP TABLE users") || !containsStatement(d.statements(), "DELETE FROM schema_migrations") {
+		t.Errorf("expected 001 to be reverted: %v", d.statements())
+	}
+
+	dbManager.modes["primary_db"] = connReadOnly
+	if r := decodeResponse(t, migrateDownHandler(map[string]interface{}{"dir": dir})); r.OK || !strings.Contains(r.Error, "read-only") {
+		t.Errorf("expected a read-only connection to refuse migrations: %+v", r)
+	}
+}
+
+func TestMigrateChecksTableAccess(t *testing.T) {
+	dir, d := withMigrations(t, checksumOf("CREATE TABLE users (id int)"))
+	if err := os.WriteFile(filepath.Join(dir, "004_copy_secrets.up.sql"), []byte("ALTER TABLE users ADD COLUMN token text; UPDATE users SET token = s.token FROM secrets s WHERE s.user_id = users.id"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	prev := accessPolicy
+	accessPolicy = &acl.Policy{
+		Allow: []acl.Rule{{Connection: "primary_db", Operations: []acl.Operation{acl.Read, acl.Update, acl.DDL}}},
+		Deny:  []acl.Rule{{Table: "secrets", Operations: []acl.Operation{acl.Read}}},
+	}
+	t.Cleanup(func() { accessPolicy = prev })
+
+	r := decodeResponse(t, migrateUpHandler(map[string]interface{}{"dir": dir}))
+	if r.OK || !strings.Contains(r.Error, "migration 4_copy_secrets") || !strings.Contains(r.Error, "read on public.secrets") {
+		t.Fatalf("expected the read of secrets to be denied: %+v", r)
+	}
+	if containsStatement(d.statements(), "BEGIN") {
+		t.Errorf("no migration should run when one is denied: %v", d.statements())
+	}
+}
+
+func TestMigrationsStayInMigrationsDir(t *testing.T) {
+	dir, d := withMigrations(t, checksumOf("CREATE TABLE users (id int)"))
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "elsewhere")); err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []string{t.TempDir(), "..", "elsewhere"} {
+		r := decodeResponse(t, migrateUpHandler(map[string]interface{}{"dir": other}))
+		if r.OK || !strings.Contains(r.Error, "inside -migrations-dir") {
+			t.Errorf("expected %s to be refused: %+v", other, r)
+		}
+	}
+	if len(d.statements()) != 0 {
+		t.Errorf("nothing should run: %v", d.statements())
+	}
+
+	migrationsDir = ""
+	if r := decodeResponse(t, migrateStatusHandler(map[string]interface{}{"dir": dir})); r.OK || !strings.Contains(r.Error, "need -migrations-dir") {
+		t.Errorf("expected migrations to need -migrations-dir: %+v", r)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// mutation is a validated data-modifying statement. Statements are built in
+// a fixed column order so that a dry run and the real call fingerprint alike.
+type mutation struct {
+	tool string
+	// table is the quoted table and name the table as the caller gave it
+	table string
+	name  string
+	// query is the statement without RETURNING
+	query  string
+	values []interface{}
+	// returning is the RETURNING list when rows are read, "*" when empty
+	returning string
+	// where and whereValues select the rows the statement changes, numbered
+	// from $1, for dry-run previews and audit before images
+	where       string
+	whereValues []interface{}
+	// confirm checks the affected count against confirmThreshold
+	confirm bool
+}
+
+// operation is the SQL verb of the statement
+func (m *mutation) operation() string {
+	switch verb, _, _ := strings.Cut(m.query, " "); verb {
+	case "UPDATE", "DELETE", "TRUNCATE":
+		return strings.ToLower(verb)
+	}
+	return "insert"
+}
+
+func (m *mutation) returningList() string {
+	if m.returning == "" {
+		return "*"
+	}
+	return m.returning
+}
+
+// runMutation executes mut in one transaction. Rows are read back when
+// returning is set or the change is audited. Confirmed statements are rolled
+// back when they affect more rows than the threshold allows without a
+// matching confirmation token.
+func runMutation(t *target, timeoutMs *int, mut *mutation, returning bool, token string) (*rowSet, int, error) {
+	limit := -1
+	var confirmed *int
+	if mut.confirm {
+		limit = confirmThreshold
+		if token != "" {
+			c, err := confirmManager.Check(token, mut.fingerprint(t.database))
+			if err != nil {
+				return nil, 0, err
+			}
+			if c.affected > limit {
+				limit = c.affected
+				confirmed = &c.affected
+			}
+		}
+	}
+
+	audited := auditLog != nil
+	read := returning || audited
+	query := mut.query
+	if read {
+		query += " RETURNING " + mut.returningList()
+	}
+	result := &rowSet{}
+	var count int
+	err := t.run(timeoutMs, modeAtomic, func(ctx context.Context, q queryer) error {
+		var before *rowSet
+		if audited && mut.where != "" {
+			var err error
+			if before, err = lockRows(ctx, q, mut.table, mut.where, mut.whereValues); err != nil {
+				return err
+			}
+		}
+		rows, n, err := execWrite(ctx, q, read, query, mut.values...)
+		if err != nil {
+			return err
+		}
+		if limit >= 0 && n > limit {
+			return &confirmationError{Affected: n, Threshold: confirmThreshold, Confirmed: confirmed}
+		}
+		if audited {
+			if err := recordChange(t, mut, query, n, before, rows); err != nil {
+				return err
+			}
+		}
+		count = n
+		if returning {
+			result = rows
+		}
+		return nil
+	})
+	if err == nil && token != "" {
+		confirmManager.Consume(token)
+	}
+	if err == nil {
+		// The audit log keeps the rows as they are; the client gets them masked
+		maskTableRows(t.database, mut.name, result.columns, result.rows)
+	}
+	return result, count, err
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/schemadiff"
+)
+
+// diffSide is one side of schema_diff
+type diffSide struct {
+	Connection string `json:"connection"`
+	Schema     string `json:"schema"`
+}
+
+func schemaDiffHandler(args map[string]interface{}) map[string]interface{} {
+	left := diffSide{Schema: "public"}
+	if s, ok := args["left_database"].(string); ok {
+		left.Connection = s
+	}
+	if s, ok := args["left_schema"].(string); ok && s != "" {
+		left.Schema = s
+	}
+	right := left
+	if s, ok := args["right_database"].(string); ok && s != "" {
+		right.Connection = s
+	}
+	if s, ok := args["right_schema"].(string); ok && s != "" {
+		right.Schema = s
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	var targets [2]*target
+	for i, side := range []*diffSide{&left, &right} {
+		t, err := resolveTarget(map[string]interface{}{}, side.Connection)
+		if err != nil {
+			return errResponse(err.Error())
+		}
+		defer t.release()
+		side.Connection = t.database
+		targets[i] = t
+	}
+	if left == right {
+		return errResponse("left and right are the same schema; pass right_database or right_schema")
+	}
+
+	var snapshots [2]*schemadiff.Schema
+	for i, side := range []diffSide{left, right} {
+		err := targets[i].run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+			var err error
+			snapshots[i], err = loadSchemaSnapshot(ctx, q, side.Schema)
+			return err
+		})
+		if err != nil {
+			return errResponse(fmt.Sprintf("Failed to read schema %s on %s: %s", side.Schema, side.Connection, err))
+		}
+	}
+
+	result := schemadiff.Compare(snapshots[0], snapshots[1], left.Schema)
+	summary := map[string]int{}
+	for _, c := range result.Changes {
+		summary[string(c.Action)]++
+	}
+	ddl := ""
+	if len(result.DDL) > 0 {
+		ddl = strings.Join(result.DDL, ";\n\n") + ";\n"
+	}
+	count := len(result.Changes)
+	return okResponse(map[string]interface{}{
+		"left":       left,
+		"right":      right,
+		"identical":  count == 0,
+		"summary":    summary,
+		"changes":    result.Changes,
+		"statements": result.DDL,
+		"ddl":        ddl,
+	}, &count)
+}
+
+// loadSchemaSnapshot reads tables, columns, constraints, indexes, views and
+// functions of schema. It must run in a transaction of its own: the search
+// path is set to schema alone, so the catalog prints references into it
+// unqualified and references elsewhere qualified. Partitions and objects
+// that belong to extensions are left out.
+func loadSchemaSnapshot(ctx context.Context, q queryer, schema string) (*schemadiff.Schema, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)`, schema).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("schema %s does not exist", schema)
+	}
+	if _, err := q.ExecContext(ctx, `SELECT set_config('search_path', $1, true)`, schemadiff.Ident(schema)); err != nil {
+		return nil, err
+	}
+
+	s := schemadiff.NewSchema()
+	err := scanEach(ctx, q, `
+            SELECT c.relname::text, a.attname::text, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull,
+                   CASE WHEN a.attgenerated = '' THEN COALESCE(pg_get_expr(d.adbin, d.adrelid), '') ELSE '' END,
+                   a.attidentity::text,
+                   CASE WHEN a.attgenerated = '' THEN '' ELSE pg_get_expr(d.adbin, d.adrelid) END
+            FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+            LEFT JOIN pg_attrdef d ON d.adrelid = c.oid AND d.adnum = a.attnum
+            WHERE n.nspname = $1 AND c.relkind IN ('r', 'p') AND NOT c.relispartition
+              AND NOT EXISTS (SELECT 1 FROM pg_depend dep WHERE dep.objid = c.oid AND dep.deptype = 'e')
+            ORDER BY c.relname, a.attnum
+    `, schema, func(rows *sql.Rows) error {
+		var table string
+		var col schemadiff.Column
+		if err := rows.Scan(&table, &col.Name, &col.Type, &col.Nullable, &col.Default, &col.Identity, &col.Generated); err != nil {
+			return err
+		}
+		t := s.Table(table)
+		t.Columns = append(t.Columns, col)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanEach(ctx, q, `
+            SELECT c.relname::text, con.conname::text, con.contype::text, pg_get_constraintdef(con.oid)
+            FROM pg_constraint con
+            JOIN pg_class c ON c.oid = con.conrelid
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE n.nspname = $1 AND con.contype IN ('p', 'u', 'c', 'x', 'f') AND NOT c.relispartition
+            ORDER BY c.relname, con.conname
+    `, schema, func(rows *sql.Rows) error {
+		var table string
+		var con schemadiff.Constraint
+		if err := rows.Scan(&table, &con.Name, &con.Type, &con.Definition); err != nil {
+			return err
+		}
+		if t, ok := s.Tables[table]; ok {
+			t.Constraints = append(t.Constraints, con)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Indexes backing primary key, unique and exclusion constraints come with the constraint
+	err = scanEach(ctx, q, `
+            SELECT c.relname::text, i.relname::text, pg_get_indexdef(i.oid)
+            FROM pg_index x
+            JOIN pg_class i ON i.oid = x.indexrelid
+            JOIN pg_class c ON c.oid = x.indrelid
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE n.nspname = $1 AND NOT c.relispartition
+              AND NOT EXISTS (SELECT 1 FROM pg_constraint con
+                              WHERE con.conindid = x.indexrelid AND con.conrelid = x.indrelid AND con.contype IN ('p', 'u', 'x'))
+            ORDER BY c.relname, i.relname
+    `, schema, func(rows *sql.Rows) error {
+		var table string
+		var ix schemadiff.Index
+		if err := rows.Scan(&table, &ix.Name, &ix.Definition); err != nil {
+			return err
+		}
+		if t, ok := s.Tables[table]; ok {
+			ix.Definition = schemadiff.Unqualify(ix.Definition, schema)
+			t.Indexes = append(t.Indexes, ix)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanEach(ctx, q, `
+            SELECT c.relname::text, c.relkind = 'm', pg_get_viewdef(c.oid)
+            FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE n.nspname = $1 AND c.relkind IN ('v', 'm')
+              AND NOT EXISTS (SELECT 1 FROM pg_depend dep WHERE dep.objid = c.oid AND dep.deptype = 'e')
+            ORDER BY c.relname
+    `, schema, func(rows *sql.Rows) error {
+		var v schemadiff.View
+		if err := rows.Scan(&v.Name, &v.Materialized, &v.Definition); err != nil {
+			return err
+		}
+		s.Views[v.Name] = &v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanEach(ctx, q, `
+            SELECT p.proname::text || '(' || pg_get_function_identity_arguments(p.oid) || ')', pg_get_functiondef(p.oid)
+            FROM pg_proc p
+            JOIN pg_namespace n ON n.oid = p.pronamespace
+            WHERE n.nspname = $1 AND p.prokind IN ('f', 'p')
+              AND NOT EXISTS (SELECT 1 FROM pg_depend dep WHERE dep.objid = p.oid AND dep.deptype = 'e')
+            ORDER BY 1
+    `, schema, func(rows *sql.Rows) error {
+		var f schemadiff.Function
+		if err := rows.Scan(&f.Signature, &f.Definition); err != nil {
+			return err
+		}
+		f.Definition = schemadiff.Unqualify(f.Definition, schema)
+		s.Functions[f.Signature] = &f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// scanEach runs a catalog query with one argument and calls scan for each row
+func scanEach(ctx context.Context, q queryer, query string, arg interface{}, scan func(*sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
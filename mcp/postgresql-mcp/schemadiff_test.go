@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// schemaCatalogAnswer answers the schema_diff catalog queries with one users table
+// whose email column has the given type
+func schemaCatalogAnswer(emailType string) func(string, []driver.NamedValue) (*fakeResult, error) {
+	return func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		switch {
+		case strings.Contains(query, "FROM pg_namespace WHERE nspname"):
+			return &fakeResult{columns: []string{"exists"}, rows: [][]driver.Value{{true}}}, nil
+		case strings.Contains(query, "format_type(a.atttypid"):
+			return &fakeResult{
+				columns: []string{"table", "column", "type", "nullable", "default", "identity", "generated"},
+				rows: [][]driver.Value{
+					{"users", "id", "integer", false, "", "a", ""},
+					{"users", "email", emailType, true, "", "", ""},
+				},
+			}, nil
+		case strings.Contains(query, "pg_get_constraintdef"):
+			return &fakeResult{
+				columns: []string{"table", "name", "type", "definition"},
+				rows:    [][]driver.Value{{"users", "users_pkey", "p", "PRIMARY KEY (id)"}},
+			}, nil
+		case strings.Contains(query, "pg_get_indexdef"):
+			schema := args[0].Value.(string)
+			return &fakeResult{
+				columns: []string{"table", "name", "definition"},
+				rows:    [][]driver.Value{{"users", "users_email", "CREATE INDEX users_email ON " + schema + ".users USING btree (email)"}},
+			}, nil
+		}
+		return nil, nil
+	}
+}
+
+func TestSchemaDiff(t *testing.T) {
+	withFakeDB(t, "local_db", schemaCatalogAnswer("text"))
+	d := addFakeDB(t, "primary_db", schemaCatalogAnswer("character varying(255)"))
+
+	r := decodeResponse(t, schemaDiffHandler(map[string]interface{}{"left_database": "local_db", "right_database": "primary_db"}))
+	if !r.OK || *r.RowCount != 1 {
+		t.Fatalf("schema_diff failed: %+v", r)
+	}
+	data := r.Data.(map[string]interface{})
+	change := data["changes"].([]interface{})[0].(map[string]interface{})
+	if change["kind"] != "column" || change["action"] != "alter" || change["name"] != "email" {
+		t.Errorf("expected only the email type to differ: %+v", change)
+	}
+	if ddl := data["ddl"].(string); !strings.Contains(ddl, "ALTER TABLE users ALTER COLUMN email TYPE character varying(255) USING email::character varying(255);") {
+		t.Errorf("unexpected DDL:\n%s", ddl)
+	}
+	if !containsStatement(d.statements(), "BEGIN READ ONLY") {
+		t.Errorf("the catalog should be read in a read-only transaction: %v", d.statements())
+	}
+}
+
+func TestSchemaDiffAcrossSchemas(t *testing.T) {
+	withFakeDB(t, "primary_db", schemaCatalogAnswer("text"))
+
+	// The index definitions differ only in the schema qualifier
+	r := decodeResponse(t, schemaDiffHandler(map[string]interface{}{"left_schema": "public", "right_schema": "staging"}))
+	if !r.OK || *r.RowCount != 0 || r.Data.(map[string]interface{})["identical"] != true {
+		t.Errorf("expected identical schemas: %+v", r)
+	}
+
+	if r := decodeResponse(t, schemaDiffHandler(map[string]interface{}{})); r.OK {
+		t.Errorf("expected comparing a schema with itself to fail: %+v", r)
+	}
+}
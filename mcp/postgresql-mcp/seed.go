@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/seed"
+)
+
+const (
+	defaultSeedRows = 10
+	maxSeedRows     = 1000000
+	// maxSeedReferences bounds the existing rows loaded for a foreign key to
+	// a table that is not generated
+	maxSeedReferences = 1000
+)
+
+// seedDir is the directory sql output is written in
+var seedDir string
+
+// defaultSeedUntil is the latest generated timestamp unless a call passes
+// until, so that the seed alone reproduces the values
+var defaultSeedUntil = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Seed outputs
+const (
+	seedInsert = "insert"
+	seedSQL    = "sql"
+)
+
+// seedRequest is one table to fill
+type seedRequest struct {
+	table string
+	rows  int
+}
+
+func generateSeedDataHandler(args map[string]interface{}) map[string]interface{} {
+	rows := defaultSeedRows
+	if r, exists := args["rows"]; exists {
+		if rFloat, ok := r.(float64); ok {
+			rows = int(rFloat)
+		}
+	}
+	list, ok := args["tables"].([]interface{})
+	if !ok || len(list) == 0 {
+		return errResponse("tables must be a non-empty array")
+	}
+	var requests []seedRequest
+	seen := map[string]bool{}
+	for i, item := range list {
+		r := seedRequest{rows: rows}
+		switch x := item.(type) {
+		case string:
+			r.table = x
+		case map[string]interface{}:
+			r.table, _ = x["table"].(string)
+			if r.table == "" {
+				return errResponse(fmt.Sprintf("tables[%d]: table is required", i))
+			}
+			if n, ok := x["rows"].(float64); ok {
+				r.rows = int(n)
+			}
+		default:
+			return errResponse(fmt.Sprintf("tables[%d]: must be a table name or {table, rows}", i))
+		}
+		if r.rows < 1 || r.rows > maxSeedRows {
+			return errResponse(fmt.Sprintf("tables[%d]: rows must be between 1 and %d", i, maxSeedRows))
+		}
+		schema, name := splitTableName(r.table)
+		if seen[schema+"."+name] {
+			return errResponse(fmt.Sprintf("tables[%d]: %s.%s is listed twice", i, schema, name))
+		}
+		seen[schema+"."+name] = true
+		requests = append(requests, r)
+	}
+
+	opts := seed.Options{Seed: time.Now().UnixNano(), Until: defaultSeedUntil}
+	if s, exists := args["seed"]; exists {
+		if sFloat, ok := s.(float64); ok {
+			opts.Seed = int64(sFloat)
+		}
+	}
+	if u, ok := args["until"].(string); ok && u != "" {
+		until, err := time.Parse(time.RFC3339, u)
+		if err != nil {
+			if until, err = time.Parse("2006-01-02", u); err != nil {
+				return errResponse(fmt.Sprintf("invalid until %q: use RFC 3339 or YYYY-MM-DD", u))
+			}
+		}
+		opts.Until = until.UTC()
+	}
+
+	output := seedInsert
+	if o, ok := args["output"].(string); ok && o != "" {
+		output = strings.ToLower(o)
+	}
+	file, _ := args["file"].(string)
+	overwrite, _ := args["overwrite"].(bool)
+	switch output {
+	case seedInsert:
+	case seedSQL:
+		if file == "" {
+			return errResponse("file is required for sql output")
+		}
+		if seedDir == "" {
+			return errResponse("sql output needs -seed-dir")
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(seedDir, file)
+		}
+		if err := checkWithin(file, seedDir); err != nil {
+			return errResponse(fmt.Sprintf("invalid file: %s", err))
+		}
+		if _, err := os.Stat(file); err == nil && !overwrite {
+			return errResponse(fmt.Sprintf("file %s already exists; pass overwrite to replace it", file))
+		}
+	default:
+		return errResponse(fmt.Sprintf("unsupported output %q (use insert or sql)", output))
+	}
+	batchSize := defaultBulkBatchSize
+	if b, exists := args["batch_size"]; exists {
+		if bFloat, ok := b.(float64); ok && bFloat > 0 {
+			batchSize = int(bFloat)
+		}
+	}
+
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	t, err := resolveTarget(map[string]interface{}{}, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	op, mode := acl.Read, modeReadOnly
+	if output == seedInsert {
+		if err := checkWritable(t.database); err != nil {
+			return errResponse(err.Error())
+		}
+		op, mode = acl.Insert, modeAtomic
+	}
+	for _, r := range requests {
+		if err := checkAccess(t.database, r.table, op); err != nil {
+			return accessDeniedResponse(err)
+		}
+	}
+
+	// Inserted rows are generated in the transaction that inserts them, so
+	// that the keys they continue from cannot change in between
+	var results []*seed.Result
+	err = t.run(timeoutMs, mode, func(ctx context.Context, q queryer) error {
+		tables, err := seedTables(ctx, q, t.database, requests)
+		if err != nil {
+			return err
+		}
+		if results, err = seed.Generate(tables, opts); err != nil {
+			return err
+		}
+		if output == seedSQL {
+			return nil
+		}
+		for _, r := range results {
+			if err := insertSeedRows(ctx, q, t, r, batchSize); err != nil {
+				return fmt.Errorf("%s: %w", r.Table.QualifiedName(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var aerr *acl.Error
+		if errors.As(err, &aerr) {
+			return accessDeniedResponse(err)
+		}
+		return errResponse(fmt.Sprintf("Seed data generation failed: %s", err))
+	}
+	if output == seedSQL {
+		if err := os.WriteFile(file, []byte(seed.Script(results, batchSize)), 0o600); err != nil {
+			return errResponse(err.Error())
+		}
+	}
+
+	count := 0
+	tables := make([]map[string]interface{}, len(results))
+	for i, r := range results {
+		count += len(r.Rows)
+		tables[i] = map[string]interface{}{"table": r.Table.QualifiedName(), "rows": len(r.Rows), "columns": r.Columns}
+	}
+	data := map[string]interface{}{
+		"connection": t.database,
+		"seed":       opts.Seed,
+		"until":      opts.Until.Format(time.RFC3339),
+		"output":     output,
+		"tables":     tables,
+	}
+	if output == seedSQL {
+		data["file"] = file
+	}
+	return okResponse(data, &count)
+}
+
+// seedTables reads the columns, keys and row counts of the requested tables.
+// Serial columns and single-column unique integers continue from the largest
+// value in the table, and foreign keys to tables that are not generated
+// reference existing rows, which need read access on database.
+func seedTables(ctx context.Context, q queryer, database string, requests []seedRequest) ([]*seed.Table, error) {
+	var tables []*seed.Table
+	var infos []*TableInfo
+	generated := map[string]bool{}
+	for _, r := range requests {
+		schema, name := splitTableName(r.table)
+		info, err := describeTable(ctx, q, schema, name)
+		if err != nil {
+			return nil, err
+		}
+		table := &seed.Table{Schema: info.Schema, Name: info.Name, Rows: r.rows}
+		if info.PrimaryKey != nil {
+			table.Unique = append(table.Unique, info.PrimaryKey.Columns)
+		}
+		for _, u := range info.UniqueConstraints {
+			table.Unique = append(table.Unique, u.Columns)
+		}
+		for _, col := range info.Columns {
+			table.Columns = append(table.Columns, seed.Column{
+				Name:       col.Name,
+				Type:       col.DataType,
+				TypeName:   col.TypeName,
+				Nullable:   col.Nullable,
+				HasDefault: col.Default != nil,
+				Serial:     col.Identity != "" || (col.Default != nil && strings.HasPrefix(*col.Default, "nextval(")),
+				Generated:  col.Generated != "",
+				Enum:       col.EnumValues,
+			})
+		}
+		for _, fk := range info.ForeignKeys {
+			table.ForeignKeys = append(table.ForeignKeys, seed.ForeignKey{
+				Columns:    fk.Columns,
+				RefTable:   fk.RefSchema + "." + fk.RefTable,
+				RefColumns: fk.RefColumns,
+			})
+		}
+		generated[table.QualifiedName()] = true
+		tables = append(tables, table)
+		infos = append(infos, info)
+	}
+
+	for i, table := range tables {
+		quoted, err := qIdent(table.QualifiedName())
+		if err != nil {
+			return nil, err
+		}
+		if err := q.QueryRowContext(ctx, "SELECT count(*) FROM "+quoted).Scan(&table.Offset); err != nil {
+			return nil, fmt.Errorf("%s: %w", table.QualifiedName(), err)
+		}
+		for j := range table.Columns {
+			col := &table.Columns[j]
+			if !isIntegerType(col.TypeName) || col.Generated {
+				continue
+			}
+			if !col.Serial && (col.HasDefault || !uniqueColumn(infos[i], col.Name)) {
+				continue
+			}
+			quotedCol, err := validateIdentifier(col.Name)
+			if err != nil {
+				return nil, err
+			}
+			query := fmt.Sprintf("SELECT COALESCE(max(%s), 0) FROM %s", quotedCol, quoted)
+			var max int64
+			if err := q.QueryRowContext(ctx, query).Scan(&max); err != nil {
+				return nil, fmt.Errorf("%s: %w", table.QualifiedName(), err)
+			}
+			col.Start = max + 1
+		}
+		for j := range table.ForeignKeys {
+			fk := &table.ForeignKeys[j]
+			if generated[fk.RefTable] && fk.RefTable != table.QualifiedName() {
+				continue
+			}
+			if err := checkAccess(database, fk.RefTable, acl.Read); err != nil {
+				return nil, err
+			}
+			existing, err := existingReferences(ctx, q, fk)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", table.QualifiedName(), err)
+			}
+			fk.Existing = existing
+		}
+	}
+	return tables, nil
+}
+
+// existingReferences loads up to maxSeedReferences rows of the columns fk
+// references, in their text form
+func existingReferences(ctx context.Context, q queryer, fk *seed.ForeignKey) ([][]interface{}, error) {
+	quoted, err := qIdent(fk.RefTable)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(fk.RefColumns))
+	selects := make([]string, len(fk.RefColumns))
+	for i, col := range fk.RefColumns {
+		if cols[i], err = validateIdentifier(col); err != nil {
+			return nil, err
+		}
+		selects[i] = cols[i] + "::text"
+	}
+	query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s ORDER BY %s LIMIT %d",
+		strings.Join(selects, ", "), quoted,
+		strings.Join(cols, " IS NOT NULL AND ")+" IS NOT NULL", strings.Join(selects, ", "), maxSeedReferences)
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var existing [][]interface{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(cols))
+		for i, v := range values {
+			row[i] = v.String
+		}
+		existing = append(existing, row)
+	}
+	return existing, rows.Err()
+}
+
+// insertSeedRows inserts the rows of r with multi-row INSERTs and moves the
+// sequences of serial and identity columns past the inserted values
+func insertSeedRows(ctx context.Context, q queryer, t *target, r *seed.Result, batchSize int) error {
+	if len(r.Rows) == 0 {
+		return nil
+	}
+	quoted, err := qIdent(r.Table.QualifiedName())
+	if err != nil {
+		return err
+	}
+	cols := make([]string, len(r.Columns))
+	for i, col := range r.Columns {
+		if cols[i], err = validateIdentifier(col); err != nil {
+			return err
+		}
+	}
+	serial := r.Serial()
+	insert := fmt.Sprintf("INSERT INTO %s (%s) ", quoted, strings.Join(cols, ", "))
+	if len(serial) > 0 {
+		insert += "OVERRIDING SYSTEM VALUE "
+	}
+	insert += "VALUES "
+	if limit := maxBindParams / len(cols); batchSize > limit {
+		batchSize = limit
+	}
+
+	for start := 0; start < len(r.Rows); start += batchSize {
+		end := start + batchSize
+		if end > len(r.Rows) {
+			end = len(r.Rows)
+		}
+		tuples := make([]string, 0, end-start)
+		values := make([]interface{}, 0, (end-start)*len(cols))
+		for _, row := range r.Rows[start:end] {
+			placeholders := make([]string, len(row))
+			for i, v := range row {
+				values = append(values, v)
+				placeholders[i] = fmt.Sprintf("$%d", len(values))
+			}
+			tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+		}
+		if _, err := q.ExecContext(ctx, insert+strings.Join(tuples, ", "), values...); err != nil {
+			return fmt.Errorf("rows %d-%d: %w", start+1, end, err)
+		}
+	}
+
+	for _, col := range serial {
+		quotedCol, _ := validateIdentifier(col)
+		query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(max(%s), 0) + 1, false) FROM %s", quotedCol, quoted)
+		if _, err := q.ExecContext(ctx, query, quoted, col); err != nil {
+			return fmt.Errorf("sequence of %s: %w", col, err)
+		}
+	}
+
+	if auditLog != nil {
+		// Generated rows are recorded without parameters or row images, like bulk loads
+		query := insert + "..."
+		if err := recordChange(t, &mutation{tool: "generate_seed_data", table: quoted, name: r.Table.QualifiedName(), query: query}, query, len(r.Rows), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIntegerType reports whether typeName is a PostgreSQL integer type
+func isIntegerType(typeName string) bool {
+	return typeName == "int2" || typeName == "int4" || typeName == "int8"
+}
+
+// uniqueColumn reports whether col alone is the primary key or a unique constraint of info
+func uniqueColumn(info *TableInfo, col string) bool {
+	if info.PrimaryKey != nil && len(info.PrimaryKey.Columns) == 1 && info.PrimaryKey.Columns[0] == col {
+		return true
+	}
+	for _, u := range info.UniqueConstraints {
+		if len(u.Columns) == 1 && u.Columns[0] == col {
+			return true
+		}
+	}
+	return false
+}
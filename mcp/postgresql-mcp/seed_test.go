@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+)
+
+// seedAnswer serves the users table of catalogAnswer with three rows
+func seedAnswer(query string, args []driver.NamedValue) (*fakeResult, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT count(*)"):
+		return &fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(3)}}}, nil
+	case strings.HasPrefix(query, `SELECT COALESCE(max("id"), 0)`):
+		return &fakeResult{columns: []string{"coalesce"}, rows: [][]driver.Value{{int64(3)}}}, nil
+	case strings.HasPrefix(query, `SELECT DISTINCT "id"::text`):
+		return &fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{"1"}, {"2"}, {"3"}}}, nil
+	}
+	return catalogAnswer(query, args)
+}
+
+// withSeedDir sets seedDir to a new directory for one test
+func withSeedDir(t *testing.T) {
+	t.Helper()
+	prev := seedDir
+	seedDir = t.TempDir()
+	t.Cleanup(func() { seedDir = prev })
+}
+
+func TestGenerateSeedData(t *testing.T) {
+	d := withFakeDB(t, "app", seedAnswer)
+
+	r := decodeResponse(t, generateSeedDataHandler(map[string]interface{}{"tables": []interface{}{"users"}, "rows": 5.0, "seed": 7.0}))
+	if !r.OK || *r.RowCount != 5 {
+		t.Fatalf("generation failed: %+v", r)
+	}
+	if data := r.Data.(map[string]interface{}); data["seed"] != 7.0 || data["until"] != "2025-01-01T00:00:00Z" {
+		t.Errorf("expected the seed and until to be reported: %v", data)
+	}
+	want := []string{"BEGIN", `INSERT INTO "public"."users" ("id", "email", "role", "manager_id") OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)`, "SELECT setval(pg_get_serial_sequence($1, $2)", "COMMIT"}
+	i := 0
+	for _, s := range d.statements() {
+		if i < len(want) && strings.Contains(s, want[i]) {
+			i++
+		}
+	}
+	if i != len(want) {
+		t.Errorf("expected the rows to be inserted in one transaction, missing %q in %v", want[i], d.statements())
+	}
+
+	withSeedDir(t)
+	file := filepath.Join(seedDir, "users.sql")
+	args := map[string]interface{}{"tables": []interface{}{map[string]interface{}{"table": "users", "rows": 2.0}}, "seed": 7.0, "output": "sql", "file": "users.sql"}
+	if r := decodeResponse(t, generateSeedDataHandler(args)); !r.OK || *r.RowCount != 2 {
+		t.Fatalf("sql output failed: %+v", r)
+	}
+	if !containsStatement(d.statements(), "BEGIN READ ONLY") {
+		t.Errorf("expected sql output to only read: %v", d.statements())
+	}
+	script, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), "OVERRIDING SYSTEM VALUE VALUES\n  (4, '") || !strings.Contains(string(script), "@example.com'") {
+		t.Errorf("expected ids after the existing rows and email addresses:\n%s", script)
+	}
+
+	if r := decodeResponse(t, generateSeedDataHandler(args)); r.OK || !strings.Contains(r.Error, "already exists") {
+		t.Errorf("expected an existing file to be kept: %+v", r)
+	}
+	args["overwrite"] = true
+	if r := decodeResponse(t, generateSeedDataHandler(args)); !r.OK {
+		t.Fatalf("overwrite failed: %+v", r)
+	}
+	again, _ := os.ReadFile(file)
+	if string(again) != string(script) {
+		t.Errorf("expected the same seed to write the same script:\n%s", again)
+	}
+
+	dbManager.modes["app"] = connReadOnly
+	if r := decodeResponse(t, generateSeedDataHandler(map[string]interface{}{"tables": []interface{}{"users"}})); r.OK || !strings.Contains(r.Error, "read-only") {
+		t.Errorf("expected a read-only connection to refuse inserts: %+v", r)
+	}
+	if r := decodeResponse(t, generateSeedDataHandler(args)); !r.OK {
+		t.Errorf("expected sql output on a read-only connection: %+v", r)
+	}
+}
+
+func TestGenerateSeedDataArgs(t *testing.T) {
+	withFakeDB(t, "app", seedAnswer)
+	for _, tc := range []struct {
+		args map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{}, "tables must be"},
+		{map[string]interface{}{"tables": []interface{}{"users", "public.users"}}, "listed twice"},
+		{map[string]interface{}{"tables": []interface{}{"users"}, "rows": 0.0}, "rows must be"},
+		{map[string]interface{}{"tables": []interface{}{"users"}, "output": "sql"}, "file is required"},
+		{map[string]interface{}{"tables": []interface{}{"users"}, "until": "soon"}, "invalid until"},
+		{map[string]interface{}{"tables": []interface{}{"users"}, "output": "sql", "file": "users.sql"}, "needs -seed-dir"},
+	} {
+		if r := decodeResponse(t, generateSeedDataHandler(tc.args)); r.OK || !strings.Contains(r.Error, tc.want) {
+			t.Errorf("%v: expected %q, got %+v", tc.args, tc.want, r)
+		}
+	}
+}
+
+func TestGenerateSeedDataChecksReferencedTables(t *testing.T) {
+	d := withFakeDB(t, "app", seedAnswer)
+	prev := accessPolicy
+	accessPolicy = &acl.Policy{Allow: []acl.Rule{{Connection: "app", Operations: []acl.Operation{acl.Insert}}}}
+	t.Cleanup(func() { accessPolicy = prev })
+
+	// manager_id references users, whose existing ids would be read
+	r := decodeResponse(t, generateSeedDataHandler(map[string]interface{}{"tables": []interface{}{"users"}}))
+	if r.OK || !strings.Contains(r.Error, "Access denied: read on public.users") {
+		t.Fatalf("expected reading the referenced table to be denied: %+v", r)
+	}
+	if containsStatement(d.statements(), "SELECT DISTINCT") || containsStatement(d.statements(), "INSERT INTO") {
+		t.Errorf("nothing should be read or inserted: %v", d.statements())
+	}
+}
+
+func TestSeedFileStaysInSeedDir(t *testing.T) {
+	withFakeDB(t, "app", seedAnswer)
+	withSeedDir(t)
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(seedDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(seedDir, "dangling.sql")); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"../users.sql", filepath.Join(outside, "users.sql"), "link/users.sql", "dangling.sql"} {
+		r := decodeResponse(t, generateSeedDataHandler(map[string]interface{}{"tables": []interface{}{"users"}, "output": "sql", "file": file, "overwrite": true}))
+		if r.OK || !strings.Contains(r.Error, "invalid file") {
+			t.Errorf("expected %s to be refused: %+v", file, r)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("nothing may be written outside -seed-dir: %v", entries)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+)
+
+// whereDescription documents the filter accepted by select, update and delete
+const whereDescription = `Filter: {"column": "age", "op": ">=", "value": 18} with op one of =, !=, <, <=, >, >=, in, not_in, like, not_like, ilike, not_ilike, between, not_between, is_null, is_not_null; ` +
+	`an optional "type" converts the value like a typed parameter. Combine with {"and": [...]}, {"or": [...]}, {"not": {...}} or an array (all must hold). ` +
+	`The shorthand {"id": 1, "status": ["a", "b"]} matches each column by equality or IN.`
+
+func selectHandler(args map[string]interface{}) map[string]interface{} {
+	table, ok := args["table"].(string)
+	if !ok {
+		return errResponse("table is required")
+	}
+
+	columns, err := stringList(args, "columns")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	// order_by items are column names or {"column": ..., "desc": true}
+	var orderBy []interface{}
+	if o, exists := args["order_by"]; exists {
+		if oList, ok := o.([]interface{}); ok {
+			orderBy = oList
+		} else {
+			return errResponse("order_by must be an array")
+		}
+	}
+
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+
+	var limit, offset int
+	if l, exists := args["limit"]; exists {
+		if limitFloat, ok := l.(float64); ok {
+			limit = int(limitFloat)
+		}
+	}
+	if o, exists := args["offset"]; exists {
+		if offsetFloat, ok := o.(float64); ok {
+			offset = int(offsetFloat)
+		}
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	idleTimeout := defaultCursorIdleTimeout
+	if t, exists := args["cursor_idle_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok && timeoutFloat > 0 {
+			idleTimeout = time.Duration(timeoutFloat) * time.Millisecond
+		}
+	}
+
+	outputFormat, err := formatArg(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	query, values, err := buildSelect(table, columns, args["where"], orderBy, limit, offset)
+	if err != nil {
+		var errs pgparam.Errors
+		if errors.As(err, &errs) {
+			return invalidParamsResponse(err)
+		}
+		return errResponse(err.Error())
+	}
+
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkAccess(t.database, table, acl.Read); err != nil {
+		return accessDeniedResponse(err)
+	}
+
+	return pagedQuery(t, outputFormat, query, values, 0, pageSizeArg(args), idleTimeout, timeoutMs)
+}
+
+// buildSelect validates every identifier and returns the SELECT statement
+// with its filter values. A nil where selects every row.
+func buildSelect(table string, columns []string, where interface{}, orderBy []interface{}, limit, offset int) (string, []interface{}, error) {
+	quotedTable, err := qIdent(table)
+	if err != nil {
+		return "", nil, err
+	}
+
+	selectList := "*"
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i, col := range columns {
+			if quoted[i], err = qIdent(col); err != nil {
+				return "", nil, err
+			}
+		}
+		selectList = strings.Join(quoted, ", ")
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList, quotedTable)
+
+	var values []interface{}
+	if where != nil {
+		var whereClause string
+		if whereClause, values, err = compileWhere(where, nil); err != nil {
+			var errs pgparam.Errors
+			if errors.As(err, &errs) {
+				return "", nil, err
+			}
+			return "", nil, fmt.Errorf("Invalid where: %w", err)
+		}
+		query += " WHERE " + whereClause
+	}
+
+	if len(orderBy) > 0 {
+		keys := make([]string, len(orderBy))
+		for i, item := range orderBy {
+			column, desc := "", false
+			switch o := item.(type) {
+			case string:
+				column = o
+			case map[string]interface{}:
+				column, _ = o["column"].(string)
+				desc, _ = o["desc"].(bool)
+			}
+			if column == "" {
+				return "", nil, fmt.Errorf("order_by[%d] must be a column name or an object with column", i)
+			}
+			quoted, err := qIdent(column)
+			if err != nil {
+				return "", nil, err
+			}
+			keys[i] = quoted
+			if desc {
+				keys[i] += " DESC"
+			}
+		}
+		query += " ORDER BY " + strings.Join(keys, ", ")
+	}
+
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	if offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	return query, values, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestSelectBuildsFilteredQuery(t *testing.T) {
+	var bound []driver.NamedValue
+	answer := cursorAnswer(3)
+	d := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if strings.HasPrefix(query, "DECLARE ") {
+			bound = args
+		}
+		return answer(query, args)
+	})
+
+	r := decodeResponse(t, selectHandler(map[string]interface{}{
+		"table":   "public.users",
+		"columns": []interface{}{"id", "email"},
+		"where": map[string]interface{}{"or": []interface{}{
+			map[string]interface{}{"column": "status", "op": "in", "value": []interface{}{"active", "trial"}},
+			map[string]interface{}{"column": "created_at", "op": ">=", "type": "date", "value": "2025-01-01"},
+		}},
+		"order_by":  []interface{}{map[string]interface{}{"column": "created_at", "desc": true}, "id"},
+		"limit":     float64(10),
+		"page_size": float64(2),
+	}))
+	if !r.OK || *r.RowCount != 2 || r.Cursor == "" {
+		t.Fatalf("unexpected first page: %+v", r)
+	}
+	want := `SELECT "id", "email" FROM "public"."users" WHERE ("status" IN ($1, $2) OR "created_at" >= $3) ORDER BY "created_at" DESC, "id" LIMIT 10`
+	if !containsStatement(d.statements(), want) {
+		t.Errorf("expected %s to be declared: %v", want, d.statements())
+	}
+	if len(bound) != 3 || bound[0].Value != "active" || bound[2].Value != "2025-01-01" {
+		t.Errorf("unexpected bound values: %+v", bound)
+	}
+}
+
+func TestSelectRejectsBadFilter(t *testing.T) {
+	d := withFakeDB(t, "primary_db", nil)
+
+	for _, args := range []map[string]interface{}{
+		{"table": "users", "where": map[string]interface{}{"column": "id", "op": "matches", "value": "x"}},
+		{"table": "users", "where": map[string]interface{}{"column": "id\"; DROP TABLE users; --", "value": float64(1)}},
+		{"table": "users", "order_by": []interface{}{map[string]interface{}{"desc": true}}},
+		{"table": "users", "where": map[string]interface{}{"column": "id", "type": "int", "value": "one"}},
+	} {
+		if r := decodeResponse(t, selectHandler(args)); r.OK {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+	if len(d.statements()) != 0 {
+		t.Errorf("expected nothing to run, got %v", d.statements())
+	}
+}
+
+func TestUpdateCompilesFilterAfterSetValues(t *testing.T) {
+	var query string
+	var bound []driver.NamedValue
+	withFakeDB(t, "primary_db", func(q string, args []driver.NamedValue) (*fakeResult, error) {
+		query, bound = q, args
+		return &fakeResult{affected: 4}, nil
+	})
+
+	r := decodeResponse(t, updateHandler(map[string]interface{}{
+		"table": "orders",
+		"data":  map[string]interface{}{"status": "archived"},
+		"where": []interface{}{
+			map[string]interface{}{"column": "placed_at", "op": "between", "type": "timestamptz", "value": []interface{}{"2024-01-01T00:00:00Z", "2024-12-31T23:59:59Z"}},
+			map[string]interface{}{"not": map[string]interface{}{"column": "status", "op": "=", "value": "open"}},
+		},
+	}))
+	if !r.OK || *r.RowCount != 4 {
+		t.Fatalf("update failed: %+v", r)
+	}
+	want := `UPDATE "orders" SET "status" = $1 WHERE ("placed_at" BETWEEN $2 AND $3 AND NOT ("status" = $4))`
+	if query != want || len(bound) != 4 || bound[3].Value != "open" {
+		t.Errorf("unexpected statement %s with %+v", query, bound)
+	}
+
+	r = decodeResponse(t, deleteHandler(map[string]interface{}{
+		"table": "orders",
+		"where": map[string]interface{}{"id": nil},
+	}))
+	if r.OK || !strings.Contains(r.Error, "use is_null") {
+		t.Errorf("expected a NULL equality to be rejected: %+v", r)
+	}
+}
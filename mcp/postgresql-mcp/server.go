@@ -13,6 +13,8 @@ type MCPServer struct {
 	name    string
 	version string
 	tools   map[string]Tool
+	// visible hides tools from tools/list and tools/call when it returns false
+	visible func(name string) bool
 }
 
 type Tool struct {
@@ -39,6 +41,11 @@ func (s *MCPServer) AddTool(name, description string, schema map[string]interfac
 	}
 }
 
+// SetToolFilter limits the tools the server offers to those visible accepts
+func (s *MCPServer) SetToolFilter(visible func(name string) bool) {
+	s.visible = visible
+}
+
 func (s *MCPServer) Serve() error {
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
@@ -98,6 +105,9 @@ func (s *MCPServer) handleInitialize(request map[string]interface{}) {
 func (s *MCPServer) handleToolsList(request map[string]interface{}) {
 	var tools []map[string]interface{}
 	for _, tool := range s.tools {
+		if s.visible != nil && !s.visible(tool.name) {
+			continue
+		}
 		tools = append(tools, map[string]interface{}{
 			"name":        tool.name,
 			"description": tool.description,
@@ -129,7 +139,7 @@ func (s *MCPServer) handleToolsCall(request map[string]interface{}) {
 	}
 
 	tool, exists := s.tools[name]
-	if !exists {
+	if !exists || (s.visible != nil && !s.visible(name)) {
 		s.sendError(-32602, "Tool not found", request["id"])
 		return
 	}
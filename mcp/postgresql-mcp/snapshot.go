@@ -0,0 +1,623 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/snapshot"
+)
+
+// snapshotsDir is the directory snapshots are kept in when a call passes no dir
+var snapshotsDir string
+
+// snapshotsAnyDir lets calls pass a dir outside snapshotsDir
+var snapshotsAnyDir bool
+
+const defaultRestoreBatchSize = 500
+
+// Restore modes
+const (
+	restoreTruncate = "truncate"
+	restoreUpsert   = "upsert"
+)
+
+// snapshotRequest is one table to snapshot
+type snapshotRequest struct {
+	table string
+	// where is the filter as given, compiled into clause and values
+	where  interface{}
+	clause string
+	values []interface{}
+}
+
+func snapshotTablesHandler(args map[string]interface{}) map[string]interface{} {
+	dir, err := snapshotPath(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	format := snapshot.JSONL
+	if f, ok := args["format"].(string); ok && f != "" {
+		format = strings.ToLower(f)
+	}
+	if format != snapshot.JSONL && format != snapshot.CSV {
+		return errResponse(fmt.Sprintf("unsupported format %q (use jsonl or csv)", format))
+	}
+	overwrite, _ := args["overwrite"].(bool)
+
+	list, ok := args["tables"].([]interface{})
+	if !ok || len(list) == 0 {
+		return errResponse("tables must be a non-empty array")
+	}
+	var requests []snapshotRequest
+	seen := map[string]bool{}
+	for i, item := range list {
+		switch x := item.(type) {
+		case string:
+			requests = append(requests, snapshotRequest{table: x})
+		case map[string]interface{}:
+			table, _ := x["table"].(string)
+			if table == "" {
+				return errResponse(fmt.Sprintf("tables[%d]: table is required", i))
+			}
+			r := snapshotRequest{table: table, where: x["where"]}
+			if r.where != nil {
+				if r.clause, r.values, err = compileWhere(r.where, nil); err != nil {
+					return whereErrResponse(fmt.Errorf("tables[%d]: %w", i, err))
+				}
+			}
+			requests = append(requests, r)
+		default:
+			return errResponse(fmt.Sprintf("tables[%d]: must be a table name or {table, where}", i))
+		}
+		schema, name := splitTableName(requests[len(requests)-1].table)
+		if seen[schema+"."+name] {
+			return errResponse(fmt.Sprintf("tables[%d]: %s.%s is listed twice", i, schema, name))
+		}
+		seen[schema+"."+name] = true
+	}
+
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		if !isSnapshot(dir) {
+			return errResponse(fmt.Sprintf("%s exists and is not a snapshot; it is never replaced", dir))
+		}
+		if !overwrite {
+			return errResponse(fmt.Sprintf("snapshot %s already exists; pass overwrite to replace it", dir))
+		}
+	}
+
+	t, err := resolveTarget(map[string]interface{}{}, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	for _, r := range requests {
+		if err := checkAccess(t.database, r.table, acl.Read); err != nil {
+			return accessDeniedResponse(err)
+		}
+	}
+
+	// Files are written next to the snapshot and moved into place once complete
+	if err := os.MkdirAll(filepath.Dir(dir), 0o700); err != nil {
+		return errResponse(err.Error())
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest := &snapshot.Manifest{
+		Version:    snapshot.Version,
+		Connection: t.database,
+		CreatedAt:  time.Now().UTC(),
+		Format:     format,
+	}
+	err = t.run(timeoutMs, modeReadOnly, func(ctx context.Context, q queryer) error {
+		// Every table is read from one snapshot, with timestamps in UTC
+		for _, stmt := range []string{"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ", "SET LOCAL TimeZone = 'UTC'"} {
+			if _, err := q.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		for _, r := range requests {
+			table, err := snapshotTable(ctx, q, t.database, r, tmp, format)
+			if err != nil {
+				return fmt.Errorf("%s: %w", r.table, err)
+			}
+			manifest.Tables = append(manifest.Tables, *table)
+		}
+		return nil
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Snapshot failed: %s", err))
+	}
+	manifest.Tables = snapshot.Order(manifest.Tables)
+	if err := snapshot.WriteManifest(tmp, manifest); err != nil {
+		return errResponse(err.Error())
+	}
+	// Only a snapshot is removed, even if something else took its place
+	if overwrite && isSnapshot(dir) {
+		if err := os.RemoveAll(dir); err != nil {
+			return errResponse(err.Error())
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return errResponse(err.Error())
+	}
+
+	count := 0
+	tables := make([]map[string]interface{}, len(manifest.Tables))
+	for i, table := range manifest.Tables {
+		count += table.Rows
+		tables[i] = map[string]interface{}{"table": table.Table, "file": table.File, "rows": table.Rows, "masked": table.Masked}
+	}
+	return okResponse(map[string]interface{}{
+		"connection": t.database,
+		"dir":        dir,
+		"format":     format,
+		"tables":     tables,
+	}, &count)
+}
+
+// snapshotTable writes the rows of one table into dir and describes them.
+// Generated columns are left out, since they cannot be restored.
+func snapshotTable(ctx context.Context, q queryer, database string, r snapshotRequest, dir, format string) (*snapshot.Table, error) {
+	quoted, err := qIdent(r.table)
+	if err != nil {
+		return nil, err
+	}
+	schema, name := splitTableName(r.table)
+	info, err := describeTable(ctx, q, schema, name)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &snapshot.Table{
+		Table:       schema + "." + name,
+		File:        schema + "." + name + "." + format,
+		Where:       r.where,
+		PrimaryKey:  []string{},
+		ForeignKeys: []snapshot.ForeignKey{},
+	}
+	var selects []string
+	for _, col := range info.Columns {
+		if col.Generated != "" {
+			continue
+		}
+		quotedCol, err := validateIdentifier(col.Name)
+		if err != nil {
+			return nil, err
+		}
+		selects = append(selects, quotedCol+"::text")
+		table.Columns = append(table.Columns, snapshot.Column{
+			Name:     col.Name,
+			Type:     col.DataType,
+			Nullable: col.Nullable,
+			Serial:   col.Identity != "" || (col.Default != nil && strings.HasPrefix(*col.Default, "nextval(")),
+		})
+	}
+	if len(table.Columns) == 0 {
+		return nil, fmt.Errorf("no columns to snapshot")
+	}
+	if info.PrimaryKey != nil {
+		table.PrimaryKey = info.PrimaryKey.Columns
+	}
+	for _, fk := range info.ForeignKeys {
+		table.ForeignKeys = append(table.ForeignKeys, snapshot.ForeignKey{
+			Name:       fk.Name,
+			Columns:    fk.Columns,
+			RefTable:   fk.RefSchema + "." + fk.RefTable,
+			RefColumns: fk.RefColumns,
+		})
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), quoted)
+	if r.clause != "" {
+		query += " WHERE " + r.clause
+	}
+	if len(table.PrimaryKey) > 0 {
+		keys := make([]string, len(table.PrimaryKey))
+		for i, k := range table.PrimaryKey {
+			keys[i], _ = validateIdentifier(k)
+		}
+		query += " ORDER BY " + strings.Join(keys, ", ")
+	}
+
+	w, err := snapshot.Create(filepath.Join(dir, table.File), format, table.ColumnNames())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, query, r.values...)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	defer rows.Close()
+	names := table.ColumnNames()
+	table.Masked = tableMasked(database, r.table, names)
+	for rows.Next() {
+		row := make([]*string, len(names))
+		ptrs := make([]interface{}, len(names))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			w.Close()
+			return nil, err
+		}
+		if table.Masked {
+			maskSnapshotRow(database, r.table, names, row)
+		}
+		if err := w.Write(row); err != nil {
+			w.Close()
+			return nil, err
+		}
+		table.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return table, w.Close()
+}
+
+// maskSnapshotRow masks the text values of one row of table in place
+func maskSnapshotRow(database, table string, columns []string, row []*string) {
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		if v != nil {
+			values[i] = *v
+		}
+	}
+	maskTableRows(database, table, columns, [][]interface{}{values})
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			row[i] = &s
+		} else {
+			row[i] = nil
+		}
+	}
+}
+
+func restoreSnapshotHandler(args map[string]interface{}) map[string]interface{} {
+	dir, err := snapshotPath(args)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	mode := restoreTruncate
+	if m, ok := args["mode"].(string); ok && m != "" {
+		mode = m
+	}
+	if mode != restoreTruncate && mode != restoreUpsert {
+		return errResponse(fmt.Sprintf("unsupported mode %q (use truncate or upsert)", mode))
+	}
+	only, err := stringList(args, "tables")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	batchSize := defaultRestoreBatchSize
+	if b, exists := args["batch_size"]; exists {
+		if bFloat, ok := b.(float64); ok && bFloat > 0 {
+			batchSize = int(bFloat)
+		}
+	}
+
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	manifest, err := snapshot.ReadManifest(dir)
+	if err != nil {
+		return errResponse(fmt.Sprintf("Failed to read snapshot: %s", err))
+	}
+	tables := manifest.Tables
+	if len(only) > 0 {
+		tables = nil
+		for _, name := range only {
+			schema, table := splitTableName(name)
+			st := manifest.Table(schema + "." + table)
+			if st == nil {
+				return errResponse(fmt.Sprintf("table %s is not in the snapshot", name))
+			}
+			tables = append(tables, *st)
+		}
+	}
+	tables = snapshot.Order(tables)
+	// Tables snapshotted with a filter have only the matching rows cleared,
+	// since truncating them would lose the rows the snapshot left out
+	filters := make([]restoreFilter, len(tables))
+	for i, st := range tables {
+		if st.Masked {
+			return errResponse(fmt.Sprintf("table %s was snapshotted with masked values; restoring it would write them over the real data", st.Table))
+		}
+		if mode == restoreUpsert && len(st.PrimaryKey) == 0 {
+			return errResponse(fmt.Sprintf("table %s has no primary key to upsert on; use mode truncate", st.Table))
+		}
+		if mode == restoreTruncate && st.Where != nil {
+			if filters[i].clause, filters[i].values, err = compileWhere(st.Where, nil); err != nil {
+				return errResponse(fmt.Sprintf("table %s: invalid filter in snapshot: %s", st.Table, err))
+			}
+		}
+	}
+
+	t, err := resolveTarget(map[string]interface{}{}, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+	if err := checkWritable(t.database); err != nil {
+		return errResponse(err.Error())
+	}
+	ops := []acl.Operation{acl.Delete, acl.Insert}
+	if mode == restoreUpsert {
+		ops = []acl.Operation{acl.Insert, acl.Update}
+	}
+	for _, st := range tables {
+		if err := checkAccess(t.database, st.Table, ops...); err != nil {
+			return accessDeniedResponse(err)
+		}
+	}
+
+	restored := make([]map[string]interface{}, len(tables))
+	count := 0
+	err = t.run(timeoutMs, modeAtomic, func(ctx context.Context, q queryer) error {
+		// Deferrable keys are checked at commit, which lets cyclic references load
+		if _, err := q.ExecContext(ctx, "SET CONSTRAINTS ALL DEFERRED"); err != nil {
+			return err
+		}
+		if mode == restoreTruncate {
+			if err := clearTables(ctx, q, t, tables, filters); err != nil {
+				return err
+			}
+		}
+		for i, st := range tables {
+			n, err := restoreTable(ctx, q, t, filepath.Join(dir, st.File), manifest.Format, &st, mode, batchSize)
+			if err != nil {
+				return fmt.Errorf("%s: %w", st.Table, err)
+			}
+			restored[i] = map[string]interface{}{"table": st.Table, "rows": n}
+			count += n
+		}
+		return nil
+	})
+	if err != nil {
+		return errResponse(fmt.Sprintf("Restore failed: %s", err))
+	}
+	return okResponse(map[string]interface{}{
+		"connection": t.database,
+		"dir":        dir,
+		"mode":       mode,
+		"tables":     restored,
+	}, &count)
+}
+
+// restoreFilter is the compiled filter a table was snapshotted with
+type restoreFilter struct {
+	clause string
+	values []interface{}
+}
+
+// clearTables empties tables before a truncate restore: tables without a
+// filter with one TRUNCATE, and the rows of filtered tables that match their
+// filter with DELETE, referencing tables first
+func clearTables(ctx context.Context, q queryer, t *target, tables []snapshot.Table, filters []restoreFilter) error {
+	quoted := make([]string, len(tables))
+	var truncated []int
+	for i, st := range tables {
+		var err error
+		if quoted[i], err = qIdent(st.Table); err != nil {
+			return err
+		}
+		if filters[i].clause == "" {
+			truncated = append(truncated, i)
+		}
+	}
+
+	if len(truncated) > 0 {
+		names := make([]string, len(truncated))
+		for j, i := range truncated {
+			names[j] = quoted[i]
+		}
+		query := "TRUNCATE " + strings.Join(names, ", ")
+		if _, err := q.ExecContext(ctx, query); err != nil {
+			return err
+		}
+		if auditLog != nil {
+			for _, i := range truncated {
+				if err := recordChange(t, &mutation{tool: "restore_snapshot", table: quoted[i], name: tables[i].Table, query: query}, query, 0, nil, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for i := len(tables) - 1; i >= 0; i-- {
+		f := filters[i]
+		if f.clause == "" {
+			continue
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s", quoted[i], f.clause)
+		res, err := q.ExecContext(ctx, query, f.values...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", tables[i].Table, err)
+		}
+		if auditLog != nil {
+			affected, _ := res.RowsAffected()
+			if err := recordChange(t, &mutation{tool: "restore_snapshot", table: quoted[i], name: tables[i].Table, query: query, values: f.values}, query, int(affected), nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreTable loads the data file at path into st in batches of INSERTs and
+// moves the sequences of serial and identity columns past the loaded values
+func restoreTable(ctx context.Context, q queryer, t *target, path, format string, st *snapshot.Table, mode string, batchSize int) (int, error) {
+	quoted, err := qIdent(st.Table)
+	if err != nil {
+		return 0, err
+	}
+	names := st.ColumnNames()
+	quotedCols := make([]string, len(names))
+	for i, col := range names {
+		if quotedCols[i], err = validateIdentifier(col); err != nil {
+			return 0, err
+		}
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE VALUES ", quoted, strings.Join(quotedCols, ", "))
+	var conflict string
+	if mode == restoreUpsert {
+		keys := make([]string, len(st.PrimaryKey))
+		for i, k := range st.PrimaryKey {
+			if keys[i], err = validateIdentifier(k); err != nil {
+				return 0, err
+			}
+		}
+		var sets []string
+		for i, col := range names {
+			if !containsString(st.PrimaryKey, col) {
+				sets = append(sets, quotedCols[i]+" = EXCLUDED."+quotedCols[i])
+			}
+		}
+		if len(sets) == 0 {
+			conflict = fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(keys, ", "))
+		} else {
+			conflict = fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keys, ", "), strings.Join(sets, ", "))
+		}
+	}
+	if limit := maxBindParams / len(names); batchSize > limit {
+		batchSize = limit
+	}
+
+	r, err := snapshot.Open(path, format, names)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	total := 0
+	var tuples []string
+	var values []interface{}
+	flush := func() error {
+		if len(tuples) == 0 {
+			return nil
+		}
+		if _, err := q.ExecContext(ctx, insert+strings.Join(tuples, ", ")+conflict, values...); err != nil {
+			return fmt.Errorf("rows %d-%d: %w", total-len(tuples)+1, total, err)
+		}
+		tuples, values = tuples[:0], values[:0]
+		return nil
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+		placeholders := make([]string, len(row))
+		for i, v := range row {
+			if v == nil {
+				values = append(values, nil)
+			} else {
+				values = append(values, *v)
+			}
+			placeholders[i] = fmt.Sprintf("$%d", len(values))
+		}
+		tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+		total++
+		if len(tuples) == batchSize {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return total, err
+	}
+
+	for i, col := range st.Columns {
+		if !col.Serial {
+			continue
+		}
+		query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(max(%s), 0) + 1, false) FROM %s", quotedCols[i], quoted)
+		if _, err := q.ExecContext(ctx, query, quoted, col.Name); err != nil {
+			return total, fmt.Errorf("sequence of %s: %w", col.Name, err)
+		}
+	}
+
+	if auditLog != nil {
+		// Restored rows are recorded without parameters or row images, like bulk loads
+		query := insert + "..." + conflict
+		if err := recordChange(t, &mutation{tool: "restore_snapshot", table: quoted, name: st.Table, query: query}, query, total, nil, nil); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// snapshotPath returns the directory of the snapshot a call names. With
+// -snapshots-dir set, a dir the call passes must be inside it unless
+// -snapshots-any-dir allows any.
+func snapshotPath(args map[string]interface{}) (string, error) {
+	name, _ := args["name"].(string)
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if !snapshot.ValidName(name) {
+		return "", fmt.Errorf("invalid snapshot name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	dir := snapshotsDir
+	if d, exists := args["dir"]; exists {
+		if dirStr, ok := d.(string); ok && dirStr != "" {
+			if snapshotsDir != "" && !snapshotsAnyDir {
+				if err := checkWithin(dirStr, snapshotsDir); err != nil {
+					return "", fmt.Errorf("dir must be inside -snapshots-dir unless -snapshots-any-dir is set: %w", err)
+				}
+			}
+			dir = dirStr
+		}
+	}
+	if dir == "" {
+		return "", fmt.Errorf("dir is required unless -snapshots-dir is set")
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// isSnapshot reports whether dir holds a snapshot manifest
+func isSnapshot(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, snapshot.ManifestFile))
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/snapshot"
+)
+
+// snapshotAnswer serves the users table of catalogAnswer with two rows
+func snapshotAnswer(query string, args []driver.NamedValue) (*fakeResult, error) {
+	if strings.HasPrefix(query, `SELECT "id"::text`) {
+		return &fakeResult{
+			columns: []string{"id", "email", "role", "manager_id"},
+			rows: [][]driver.Value{
+				{"1", "ann@example.com", "admin", nil},
+				{"2", "bob@example.com", "member", "1"},
+			},
+		}, nil
+	}
+	return catalogAnswer(query, args)
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	d := withFakeDB(t, "app", snapshotAnswer)
+	dir := t.TempDir()
+
+	r := decodeResponse(t, snapshotTablesHandler(map[string]interface{}{
+		"name": "checkpoint", "dir": dir, "format": "csv",
+		"tables": []interface{}{map[string]interface{}{"table": "users", "where": map[string]interface{}{"column": "id", "op": "<", "value": 10.0}}},
+	}))
+	if !r.OK || *r.RowCount != 2 {
+		t.Fatalf("snapshot failed: %+v", r)
+	}
+	if !containsStatement(d.statements(), `FROM "users" WHERE "id" < $1 ORDER BY "id"`) {
+		t.Errorf("expected the filtered rows in key order: %v", d.statements())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "checkpoint", "public.users.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,email,role,manager_id\n1,ann@example.com,admin,\\N\n2,bob@example.com,member,1\n"; string(data) != want {
+		t.Errorf("unexpected data file:\n%s", data)
+	}
+	manifest, err := snapshot.ReadManifest(filepath.Join(dir, "checkpoint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := manifest.Table("public.users"); users == nil || !users.Columns[0].Serial || users.PrimaryKey[0] != "id" || len(users.ForeignKeys) != 1 {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+
+	r = decodeResponse(t, snapshotTablesHandler(map[string]interface{}{"name": "checkpoint", "dir": dir, "tables": []interface{}{"users"}}))
+	if r.OK || !strings.Contains(r.Error, "already exists") {
+		t.Errorf("expected an existing snapshot to be kept: %+v", r)
+	}
+
+	r = decodeResponse(t, restoreSnapshotHandler(map[string]interface{}{"name": "checkpoint", "dir": dir}))
+	if !r.OK || *r.RowCount != 2 {
+		t.Fatalf("restore failed: %+v", r)
+	}
+	stmts := d.statements()
+	// The snapshot was filtered, so only the rows it covers are cleared
+	want := []string{"BEGIN", `DELETE FROM "public"."users" WHERE "id" < $1`, `INSERT INTO "public"."users" ("id", "email", "role", "manager_id") OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)`, "SELECT setval(pg_get_serial_sequence($1, $2)", "COMMIT"}
+	i := 0
+	for _, s := range stmts {
+		if i < len(want) && strings.Contains(s, want[i]) {
+			i++
+		}
+	}
+	if i != len(want) {
+		t.Errorf("expected the table to be cleared and reloaded in one transaction, missing %q in %v", want[i], stmts)
+	}
+	if containsStatement(stmts, "TRUNCATE") {
+		t.Errorf("a filtered table must not be truncated: %v", stmts)
+	}
+
+	r = decodeResponse(t, restoreSnapshotHandler(map[string]interface{}{"name": "checkpoint", "dir": dir, "mode": "upsert"}))
+	if !r.OK {
+		t.Fatalf("upsert restore failed: %+v", r)
+	}
+	if !containsStatement(d.statements(), `ON CONFLICT ("id") DO UPDATE SET "email" = EXCLUDED."email"`) {
+		t.Errorf("expected an upsert on the primary key: %v", d.statements())
+	}
+
+	dbManager.modes["app"] = connReadOnly
+	if r := decodeResponse(t, restoreSnapshotHandler(map[string]interface{}{"name": "checkpoint", "dir": dir})); r.OK || !strings.Contains(r.Error, "read-only") {
+		t.Errorf("expected a read-only connection to refuse a restore: %+v", r)
+	}
+}
+
+func TestRestoreUnfilteredAndMaskedTables(t *testing.T) {
+	d := withFakeDB(t, "app", snapshotAnswer)
+	dir := t.TempDir()
+	if r := decodeResponse(t, snapshotTablesHandler(map[string]interface{}{"name": "masked", "dir": dir, "tables": []interface{}{"users"}})); !r.OK {
+		t.Fatalf("snapshot failed: %+v", r)
+	}
+	if r := decodeResponse(t, restoreSnapshotHandler(map[string]interface{}{"name": "masked", "dir": dir})); !r.OK {
+		t.Fatalf("restore failed: %+v", r)
+	}
+	if !containsStatement(d.statements(), `TRUNCATE "public"."users"`) {
+		t.Errorf("expected an unfiltered table to be truncated: %v", d.statements())
+	}
+	restored := len(d.statements())
+	manifest, err := snapshot.ReadManifest(filepath.Join(dir, "masked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Tables[0].Masked = true
+	if err := snapshot.WriteManifest(filepath.Join(dir, "masked"), manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	r := decodeResponse(t, restoreSnapshotHandler(map[string]interface{}{"name": "masked", "dir": dir, "mode": "upsert"}))
+	if r.OK || !strings.Contains(r.Error, "masked values") {
+		t.Errorf("expected a masked table to be refused: %+v", r)
+	}
+	if containsStatement(d.statements()[restored:], "INSERT INTO") {
+		t.Errorf("nothing should be restored: %v", d.statements()[restored:])
+	}
+}
+
+func TestSnapshotPath(t *testing.T) {
+	if _, err := snapshotPath(map[string]interface{}{"name": "../etc", "dir": "/tmp"}); err == nil {
+		t.Errorf("expected a name leaving the snapshot directory to be refused")
+	}
+	if _, err := snapshotPath(map[string]interface{}{"name": "a"}); err == nil || !strings.Contains(err.Error(), "-snapshots-dir") {
+		t.Errorf("expected dir to be required without -snapshots-dir: %v", err)
+	}
+
+	root := t.TempDir()
+	prev := snapshotsDir
+	snapshotsDir = root
+	t.Cleanup(func() { snapshotsDir = prev; snapshotsAnyDir = false })
+	if dir, err := snapshotPath(map[string]interface{}{"name": "a", "dir": filepath.Join(root, "team")}); err != nil || dir != filepath.Join(root, "team", "a") {
+		t.Errorf("expected a dir inside -snapshots-dir to be used: %s %v", dir, err)
+	}
+	if _, err := snapshotPath(map[string]interface{}{"name": "a", "dir": filepath.Join(root, "..")}); err == nil || !strings.Contains(err.Error(), "inside -snapshots-dir") {
+		t.Errorf("expected a dir outside -snapshots-dir to be refused: %v", err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(root, "elsewhere")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshotPath(map[string]interface{}{"name": "a", "dir": filepath.Join(root, "elsewhere")}); err == nil {
+		t.Errorf("expected a link out of -snapshots-dir to be refused")
+	}
+	snapshotsAnyDir = true
+	if _, err := snapshotPath(map[string]interface{}{"name": "a", "dir": filepath.Join(root, "..")}); err != nil {
+		t.Errorf("expected -snapshots-any-dir to allow any dir: %v", err)
+	}
+}
+
+func TestSnapshotOverwriteKeepsOtherDirectories(t *testing.T) {
+	withFakeDB(t, "app", snapshotAnswer)
+	dir := t.TempDir()
+	keep := filepath.Join(dir, "project", "main.go")
+	if err := os.MkdirAll(filepath.Dir(keep), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keep, []byte("package main"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := decodeResponse(t, snapshotTablesHandler(map[string]interface{}{"name": "project", "dir": dir, "overwrite": true, "tables": []interface{}{"users"}}))
+	if r.OK || !strings.Contains(r.Error, "is not a snapshot") {
+		t.Errorf("expected a directory without a manifest to be kept: %+v", r)
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("the directory must be left alone: %v", err)
+	}
+
+	args := map[string]interface{}{"name": "checkpoint", "dir": dir, "tables": []interface{}{"users"}}
+	if r := decodeResponse(t, snapshotTablesHandler(args)); !r.OK {
+		t.Fatalf("snapshot failed: %+v", r)
+	}
+	args["overwrite"] = true
+	if r := decodeResponse(t, snapshotTablesHandler(args)); !r.OK {
+		t.Errorf("expected a snapshot to be replaced with overwrite: %+v", r)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultTxIdleTimeout = 5 * time.Minute
+
+// txSession is an open transaction pinned to one physical connection
+type txSession struct {
+	id          string
+	database    string
+	conn        *sql.Conn
+	tx          *sql.Tx
+	idleTimeout time.Duration
+	startedAt   time.Time
+	lastUsed    time.Time
+	timer       *time.Timer
+	savepoints  []string
+	internal    bool
+	mu          sync.Mutex
+}
+
+// TxManager tracks open transactions by handle and rolls back idle ones
+type TxManager struct {
+	mu       sync.Mutex
+	sessions map[string]*txSession
+}
+
+func NewTxManager() *TxManager {
+	return &TxManager{sessions: make(map[string]*txSession)}
+}
+
+var txManager = NewTxManager()
+
+// newHandle returns prefix followed by 16 random hex digits
+func newHandle(prefix string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// Begin pins a connection from db and opens a transaction on it
+func (m *TxManager) Begin(database string, db *sql.DB, opts *sql.TxOptions, idleTimeout time.Duration) (*txSession, error) {
+	return m.begin(database, db, opts, idleTimeout, false)
+}
+
+// begin opens a session; internal sessions back server-side cursors and are
+// not listed by list_transactions
+func (m *TxManager) begin(database string, db *sql.DB, opts *sql.TxOptions, idleTimeout time.Duration, internal bool) (*txSession, error) {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultTxIdleTimeout
+	}
+	id, err := newHandle("tx_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate transaction handle: %w", err)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	tx, err := conn.BeginTx(ctx, opts)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	now := time.Now()
+	s := &txSession{
+		id:          id,
+		database:    database,
+		conn:        conn,
+		tx:          tx,
+		idleTimeout: idleTimeout,
+		startedAt:   now,
+		lastUsed:    now,
+		internal:    internal,
+	}
+	s.timer = time.AfterFunc(idleTimeout, func() { m.expire(id) })
+
+	m.mu.Lock()
+	m.sessions[id] = s
+	m.mu.Unlock()
+	logger.Printf("Began transaction %s on %s", id, database)
+	return s, nil
+}
+
+// Acquire locks the session for exclusive use. Callers must call Release.
+func (m *TxManager) Acquire(id string) (*txSession, error) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no such transaction: %s (it may have been committed, rolled back or expired)", id)
+	}
+	s.mu.Lock()
+	// The idle timer may have fired while we waited for the lock
+	m.mu.Lock()
+	_, still := m.sessions[id]
+	m.mu.Unlock()
+	if !still {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("no such transaction: %s (it may have been committed, rolled back or expired)", id)
+	}
+	s.timer.Stop()
+	return s, nil
+}
+
+// Release unlocks the session and restarts its idle timer
+func (s *txSession) Release() {
+	s.lastUsed = time.Now()
+	s.timer.Reset(s.idleTimeout)
+	s.mu.Unlock()
+}
+
+// Commit commits and forgets the transaction
+func (m *TxManager) Commit(id string) error {
+	s, err := m.detach(id)
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	defer s.conn.Close()
+	if err := s.tx.Commit(); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
+	logger.Printf("Committed transaction %s on %s", id, s.database)
+	return nil
+}
+
+// Rollback rolls back and forgets the transaction
+func (m *TxManager) Rollback(id string) error {
+	s, err := m.detach(id)
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	defer s.conn.Close()
+	if err := s.tx.Rollback(); err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	logger.Printf("Rolled back transaction %s on %s", id, s.database)
+	return nil
+}
+
+// detach removes the session from the manager and returns it locked
+func (m *TxManager) detach(id string) (*txSession, error) {
+	s, err := m.Acquire(id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	cursorManager.forgetSession(id)
+	return s, nil
+}
+
+func (m *TxManager) expire(id string) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if ok {
+		delete(m.sessions, id)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	cursorManager.forgetSession(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tx.Rollback()
+	s.conn.Close()
+	logger.Printf("Rolled back idle transaction %s on %s after %s", id, s.database, s.idleTimeout)
+}
+
+// RollbackDatabase rolls back every open transaction on database, e.g. before
+// the connection pool is closed
+func (m *TxManager) RollbackDatabase(database string) {
+	m.mu.Lock()
+	var ids []string
+	for id, s := range m.sessions {
+		if database == "" || s.database == database {
+			ids = append(ids, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, id := range ids {
+		if err := m.Rollback(id); err != nil {
+			logger.Printf("Failed to roll back %s: %s", id, err)
+		}
+	}
+}
+
+// List describes the open transactions
+func (m *TxManager) List() []map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []map[string]interface{}{}
+	for id, s := range m.sessions {
+		if s.internal {
+			continue
+		}
+		out = append(out, map[string]interface{}{
+			"transaction": id,
+			"database":    s.database,
+			"started_at":  s.startedAt.Format(time.RFC3339),
+			"last_used":   s.lastUsed.Format(time.RFC3339),
+		})
+	}
+	return out
+}
+
+// Savepoint creates a named savepoint. The session must be acquired.
+func (s *txSession) Savepoint(name string) error {
+	quoted, err := validateIdentifier(name)
+	if err != nil {
+		return err
+	}
+	if _, err := s.tx.Exec("SAVEPOINT " + quoted); err != nil {
+		return err
+	}
+	s.savepoints = append(s.savepoints, name)
+	return nil
+}
+
+// RollbackToSavepoint undoes everything after the savepoint, which stays defined
+func (s *txSession) RollbackToSavepoint(name string) error {
+	idx, quoted, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	if _, err := s.tx.Exec("ROLLBACK TO SAVEPOINT " + quoted); err != nil {
+		return err
+	}
+	s.savepoints = s.savepoints[:idx+1]
+	return nil
+}
+
+// ReleaseSavepoint forgets the savepoint and any savepoints created after it
+func (s *txSession) ReleaseSavepoint(name string) error {
+	idx, quoted, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	if _, err := s.tx.Exec("RELEASE SAVEPOINT " + quoted); err != nil {
+		return err
+	}
+	s.savepoints = s.savepoints[:idx]
+	return nil
+}
+
+// findSavepoint checks the name locally first, because an unknown savepoint is a
+// server error that would abort the whole transaction
+func (s *txSession) findSavepoint(name string) (int, string, error) {
+	quoted, err := validateIdentifier(name)
+	if err != nil {
+		return 0, "", err
+	}
+	for i := len(s.savepoints) - 1; i >= 0; i-- {
+		if s.savepoints[i] == name {
+			return i, quoted, nil
+		}
+	}
+	return 0, "", fmt.Errorf("no such savepoint: %s", name)
+}
+
+func beginTransactionHandler(args map[string]interface{}) map[string]interface{} {
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+
+	opts := &sql.TxOptions{}
+	if r, exists := args["read_only"]; exists {
+		if roBool, ok := r.(bool); ok {
+			opts.ReadOnly = roBool
+		}
+	}
+	if l, exists := args["isolation_level"]; exists {
+		levelStr, _ := l.(string)
+		switch levelStr {
+		case "read_committed":
+			opts.Isolation = sql.LevelReadCommitted
+		case "repeatable_read":
+			opts.Isolation = sql.LevelRepeatableRead
+		case "serializable":
+			opts.Isolation = sql.LevelSerializable
+		default:
+			return errResponse(fmt.Sprintf("invalid isolation_level: %q", levelStr))
+		}
+	}
+
+	idleTimeout := defaultTxIdleTimeout
+	if t, exists := args["idle_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok && timeoutFloat > 0 {
+			idleTimeout = time.Duration(timeoutFloat) * time.Millisecond
+		}
+	}
+
+	if !dbManager.HasConnection(database) {
+		return errResponse("No database connection available")
+	}
+
+	db := dbManager.GetConnection(database)
+	if db == nil {
+		return errResponse("No database connection available")
+	}
+	if database == "" {
+		database = dbManager.NameOf(db)
+	}
+
+	s, err := txManager.Begin(database, db, opts, idleTimeout)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	return okResponse(map[string]interface{}{
+		"transaction":     s.id,
+		"database":        database,
+		"idle_timeout_ms": idleTimeout.Milliseconds(),
+	}, nil)
+}
+
+func commitHandler(args map[string]interface{}) map[string]interface{} {
+	id, ok := args["transaction"].(string)
+	if !ok {
+		return errResponse("transaction is required")
+	}
+	if err := txManager.Commit(id); err != nil {
+		return errResponse(err.Error())
+	}
+	return okResponse(map[string]string{"transaction": id, "status": "committed"}, nil)
+}
+
+func rollbackHandler(args map[string]interface{}) map[string]interface{} {
+	id, ok := args["transaction"].(string)
+	if !ok {
+		return errResponse("transaction is required")
+	}
+
+	if sp, ok := args["savepoint"].(string); ok && sp != "" {
+		s, err := txManager.Acquire(id)
+		if err != nil {
+			return errResponse(err.Error())
+		}
+		defer s.Release()
+		if err := s.RollbackToSavepoint(sp); err != nil {
+			return errResponse(fmt.Sprintf("Rollback to savepoint failed: %s", err))
+		}
+		return okResponse(map[string]string{"transaction": id, "savepoint": sp, "status": "rolled_back_to_savepoint"}, nil)
+	}
+
+	if err := txManager.Rollback(id); err != nil {
+		return errResponse(err.Error())
+	}
+	return okResponse(map[string]string{"transaction": id, "status": "rolled_back"}, nil)
+}
+
+func savepointHandler(args map[string]interface{}) map[string]interface{} {
+	id, ok := args["transaction"].(string)
+	if !ok {
+		return errResponse("transaction is required")
+	}
+	name, ok := args["name"].(string)
+	if !ok {
+		return errResponse("name is required")
+	}
+
+	s, err := txManager.Acquire(id)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer s.Release()
+
+	release := false
+	if r, exists := args["release"]; exists {
+		if relBool, ok := r.(bool); ok {
+			release = relBool
+		}
+	}
+	if release {
+		if err := s.ReleaseSavepoint(name); err != nil {
+			return errResponse(fmt.Sprintf("Release savepoint failed: %s", err))
+		}
+		return okResponse(map[string]interface{}{"transaction": id, "savepoint": name, "status": "released", "savepoints": s.savepoints}, nil)
+	}
+	if err := s.Savepoint(name); err != nil {
+		return errResponse(fmt.Sprintf("Savepoint failed: %s", err))
+	}
+	return okResponse(map[string]interface{}{"transaction": id, "savepoint": name, "status": "created", "savepoints": s.savepoints}, nil)
+}
+
+func listTransactionsHandler(args map[string]interface{}) map[string]interface{} {
+	return okResponse(txManager.List(), nil)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decodeResponse(t *testing.T, resp map[string]interface{}) Response {
+	t.Helper()
+	var r Response
+	if err := json.Unmarshal([]byte(responseText(t, resp)), &r); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return r
+}
+
+func TestTransactionLifecycle(t *testing.T) {
+	d := withFakeDB(t, "primary_db", nil)
+
+	begin := decodeResponse(t, beginTransactionHandler(map[string]interface{}{"database": "primary_db"}))
+	if !begin.OK {
+		t.Fatalf("begin failed: %s", begin.Error)
+	}
+	id := begin.Data.(map[string]interface{})["transaction"].(string)
+
+	upd := decodeResponse(t, updateHandler(map[string]interface{}{
+		"table":       "users",
+		"data":        map[string]interface{}{"email": "x@example.com"},
+		"where":       map[string]interface{}{"id": float64(1)},
+		"transaction": id,
+	}))
+	if !upd.OK {
+		t.Fatalf("update failed: %s", upd.Error)
+	}
+
+	if r := decodeResponse(t, savepointHandler(map[string]interface{}{"transaction": id, "name": "before_fix"})); !r.OK {
+		t.Fatalf("savepoint failed: %s", r.Error)
+	}
+	if r := decodeResponse(t, rollbackHandler(map[string]interface{}{"transaction": id, "savepoint": "missing"})); r.OK {
+		t.Fatalf("expected unknown savepoint to fail")
+	}
+	if r := decodeResponse(t, rollbackHandler(map[string]interface{}{"transaction": id, "savepoint": "before_fix"})); !r.OK {
+		t.Fatalf("rollback to savepoint failed: %s", r.Error)
+	}
+
+	q := decodeResponse(t, queryHandler(map[string]interface{}{"sql": "SELECT 1", "transaction": id}))
+	if !q.OK {
+		t.Fatalf("query failed: %s", q.Error)
+	}
+
+	if r := decodeResponse(t, commitHandler(map[string]interface{}{"transaction": id})); !r.OK {
+		t.Fatalf("commit failed: %s", r.Error)
+	}
+	if r := decodeResponse(t, commitHandler(map[string]interface{}{"transaction": id})); r.OK {
+		t.Fatalf("expected second commit to fail")
+	}
+
+	stmts := d.statements()
+	for _, want := range []string{
+		"BEGIN",
+		"SAVEPOINT mcp_stmt",
+		`UPDATE "users" SET "email" = $1 WHERE "id" = $2`,
+		`SAVEPOINT "before_fix"`,
+		`ROLLBACK TO SAVEPOINT "before_fix"`,
+		"SET LOCAL transaction_read_only = on",
+		"COMMIT",
+	} {
+		if !containsStatement(stmts, want) {
+			t.Errorf("expected statement %q in %v", want, stmts)
+		}
+	}
+}
+
+func TestTransactionDatabaseMismatch(t *testing.T) {
+	withFakeDB(t, "primary_db", nil)
+	begin := decodeResponse(t, beginTransactionHandler(map[string]interface{}{"database": "primary_db"}))
+	id := begin.Data.(map[string]interface{})["transaction"].(string)
+
+	r := decodeResponse(t, deleteHandler(map[string]interface{}{
+		"table":       "users",
+		"where":       map[string]interface{}{"id": float64(1)},
+		"database":    "analytics_db",
+		"transaction": id,
+	}))
+	if r.OK {
+		t.Fatalf("expected mismatch error")
+	}
+}
+
+func TestTransactionIdleExpiry(t *testing.T) {
+	d := withFakeDB(t, "primary_db", nil)
+	begin := decodeResponse(t, beginTransactionHandler(map[string]interface{}{"database": "primary_db", "idle_timeout_ms": float64(1)}))
+	id := begin.Data.(map[string]interface{})["transaction"].(string)
+
+	txManager.expire(id)
+	if _, err := txManager.Acquire(id); err == nil {
+		t.Fatalf("expected expired transaction to be gone")
+	}
+	if !containsStatement(d.statements(), "ROLLBACK") {
+		t.Fatalf("expected rollback on expiry")
+	}
+}
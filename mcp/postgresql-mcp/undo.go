@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/audit"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/dbguard"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
+)
+
+// Compensating actions
+const (
+	undoDelete = "delete"
+	undoUpdate = "update"
+	undoInsert = "insert"
+)
+
+// loadTools record the rows they load without row images, so their entries
+// cannot be undone
+var loadTools = map[string]bool{
+	"bulk_insert":        true,
+	"restore_snapshot":   true,
+	"generate_seed_data": true,
+}
+
+// undoStep reverts one row of an audited change
+type undoStep struct {
+	Entry  string                 `json:"entry"`
+	Action string                 `json:"action"`
+	Table  string                 `json:"table"`
+	Key    map[string]interface{} `json:"key"`
+	SQL    string                 `json:"sql,omitempty"`
+	Params []interface{}          `json:"params,omitempty"`
+	// Conflict explains why the row cannot be reverted safely
+	Conflict string `json:"conflict,omitempty"`
+
+	// expect is the row as the change left it, nil when it was deleted
+	expect map[string]interface{}
+	// restore is the row as it was before the change, nil when it was inserted
+	restore map[string]interface{}
+}
+
+// undoPlan reverts audited entries of one table set, newest entry first
+type undoPlan struct {
+	entries []*audit.Entry
+	steps   []*undoStep
+}
+
+func undoHandler(args map[string]interface{}) map[string]interface{} {
+	if auditLog == nil {
+		return errResponse("Audit logging is disabled; undo needs the row images of the audit log")
+	}
+
+	ids, err := stringList(args, "ids")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	if id, ok := args["id"].(string); ok && id != "" {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errResponse("id or ids is required")
+	}
+
+	apply := false
+	if a, exists := args["apply"]; exists {
+		if aBool, ok := a.(bool); ok {
+			apply = aBool
+		}
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	var entries []*audit.Entry
+	for _, id := range ids {
+		found, err := auditLog.Search(audit.Query{ID: id})
+		if err != nil {
+			return errResponse(err.Error())
+		}
+		if len(found) == 0 {
+			return errResponse(fmt.Sprintf("audit entry not found: %s", id))
+		}
+		entries = append(entries, found[0])
+	}
+	// Later changes are reverted first
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+
+	database := entries[0].Connection
+	for _, e := range entries {
+		if e.Connection != database {
+			return errResponse("all entries must belong to the same connection")
+		}
+		if loadTools[e.Tool] {
+			return errResponse(fmt.Sprintf("audit entry %s was recorded by %s, which loads rows without row images; it cannot be undone", e.ID, e.Tool))
+		}
+		if len(e.Before) == 0 && len(e.After) == 0 {
+			return errResponse(fmt.Sprintf("audit entry %s has no row images and cannot be undone", e.ID))
+		}
+	}
+	if !dbManager.HasConnection(database) {
+		return errResponse(fmt.Sprintf("No such connection: %s", database))
+	}
+	if dsn := dbguard.RedactDSN(dbManager.DSN(database)); dsn != entries[0].DSN {
+		return errResponse(fmt.Sprintf("connection %s now points to %s, not %s where the changes were made", database, dsn, entries[0].DSN))
+	}
+
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+
+	if err := checkWritable(t.database); err != nil {
+		return errResponse(err.Error())
+	}
+	for _, e := range entries {
+		if err := checkAccess(t.database, e.Table, undoOperations(e)...); err != nil {
+			return accessDeniedResponse(fmt.Errorf("audit entry %s: %w", e.ID, err))
+		}
+	}
+
+	plan := &undoPlan{entries: entries}
+	mode := modeRollback
+	if apply {
+		mode = modeAtomic
+	}
+	conflicts := 0
+	err = t.run(timeoutMs, mode, func(ctx context.Context, q queryer) error {
+		if err := plan.build(ctx, q); err != nil {
+			return err
+		}
+		var err error
+		if conflicts, err = plan.run(ctx, q, t, apply); err != nil {
+			return err
+		}
+		if conflicts > 0 && apply {
+			return fmt.Errorf("%d rows cannot be reverted", conflicts)
+		}
+		return nil
+	})
+	plan.mask(database)
+	if err != nil {
+		if conflicts > 0 {
+			return errResponseWithDetail(fmt.Sprintf("Undo aborted, nothing was changed: %s", err), plan.steps)
+		}
+		return errResponse(fmt.Sprintf("Undo failed: %s", err))
+	}
+
+	count := len(plan.steps) - conflicts
+	return okResponse(map[string]interface{}{
+		"applied":   apply,
+		"entries":   ids,
+		"conflicts": conflicts,
+		"steps":     plan.steps,
+	}, &count)
+}
+
+// build turns every entry into one step per recorded row. Rows are matched
+// by primary key, or by every column when the table has none.
+func (p *undoPlan) build(ctx context.Context, q queryer) error {
+	for _, e := range p.entries {
+		table, err := qIdent(e.Table)
+		if err != nil {
+			return err
+		}
+		keys, err := primaryKeyColumns(ctx, q, table)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Table, err)
+		}
+		if len(keys) == 0 {
+			for _, col := range e.Columns {
+				// json and xml have no equality operator
+				if col.Name != upsertFlag && col.Type != "json" && col.Type != "xml" {
+					keys = append(keys, col.Name)
+				}
+			}
+		}
+
+		newStep := func(action string, expect, restore map[string]interface{}) *undoStep {
+			image := expect
+			if image == nil {
+				image = restore
+			}
+			key := make(map[string]interface{}, len(keys))
+			for _, k := range keys {
+				key[k] = image[k]
+			}
+			return &undoStep{Entry: e.ID, Action: action, Table: e.Table, Key: key, expect: expect, restore: restore}
+		}
+
+		switch e.Operation {
+		case "delete":
+			for _, row := range e.Before {
+				p.steps = append(p.steps, newStep(undoInsert, nil, row))
+			}
+		case "update", "insert":
+			// Updated rows are paired with their before image by key; an
+			// upsert flags the rows it inserted
+			before := make(map[string]map[string]interface{}, len(e.Before))
+			for _, row := range e.Before {
+				before[keyString(row, keys)] = row
+			}
+			for _, row := range e.After {
+				inserted, flagged := row[upsertFlag].(bool)
+				if e.Operation == "insert" && (!flagged || inserted) {
+					p.steps = append(p.steps, newStep(undoDelete, row, nil))
+					continue
+				}
+				step := newStep(undoUpdate, row, before[keyString(row, keys)])
+				if step.restore == nil {
+					step.Conflict = "no before image with the same key; was the key changed?"
+				}
+				p.steps = append(p.steps, step)
+			}
+		default:
+			return fmt.Errorf("audit entry %s: unsupported operation %q", e.ID, e.Operation)
+		}
+	}
+	return nil
+}
+
+// run checks and executes every step and returns the number of conflicts.
+// Each step runs under a savepoint, so a failing step is skipped and a
+// preview still shows the others. Only applied steps are audited.
+func (p *undoPlan) run(ctx context.Context, q queryer, t *target, record bool) (int, error) {
+	conflicts := 0
+	for i, step := range p.steps {
+		if step.Conflict == "" {
+			if _, err := q.ExecContext(ctx, "SAVEPOINT mcp_undo"); err != nil {
+				return conflicts, err
+			}
+			if err := step.run(ctx, q, t, p.entryOf(step), i, record); err != nil {
+				step.Conflict = err.Error()
+				if _, rerr := q.ExecContext(ctx, "ROLLBACK TO SAVEPOINT mcp_undo"); rerr != nil {
+					return conflicts, rerr
+				}
+			}
+			if _, err := q.ExecContext(ctx, "RELEASE SAVEPOINT mcp_undo"); err != nil {
+				return conflicts, err
+			}
+		}
+		if step.Conflict != "" {
+			conflicts++
+		}
+	}
+	return conflicts, nil
+}
+
+// mask hides the recorded values of masked columns in the steps' keys and
+// parameters before they are returned
+func (p *undoPlan) mask(database string) {
+	for _, step := range p.steps {
+		if e := p.entryOf(step); tableMasked(database, e.Table, columnNames(e.Columns)) {
+			maskTableMaps(database, e.Table, []map[string]interface{}{step.Key})
+			step.Params = maskParams(step.Params)
+		}
+	}
+}
+
+func (p *undoPlan) entryOf(step *undoStep) *audit.Entry {
+	for _, e := range p.entries {
+		if e.ID == step.Entry {
+			return e
+		}
+	}
+	return nil
+}
+
+// run locks the row, checks it is as the change left it and applies the
+// compensating statement. Any error is reported as the step's conflict.
+func (s *undoStep) run(ctx context.Context, q queryer, t *target, e *audit.Entry, n int, record bool) error {
+	types := make(map[string]pgvalue.Column, len(e.Columns))
+	for _, col := range e.Columns {
+		types[col.Name] = col
+	}
+	table, err := qIdent(e.Table)
+	if err != nil {
+		return err
+	}
+
+	var conv pgparam.Converter
+	where, whereValues, err := keyCondition(&conv, types, s.Key, nil, fmt.Sprintf("steps[%d].key", n))
+	if err != nil {
+		return err
+	}
+	if err := conv.Err(); err != nil {
+		return err
+	}
+	current, err := lockRows(ctx, q, table, where, whereValues)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case s.expect == nil && len(current.rows) > 0:
+		return fmt.Errorf("a row with this key exists again")
+	case s.expect != nil && len(current.rows) == 0:
+		return fmt.Errorf("the row no longer exists")
+	case s.expect != nil && len(current.rows) > 1:
+		return fmt.Errorf("the key matches %d rows", len(current.rows))
+	case s.expect != nil:
+		if changed := changedColumns(types, s.expect, current.maps()[0]); len(changed) > 0 {
+			return fmt.Errorf("changed since it was recorded: %s", strings.Join(changed, ", "))
+		}
+	}
+
+	var values []interface{}
+	switch s.Action {
+	case undoDelete:
+		if where, values, err = keyCondition(&conv, types, s.Key, nil, fmt.Sprintf("steps[%d].key", n)); err != nil {
+			return err
+		}
+		s.SQL = fmt.Sprintf("DELETE FROM %s WHERE %s", table, where)
+	case undoUpdate:
+		var sets []string
+		for _, col := range imageColumns(s.restore) {
+			if !sameValue(types[col], s.restore[col], s.expect[col]) {
+				quoted, err := validateIdentifier(col)
+				if err != nil {
+					return err
+				}
+				values = append(values, bindImage(&conv, types[col], s.restore[col], fmt.Sprintf("steps[%d].%s", n, col)))
+				sets = append(sets, fmt.Sprintf("%s = $%d", quoted, len(values)))
+			}
+		}
+		if len(sets) == 0 {
+			return nil
+		}
+		if where, values, err = keyCondition(&conv, types, s.Key, values, fmt.Sprintf("steps[%d].key", n)); err != nil {
+			return err
+		}
+		s.SQL = fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(sets, ", "), where)
+	case undoInsert:
+		cols := imageColumns(s.restore)
+		quoted := make([]string, len(cols))
+		placeholders := make([]string, len(cols))
+		for i, col := range cols {
+			if quoted[i], err = validateIdentifier(col); err != nil {
+				return err
+			}
+			values = append(values, bindImage(&conv, types[col], s.restore[col], fmt.Sprintf("steps[%d].%s", n, col)))
+			placeholders[i] = fmt.Sprintf("$%d", len(values))
+		}
+		// Identity columns get their recorded values back
+		s.SQL = fmt.Sprintf("INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE VALUES (%s)", table, strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	}
+	if err := conv.Err(); err != nil {
+		return err
+	}
+	s.Params = values
+
+	after, count, err := execWrite(ctx, q, true, s.SQL+" RETURNING *", values...)
+	if err != nil {
+		return err
+	}
+	if !record {
+		return nil
+	}
+	mut := &mutation{tool: "undo", table: table, name: e.Table, query: s.SQL, values: values}
+	return recordChange(t, mut, s.SQL+" RETURNING *", count, current, after)
+}
+
+// keyCondition matches key with placeholders numbered after values
+func keyCondition(conv *pgparam.Converter, types map[string]pgvalue.Column, key map[string]interface{}, values []interface{}, name string) (string, []interface{}, error) {
+	var conds []string
+	for _, col := range imageColumns(key) {
+		quoted, err := validateIdentifier(col)
+		if err != nil {
+			return "", nil, err
+		}
+		if key[col] == nil {
+			conds = append(conds, quoted+" IS NULL")
+			continue
+		}
+		values = append(values, bindImage(conv, types[col], key[col], name+"."+col))
+		conds = append(conds, fmt.Sprintf("%s = $%d", quoted, len(values)))
+	}
+	return strings.Join(conds, " AND "), values, nil
+}
+
+// bindImage converts a recorded value back into a parameter of its column type
+func bindImage(conv *pgparam.Converter, col pgvalue.Column, v interface{}, name string) interface{} {
+	if col.Type != "" && pgparam.Supports(col.Type) {
+		return conv.Convert(name, map[string]interface{}{"value": v, "type": col.Type})
+	}
+	return conv.Convert(name, v)
+}
+
+// imageColumns returns the columns of a row image in a fixed order
+func imageColumns(row map[string]interface{}) []string {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		if col != upsertFlag {
+			cols = append(cols, col)
+		}
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// changedColumns lists the columns of image whose current value differs
+func changedColumns(types map[string]pgvalue.Column, image, current map[string]interface{}) []string {
+	var changed []string
+	for _, col := range imageColumns(image) {
+		if value, ok := current[col]; ok && !sameValue(types[col], image[col], value) {
+			changed = append(changed, col)
+		}
+	}
+	return changed
+}
+
+// sameValue compares a recorded value with another recorded or freshly read
+// one through their JSON form. Timestamps compare as instants, since the
+// session time zone may differ from when they were recorded.
+func sameValue(col pgvalue.Column, a, b interface{}) bool {
+	a, b = jsonValue(a), jsonValue(b)
+	if col.Type == "timestamptz" {
+		as, aok := a.(string)
+		bs, bok := b.(string)
+		if aok && bok {
+			at, aerr := time.Parse(time.RFC3339Nano, as)
+			bt, berr := time.Parse(time.RFC3339Nano, bs)
+			if aerr == nil && berr == nil {
+				return at.Equal(bt)
+			}
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// jsonValue returns v as it reads back from JSON
+func jsonValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// keyString identifies a row image by its key values
+func keyString(row map[string]interface{}, keys []string) string {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		values[i] = jsonValue(row[k])
+	}
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+// undoOperations returns the operations that revert e: deleted rows are
+// inserted again, inserted rows deleted and updated rows updated back. Rows an
+// upsert updated are updated back as well.
+func undoOperations(e *audit.Entry) []acl.Operation {
+	switch e.Operation {
+	case "delete":
+		return []acl.Operation{acl.Insert}
+	case "insert":
+		if e.Tool == "upsert" {
+			return []acl.Operation{acl.Delete, acl.Update}
+		}
+		return []acl.Operation{acl.Delete}
+	}
+	return []acl.Operation{acl.Update}
+}
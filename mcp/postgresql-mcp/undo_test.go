@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/audit"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
+)
+
+var undoColumns = []pgvalue.Column{{Name: "id", Type: "int4"}, {Name: "email", Type: "text"}}
+
+// undoAnswer reports id as the primary key of users and current as the row
+// it holds now
+func undoAnswer(current [][]driver.Value) func(string, []driver.NamedValue) (*fakeResult, error) {
+	return func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		switch {
+		case strings.Contains(query, "indisprimary"):
+			return &fakeResult{columns: []string{"attname"}, types: []string{"TEXT"}, rows: [][]driver.Value{{"id"}}}, nil
+		case strings.HasSuffix(query, "FOR UPDATE"):
+			return &fakeResult{columns: []string{"id", "email"}, types: []string{"INT4", "TEXT"}, rows: current}, nil
+		}
+		return nil, nil
+	}
+}
+
+func appendEntry(t *testing.T, l *audit.Log, e *audit.Entry) string {
+	t.Helper()
+	e.Connection = "primary_db"
+	e.DSN = "postgresql://fake@localhost/primary_db"
+	e.Table = "users"
+	e.Columns = undoColumns
+	if err := l.Append(e); err != nil {
+		t.Fatal(err)
+	}
+	return e.ID
+}
+
+func TestUndoUpdatePreviewRollsBack(t *testing.T) {
+	l := withAuditLog(t)
+	d := withFakeDB(t, "primary_db", undoAnswer([][]driver.Value{{int64(1), "new@example.com"}}))
+	id := appendEntry(t, l, &audit.Entry{
+		Tool: "update", Operation: "update",
+		Before: []map[string]interface{}{{"id": 1.0, "email": "old@example.com"}},
+		After:  []map[string]interface{}{{"id": 1.0, "email": "new@example.com"}},
+	})
+
+	r := decodeResponse(t, undoHandler(map[string]interface{}{"id": id}))
+	if !r.OK || *r.RowCount != 1 {
+		t.Fatalf("preview failed: %+v", r)
+	}
+	data := r.Data.(map[string]interface{})
+	step := data["steps"].([]interface{})[0].(map[string]interface{})
+	if data["applied"] != false || step["action"] != "update" || step["sql"] != `UPDATE "users" SET "email" = $1 WHERE "id" = $2` {
+		t.Errorf("unexpected preview: %+v", data)
+	}
+	stmts := d.statements()
+	if !containsStatement(stmts, "ROLLBACK") || containsStatement(stmts, "COMMIT") {
+		t.Errorf("a preview must roll back: %v", stmts)
+	}
+	if entries, _ := l.Search(audit.Query{Operation: "undo"}); len(entries) != 0 {
+		t.Errorf("a preview must not be audited: %+v", entries)
+	}
+}
+
+func TestUndoRefusesChangedRows(t *testing.T) {
+	l := withAuditLog(t)
+	d := withFakeDB(t, "primary_db", undoAnswer([][]driver.Value{{int64(1), "other@example.com"}}))
+	id := appendEntry(t, l, &audit.Entry{
+		Tool: "update", Operation: "update",
+		Before: []map[string]interface{}{{"id": 1.0, "email": "old@example.com"}},
+		After:  []map[string]interface{}{{"id": 1.0, "email": "new@example.com"}},
+	})
+
+	r := decodeResponse(t, undoHandler(map[string]interface{}{"id": id, "apply": true}))
+	if r.OK || !strings.Contains(r.Error, "Undo aborted") {
+		t.Fatalf("expected the undo to be refused: %+v", r)
+	}
+	step := r.Detail.([]interface{})[0].(map[string]interface{})
+	if !strings.Contains(step["conflict"].(string), "email") {
+		t.Errorf("expected the changed column to be named: %+v", step)
+	}
+	if containsStatement(d.statements(), "COMMIT") || containsStatement(d.statements(), `UPDATE "users"`) {
+		t.Errorf("nothing may change on conflict: %v", d.statements())
+	}
+}
+
+func TestUndoDeleteAndInsertApplied(t *testing.T) {
+	l := withAuditLog(t)
+	d := withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		// Only the inserted row 3 still exists
+		if strings.HasSuffix(query, "FOR UPDATE") && fmt.Sprint(args[0].Value) == "3" {
+			return &fakeResult{columns: []string{"id", "email"}, types: []string{"INT4", "TEXT"}, rows: [][]driver.Value{{int64(3), "added@example.com"}}}, nil
+		}
+		return undoAnswer(nil)(query, args)
+	})
+	deleted := appendEntry(t, l, &audit.Entry{
+		Tool: "delete", Operation: "delete",
+		Before: []map[string]interface{}{{"id": 2.0, "email": "gone@example.com"}},
+	})
+	inserted := appendEntry(t, l, &audit.Entry{
+		Tool: "insert", Operation: "insert",
+		After: []map[string]interface{}{{"id": 3.0, "email": "added@example.com"}},
+	})
+
+	r := decodeResponse(t, undoHandler(map[string]interface{}{"ids": []interface{}{deleted, inserted}, "apply": true}))
+	if !r.OK || *r.RowCount != 2 {
+		t.Fatalf("undo failed: %+v", r)
+	}
+	stmts := d.statements()
+	for _, want := range []string{
+		`DELETE FROM "users" WHERE "id" = $1 RETURNING *`,
+		`INSERT INTO "users" ("email", "id") OVERRIDING SYSTEM VALUE VALUES ($1, $2) RETURNING *`,
+		"COMMIT",
+	} {
+		if !containsStatement(stmts, want) {
+			t.Errorf("expected statement %q in %v", want, stmts)
+		}
+	}
+	// The newer insert is reverted before the delete
+	steps := r.Data.(map[string]interface{})["steps"].([]interface{})
+	if steps[0].(map[string]interface{})["entry"] != inserted {
+		t.Errorf("expected the newest entry first: %+v", steps)
+	}
+	if entries, _ := l.Search(audit.Query{Operation: "undo"}); len(entries) != 2 {
+		t.Errorf("expected both compensations to be audited, got %d", len(entries))
+	}
+}
+
+func TestUndoRefusesLoads(t *testing.T) {
+	l := withAuditLog(t)
+	withFakeDB(t, "primary_db", undoAnswer(nil))
+	for _, tool := range []string{"bulk_insert", "restore_snapshot", "generate_seed_data"} {
+		id := appendEntry(t, l, &audit.Entry{Tool: tool, Operation: "insert", Affected: 3})
+		r := decodeResponse(t, undoHandler(map[string]interface{}{"id": id}))
+		if r.OK || !strings.Contains(r.Error, "recorded by "+tool) {
+			t.Errorf("expected %s entries to be refused: %+v", tool, r)
+		}
+	}
+}
+
+func TestUndoRefusesUnsafeColumnNames(t *testing.T) {
+	l := withAuditLog(t)
+	d := withFakeDB(t, "primary_db", undoAnswer(nil))
+	id := appendEntry(t, l, &audit.Entry{
+		Tool: "delete", Operation: "delete",
+		Before: []map[string]interface{}{{"id": 2.0, `email" = 'x'; DROP TABLE users; --`: "gone@example.com"}},
+	})
+
+	r := decodeResponse(t, undoHandler(map[string]interface{}{"id": id, "apply": true}))
+	if r.OK {
+		t.Fatalf("expected the undo to be refused: %+v", r)
+	}
+	step := r.Detail.([]interface{})[0].(map[string]interface{})
+	if !strings.Contains(step["conflict"].(string), "invalid identifier") {
+		t.Errorf("expected the column name to be rejected: %+v", step)
+	}
+	if containsStatement(d.statements(), "DROP TABLE") || containsStatement(d.statements(), `INSERT INTO "users"`) {
+		t.Errorf("an unsafe column name must not reach SQL: %v", d.statements())
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/acl"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+)
+
+// upsertFlag is the RETURNING column that tells inserted rows from updated
+// ones: a freshly inserted row version has no deleting transaction yet
+const upsertFlag = "mcp_inserted"
+
+func upsertHandler(args map[string]interface{}) map[string]interface{} {
+	table, ok := args["table"].(string)
+	if !ok {
+		return errResponse("table is required")
+	}
+
+	// data is one row object or an array of row objects with the same keys
+	var rows []map[string]interface{}
+	switch d := args["data"].(type) {
+	case map[string]interface{}:
+		rows = append(rows, d)
+	case []interface{}:
+		for i, item := range d {
+			row, ok := item.(map[string]interface{})
+			if !ok {
+				return errResponse(fmt.Sprintf("data[%d] must be an object", i))
+			}
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return errResponse("data must be a non-empty object or array of objects")
+	}
+
+	conflictColumns, err := stringList(args, "conflict_columns")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	var constraint string
+	if c, exists := args["conflict_constraint"]; exists {
+		if cStr, ok := c.(string); ok {
+			constraint = cStr
+		}
+	}
+	updateColumns, err := stringList(args, "update_columns")
+	if err != nil {
+		return errResponse(err.Error())
+	}
+
+	doNothing := false
+	if d, exists := args["do_nothing"]; exists {
+		if dBool, ok := d.(bool); ok {
+			doNothing = dBool
+		}
+	}
+
+	var database string
+	if d, exists := args["database"]; exists {
+		if dbStr, ok := d.(string); ok {
+			database = dbStr
+		}
+	}
+
+	returning := true
+	if r, exists := args["returning"]; exists {
+		if retBool, ok := r.(bool); ok {
+			returning = retBool
+		}
+	}
+
+	var timeoutMs *int
+	if t, exists := args["statement_timeout_ms"]; exists {
+		if timeoutFloat, ok := t.(float64); ok {
+			timeoutInt := int(timeoutFloat)
+			timeoutMs = &timeoutInt
+		}
+	}
+
+	// Audited upserts read every column back for the after image
+	mut, err := buildUpsert(table, rows, conflictColumns, constraint, updateColumns, doNothing, returning || auditLog != nil)
+	if err != nil {
+		var errs pgparam.Errors
+		if errors.As(err, &errs) {
+			return invalidParamsResponse(err)
+		}
+		return errResponse(err.Error())
+	}
+
+	t, err := resolveTarget(args, database)
+	if err != nil {
+		return errResponse(err.Error())
+	}
+	defer t.release()
+
+	if err := checkWritable(t.database); err != nil {
+		return errResponse(err.Error())
+	}
+	ops := []acl.Operation{acl.Insert}
+	if !doNothing {
+		ops = append(ops, acl.Update)
+	}
+	if err := checkAccess(t.database, table, ops...); err != nil {
+		return accessDeniedResponse(err)
+	}
+
+	// The RETURNING flag is always read to tell inserted rows from updated ones
+	result, _, err := runMutation(t, timeoutMs, mut, true, "")
+	if err != nil {
+		return errResponse(fmt.Sprintf("Upsert failed: %s", err))
+	}
+
+	inserted, updated := 0, 0
+	results := make([]map[string]interface{}, 0, len(result.rows))
+	for _, row := range result.maps() {
+		action := "updated"
+		if isInserted, _ := row[upsertFlag].(bool); isInserted {
+			action = "inserted"
+			inserted++
+		} else {
+			updated++
+		}
+		delete(row, upsertFlag)
+		entry := map[string]interface{}{"action": action}
+		if returning {
+			entry["row"] = row
+		}
+		results = append(results, entry)
+	}
+
+	count := inserted + updated
+	return okResponse(map[string]interface{}{
+		"inserted": inserted,
+		"updated":  updated,
+		"skipped":  len(rows) - count,
+		"rows":     results,
+	}, &count)
+}
+
+// buildUpsert validates every identifier and returns the INSERT ... ON
+// CONFLICT statement with its converted values. Rows that may conflict on
+// conflictColumns are selected by the mutation's where for before images.
+func buildUpsert(table string, rows []map[string]interface{}, conflictColumns []string, constraint string, updateColumns []string, doNothing, returning bool) (*mutation, error) {
+	quotedTable, err := qIdent(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	for col := range rows[0] {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	quotedColumns := make([]string, len(columns))
+	for i, col := range columns {
+		if quotedColumns[i], err = validateIdentifier(col); err != nil {
+			return nil, err
+		}
+	}
+
+	var conv pgparam.Converter
+	var values []interface{}
+	tuples := make([]string, len(rows))
+	for r, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("data[%d] must have the same keys as data[0]", r)
+		}
+		placeholders := make([]string, len(columns))
+		for i, col := range columns {
+			val, ok := row[col]
+			if !ok {
+				return nil, fmt.Errorf("data[%d] must have the same keys as data[0]", r)
+			}
+			name := "data." + col
+			if len(rows) > 1 {
+				name = fmt.Sprintf("data[%d].%s", r, col)
+			}
+			values = append(values, conv.Convert(name, val))
+			placeholders[i] = fmt.Sprintf("$%d", len(values))
+		}
+		tuples[r] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	if err := conv.Err(); err != nil {
+		return nil, err
+	}
+
+	// Conflict target: the columns of a unique index, or a named constraint
+	var target string
+	switch {
+	case len(conflictColumns) > 0 && constraint != "":
+		return nil, fmt.Errorf("pass either conflict_columns or conflict_constraint, not both")
+	case len(conflictColumns) > 0:
+		quoted := make([]string, len(conflictColumns))
+		for i, col := range conflictColumns {
+			if quoted[i], err = validateIdentifier(col); err != nil {
+				return nil, err
+			}
+		}
+		target = " (" + strings.Join(quoted, ", ") + ")"
+	case constraint != "":
+		quoted, err := validateIdentifier(constraint)
+		if err != nil {
+			return nil, err
+		}
+		target = " ON CONSTRAINT " + quoted
+	}
+
+	var action string
+	if doNothing {
+		if len(updateColumns) > 0 {
+			return nil, fmt.Errorf("update_columns cannot be combined with do_nothing")
+		}
+		action = "DO NOTHING"
+	} else {
+		if target == "" {
+			return nil, fmt.Errorf("conflict_columns or conflict_constraint is required unless do_nothing is set")
+		}
+		// By default every supplied column except the conflict key is updated
+		if len(updateColumns) == 0 {
+			for _, col := range columns {
+				if !containsString(conflictColumns, col) {
+					updateColumns = append(updateColumns, col)
+				}
+			}
+		}
+		if len(updateColumns) == 0 {
+			return nil, fmt.Errorf("no columns left to update; pass update_columns or set do_nothing")
+		}
+		sets := make([]string, len(updateColumns))
+		for i, col := range updateColumns {
+			if !containsString(columns, col) {
+				return nil, fmt.Errorf("update column %q is not in data", col)
+			}
+			quoted, err := validateIdentifier(col)
+			if err != nil {
+				return nil, err
+			}
+			sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", quoted, quoted)
+		}
+		action = "DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+
+	mut := &mutation{
+		tool:  "upsert",
+		table: quotedTable,
+		name:  table,
+		query: fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON CONFLICT%s %s",
+			quotedTable,
+			strings.Join(quotedColumns, ", "),
+			strings.Join(tuples, ", "),
+			target,
+			action),
+		values:    values,
+		returning: "(xmax = 0) AS " + upsertFlag,
+	}
+	if returning {
+		mut.returning = "*, " + mut.returning
+	}
+
+	// The rows an upsert may update are those holding its conflict keys; a
+	// key left to its default cannot be matched ahead of time
+	if len(conflictColumns) > 0 && !doNothing {
+		index := make([]int, len(conflictColumns))
+		for i, col := range conflictColumns {
+			index[i] = sort.SearchStrings(columns, col)
+			if index[i] == len(columns) || columns[index[i]] != col {
+				return mut, nil
+			}
+		}
+		keys := make([]string, len(rows))
+		for r := range rows {
+			placeholders := make([]string, len(conflictColumns))
+			for i, j := range index {
+				mut.whereValues = append(mut.whereValues, values[r*len(columns)+j])
+				placeholders[i] = fmt.Sprintf("$%d", len(mut.whereValues))
+			}
+			keys[r] = "(" + strings.Join(placeholders, ", ") + ")"
+		}
+		quoted := make([]string, len(conflictColumns))
+		for i, col := range conflictColumns {
+			quoted[i] = `"` + col + `"`
+		}
+		mut.where = fmt.Sprintf("(%s) IN (%s)", strings.Join(quoted, ", "), strings.Join(keys, ", "))
+	}
+	return mut, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestUpsertReportsInsertedAndUpdated(t *testing.T) {
+	var query string
+	withFakeDB(t, "primary_db", func(q string, args []driver.NamedValue) (*fakeResult, error) {
+		query = q
+		return &fakeResult{
+			columns: []string{"id", "username", "email", upsertFlag},
+			rows: [][]driver.Value{
+				{int64(1), "alice", "alice@example.com", false},
+				{int64(9), "bob", "bob@example.com", true},
+			},
+		}, nil
+	})
+
+	r := decodeResponse(t, upsertHandler(map[string]interface{}{
+		"table": "users",
+		"data": []interface{}{
+			map[string]interface{}{"username": "alice", "email": "alice@example.com"},
+			map[string]interface{}{"username": "bob", "email": "bob@example.com"},
+		},
+		"conflict_columns": []interface{}{"username"},
+	}))
+	if !r.OK || *r.RowCount != 2 {
+		t.Fatalf("upsert failed: %+v", r)
+	}
+	want := `INSERT INTO "users" ("email", "username") VALUES ($1, $2), ($3, $4) ON CONFLICT ("username") DO UPDATE SET "email" = EXCLUDED."email" RETURNING *, (xmax = 0) AS mcp_inserted`
+	if query != want {
+		t.Errorf("unexpected statement:\n%s\nwant\n%s", query, want)
+	}
+
+	data := r.Data.(map[string]interface{})
+	if data["inserted"].(float64) != 1 || data["updated"].(float64) != 1 || data["skipped"].(float64) != 0 {
+		t.Errorf("unexpected counts: %+v", data)
+	}
+	first := data["rows"].([]interface{})[0].(map[string]interface{})
+	row := first["row"].(map[string]interface{})
+	if first["action"] != "updated" || row["username"] != "alice" || row[upsertFlag] != nil {
+		t.Errorf("unexpected first row: %+v", first)
+	}
+}
+
+func TestUpsertDoNothingCountsSkipped(t *testing.T) {
+	var query string
+	withFakeDB(t, "primary_db", func(q string, args []driver.NamedValue) (*fakeResult, error) {
+		query = q
+		return &fakeResult{columns: []string{upsertFlag}}, nil
+	})
+
+	r := decodeResponse(t, upsertHandler(map[string]interface{}{
+		"table":               "public.users",
+		"data":                map[string]interface{}{"username": "alice"},
+		"conflict_constraint": "users_username_key",
+		"do_nothing":          true,
+		"returning":           false,
+	}))
+	if !r.OK || r.Data.(map[string]interface{})["skipped"].(float64) != 1 {
+		t.Fatalf("expected the row to be skipped: %+v", r)
+	}
+	if !strings.HasSuffix(query, `ON CONFLICT ON CONSTRAINT "users_username_key" DO NOTHING RETURNING (xmax = 0) AS mcp_inserted`) {
+		t.Errorf("unexpected statement: %s", query)
+	}
+}
+
+func TestUpsertValidation(t *testing.T) {
+	d := withFakeDB(t, "primary_db", nil)
+
+	row := map[string]interface{}{"username": "alice", "email": "a@example.com"}
+	for _, args := range []map[string]interface{}{
+		{"table": "users", "data": row},
+		{"table": "users", "data": row, "conflict_columns": []interface{}{"username"}, "conflict_constraint": "users_username_key"},
+		{"table": "users", "data": row, "conflict_columns": []interface{}{"user name"}},
+		{"table": "users", "data": row, "conflict_columns": []interface{}{"username"}, "update_columns": []interface{}{"created_at"}},
+		{"table": "users", "data": row, "do_nothing": true, "update_columns": []interface{}{"email"}},
+		{"table": "users", "data": map[string]interface{}{"username": "alice"}, "conflict_columns": []interface{}{"username"}},
+		{"table": "users", "data": []interface{}{row, map[string]interface{}{"username": "bob"}}, "conflict_columns": []interface{}{"username"}},
+	} {
+		if r := decodeResponse(t, upsertHandler(args)); r.OK {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+	if len(d.statements()) != 0 {
+		t.Errorf("expected nothing to run, got %v", d.statements())
+	}
+}
@@ -2,23 +2,93 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/filter"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/format"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgparam"
+	"github.com/Ryota-Onuma/ai-agents/mcp/postgresql-mcp/internal/pgvalue"
 )
 
 var logger = log.New(os.Stderr, "", log.LstdFlags)
 
 var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
+// checkWithin returns an error unless path is root or inside it. Paths with
+// .. elements are refused, and symbolic links are resolved on both sides, so
+// a link cannot lead out of root.
+func checkWithin(path, root string) error {
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return fmt.Errorf("%s must not contain ..", path)
+		}
+	}
+	realPath, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	realRoot, err := resolvePath(root)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside %s", path, root)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path with the symbolic links of its
+// longest existing prefix resolved. A link that cannot be resolved, like one
+// pointing nowhere, is an error rather than a name to create.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			if filepath.Dir(dir) == dir {
+				return abs, nil
+			}
+			continue
+		}
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(real, rel), nil
+	}
+}
+
 // Response structures
 type Response struct {
 	OK       bool        `json:"ok"`
 	Data     interface{} `json:"data,omitempty"`
 	Error    string      `json:"error,omitempty"`
 	RowCount *int        `json:"rowCount,omitempty"`
+	Detail   interface{} `json:"detail,omitempty"`
+	// Cursor is the continuation token for fetch_more when more rows remain
+	Cursor string `json:"cursor,omitempty"`
+	// Truncated is set when rows were dropped and no cursor can return them
+	Truncated bool `json:"truncated,omitempty"`
+	// Columns describes the returned rows, in order
+	Columns []pgvalue.Column `json:"columns,omitempty"`
+	// Format names the text rendering that follows a non-JSON header
+	Format string `json:"format,omitempty"`
 }
 
 // validateIdentifier validates one identifier part and returns the double-quoted identifier
@@ -44,13 +114,129 @@ func qIdent(ident string) (string, error) {
 }
 
 func okResponse(data interface{}, rowCount *int) map[string]interface{} {
-	resp := Response{OK: true}
+	return okPageResponse(data, rowCount, "", false)
+}
+
+// okPageResponse returns one page of rows and the cursor for the next page, if any
+func okPageResponse(data interface{}, rowCount *int, cursor string, truncated bool) map[string]interface{} {
+	resp := Response{OK: true, Cursor: cursor, Truncated: truncated}
 	if data != nil {
 		resp.Data = data
 	}
 	if rowCount != nil {
 		resp.RowCount = rowCount
 	}
+	return marshalResponse(resp)
+}
+
+// formatArg reads and validates the output format, defaulting to JSON
+func formatArg(args map[string]interface{}) (string, error) {
+	var name string
+	if f, exists := args["format"]; exists {
+		if fStr, ok := f.(string); ok {
+			name = fStr
+		}
+	}
+	return format.Validate(name)
+}
+
+// stringList reads an optional array of strings
+func stringList(args map[string]interface{}, key string) ([]string, error) {
+	var list []string
+	if v, exists := args[key]; exists {
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be an array of strings", key)
+		}
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be an array of strings", key)
+			}
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+// paramsArg reads params and converts them for binding
+func paramsArg(args map[string]interface{}) ([]interface{}, error) {
+	var params []interface{}
+	if p, exists := args["params"]; exists {
+		if paramSlice, ok := p.([]interface{}); ok {
+			params = paramSlice
+		}
+	}
+	return pgparam.ConvertAll(params)
+}
+
+// invalidParamsResponse reports parameter conversion failures, one detail
+// entry per parameter
+func invalidParamsResponse(err error) map[string]interface{} {
+	var errs pgparam.Errors
+	if errors.As(err, &errs) {
+		return errResponseWithDetail(fmt.Sprintf("Invalid params: %s", err), errs)
+	}
+	return errResponse(fmt.Sprintf("Invalid params: %s", err))
+}
+
+// compileWhere compiles a filter argument into a WHERE expression whose
+// placeholders follow values, returning the extended values
+func compileWhere(where interface{}, values []interface{}) (string, []interface{}, error) {
+	c := filter.Compiler{Quote: qIdent, Args: values}
+	expr, err := c.Compile(where)
+	if err != nil {
+		return "", nil, err
+	}
+	return expr, c.Args, nil
+}
+
+// whereErrResponse reports a filter that failed to compile
+func whereErrResponse(err error) map[string]interface{} {
+	var errs pgparam.Errors
+	if errors.As(err, &errs) {
+		return invalidParamsResponse(err)
+	}
+	return errResponse(fmt.Sprintf("Invalid where: %s", err))
+}
+
+// okRowsResponse returns one page of rows in outputFormat
+func okRowsResponse(outputFormat string, rs *rowSet, cursor string, truncated bool) map[string]interface{} {
+	rowCount := len(rs.rows)
+	var data interface{}
+	if outputFormat == format.JSON {
+		data = rs.maps()
+	}
+	return okTableResponse(outputFormat, rs, data, &rowCount, cursor, truncated)
+}
+
+// okTableResponse returns a response describing the columns of rs. For JSON,
+// data is the payload. Other formats get a JSON header with the response
+// metadata followed by the rows rendered as text; data then carries anything
+// the rows do not.
+func okTableResponse(outputFormat string, rs *rowSet, data interface{}, rowCount *int, cursor string, truncated bool) map[string]interface{} {
+	resp := Response{
+		OK:        true,
+		Data:      data,
+		RowCount:  rowCount,
+		Cursor:    cursor,
+		Truncated: truncated,
+		Columns:   rs.describe(),
+	}
+	if outputFormat == format.JSON {
+		return marshalResponse(resp)
+	}
+
+	body, err := format.Render(outputFormat, rs.columns, rs.rows)
+	if err != nil {
+		return errResponse(fmt.Sprintf("Failed to render %s: %s", outputFormat, err))
+	}
+	resp.Format = outputFormat
+	return marshalResponse(resp, body)
+}
+
+// marshalResponse encodes resp as the first text content, followed by extra
+func marshalResponse(resp Response, extra ...string) map[string]interface{} {
 	b, err := json.Marshal(resp)
 	if err != nil {
 		logger.Printf("Failed to marshal response: %s", err)
@@ -60,15 +246,23 @@ func okResponse(data interface{}, rowCount *int) map[string]interface{} {
 			},
 		}
 	}
-	return map[string]interface{}{
-		"content": []map[string]interface{}{
-			{"type": "text", "text": string(b)},
-		},
+	content := []map[string]interface{}{
+		{"type": "text", "text": string(b)},
+	}
+	for _, text := range extra {
+		content = append(content, map[string]interface{}{"type": "text", "text": text})
 	}
+	return map[string]interface{}{"content": content}
 }
 
 func errResponse(msg string) map[string]interface{} {
-	resp := Response{OK: false, Error: msg}
+	return errResponseWithDetail(msg, nil)
+}
+
+// errResponseWithDetail returns an error response carrying structured detail,
+// e.g. the kind of statement that was rejected
+func errResponseWithDetail(msg string, detail interface{}) map[string]interface{} {
+	resp := Response{OK: false, Error: msg, Detail: detail}
 	b, err := json.Marshal(resp)
 	if err != nil {
 		logger.Printf("Failed to marshal error response: %s", err)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestQueryBindsTypedParams(t *testing.T) {
+	var bound []driver.NamedValue
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if strings.HasPrefix(query, "DECLARE ") {
+			bound = args
+		}
+		return nil, nil
+	})
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{
+		"sql": "SELECT * FROM users WHERE id = ANY($1) AND active = $2 AND profile @> $3",
+		"params": []interface{}{
+			map[string]interface{}{"value": []interface{}{float64(1), float64(2)}, "type": "int[]"},
+			map[string]interface{}{"value": "true", "type": "boolean"},
+			map[string]interface{}{"value": map[string]interface{}{"plan": "pro"}, "type": "jsonb"},
+		},
+	}))
+	if !r.OK {
+		t.Fatalf("query failed: %s", r.Error)
+	}
+	if len(bound) != 3 || bound[0].Value != `{"1","2"}` || bound[1].Value != true || bound[2].Value != `{"plan":"pro"}` {
+		t.Errorf("unexpected bound values: %+v", bound)
+	}
+}
+
+func TestQueryReportsEachBadParam(t *testing.T) {
+	d := withFakeDB(t, "primary_db", func(string, []driver.NamedValue) (*fakeResult, error) { return nil, nil })
+
+	r := decodeResponse(t, queryHandler(map[string]interface{}{
+		"sql": "SELECT * FROM users WHERE id = $1 AND created_at > $2",
+		"params": []interface{}{
+			map[string]interface{}{"value": "one", "type": "int"},
+			map[string]interface{}{"value": "yesterday-ish", "type": "timestamptz"},
+		},
+	}))
+	if r.OK || !strings.HasPrefix(r.Error, "Invalid params: ") {
+		t.Fatalf("expected the params to be rejected: %+v", r)
+	}
+	detail, ok := r.Detail.([]interface{})
+	if !ok || len(detail) != 2 || detail[1].(map[string]interface{})["param"] != "$2" {
+		t.Errorf("expected one detail entry per bad parameter, got %#v", r.Detail)
+	}
+	if len(d.statements()) != 0 {
+		t.Errorf("expected nothing to run, got %v", d.statements())
+	}
+}
+
+func TestInsertConvertsTypedValues(t *testing.T) {
+	var bound []driver.NamedValue
+	withFakeDB(t, "primary_db", func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		bound = args
+		return nil, nil
+	})
+
+	r := decodeResponse(t, insertHandler(map[string]interface{}{
+		"table":     "products",
+		"data":      map[string]interface{}{"tags": map[string]interface{}{"value": []interface{}{"a", "b"}, "type": "text[]"}},
+		"returning": false,
+	}))
+	if !r.OK || len(bound) != 1 || bound[0].Value != `{"a","b"}` {
+		t.Fatalf("unexpected result %+v with %+v", r, bound)
+	}
+
+	r = decodeResponse(t, insertHandler(map[string]interface{}{
+		"table": "products",
+		"data":  map[string]interface{}{"price": map[string]interface{}{"value": "cheap", "type": "numeric"}},
+	}))
+	if r.OK || !strings.Contains(r.Error, "data.price (numeric)") {
+		t.Errorf("expected the column to be named in the error: %+v", r)
+	}
+}